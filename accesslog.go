@@ -0,0 +1,20 @@
+package traefik_plugin_s3_auth
+
+import "net/http"
+
+// accessLogFields are the suffixes of the request headers set for the Traefik access logs.
+var accessLogFields = []string{"Access-Key-Id", "Tenant", "Action", "Decision", "Reason"}
+
+// setAccessLogHeaders sets the decision of the request as request headers, eg: `X-S3Auth-Decision: deny`. Traefik
+// reads the request headers of its access logs once the request is served, and by then the request sent to the
+// backend was already copied, so the headers are only for the access logs.
+func setAccessLogHeaders(h http.Header, prefix string, e *decisionLogEntry) {
+	if e.Decision == "" {
+		return
+	}
+	for i, v := range []string{e.AccessKeyID, e.Tenant, e.Action, e.Decision, e.Reason} {
+		if v != "" {
+			h.Set(prefix+accessLogFields[i], v)
+		}
+	}
+}
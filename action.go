@@ -0,0 +1,188 @@
+package traefik_plugin_s3_auth
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// s3Operation is the S3 API operation of a request, eg: `CreateMultipartUpload`, together with the IAM action
+// policies check it against, eg: `s3:PutObject`.
+// https://docs.aws.amazon.com/service-authorization/latest/reference/list_amazons3.html
+type s3Operation struct {
+	Name   string
+	Action string
+}
+
+// operationRule maps a method, a target (the service, a bucket or an object) and an optional query sub-resource to
+// an operation. The first matching rule wins, so more specific sub-resources come first.
+type operationRule struct {
+	method      string
+	target      int
+	subresource string
+	op          s3Operation
+}
+
+const (
+	targetService = iota
+	targetBucket
+	targetObject
+)
+
+var operationRules = []operationRule{
+	{http.MethodGet, targetService, "", s3Operation{"ListBuckets", "s3:ListAllMyBuckets"}},
+
+	{http.MethodGet, targetBucket, "uploads", s3Operation{"ListMultipartUploads", "s3:ListBucketMultipartUploads"}},
+	{http.MethodGet, targetBucket, "versions", s3Operation{"ListObjectVersions", "s3:ListBucketVersions"}},
+	{http.MethodGet, targetBucket, "location", s3Operation{"GetBucketLocation", "s3:GetBucketLocation"}},
+	{http.MethodGet, targetBucket, "policy", s3Operation{"GetBucketPolicy", "s3:GetBucketPolicy"}},
+	{http.MethodGet, targetBucket, "acl", s3Operation{"GetBucketAcl", "s3:GetBucketAcl"}},
+	{http.MethodGet, targetBucket, "tagging", s3Operation{"GetBucketTagging", "s3:GetBucketTagging"}},
+	{http.MethodGet, targetBucket, "cors", s3Operation{"GetBucketCors", "s3:GetBucketCORS"}},
+	{http.MethodGet, targetBucket, "lifecycle", s3Operation{"GetBucketLifecycleConfiguration", "s3:GetLifecycleConfiguration"}},
+	{http.MethodGet, targetBucket, "versioning", s3Operation{"GetBucketVersioning", "s3:GetBucketVersioning"}},
+	{http.MethodGet, targetBucket, "encryption", s3Operation{"GetBucketEncryption", "s3:GetEncryptionConfiguration"}},
+	{http.MethodGet, targetBucket, "notification", s3Operation{"GetBucketNotificationConfiguration", "s3:GetBucketNotification"}},
+	{http.MethodGet, targetBucket, "object-lock", s3Operation{"GetObjectLockConfiguration", "s3:GetBucketObjectLockConfiguration"}},
+	{http.MethodGet, targetBucket, "list-type", s3Operation{"ListObjectsV2", "s3:ListBucket"}},
+	{http.MethodGet, targetBucket, "", s3Operation{"ListObjects", "s3:ListBucket"}},
+	{http.MethodHead, targetBucket, "", s3Operation{"HeadBucket", "s3:ListBucket"}},
+	{http.MethodPut, targetBucket, "policy", s3Operation{"PutBucketPolicy", "s3:PutBucketPolicy"}},
+	{http.MethodPut, targetBucket, "acl", s3Operation{"PutBucketAcl", "s3:PutBucketAcl"}},
+	{http.MethodPut, targetBucket, "tagging", s3Operation{"PutBucketTagging", "s3:PutBucketTagging"}},
+	{http.MethodPut, targetBucket, "cors", s3Operation{"PutBucketCors", "s3:PutBucketCORS"}},
+	{http.MethodPut, targetBucket, "lifecycle", s3Operation{"PutBucketLifecycleConfiguration", "s3:PutLifecycleConfiguration"}},
+	{http.MethodPut, targetBucket, "versioning", s3Operation{"PutBucketVersioning", "s3:PutBucketVersioning"}},
+	{http.MethodPut, targetBucket, "encryption", s3Operation{"PutBucketEncryption", "s3:PutEncryptionConfiguration"}},
+	{http.MethodPut, targetBucket, "notification", s3Operation{"PutBucketNotificationConfiguration", "s3:PutBucketNotification"}},
+	{http.MethodPut, targetBucket, "object-lock", s3Operation{"PutObjectLockConfiguration", "s3:PutBucketObjectLockConfiguration"}},
+	{http.MethodPut, targetBucket, "", s3Operation{"CreateBucket", "s3:CreateBucket"}},
+	{http.MethodDelete, targetBucket, "policy", s3Operation{"DeleteBucketPolicy", "s3:DeleteBucketPolicy"}},
+	{http.MethodDelete, targetBucket, "tagging", s3Operation{"DeleteBucketTagging", "s3:PutBucketTagging"}},
+	{http.MethodDelete, targetBucket, "cors", s3Operation{"DeleteBucketCors", "s3:PutBucketCORS"}},
+	{http.MethodDelete, targetBucket, "lifecycle", s3Operation{"DeleteBucketLifecycle", "s3:PutLifecycleConfiguration"}},
+	{http.MethodDelete, targetBucket, "encryption", s3Operation{"DeleteBucketEncryption", "s3:PutEncryptionConfiguration"}},
+	{http.MethodDelete, targetBucket, "", s3Operation{"DeleteBucket", "s3:DeleteBucket"}},
+	{http.MethodPost, targetBucket, "delete", s3Operation{"DeleteObjects", "s3:DeleteObject"}},
+	{http.MethodPost, targetBucket, "", s3Operation{"PostObject", "s3:PutObject"}},
+
+	{http.MethodGet, targetObject, "uploadId", s3Operation{"ListParts", "s3:ListMultipartUploadParts"}},
+	{http.MethodGet, targetObject, "acl", s3Operation{"GetObjectAcl", "s3:GetObjectAcl"}},
+	{http.MethodGet, targetObject, "tagging", s3Operation{"GetObjectTagging", "s3:GetObjectTagging"}},
+	{http.MethodGet, targetObject, "retention", s3Operation{"GetObjectRetention", "s3:GetObjectRetention"}},
+	{http.MethodGet, targetObject, "legal-hold", s3Operation{"GetObjectLegalHold", "s3:GetObjectLegalHold"}},
+	{http.MethodGet, targetObject, "attributes", s3Operation{"GetObjectAttributes", "s3:GetObjectAttributes"}},
+	{http.MethodGet, targetObject, "versionId", s3Operation{"GetObject", "s3:GetObjectVersion"}},
+	{http.MethodGet, targetObject, "", s3Operation{"GetObject", "s3:GetObject"}},
+	{http.MethodHead, targetObject, "", s3Operation{"HeadObject", "s3:GetObject"}},
+	{http.MethodPut, targetObject, "uploadId", s3Operation{"UploadPart", "s3:PutObject"}},
+	{http.MethodPut, targetObject, "acl", s3Operation{"PutObjectAcl", "s3:PutObjectAcl"}},
+	{http.MethodPut, targetObject, "tagging", s3Operation{"PutObjectTagging", "s3:PutObjectTagging"}},
+	{http.MethodPut, targetObject, "retention", s3Operation{"PutObjectRetention", "s3:PutObjectRetention"}},
+	{http.MethodPut, targetObject, "legal-hold", s3Operation{"PutObjectLegalHold", "s3:PutObjectLegalHold"}},
+	{http.MethodPut, targetObject, "", s3Operation{"PutObject", "s3:PutObject"}},
+	{http.MethodPost, targetObject, "uploads", s3Operation{"CreateMultipartUpload", "s3:PutObject"}},
+	{http.MethodPost, targetObject, "uploadId", s3Operation{"CompleteMultipartUpload", "s3:PutObject"}},
+	{http.MethodPost, targetObject, "restore", s3Operation{"RestoreObject", "s3:RestoreObject"}},
+	{http.MethodPost, targetObject, "select", s3Operation{"SelectObjectContent", "s3:GetObject"}},
+	{http.MethodDelete, targetObject, "uploadId", s3Operation{"AbortMultipartUpload", "s3:AbortMultipartUpload"}},
+	{http.MethodDelete, targetObject, "tagging", s3Operation{"DeleteObjectTagging", "s3:DeleteObjectTagging"}},
+	{http.MethodDelete, targetObject, "versionId", s3Operation{"DeleteObject", "s3:DeleteObjectVersion"}},
+	{http.MethodDelete, targetObject, "", s3Operation{"DeleteObject", "s3:DeleteObject"}},
+}
+
+// listings are the bucket operations scoped by their `prefix` parameter.
+var listings = map[string]bool{"ListObjects": true, "ListObjectsV2": true, "ListObjectVersions": true, "ListMultipartUploads": true}
+
+// unknownOperation is used for requests no rule matches, policies only allow it through `s3:*` style wildcards.
+var unknownOperation = s3Operation{"Unknown", "s3:Unknown"}
+
+// classify infers the S3 operation of a request from its method, target and query sub-resources.
+func classify(req *http.Request, res s3Resource) s3Operation {
+	target := targetObject
+	switch {
+	case res.Bucket == "":
+		target = targetService
+	case res.Key == "":
+		target = targetBucket
+	}
+	q, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		return unknownOperation
+	}
+	for _, r := range operationRules {
+		if r.method != req.Method || r.target != target {
+			continue
+		}
+		if _, ok := q[r.subresource]; r.subresource == "" || ok {
+			return r.op
+		}
+	}
+	return unknownOperation
+}
+
+// knownSubresources are the query parameters S3 treats as sub-resources, eg: `?acl`.
+var knownSubresources = map[string]bool{
+	"accelerate": true, "acl": true, "analytics": true, "attributes": true, "cors": true, "delete": true,
+	"encryption": true, "intelligent-tiering": true, "inventory": true, "legal-hold": true, "lifecycle": true,
+	"location": true, "logging": true, "metrics": true, "notification": true, "object-lock": true,
+	"ownershipControls": true, "policy": true, "policyStatus": true, "publicAccessBlock": true, "replication": true,
+	"requestPayment": true, "restore": true, "retention": true, "select": true, "tagging": true, "torrent": true,
+	"uploadId": true, "uploads": true, "versionId": true, "versioning": true, "versions": true, "website": true,
+}
+
+// subresources returns the S3 sub-resources in the query string of the request, eg: `acl` for `PUT /bucket?acl`.
+func subresources(req *http.Request) []string {
+	var out []string
+	for k := range req.URL.Query() {
+		if knownSubresources[k] {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// arn returns the ARN of the bucket or object, eg: `arn:aws:s3:::bucket/key`.
+func (r s3Resource) arn() string {
+	if r.Key == "" {
+		return "arn:aws:s3:::" + r.Bucket
+	}
+	return "arn:aws:s3:::" + r.Bucket + "/" + r.Key
+}
+
+// operationCounter counts the requests of each operation that were let through.
+type operationCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func newOperationCounter() *operationCounter {
+	return &operationCounter{counts: map[string]uint64{}}
+}
+
+func (c *operationCounter) record(op s3Operation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts[op.Name]++
+}
+
+// OperationCount is the number of requests of an operation, eg: `PutObject`.
+type OperationCount struct {
+	Operation string `json:"operation"`
+	Count     uint64 `json:"count"`
+}
+
+func (c *operationCounter) list() []OperationCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]OperationCount, 0, len(c.counts))
+	for name, n := range c.counts {
+		out = append(out, OperationCount{Operation: name, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Operation < out[j].Operation })
+	return out
+}
@@ -0,0 +1,99 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestOperations(t *testing.T) {
+	tc := []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{method: http.MethodGet, path: "/", expected: "ListBuckets"},
+		{method: http.MethodGet, path: "/bucket", expected: "ListObjects"},
+		{method: http.MethodGet, path: "/bucket?list-type=2&prefix=logs", expected: "ListObjectsV2"},
+		{method: http.MethodGet, path: "/bucket?uploads", expected: "ListMultipartUploads"},
+		{method: http.MethodGet, path: "/bucket?location", expected: "GetBucketLocation"},
+		{method: http.MethodHead, path: "/bucket", expected: "HeadBucket"},
+		{method: http.MethodPut, path: "/bucket", expected: "CreateBucket"},
+		{method: http.MethodPut, path: "/bucket?versioning", expected: "PutBucketVersioning"},
+		{method: http.MethodDelete, path: "/bucket", expected: "DeleteBucket"},
+		{method: http.MethodPost, path: "/bucket?delete", expected: "DeleteObjects"},
+		{method: http.MethodGet, path: "/bucket/key.txt", expected: "GetObject"},
+		{method: http.MethodHead, path: "/bucket/key.txt", expected: "HeadObject"},
+		{method: http.MethodGet, path: "/bucket/key.txt?tagging", expected: "GetObjectTagging"},
+		{method: http.MethodPut, path: "/bucket/key.txt", expected: "PutObject"},
+		{method: http.MethodPost, path: "/bucket/key.txt?uploads", expected: "CreateMultipartUpload"},
+		{method: http.MethodPut, path: "/bucket/key.txt?partNumber=1&uploadId=abc", expected: "UploadPart"},
+		{method: http.MethodPost, path: "/bucket/key.txt?uploadId=abc", expected: "CompleteMultipartUpload"},
+		{method: http.MethodDelete, path: "/bucket/key.txt?uploadId=abc", expected: "AbortMultipartUpload"},
+		{method: http.MethodDelete, path: "/bucket/key.txt", expected: "DeleteObject"},
+		{method: http.MethodPatch, path: "/bucket/key.txt", expected: "Unknown"},
+	}
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+
+	var op string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		op, _ = req.Context().Value(plugin.OperationContextKey).(string)
+	})
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+	for _, tt := range tc {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			op = ""
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, tt.path, cred))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+			}
+			if op != tt.expected {
+				t.Errorf("expected operation %q, got %q", tt.expected, op)
+			}
+		})
+	}
+}
+
+func TestPolicyOperations(t *testing.T) {
+	cred := validCredential()
+	cred.Policy = &plugin.Policy{Statement: []*plugin.PolicyStatement{
+		{Effect: "Allow", Action: []string{"s3:PutObject"}, Resource: []string{"arn:aws:s3:::bucket/*"}},
+	}}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	tc := []struct {
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		// Multipart uploads are authorized as `s3:PutObject`, like in IAM.
+		{method: http.MethodPost, path: "/bucket/key.txt?uploads", expectedStatus: http.StatusOK},
+		{method: http.MethodPut, path: "/bucket/key.txt?partNumber=1&uploadId=abc", expectedStatus: http.StatusOK},
+		{method: http.MethodDelete, path: "/bucket/key.txt?uploadId=abc", expectedStatus: http.StatusForbidden},
+		{method: http.MethodPut, path: "/bucket/key.txt?tagging", expectedStatus: http.StatusForbidden},
+	}
+	for _, tt := range tc {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, tt.path, cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package traefik_plugin_s3_auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminServer is shared by every middleware instance configured with the same address, since
+// Traefik creates a new instance for each router and on every configuration reload.
+type adminServer struct {
+	// loopback is true when the server only listens on a loopback address.
+	loopback bool
+
+	mu          sync.RWMutex
+	plugins     map[string]*Plugin
+	token       string
+	maintenance maintenance
+	grants      grants
+}
+
+var (
+	adminMu      sync.Mutex
+	adminServers = map[string]*adminServer{}
+)
+
+// registerAdmin adds the middleware to the admin server of the address, starting it on first use. The last middleware
+// registered with a token sets the one of the server.
+func registerAdmin(addr, token, name string, p *Plugin) (*adminServer, error) {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+
+	s, ok := adminServers[addr]
+	if !ok {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		s = &adminServer{loopback: loopbackAddress(addr), plugins: map[string]*Plugin{}}
+		go func() {
+			if err := http.Serve(ln, s.handler()); err != nil { //nolint:gosec
+				logs.error("admin server stopped", "address", addr, "error", err)
+			}
+		}()
+		adminServers[addr] = s
+	}
+
+	s.mu.Lock()
+	s.plugins[name] = p
+	if token != "" {
+		s.token = token
+	}
+	s.mu.Unlock()
+	return s, nil
+}
+
+// loopbackAddress reports whether the listen address only accepts local connections, eg: `127.0.0.1:8089`.
+func loopbackAddress(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorized reports whether the request may change the state of the middlewares or evaluate requests, writing the
+// error when it may not. It needs the token of the server as a bearer token, or a loopback server without a token.
+func (s *adminServer) authorized(rw http.ResponseWriter, req *http.Request) bool {
+	s.mu.RLock()
+	token := s.token
+	s.mu.RUnlock()
+
+	if token == "" {
+		if s.loopback {
+			return true
+		}
+		http.Error(rw, "the `adminToken` must be set to use this endpoint on a non-loopback `adminAddress`", http.StatusForbidden)
+		return false
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) != 1 {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (s *adminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.serveStatus)
+	mux.HandleFunc("/reload", s.serveReload)
+	mux.HandleFunc("/maintenance", s.serveMaintenance)
+	mux.HandleFunc("/simulate", s.serveSimulate)
+	mux.HandleFunc("/grants", s.serveGrants)
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	return mux
+}
+
+// version is the release of the middleware reported by the `/status` endpoint, bumped along with the CHANGELOG.
+const version = "v0.0.20"
+
+type middlewareStatus struct {
+	Name string `json:"name"`
+	// Ready is false until the middleware has credentials to validate the requests with, eg: for readiness probes.
+	Ready       bool                   `json:"ready"`
+	Counts      credentialCounts       `json:"counts"`
+	Sources     SourceStatus           `json:"sources"`
+	Caches      map[string]CacheStatus `json:"caches"`
+	Credentials []CredentialStatus     `json:"credentials"`
+	Operations  []OperationCount       `json:"operations"`
+	Denylist    *DenylistStatus        `json:"denylist,omitempty"`
+	ReadOnly    bool                   `json:"readOnly"`
+}
+
+// credentialCounts summarizes the credentials of a middleware by their warnings.
+type credentialCounts struct {
+	Total     int `json:"total"`
+	Expired   int `json:"expired"`
+	Expiring  int `json:"expiring"`
+	Unused    int `json:"unused"`
+	OverQuota int `json:"overQuota"`
+}
+
+func countCredentials(creds []CredentialStatus) credentialCounts {
+	c := credentialCounts{Total: len(creds)}
+	for _, cred := range creds {
+		for _, w := range cred.Warnings {
+			switch w {
+			case "expired":
+				c.Expired++
+			case "expiring":
+				c.Expiring++
+			case "unused":
+				c.Unused++
+			case "over-quota":
+				c.OverQuota++
+			}
+		}
+	}
+	return c
+}
+
+func (s *adminServer) serveStatus(rw http.ResponseWriter, req *http.Request) {
+	s.mu.RLock()
+	statuses := make([]middlewareStatus, 0, len(s.plugins))
+	for name, p := range s.plugins {
+		creds := p.CredentialStatus()
+		st := middlewareStatus{
+			Name:        name,
+			Ready:       len(p.store.list()) > 0,
+			Counts:      countCredentials(creds),
+			Sources:     p.store.sourceStatus(),
+			Caches:      p.CacheStatus(),
+			Credentials: creds,
+			Operations:  p.operations.list(),
+			ReadOnly:    p.readOnlyMode(),
+		}
+		if p.denylist != nil {
+			d := p.denylist.snapshot()
+			st.Denylist = &d
+		}
+		statuses = append(statuses, st)
+	}
+	s.mu.RUnlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(map[string]interface{}{"version": version, "middlewares": statuses}); err != nil {
+		logs.error("failed to encode the status", "error", err)
+	}
+}
+
+type reloadResult struct {
+	Middlewares []string        `json:"middlewares"`
+	Error       string          `json:"error,omitempty"`
+	Diff        *credentialDiff `json:"diff,omitempty"`
+}
+
+// serveReload reloads every credential store, each once even when shared by several middlewares.
+func (s *adminServer) serveReload(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(rw, req) {
+		return
+	}
+
+	s.mu.RLock()
+	names := map[*credentialStore][]string{}
+	for name, p := range s.plugins {
+		names[p.store] = append(names[p.store], name)
+	}
+	s.mu.RUnlock()
+
+	results := make([]reloadResult, 0, len(names))
+	for store, n := range names {
+		sort.Strings(n)
+		r := reloadResult{Middlewares: n}
+		if diff, err := store.reload("admin"); err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Diff = &diff
+		}
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Middlewares[0] < results[j].Middlewares[0] })
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(map[string]interface{}{"results": results}); err != nil {
+		logs.error("failed to encode the reload results", "error", err)
+	}
+}
+
+// serveMaintenance reports the read-only mode, and toggles it for every middleware with a `POST`, eg:
+// `/maintenance?readOnly=true&reason=backend+upgrade`.
+func (s *adminServer) serveMaintenance(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !s.authorized(rw, req) {
+			return
+		}
+		enabled, err := strconv.ParseBool(req.URL.Query().Get("readOnly"))
+		if err != nil {
+			http.Error(rw, "the `readOnly` parameter must be a boolean", http.StatusBadRequest)
+			return
+		}
+		s.maintenance.set(enabled, req.URL.Query().Get("reason"), time.Now())
+		logs.info("read-only maintenance mode set", "readOnly", enabled)
+	default:
+		rw.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(s.maintenance.status()); err != nil {
+		logs.error("failed to encode the maintenance status", "error", err)
+	}
+}
+
+type simulationResult struct {
+	Middleware string `json:"middleware"`
+	SimulationResult
+}
+
+// serveSimulate dry-runs the JSON SimulationRequest in the body against every middleware, or only the one named by
+// the `middleware` parameter.
+func (s *adminServer) serveSimulate(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(rw, req) {
+		return
+	}
+	var sr SimulationRequest
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxSourceBytes)).Decode(&sr); err != nil {
+		http.Error(rw, "invalid simulation request", http.StatusBadRequest)
+		return
+	}
+	only := req.URL.Query().Get("middleware")
+
+	s.mu.RLock()
+	results := make([]simulationResult, 0, len(s.plugins))
+	var err error
+	for name, p := range s.plugins {
+		if only != "" && name != only {
+			continue
+		}
+		var r SimulationResult
+		if r, err = p.Simulate(sr); err != nil {
+			break
+		}
+		results = append(results, simulationResult{Middleware: name, SimulationResult: r})
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Middleware < results[j].Middleware })
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(map[string]interface{}{"results": results}); err != nil {
+		logs.error("failed to encode the simulation results", "error", err)
+	}
+}
+
+// serveGrants lists the active grants added through the admin server, adds the JSON Grant in the body of a `POST` and
+// revokes the one named by the `id` parameter of a `DELETE`. They apply to every middleware.
+func (s *adminServer) serveGrants(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !s.authorized(rw, req) {
+			return
+		}
+		var g Grant
+		if err := json.NewDecoder(io.LimitReader(req.Body, maxSourceBytes)).Decode(&g); err != nil {
+			http.Error(rw, "invalid grant", http.StatusBadRequest)
+			return
+		}
+		if err := s.grants.add(&g); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logs.info("granted", "accessKeyId", g.AccessKeyID, "actions", strings.Join(g.Actions, ","), "prefix", g.Prefix, "expires", g.Expires)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(rw).Encode(g); err != nil {
+			logs.error("failed to encode the grant", "error", err)
+		}
+		return
+	case http.MethodDelete:
+		if !s.authorized(rw, req) {
+			return
+		}
+		id := req.URL.Query().Get("id")
+		if !s.grants.revoke(id) {
+			http.Error(rw, fmt.Sprintf("unknown grant %q", id), http.StatusNotFound)
+			return
+		}
+		logs.info("revoked grant", "grant", id)
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		rw.Header().Set("Allow", http.MethodGet+", "+http.MethodPost+", "+http.MethodDelete)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(map[string]interface{}{"grants": s.grants.active(time.Now())}); err != nil {
+		logs.error("failed to encode the grants", "error", err)
+	}
+}
+
+// serveMetrics exposes the metrics of every middleware in the Prometheus text format, or in the OpenMetrics one when
+// the scraper accepts it.
+func (s *adminServer) serveMetrics(rw http.ResponseWriter, req *http.Request) {
+	s.mu.RLock()
+	all := make([]*metrics, 0, len(s.plugins))
+	for _, p := range s.plugins {
+		all = append(all, p.metrics)
+	}
+	s.mu.RUnlock()
+
+	if strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text") {
+		rw.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		writeMetrics(rw, all, true)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(rw, all, false)
+}
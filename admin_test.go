@@ -0,0 +1,125 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+// freePort returns a port nothing listens on, the admin servers being shared process-wide by address.
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestAdminToken(t *testing.T) {
+	token := strings.Repeat("t", 32)
+	tc := []struct {
+		name           string
+		host           string
+		token          string
+		method         string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			name:           "reading without the token",
+			host:           "127.0.0.1",
+			token:          token,
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "changing without the token",
+			host:           "127.0.0.1",
+			token:          token,
+			method:         http.MethodPost,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "changing with another token",
+			host:           "127.0.0.1",
+			token:          token,
+			method:         http.MethodPost,
+			authorization:  "Bearer " + strings.Repeat("x", 32),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "changing with the token",
+			host:           "127.0.0.1",
+			token:          token,
+			method:         http.MethodPost,
+			authorization:  "Bearer " + token,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "loopback address without a token",
+			host:           "127.0.0.1",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "every address without a token",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			port := freePort(t)
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.AdminAddress = net.JoinHostPort(tt.host, port)
+			cfg.AdminToken = tt.token
+			newTestPlugin(t, cfg)
+
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, "http://127.0.0.1:"+port+"/maintenance?readOnly=false", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestInvalidAdminToken(t *testing.T) {
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.Credentials[0].NotAfter = "2020-01-01T00:00:00Z"
+	cfg.ExpiryWarningDays = 7
+	cfg.AdminToken = "short"
+	var err error
+	out := captureStdout(t, func() {
+		_, err = plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin")
+	})
+	if err == nil || !strings.Contains(err.Error(), "`adminToken`") {
+		t.Errorf("expected an error about the `adminToken`, got %v", err)
+	}
+	if strings.Contains(out, "credential expired") {
+		t.Errorf("expected no hygiene pass for a rejected configuration, got %q", out)
+	}
+}
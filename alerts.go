@@ -0,0 +1,183 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAlertWindow = 5 * time.Minute
+
+const (
+	// maxAlertDistinct bounds the distinct access key ids or client ips remembered per window.
+	maxAlertDistinct = 100
+	// maxAlertWindows bounds the windows, the access key ids and client ips claimed by the clients once there are
+	// that many share a single window per kind, reported as `*`.
+	maxAlertWindows = 4096
+	alertOverflow   = "*"
+)
+
+// AlertsConfig posts a summary to a webhook when an access key id or a client ip fails to authenticate too often, eg:
+// to notify a security team of credential abuse attempts.
+type AlertsConfig struct {
+	// URL receiving a `POST` with the JSON summary.
+	URL string `json:"url,omitempty"`
+	// Window of the failure counters, defaults to `5m`.
+	Window string `json:"window,omitempty"`
+	// KeyThreshold is the number of failures of an access key id per window that triggers an alert.
+	KeyThreshold int64 `json:"keyThreshold,omitempty"`
+	// SourceThreshold is the number of failures of a client ip per window that triggers an alert.
+	SourceThreshold int64 `json:"sourceThreshold,omitempty"`
+}
+
+// alertEvent is the summary posted to the webhook, once per access key id or client ip and window.
+type alertEvent struct {
+	Event       string           `json:"event"`
+	Middleware  string           `json:"middleware"`
+	Time        time.Time        `json:"time"`
+	AccessKeyID string           `json:"accessKeyId,omitempty"`
+	SourceIP    string           `json:"sourceIp,omitempty"`
+	Failures    int64            `json:"failures"`
+	Threshold   int64            `json:"threshold"`
+	Window      string           `json:"window"`
+	Since       time.Time        `json:"since"`
+	Reasons     map[string]int64 `json:"reasons"`
+	// AccessKeyIDs are the keys a client ip failed with, SourceIPs the clients failing with an access key id.
+	AccessKeyIDs []string `json:"accessKeyIds,omitempty"`
+	SourceIPs    []string `json:"sourceIps,omitempty"`
+}
+
+type alerts struct {
+	url             string
+	name            string
+	window          time.Duration
+	keyThreshold    int64
+	sourceThreshold int64
+
+	mu      sync.Mutex
+	windows map[string]*alertWindow
+}
+
+// alertWindow counts the failures of an access key id or a client ip since the start of the window.
+type alertWindow struct {
+	start    time.Time
+	failures int64
+	reasons  map[string]int64
+	distinct map[string]bool
+}
+
+func newAlerts(config *AlertsConfig, name string) (*alerts, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return nil, fmt.Errorf("invalid alerts `url`: %q", config.URL)
+	}
+	if config.KeyThreshold < 0 || config.SourceThreshold < 0 {
+		return nil, errors.New("the alerts `keyThreshold` and `sourceThreshold` can't be negative")
+	}
+	if config.KeyThreshold == 0 && config.SourceThreshold == 0 {
+		return nil, errors.New("the alerts need a `keyThreshold` or a `sourceThreshold`")
+	}
+	a := &alerts{
+		url:             config.URL,
+		name:            name,
+		window:          defaultAlertWindow,
+		keyThreshold:    config.KeyThreshold,
+		sourceThreshold: config.SourceThreshold,
+		windows:         map[string]*alertWindow{},
+	}
+	if config.Window != "" {
+		d, err := time.ParseDuration(config.Window)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid alerts `window` %q, eg: `5m`", config.Window)
+		}
+		a.window = d
+	}
+	return a, nil
+}
+
+// failed counts the failure of the claimed access key id, empty when the header couldn't be parsed, and of the client
+// ip, posting an alert when either reaches its threshold.
+func (a *alerts) failed(accessKeyID, ip, reason string, now time.Time) {
+	var events []alertEvent
+	a.mu.Lock()
+	a.sweep(now)
+	if accessKeyID != "" && a.keyThreshold > 0 {
+		if ev, id, ips, ok := a.count("key:", accessKeyID, ip, reason, a.keyThreshold, now); ok {
+			ev.AccessKeyID, ev.SourceIPs = id, ips
+			events = append(events, ev)
+		}
+	}
+	if a.sourceThreshold > 0 {
+		if ev, id, keys, ok := a.count("source:", ip, accessKeyID, reason, a.sourceThreshold, now); ok {
+			ev.SourceIP, ev.AccessKeyIDs = id, keys
+			events = append(events, ev)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, ev := range events {
+		go func(ev alertEvent) {
+			if err := postJSON(a.url, ev); err != nil {
+				logs.error("failed to post the alert", "accessKeyId", ev.AccessKeyID, "sourceIp", ev.SourceIP, "error", err)
+			}
+		}(ev)
+	}
+}
+
+// count adds the failure to the window of the id of the kind, and returns the alert with the id counted, and the
+// distinct other ids when the failures reach the threshold, eg: the client ips failing with an access key id.
+func (a *alerts) count(kind, id, other, reason string, threshold int64, now time.Time) (alertEvent, string, []string, bool) {
+	if _, ok := a.windows[kind+id]; !ok && len(a.windows) >= maxAlertWindows {
+		// Random access key ids don't grow the windows without limit.
+		id = alertOverflow
+	}
+	w, ok := a.windows[kind+id]
+	if !ok || now.Sub(w.start) >= a.window {
+		w = &alertWindow{start: now, reasons: map[string]int64{}, distinct: map[string]bool{}}
+		a.windows[kind+id] = w
+	}
+	w.failures++
+	w.reasons[reason]++
+	if other != "" && len(w.distinct) < maxAlertDistinct {
+		w.distinct[other] = true
+	}
+	if w.failures != threshold {
+		return alertEvent{}, "", nil, false
+	}
+	ev := alertEvent{
+		Event:      "auth.failures",
+		Middleware: a.name,
+		Time:       now.UTC(),
+		Failures:   w.failures,
+		Threshold:  threshold,
+		Window:     a.window.String(),
+		Since:      w.start.UTC(),
+		Reasons:    make(map[string]int64, len(w.reasons)),
+	}
+	for r, n := range w.reasons {
+		ev.Reasons[r] = n
+	}
+	others := make([]string, 0, len(w.distinct))
+	for o := range w.distinct {
+		others = append(others, o)
+	}
+	sort.Strings(others)
+	return ev, id, others, true
+}
+
+// sweep drops the windows that ended, once they are numerous.
+func (a *alerts) sweep(now time.Time) {
+	if len(a.windows) < 1024 {
+		return
+	}
+	for id, w := range a.windows {
+		if now.Sub(w.start) >= a.window {
+			delete(a.windows, id)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestAlerts(t *testing.T) {
+	events := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var ev map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&ev); err != nil {
+			t.Errorf("invalid alert: %v", err)
+		}
+		events <- ev
+	}))
+	defer srv.Close()
+
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.Alerts = &plugin.AlertsConfig{URL: srv.URL, KeyThreshold: 2, SourceThreshold: 3}
+	p := newTestPlugin(t, cfg)
+
+	wrong := validCredential()
+	wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+	unknown := validCredential()
+	unknown.AccessKeyID = "AKIAUNKNOWN"
+	// Unsigned requests never count, the others fail twice with the same key then once with an unknown one.
+	for _, c := range []*plugin.Credential{nil, wrong, wrong, unknown, wrong} {
+		req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+		if c != nil {
+			signRequest(t, req, c, p.Now())
+		}
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	got := map[string]map[string]interface{}{}
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-events:
+			if ev["accessKeyId"] != nil {
+				got["key"] = ev
+			} else {
+				got["source"] = ev
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected 2 alerts, got %d", i)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("expected a single alert per window, got %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	key := got["key"]
+	if key["event"] != "auth.failures" || key["accessKeyId"] != "ACCESS_ACCESS_ACCESS" || key["failures"] != float64(2) || key["middleware"] != "s3-plugin" {
+		t.Errorf("unexpected key alert: %v", key)
+	}
+	if reasons, _ := key["reasons"].(map[string]interface{}); reasons["signature_mismatch"] != float64(2) {
+		t.Errorf("expected 2 signature mismatches, got %v", key["reasons"])
+	}
+	source := got["source"]
+	if source["sourceIp"] != "192.0.2.1" || source["failures"] != float64(3) || source["window"] != "5m0s" {
+		t.Errorf("unexpected source alert: %v", source)
+	}
+	if keys, _ := source["accessKeyIds"].([]interface{}); len(keys) != 2 || keys[0] != "ACCESS_ACCESS_ACCESS" || keys[1] != "AKIAUNKNOWN" {
+		t.Errorf("expected the keys tried by the client, got %v", source["accessKeyIds"])
+	}
+}
+
+func TestAlertsOverflow(t *testing.T) {
+	events := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var ev map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&ev); err != nil {
+			t.Errorf("invalid alert: %v", err)
+		}
+		events <- ev
+	}))
+	defer srv.Close()
+
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.Alerts = &plugin.AlertsConfig{URL: srv.URL, KeyThreshold: 2}
+	p := newTestPlugin(t, cfg)
+
+	// Past 4096 windows, the random access key ids share a single one.
+	for i := 0; i < 4096+2; i++ {
+		unknown := validCredential()
+		unknown.AccessKeyID = fmt.Sprintf("AKIARANDOM%06d", i)
+		req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+		signRequest(t, req, unknown, p.Now())
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	select {
+	case ev := <-events:
+		if ev["accessKeyId"] != "*" || ev["failures"] != float64(2) {
+			t.Errorf("unexpected alert: %v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the shared window to alert")
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("expected a single alert, got %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestInvalidAlerts(t *testing.T) {
+	tc := []struct {
+		name     string
+		alerts   *plugin.AlertsConfig
+		expected string
+	}{
+		{name: "url", alerts: &plugin.AlertsConfig{URL: "ftp://example.com", KeyThreshold: 1}, expected: "`url`"},
+		{name: "thresholds", alerts: &plugin.AlertsConfig{URL: "https://example.com"}, expected: "`keyThreshold` or a `sourceThreshold`"},
+		{name: "window", alerts: &plugin.AlertsConfig{URL: "https://example.com", KeyThreshold: 1, Window: "1ms"}, expected: "`window`"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.Alerts = tt.alerts
+			_, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin")
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected an error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultAuditQueueSize = 1024
+	defaultAuditTimeout   = 5 * time.Second
+)
+
+// AuditConfig mirrors the validated requests to an audit sink, eg: for compliance recording of who wrote what. The
+// records are sent asynchronously, so the sink never slows the requests down.
+type AuditConfig struct {
+	// URL receiving a `POST` with a JSON record of every mirrored request.
+	URL string `json:"url,omitempty"`
+	// Headers are extra request headers, eg: a bearer token.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Methods restricts the mirrored requests, eg: `PUT`, `POST` and `DELETE`. Every request is mirrored by default.
+	Methods []string `json:"methods,omitempty"`
+	// MaxBodyBytes includes up to this many bytes of the request bodies in the records, disabled by default.
+	MaxBodyBytes int64 `json:"maxBodyBytes,omitempty"`
+	// QueueSize is how many records can wait for the sink, defaults to 1024. Records are dropped once it is full.
+	QueueSize int `json:"queueSize,omitempty"`
+	// Timeout bounds the delivery of each record, defaults to `5s`.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// auditRecord describes a validated request, never including its credentials.
+type auditRecord struct {
+	Time          time.Time           `json:"time"`
+	RequestID     string              `json:"requestId,omitempty"`
+	CorrelationID string              `json:"correlationId,omitempty"`
+	AccessKeyID   string              `json:"accessKeyId"`
+	User          string              `json:"user"`
+	Tenant        string              `json:"tenant,omitempty"`
+	Operation     string              `json:"operation"`
+	Bucket        string              `json:"bucket,omitempty"`
+	Key           string              `json:"key,omitempty"`
+	Method        string              `json:"method"`
+	Path          string              `json:"path"`
+	Query         string              `json:"query,omitempty"`
+	SourceIP      string              `json:"sourceIp"`
+	Status        int                 `json:"status"`
+	Headers       map[string][]string `json:"headers"`
+	Body          []byte              `json:"body,omitempty"`
+	BodyTruncated bool                `json:"bodyTruncated,omitempty"`
+}
+
+type auditor struct {
+	url     string
+	headers map[string]string
+	methods []string
+	maxBody int64
+	client  *http.Client
+	queue   chan *auditRecord
+	dropped uint64
+}
+
+func newAuditor(config *AuditConfig) (*auditor, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return nil, fmt.Errorf("invalid audit `url`: %q", config.URL)
+	}
+	if config.MaxBodyBytes < 0 || config.QueueSize < 0 {
+		return nil, errors.New("the audit `maxBodyBytes` and `queueSize` must not be negative")
+	}
+	timeout := defaultAuditTimeout
+	if config.Timeout != "" {
+		d, err := time.ParseDuration(config.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid audit `timeout` %q, eg: `5s`", config.Timeout)
+		}
+		timeout = d
+	}
+	size := config.QueueSize
+	if size == 0 {
+		size = defaultAuditQueueSize
+	}
+	a := &auditor{
+		url:     config.URL,
+		headers: config.Headers,
+		methods: config.Methods,
+		maxBody: config.MaxBodyBytes,
+		client:  &http.Client{Timeout: timeout},
+		queue:   make(chan *auditRecord, size),
+	}
+	go a.run()
+	return a, nil
+}
+
+// covers reports whether the requests of the method are mirrored.
+func (a *auditor) covers(method string) bool {
+	if len(a.methods) == 0 {
+		return true
+	}
+	for _, m := range a.methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// record describes the request before it is rewritten for the backend, without the credential headers.
+func (a *auditor) record(req *http.Request, cred *Credential, user string, op s3Operation, res s3Resource, ip string, secret []string, now time.Time) *auditRecord {
+	headers := req.Header.Clone()
+	for _, h := range secret {
+		headers.Del(h)
+	}
+	for _, h := range []string{"Authorization", "X-Amz-Security-Token", "Cookie"} {
+		headers.Del(h)
+	}
+	return &auditRecord{
+		Time:          now.UTC(),
+		RequestID:     req.Header.Get(requestIDHeader),
+		CorrelationID: correlationID(req),
+		AccessKeyID:   cred.AccessKeyID,
+		User:          user,
+		Tenant:        cred.Tenant,
+		Operation:     op.Name,
+		Bucket:        res.Bucket,
+		Key:           res.Key,
+		Method:        req.Method,
+		Path:          req.URL.Path,
+		Query:         req.URL.RawQuery,
+		SourceIP:      ip,
+		Headers:       headers,
+	}
+}
+
+// enqueue hands the record to the sink, dropping it when the queue is full.
+func (a *auditor) enqueue(r *auditRecord) {
+	select {
+	case a.queue <- r:
+	default:
+		n := atomic.AddUint64(&a.dropped, 1)
+		logs.warn("audit queue is full, dropped the record", "accessKeyId", r.AccessKeyID, "dropped", n)
+	}
+}
+
+func (a *auditor) run() {
+	for r := range a.queue {
+		if err := a.send(r); err != nil {
+			logs.error("failed to send the audit record", "accessKeyId", r.AccessKeyID, "error", err)
+		}
+	}
+}
+
+func (a *auditor) send(r *auditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, a.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range a.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxSourceBytes))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// auditedBody keeps the first bytes of the body as the backend reads it.
+type auditedBody struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (b *auditedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if left := b.max - int64(b.buf.Len()); left > 0 {
+		if int64(n) > left {
+			b.buf.Write(p[:left])
+			b.truncated = true
+		} else {
+			b.buf.Write(p[:n])
+		}
+	} else if n > 0 {
+		b.truncated = true
+	}
+	return n, err
+}
@@ -0,0 +1,85 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+type auditRecord struct {
+	AccessKeyID   string              `json:"accessKeyId"`
+	Operation     string              `json:"operation"`
+	Bucket        string              `json:"bucket"`
+	Key           string              `json:"key"`
+	Status        int                 `json:"status"`
+	Headers       map[string][]string `json:"headers"`
+	Body          []byte              `json:"body"`
+	BodyTruncated bool                `json:"bodyTruncated"`
+}
+
+func TestAudit(t *testing.T) {
+	records := make(chan auditRecord, 10)
+	sink := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var r auditRecord
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			t.Error(err)
+		}
+		if req.Header.Get("X-Audit-Token") != "secret" {
+			t.Errorf("expected the audit headers, got %v", req.Header)
+		}
+		records <- r
+	}))
+	defer sink.Close()
+
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.Audit = &plugin.AuditConfig{URL: sink.URL, Headers: map[string]string{"X-Audit-Token": "secret"}, Methods: []string{"PUT"}, MaxBodyBytes: 5}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.Copy(io.Discard, req.Body)
+		rw.WriteHeader(http.StatusCreated)
+	})
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+	p.Now = func() time.Time { return now }
+
+	get := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/skipped.txt", nil)
+	signRequest(t, get, cred, now)
+	p.ServeHTTP(httptest.NewRecorder(), get)
+
+	put := httptest.NewRequest(http.MethodPut, "https://s3.example.com/bucket/reports/2025.csv", strings.NewReader("hello world"))
+	put.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	signRequest(t, put, cred, now)
+	p.ServeHTTP(httptest.NewRecorder(), put)
+
+	select {
+	case r := <-records:
+		if r.AccessKeyID != cred.AccessKeyID || r.Operation != "PutObject" || r.Bucket != "bucket" || r.Key != "reports/2025.csv" || r.Status != http.StatusCreated {
+			t.Errorf("unexpected record: %+v", r)
+		}
+		if string(r.Body) != "hello" || !r.BodyTruncated {
+			t.Errorf("expected the truncated body, got %q, truncated: %t", r.Body, r.BodyTruncated)
+		}
+		if _, ok := r.Headers["Authorization"]; ok || len(r.Headers["X-Amz-Date"]) == 0 {
+			t.Errorf("expected the headers without the credentials, got %v", r.Headers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an audit record")
+	}
+	select {
+	case r := <-records:
+		t.Errorf("expected a single audit record, got %+v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,132 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultAuthWebhookTimeout = time.Second
+
+// AuthWebhookConfig asks an external service to authorize every validated request.
+type AuthWebhookConfig struct {
+	// URL receiving a `POST` with the same JSON document as OPA's input. A `200` allows the request, unless the body is
+	// `{"allow": false}`, and any other status code denies it.
+	URL string `json:"url,omitempty"`
+	// Headers are extra request headers, eg: a bearer token.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout bounds each decision, defaults to `1s`.
+	Timeout string `json:"timeout,omitempty"`
+	// CacheTTL caches the decisions of identical requests, eg: `30s`. Disabled by default.
+	CacheTTL string `json:"cacheTtl,omitempty"`
+	// FailOpen lets requests through when the webhook is unreachable or fails, they are rejected by default.
+	FailOpen bool `json:"failOpen,omitempty"`
+}
+
+type authWebhook struct {
+	url      string
+	headers  map[string]string
+	failOpen bool
+	client   *http.Client
+	cache    *decisionCache
+}
+
+func newAuthWebhook(config *AuthWebhookConfig) (*authWebhook, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return nil, fmt.Errorf("invalid auth webhook `url`: %q", config.URL)
+	}
+	timeout := defaultAuthWebhookTimeout
+	if config.Timeout != "" {
+		d, err := time.ParseDuration(config.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid auth webhook `timeout` %q, eg: `1s`", config.Timeout)
+		}
+		timeout = d
+	}
+	cache, err := newDecisionCache(config.CacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth webhook `cacheTtl` %q, eg: `30s`", config.CacheTTL)
+	}
+	return &authWebhook{url: config.URL, headers: config.Headers, failOpen: config.FailOpen, client: &http.Client{Timeout: timeout}, cache: cache}, nil
+}
+
+// decide asks the auth webhook whether the request is allowed. Errors wrap errDecisionUnavailable unless it fails open.
+func (w *authWebhook) decide(ctx context.Context, input opaInput, now time.Time) error {
+	key, err := w.cache.key(input)
+	if err != nil {
+		return err
+	}
+	reason, ok := w.cache.get(key, now)
+	if !ok {
+		if reason, err = w.call(ctx, input); err != nil {
+			if w.failOpen {
+				logs.warn("auth webhook is unavailable, failing open", "error", err)
+				return nil
+			}
+			return fmt.Errorf("%w: %s", errDecisionUnavailable, err.Error())
+		}
+		w.cache.put(key, reason, now)
+	}
+	if reason != "" {
+		return fmt.Errorf("denied by the auth webhook: %s", reason)
+	}
+	return nil
+}
+
+// call returns the reason for denying the request, if any. Server errors fail like an unreachable webhook.
+func (w *authWebhook) call(ctx context.Context, input opaInput) (string, error) {
+	b, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("status code %d", resp.StatusCode), nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceBytes))
+	if err != nil {
+		return "", err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "", nil
+	}
+	var out struct {
+		Allow  *bool  `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", errors.New("invalid auth webhook response")
+	}
+	if out.Allow != nil && !*out.Allow {
+		if out.Reason == "" {
+			out.Reason = "not allowed"
+		}
+		return out.Reason, nil
+	}
+	return "", nil
+}
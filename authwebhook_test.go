@@ -0,0 +1,118 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestAuthWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var input map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil || input["accessKeyId"] == nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch input["bucket"] {
+		case "allowed":
+		case "body":
+			_, _ = rw.Write([]byte(`{"allow": false, "reason": "bucket is frozen"}`))
+		case "forbidden":
+			rw.WriteHeader(http.StatusForbidden)
+		default:
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	tc := []struct {
+		name           string
+		target         string
+		failOpen       bool
+		expectedStatus int
+	}{
+		{
+			name:           "allowed",
+			target:         "/allowed/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "denied by body",
+			target:         "/body/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "denied by status code",
+			target:         "/forbidden/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unavailable",
+			target:         "/broken/object.txt",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "unavailable failing open",
+			target:         "/broken/object.txt",
+			failOpen:       true,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.AuthWebhook = &plugin.AuthWebhookConfig{URL: server.URL, FailOpen: tt.failOpen}
+			p := newTestPlugin(t, cfg)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, http.MethodGet, tt.target, cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestAuthWebhookCache(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.AuthWebhook = &plugin.AuthWebhookConfig{URL: server.URL, CacheTTL: "1m"}
+	p := newTestPlugin(t, cfg)
+
+	for _, target := range []string{"/bucket/a.txt", "/bucket/a.txt", "/bucket/b.txt"} {
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, newSignedRequest(t, http.MethodGet, target, cred))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("expected 2 webhook calls, got %d", got)
+	}
+}
+
+func TestInvalidAuthWebhook(t *testing.T) {
+	for _, c := range []*plugin.AuthWebhookConfig{{URL: "authz:8080"}, {URL: "http://authz", Timeout: "soon"}, {URL: "http://authz", CacheTTL: "-1s"}} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.AuthWebhook = c
+		if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "auth webhook") {
+			t.Errorf("expected an auth webhook error, got %v", err)
+		}
+	}
+}
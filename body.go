@@ -0,0 +1,114 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+const (
+	// defaultMaxBufferedBody bounds the bodies hashed in memory, eg: STS calls.
+	defaultMaxBufferedBody = 1 << 20
+	// defaultMaxSpilledBody is the largest S3 `PutObject`.
+	defaultMaxSpilledBody = 5 << 30
+)
+
+// bodyBuffer holds the bodies of requests without `x-amz-content-sha256` while hashing them, in memory up to
+// maxBuffered bytes and then in a temporary file of spillDir, when set.
+type bodyBuffer struct {
+	maxBuffered int64
+	maxSpilled  int64
+	spillDir    string
+}
+
+func newBodyBuffer(config *Config) (*bodyBuffer, error) {
+	b := &bodyBuffer{maxBuffered: config.MaxBufferedBodyBytes, maxSpilled: config.MaxSpilledBodyBytes, spillDir: config.BodySpillDir}
+	if b.maxBuffered == 0 {
+		b.maxBuffered = defaultMaxBufferedBody
+	}
+	if b.maxSpilled == 0 {
+		b.maxSpilled = defaultMaxSpilledBody
+	}
+	if b.maxBuffered < 0 || b.maxSpilled < 0 {
+		return nil, errors.New("`maxBufferedBodyBytes` and `maxSpilledBodyBytes` must not be negative")
+	}
+	if b.spillDir != "" {
+		if fi, err := os.Stat(b.spillDir); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("invalid `bodySpillDir` %q, it must be an existing directory", b.spillDir)
+		}
+	}
+	return b, nil
+}
+
+// hash hashes the request body and puts it back so it can still be read downstream. Bodies spilled to a temporary
+// file are removed once closed.
+func (b *bodyBuffer) hash(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return emptyHash, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(req.Body, b.maxBuffered+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(buf)) <= b.maxBuffered {
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(buf))
+		sum := sha256.Sum256(buf)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	if b.spillDir == "" {
+		return "", fmt.Errorf("body is larger than %d bytes", b.maxBuffered)
+	}
+	return b.spill(req, buf)
+}
+
+func (b *bodyBuffer) spill(req *http.Request, buf []byte) (string, error) {
+	f, err := os.CreateTemp(b.spillDir, "s3auth-body-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to spill the body: %w", err)
+	}
+	body := &spilledBody{File: f}
+	h := sha256.New()
+	w := io.MultiWriter(f, h)
+	n, err := w.Write(buf)
+	if err == nil {
+		var m int64
+		m, err = io.Copy(w, io.LimitReader(req.Body, b.maxSpilled-int64(n)+1))
+		if err == nil && int64(n)+m > b.maxSpilled {
+			err = fmt.Errorf("body is larger than %d bytes", b.maxSpilled)
+		}
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = body.Close()
+		return "", err
+	}
+	_ = req.Body.Close()
+	req.Body = body
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// spilledBody replays a body from its temporary file, removing it when closed.
+type spilledBody struct {
+	*os.File
+	once sync.Once
+}
+
+func (s *spilledBody) Close() error {
+	var err error
+	s.once.Do(func() {
+		err = s.File.Close()
+		if rerr := os.Remove(s.File.Name()); err == nil {
+			err = rerr
+		}
+	})
+	return err
+}
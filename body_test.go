@@ -0,0 +1,102 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestBodyBuffering(t *testing.T) {
+	tc := []struct {
+		name           string
+		size           int
+		spill          bool
+		expectedStatus int
+	}{
+		{
+			name:           "buffered",
+			size:           64,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "too large to buffer",
+			size:           200,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "spilled",
+			size:           200,
+			spill:          true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "too large to spill",
+			size:           2000,
+			spill:          true,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cred := validCredential()
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.MaxBufferedBodyBytes = 100
+			cfg.MaxSpilledBodyBytes = 1000
+			if tt.spill {
+				cfg.BodySpillDir = dir
+			}
+
+			var forwarded string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				b, err := io.ReadAll(req.Body)
+				if err != nil {
+					t.Error(err)
+				}
+				forwarded = string(b)
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+			p.Now = func() time.Time { return now }
+
+			body := strings.Repeat("x", tt.size)
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPut, "https://s3.example.com/bucket/key", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signRequest(t, req, cred, now)
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus == http.StatusOK && forwarded != body {
+				t.Errorf("expected the body to be forwarded, got %d bytes", len(forwarded))
+			}
+			if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+				t.Errorf("expected the spilled bodies to be removed, got %v", entries)
+			}
+		})
+	}
+}
+
+func TestInvalidBodyBuffering(t *testing.T) {
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.BodySpillDir = "/nonexistent/s3auth"
+	if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "bodySpillDir") {
+		t.Errorf("expected a bodySpillDir error, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// bucketMapping is the backend bucket, and optional key prefix, a logical bucket is stored in.
+type bucketMapping struct {
+	bucket string
+	prefix string
+}
+
+// compileBucketMappings parses the `logical: bucket[/prefix/]` entries, eg: `photos: media-eu/photos/`.
+func compileBucketMappings(mappings map[string]string) (map[string]bucketMapping, error) {
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]bucketMapping, len(mappings))
+	for logical, target := range mappings {
+		if logical == "" || strings.ContainsAny(logical, "/*? ") {
+			return nil, fmt.Errorf("invalid logical bucket %q", logical)
+		}
+		bucket, prefix, _ := strings.Cut(target, "/")
+		if bucket == "" || strings.ContainsAny(target, "*? ") || (prefix != "" && !strings.HasSuffix(prefix, "/")) ||
+			strings.Contains(prefix, "//") {
+			return nil, fmt.Errorf("invalid target %q of bucket %q, eg: `media-eu` or `media-eu/photos/`", target, logical)
+		}
+		out[strings.ToLower(logical)] = bucketMapping{bucket: bucket, prefix: prefix}
+	}
+	return out, nil
+}
+
+// mapBucket points a validated request at the backend bucket of its logical bucket, keeping the addressing style of
+// the client. With a prefix, bucket requests other than listings, `HeadBucket` and `GetBucketLocation` are denied
+// since they would affect the whole backend bucket.
+func mapBucket(req *http.Request, res s3Resource, op s3Operation, mappings map[string]bucketMapping, domains []string) error {
+	if err := mapCopySource(req, mappings); err != nil {
+		return err
+	}
+	m, ok := mappings[strings.ToLower(res.Bucket)]
+	if !ok {
+		return nil
+	}
+	switch {
+	case m.prefix == "" || res.Key != "":
+	case listings[op.Name]:
+		q := req.URL.Query()
+		q.Set("prefix", m.prefix+q.Get("prefix"))
+		req.URL.RawQuery = q.Encode()
+	case op.Name == "DeleteObjects":
+		// Rewrites the keys of the body the same way injecting tenants does.
+		if err := (&tenancy{inject: true}).applyDelete(req, m.prefix); err != nil {
+			return err
+		}
+	case op.Name == "HeadBucket" || op.Name == "GetBucketLocation":
+	default:
+		return fmt.Errorf("operation %s is not allowed on the mapped bucket %q", op.Name, res.Bucket)
+	}
+	key := res.Key
+	if key != "" {
+		key = m.prefix + key
+	}
+	if _, ok := virtualHostBucket(req, domains); ok {
+		host := m.bucket + req.Host[len(res.Bucket):]
+		req.Host = host
+		req.URL.Host = host
+		req.URL.Path = "/" + key
+	} else {
+		p := "/" + m.bucket
+		if key != "" || strings.HasSuffix(req.URL.Path, "/") {
+			p += "/" + key
+		}
+		req.URL.Path = p
+	}
+	req.URL.RawPath = ""
+	return nil
+}
+
+// mapCopySource maps the bucket of the `x-amz-copy-source` of copies, eg: `/photos/cat.jpg?versionId=1`.
+func mapCopySource(req *http.Request, mappings map[string]bucketMapping) error {
+	h := req.Header.Get("X-Amz-Copy-Source")
+	if h == "" {
+		return nil
+	}
+	src, version, hasVersion := strings.Cut(strings.TrimPrefix(h, "/"), "?")
+	src, err := url.PathUnescape(src)
+	if err != nil {
+		return errors.New("invalid copy source")
+	}
+	bucket, key, _ := strings.Cut(src, "/")
+	m, ok := mappings[strings.ToLower(bucket)]
+	if !ok {
+		return nil
+	}
+	h = (&url.URL{Path: "/" + m.bucket + "/" + m.prefix + key}).EscapedPath()
+	if hasVersion {
+		h += "?" + version
+	}
+	req.Header.Set("X-Amz-Copy-Source", h)
+	return nil
+}
@@ -0,0 +1,154 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestBucketMappings(t *testing.T) {
+	tc := []struct {
+		name               string
+		method             string
+		host               string
+		path               string
+		copySource         string
+		backendHost        string
+		expectedStatus     int
+		expectedHost       string
+		expectedURI        string
+		expectedCopySource string
+	}{
+		{
+			name:           "path-style object",
+			method:         http.MethodGet,
+			host:           "s3.example.com",
+			path:           "/photos/2025/cat.jpg",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "s3.example.com",
+			expectedURI:    "/media-eu/photos/2025/cat.jpg",
+		},
+		{
+			name:           "virtual-host-style object",
+			method:         http.MethodGet,
+			host:           "photos.s3.example.com",
+			path:           "/2025/cat.jpg",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "media-eu.s3.example.com",
+			expectedURI:    "/photos/2025/cat.jpg",
+		},
+		{
+			name:           "listing",
+			method:         http.MethodGet,
+			host:           "s3.example.com",
+			path:           "/photos?list-type=2&prefix=2025%2F",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "s3.example.com",
+			expectedURI:    "/media-eu?list-type=2&prefix=photos%2F2025%2F",
+		},
+		{
+			name:               "copy",
+			method:             http.MethodPut,
+			host:               "s3.example.com",
+			path:               "/archive/cat.jpg",
+			copySource:         "/photos/2025/cat.jpg?versionId=1",
+			expectedStatus:     http.StatusOK,
+			expectedHost:       "s3.example.com",
+			expectedURI:        "/cold/cat.jpg",
+			expectedCopySource: "/media-eu/photos/2025/cat.jpg?versionId=1",
+		},
+		{
+			name:           "whole bucket",
+			method:         http.MethodPut,
+			host:           "s3.example.com",
+			path:           "/archive?policy",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "s3.example.com",
+			expectedURI:    "/cold?policy",
+		},
+		{
+			name:           "prefixed bucket",
+			method:         http.MethodPut,
+			host:           "s3.example.com",
+			path:           "/photos?policy",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unmapped",
+			method:         http.MethodGet,
+			host:           "s3.example.com",
+			path:           "/other/cat.jpg",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "s3.example.com",
+			expectedURI:    "/other/cat.jpg",
+		},
+		{
+			name:           "backend host",
+			method:         http.MethodGet,
+			host:           "photos.s3.example.com",
+			path:           "/2025/cat.jpg",
+			backendHost:    "minio.internal:9000",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "minio.internal:9000",
+			expectedURI:    "/media-eu/photos/2025/cat.jpg",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.VirtualHostDomains = []string{"s3.example.com"}
+			cfg.BackendHost = tt.backendHost
+			cfg.BucketMappings = map[string]string{"photos": "media-eu/photos/", "archive": "cold"}
+
+			var forwarded *http.Request
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+			p.Now = func() time.Time { return now }
+
+			req := httptest.NewRequest(tt.method, "https://"+tt.host+tt.path, nil)
+			if tt.copySource != "" {
+				req.Header.Set("X-Amz-Copy-Source", tt.copySource)
+			}
+			signRequest(t, req, cred, now)
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if forwarded.Host != tt.expectedHost || forwarded.URL.RequestURI() != tt.expectedURI {
+				t.Errorf("expected %s%s, got %s%s", tt.expectedHost, tt.expectedURI, forwarded.Host, forwarded.URL.RequestURI())
+			}
+			if got := forwarded.Header.Get("X-Amz-Copy-Source"); got != tt.expectedCopySource {
+				t.Errorf("expected copy source %q, got %q", tt.expectedCopySource, got)
+			}
+		})
+	}
+}
+
+func TestInvalidBucketMappings(t *testing.T) {
+	for _, target := range []string{"", "media-eu/photos", "media-eu/*"} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.BucketMappings = map[string]string{"photos": target}
+		if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "bucketMappings") {
+			t.Errorf("expected a bucketMappings error for %q, got %v", target, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package traefik_plugin_s3_auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// canonicalField is a component of the canonical request, as built by the middleware and as the client implies it.
+type canonicalField struct {
+	name   string
+	plugin string
+	client string
+}
+
+// canonicalDiff compares the canonical request the middleware built with the one implied by the request as received,
+// following the SigV4 rules the SDKs implement: URI-encoded paths and queries, trimmed header values and the signed
+// headers in the order of the authorization header. Only the differing components are returned, the session token
+// is never included.
+func canonicalDiff(s *s3request, req *http.Request, a authorization) []canonicalField {
+	var diff []canonicalField
+	add := func(name, plugin, client string) {
+		if plugin != client {
+			diff = append(diff, canonicalField{name: name, plugin: plugin, client: client})
+		}
+	}
+	add("method", s.method, req.Method)
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	add("path", s.uri, awsEncode(path, false))
+	add("query", canonString(s.queryParams, "=", "&", true), canonicalQuery(req))
+	for _, name := range a.SignedHeaders {
+		v, _ := resolveValue(name, req)
+		if values := req.Header.Values(name); len(values) > 0 {
+			trimmed := make([]string, 0, len(values))
+			for _, v := range values {
+				trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+			}
+			v = strings.Join(trimmed, ",")
+		}
+		plugin := s.signedHeaders[strings.ToLower(name)]
+		if strings.EqualFold(name, "x-amz-security-token") && plugin != v {
+			diff = append(diff, canonicalField{name: "header:x-amz-security-token", plugin: redacted, client: redacted})
+			continue
+		}
+		add("header:"+strings.ToLower(name), plugin, v)
+	}
+	add("signedHeaders", strings.Join(sortedKeys(s.signedHeaders), ";"), strings.Join(a.SignedHeaders, ";"))
+	if h := req.Header.Get("X-Amz-Content-Sha256"); h != "" {
+		add("payloadHash", s.payloadHash, h)
+	}
+	return diff
+}
@@ -0,0 +1,365 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// CedarConfig authorizes requests with Cedar policies, an alternative to the JSON policy documents.
+// https://docs.cedarpolicy.com/policies/syntax-policy.html
+type CedarConfig struct {
+	// Policies is the Cedar source, eg: `permit (principal in S3Auth::Group::"etl", action, resource in S3::Bucket::"logs");`.
+	Policies string `json:"policies,omitempty"`
+	// Path of a file with the policies, a leading `~/` is expanded to the home directory.
+	Path string `json:"path,omitempty"`
+}
+
+// Entity types of principals, actions and resources.
+const (
+	cedarAccessKey = "S3Auth::AccessKey"
+	cedarGroup     = "S3Auth::Group"
+	cedarRole      = "S3Auth::Role"
+	cedarAction    = "S3::Action"
+	cedarBucket    = "S3::Bucket"
+	cedarObject    = "S3::Object"
+)
+
+type cedarEntity struct {
+	typ string
+	id  string
+}
+
+// cedarConstraint is the scope constraint on the principal, action or resource. An empty op matches everything.
+type cedarConstraint struct {
+	op       string
+	entities []cedarEntity
+}
+
+type cedarPolicy struct {
+	permit    bool
+	principal cedarConstraint
+	action    cedarConstraint
+	resource  cedarConstraint
+}
+
+// cedarRequest is the principal, action and resource of a request.
+type cedarRequest struct {
+	cred   *Credential
+	action string
+	res    s3Resource
+}
+
+func loadCedar(config *CedarConfig) ([]cedarPolicy, error) {
+	if config == nil {
+		return nil, nil
+	}
+	src := config.Policies
+	if config.Path != "" {
+		b, err := readSourceFile(config.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the cedar policies: %w", err)
+		}
+		src += "\n" + string(b)
+	}
+	policies, err := parseCedar(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cedar policies: %w", err)
+	}
+	if len(policies) == 0 {
+		return nil, errors.New("must specify at least one cedar policy")
+	}
+	return policies, nil
+}
+
+// evaluateCedar applies the Cedar semantics: a matching `forbid` wins, otherwise a matching `permit` is required.
+func evaluateCedar(policies []cedarPolicy, r cedarRequest) error {
+	permitted := false
+	for i, p := range policies {
+		if !p.principal.matchPrincipal(r.cred) || !p.action.matchAction(r.action) || !p.resource.matchResource(r.res) {
+			continue
+		}
+		if !p.permit {
+			return fmt.Errorf("forbidden by cedar policy %d", i)
+		}
+		permitted = true
+	}
+	if !permitted {
+		return errors.New("not permitted by any cedar policy")
+	}
+	return nil
+}
+
+func (c cedarConstraint) matchPrincipal(cred *Credential) bool {
+	if c.op == "" {
+		return true
+	}
+	for _, e := range c.entities {
+		switch {
+		case e.typ == cedarAccessKey:
+			// Temporary credentials act as the access key that issued them.
+			if e.id == cred.AccessKeyID || (cred.parent != "" && e.id == cred.parent) {
+				return true
+			}
+		case c.op == "in" && e.typ == cedarGroup:
+			if contains(cred.Groups, e.id) {
+				return true
+			}
+		case c.op == "in" && e.typ == cedarRole:
+			if contains(cred.Roles, e.id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c cedarConstraint) matchAction(action string) bool {
+	if c.op == "" {
+		return true
+	}
+	for _, e := range c.entities {
+		if e.typ == cedarAction && "s3:"+e.id == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (c cedarConstraint) matchResource(res s3Resource) bool {
+	if c.op == "" {
+		return true
+	}
+	for _, e := range c.entities {
+		switch e.typ {
+		case cedarBucket:
+			// Objects are in their bucket.
+			if e.id == res.Bucket && (res.Key == "" || c.op == "in") {
+				return true
+			}
+		case cedarObject:
+			if res.Key != "" && e.id == res.Bucket+"/"+res.Key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// cedarParser parses the scope of Cedar policies. Conditions (`when` and `unless`) are not supported.
+type cedarParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseCedar(src string) ([]cedarPolicy, error) {
+	tokens, err := tokenizeCedar(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &cedarParser{tokens: tokens}
+	var policies []cedarPolicy
+	for p.pos < len(p.tokens) {
+		policy, err := p.policy()
+		if err != nil {
+			return nil, fmt.Errorf("policy %d: %w", len(policies), err)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+func (p *cedarParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *cedarParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *cedarParser) expect(want string) error {
+	if got := p.next(); got != want {
+		return fmt.Errorf("expected %q, got %q", want, got)
+	}
+	return nil
+}
+
+func (p *cedarParser) policy() (cedarPolicy, error) {
+	var policy cedarPolicy
+	// Annotations, eg: `@id("etl")`, are ignored.
+	for p.peek() == "@" {
+		p.pos++
+		if name := p.next(); !isCedarIdent(name) {
+			return policy, fmt.Errorf("invalid annotation: %q", name)
+		}
+		if err := p.expect("("); err != nil {
+			return policy, err
+		}
+		if v := p.next(); !strings.HasPrefix(v, `"`) {
+			return policy, fmt.Errorf("expected an annotation value, got %q", v)
+		}
+		if err := p.expect(")"); err != nil {
+			return policy, err
+		}
+	}
+	switch effect := p.next(); effect {
+	case "permit":
+		policy.permit = true
+	case "forbid":
+	default:
+		return policy, fmt.Errorf("expected `permit` or `forbid`, got %q", effect)
+	}
+	if err := p.expect("("); err != nil {
+		return policy, err
+	}
+	var err error
+	if policy.principal, err = p.constraint("principal", false); err != nil {
+		return policy, err
+	}
+	if err := p.expect(","); err != nil {
+		return policy, err
+	}
+	if policy.action, err = p.constraint("action", true); err != nil {
+		return policy, err
+	}
+	if err := p.expect(","); err != nil {
+		return policy, err
+	}
+	if policy.resource, err = p.constraint("resource", false); err != nil {
+		return policy, err
+	}
+	if err := p.expect(")"); err != nil {
+		return policy, err
+	}
+	if t := p.peek(); t == "when" || t == "unless" {
+		return policy, fmt.Errorf("`%s` conditions are not supported", t)
+	}
+	return policy, p.expect(";")
+}
+
+func (p *cedarParser) constraint(variable string, list bool) (cedarConstraint, error) {
+	var c cedarConstraint
+	if err := p.expect(variable); err != nil {
+		return c, err
+	}
+	if t := p.peek(); t != "==" && t != "in" {
+		return c, nil
+	}
+	c.op = p.next()
+	if list && c.op == "in" && p.peek() == "[" {
+		p.pos++
+		for {
+			e, err := p.entity()
+			if err != nil {
+				return c, err
+			}
+			c.entities = append(c.entities, e)
+			if p.peek() != "," {
+				break
+			}
+			p.pos++
+		}
+		return c, p.expect("]")
+	}
+	e, err := p.entity()
+	if err != nil {
+		return c, err
+	}
+	c.entities = append(c.entities, e)
+	return c, nil
+}
+
+func (p *cedarParser) entity() (cedarEntity, error) {
+	var parts []string
+	for {
+		t := p.next()
+		if strings.HasPrefix(t, `"`) {
+			e := cedarEntity{typ: strings.Join(parts, "::"), id: t[1:]}
+			switch e.typ {
+			case cedarAccessKey, cedarGroup, cedarRole, cedarAction, cedarBucket, cedarObject:
+				return e, nil
+			default:
+				return e, fmt.Errorf("unsupported entity type: %q", e.typ)
+			}
+		}
+		if t == "" || !isCedarIdent(t) {
+			return cedarEntity{}, fmt.Errorf("expected an entity, got %q", t)
+		}
+		parts = append(parts, t)
+		if err := p.expect("::"); err != nil {
+			return cedarEntity{}, err
+		}
+	}
+}
+
+func isCedarIdent(t string) bool {
+	for i, r := range t {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenizeCedar splits the source into identifiers, operators and strings. Strings keep their opening quote so they
+// can't be confused with identifiers, eg: `"permit`.
+func tokenizeCedar(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "::") || strings.HasPrefix(src[i:], "=="):
+			tokens = append(tokens, src[i:i+2])
+			i += 2
+		case strings.ContainsRune("(),;[]@", rune(c)):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			var b strings.Builder
+			b.WriteByte('"')
+			i++
+			for ; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				b.WriteByte(src[i])
+			}
+			if i >= len(src) {
+				return nil, errors.New("unterminated string")
+			}
+			i++
+			tokens = append(tokens, b.String())
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
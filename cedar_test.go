@@ -0,0 +1,102 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestCedar(t *testing.T) {
+	policies := `
+// Readers can list and read the logs.
+@id("readers")
+permit (
+  principal in S3Auth::Role::"reader",
+  action in [S3::Action::"GetObject", S3::Action::"ListBucket"],
+  resource in S3::Bucket::"logs"
+);
+permit (principal == S3Auth::AccessKey::"` + validCredential().AccessKeyID + `", action == S3::Action::"PutObject", resource == S3::Object::"logs/upload.txt");
+forbid (principal, action, resource == S3::Object::"logs/secret.txt");
+`
+	tc := []struct {
+		name           string
+		method         string
+		target         string
+		expectedStatus int
+	}{
+		{
+			name:           "permitted object",
+			method:         http.MethodGet,
+			target:         "/logs/app.log",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "permitted bucket",
+			method:         http.MethodGet,
+			target:         "/logs",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "permitted access key",
+			method:         http.MethodPut,
+			target:         "/logs/upload.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "not permitted action",
+			method:         http.MethodPut,
+			target:         "/logs/app.log",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "not permitted bucket",
+			method:         http.MethodGet,
+			target:         "/other/app.log",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "forbidden",
+			method:         http.MethodGet,
+			target:         "/logs/secret.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Roles = []string{"reader"}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.Cedar = &plugin.CedarConfig{Policies: policies}
+			p := newTestPlugin(t, cfg)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, tt.target, cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestInvalidCedar(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`permit (principal, action, resource)`,
+		`allow (principal, action, resource);`,
+		`permit (principal, action, resource) when { context.secure };`,
+		`permit (principal == User::"alice", action, resource);`,
+		`permit (principal, action, resource == S3::Bucket::"logs);`,
+	} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.Cedar = &plugin.CedarConfig{Policies: src}
+		if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "cedar") {
+			t.Errorf("expected a cedar error for %q, got %v", src, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// streamingPrefix marks the payloads using the `aws-chunked` encoding, signed or not, with or without trailers.
+	streamingPrefix = "STREAMING-"
+	// maxChunkLine bounds the chunk headers and the trailer lines, their signatures are well under it.
+	maxChunkLine = 4096
+)
+
+var (
+	errMissingDecodedLength = errors.New("aws-chunked uploads must set x-amz-decoded-content-length")
+	errChunkedEncoding      = errors.New("invalid aws-chunked encoding")
+)
+
+// isAWSChunked reports whether the body uses the `aws-chunked` encoding, ie its chunks are framed and may be followed
+// by trailers, eg: the checksum of `STREAMING-UNSIGNED-PAYLOAD-TRAILER`.
+func isAWSChunked(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), streamingPrefix)
+}
+
+// decodedLength returns the size of the object an `aws-chunked` upload declares.
+func decodedLength(req *http.Request) (int64, error) {
+	v := req.Header.Get("X-Amz-Decoded-Content-Length")
+	if v == "" {
+		return 0, errMissingDecodedLength
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid x-amz-decoded-content-length: %q", v)
+	}
+	return n, nil
+}
+
+const (
+	chunkSize = iota
+	chunkExtension
+	chunkSizeLF
+	chunkData
+	chunkDataCR
+	chunkDataLF
+	chunkTrailer
+	chunkTrailerLF
+	chunkDone
+)
+
+// chunkedBody forwards an `aws-chunked` body byte for byte, the framing, chunk signatures and trailers included, while
+// following its framing. Reads fail once the body is malformed, carries more than its declared length, or ends
+// before its last chunk and trailers, so the proxy aborts the upload rather than the backend storing a corrupt
+// object.
+type chunkedBody struct {
+	io.ReadCloser
+	declared int64
+	decoded  int64
+	state    int
+	size     int64
+	line     int
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if perr := b.parse(p[:n]); perr != nil {
+		return n, perr
+	}
+	if errors.Is(err, io.EOF) && b.state != chunkDone {
+		return n, fmt.Errorf("%w: the body ended after %d of %d bytes", errChunkedEncoding, b.decoded, b.declared)
+	}
+	return n, err
+}
+
+func (b *chunkedBody) parse(p []byte) error {
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch b.state {
+		case chunkSize:
+			switch {
+			case c == ';' && b.line > 0:
+				b.state = chunkExtension
+			case c == '\r' && b.line > 0:
+				b.state = chunkSizeLF
+			default:
+				d, ok := hexDigit(c)
+				if !ok || b.line >= 16 {
+					return fmt.Errorf("%w: invalid chunk size", errChunkedEncoding)
+				}
+				b.size = b.size<<4 | d
+			}
+			b.line++
+		case chunkExtension:
+			if c == '\r' {
+				b.state = chunkSizeLF
+			}
+			b.line++
+		case chunkSizeLF:
+			if c != '\n' {
+				return fmt.Errorf("%w: invalid chunk header", errChunkedEncoding)
+			}
+			b.line = 0
+			if b.size == 0 {
+				b.state = chunkTrailer
+				continue
+			}
+			if b.decoded+b.size > b.declared {
+				return fmt.Errorf("%w: the chunks are larger than the declared %d bytes", errChunkedEncoding, b.declared)
+			}
+			b.state = chunkData
+		case chunkData:
+			n := int64(len(p) - i)
+			if n > b.size {
+				n = b.size
+			}
+			b.size -= n
+			b.decoded += n
+			i += int(n) - 1
+			if b.size == 0 {
+				b.state = chunkDataCR
+			}
+		case chunkDataCR:
+			if c != '\r' {
+				return fmt.Errorf("%w: missing the end of a chunk", errChunkedEncoding)
+			}
+			b.state = chunkDataLF
+		case chunkDataLF:
+			if c != '\n' {
+				return fmt.Errorf("%w: missing the end of a chunk", errChunkedEncoding)
+			}
+			b.state = chunkSize
+		case chunkTrailer:
+			if c == '\r' {
+				b.state = chunkTrailerLF
+			}
+			b.line++
+		case chunkTrailerLF:
+			if c != '\n' {
+				return fmt.Errorf("%w: invalid trailer", errChunkedEncoding)
+			}
+			// An empty line ends the trailers.
+			if b.line == 1 {
+				if b.decoded != b.declared {
+					return fmt.Errorf("%w: the body has %d of %d bytes", errChunkedEncoding, b.decoded, b.declared)
+				}
+				b.state = chunkDone
+				continue
+			}
+			b.state = chunkTrailer
+			b.line = 0
+		case chunkDone:
+			return fmt.Errorf("%w: data after the trailers", errChunkedEncoding)
+		}
+		if b.line > maxChunkLine {
+			return fmt.Errorf("%w: the chunk header or trailer is too long", errChunkedEncoding)
+		}
+	}
+	return nil
+}
+
+func hexDigit(c byte) (int64, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int64(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return int64(c-'a') + 10, true
+	case c >= 'A' && c <= 'F':
+		return int64(c-'A') + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,120 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestAWSChunked(t *testing.T) {
+	tc := []struct {
+		name           string
+		payload        string
+		body           string
+		decodedLength  string
+		expectedStatus int
+	}{
+		{
+			name:           "unsigned with a trailer",
+			payload:        "STREAMING-UNSIGNED-PAYLOAD-TRAILER",
+			body:           "5\r\nhello\r\n6\r\n world\r\n0\r\nx-amz-checksum-crc32:DUoRhQ==\r\n\r\n",
+			decodedLength:  "11",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "signed chunks",
+			payload:        "STREAMING-AWS4-HMAC-SHA256-PAYLOAD",
+			body:           "b;chunk-signature=0123abcd\r\nhello world\r\n0;chunk-signature=4567cdef\r\n\r\n",
+			decodedLength:  "11",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing the decoded length",
+			payload:        "STREAMING-UNSIGNED-PAYLOAD-TRAILER",
+			body:           "5\r\nhello\r\n0\r\n\r\n",
+			expectedStatus: http.StatusLengthRequired,
+		},
+		{
+			name:           "decoded length over the limit",
+			payload:        "STREAMING-UNSIGNED-PAYLOAD-TRAILER",
+			body:           "5\r\nhello\r\n0\r\n\r\n",
+			decodedLength:  "4096",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "truncated",
+			payload:        "STREAMING-UNSIGNED-PAYLOAD-TRAILER",
+			body:           "b\r\nhello wo",
+			decodedLength:  "11",
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "missing the trailers",
+			payload:        "STREAMING-UNSIGNED-PAYLOAD-TRAILER",
+			body:           "b\r\nhello world\r\n0\r\n",
+			decodedLength:  "11",
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "larger than declared",
+			payload:        "STREAMING-UNSIGNED-PAYLOAD-TRAILER",
+			body:           "b\r\nhello world\r\n0\r\n\r\n",
+			decodedLength:  "5",
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "invalid chunk size",
+			payload:        "STREAMING-UNSIGNED-PAYLOAD-TRAILER",
+			body:           "zz\r\nhello world\r\n0\r\n\r\n",
+			decodedLength:  "11",
+			expectedStatus: http.StatusBadGateway,
+		},
+	}
+	cred := validCredential()
+	// Below the encoded size of the bodies, the limit applies to the decoded length.
+	cred.MaxUploadSize = 16
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	var received string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received = string(b)
+	})
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			received = ""
+			req := httptest.NewRequest(http.MethodPut, "https://s3.example.com/bucket/object.bin", strings.NewReader(tt.body))
+			req.Header.Set("Content-Encoding", "aws-chunked")
+			req.Header.Set("X-Amz-Content-Sha256", tt.payload)
+			if tt.decodedLength != "" {
+				req.Header.Set("X-Amz-Decoded-Content-Length", tt.decodedLength)
+			}
+			signRequest(t, req, cred, p.Now())
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status code %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body)
+			}
+			if tt.expectedStatus == http.StatusOK && received != tt.body {
+				t.Errorf("expected the body to be forwarded as is, got %q", received)
+			}
+		})
+	}
+}
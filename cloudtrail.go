@@ -0,0 +1,126 @@
+package traefik_plugin_s3_auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	decisionLogFormatJSON       = "json"
+	decisionLogFormatCloudTrail = "cloudtrail"
+
+	defaultCloudTrailRegion = "us-east-1"
+)
+
+// cloudTrailEvent is a decision log entry in the schema of the CloudTrail S3 data events, so the CloudTrail analysis
+// tools and SIEM parsers work against the decision log.
+// https://docs.aws.amazon.com/awscloudtrail/latest/userguide/cloudtrail-event-reference-record-contents.html
+type cloudTrailEvent struct {
+	EventVersion        string                 `json:"eventVersion"`
+	UserIdentity        cloudTrailIdentity     `json:"userIdentity"`
+	EventTime           string                 `json:"eventTime"`
+	EventSource         string                 `json:"eventSource"`
+	EventName           string                 `json:"eventName"`
+	AWSRegion           string                 `json:"awsRegion"`
+	SourceIPAddress     string                 `json:"sourceIPAddress"`
+	UserAgent           string                 `json:"userAgent"`
+	ErrorCode           string                 `json:"errorCode,omitempty"`
+	RequestParameters   map[string]string      `json:"requestParameters"`
+	ResponseElements    interface{}            `json:"responseElements"`
+	AdditionalEventData map[string]interface{} `json:"additionalEventData"`
+	RequestID           string                 `json:"requestID,omitempty"`
+	EventID             string                 `json:"eventID"`
+	ReadOnly            bool                   `json:"readOnly"`
+	Resources           []cloudTrailResource   `json:"resources,omitempty"`
+	EventType           string                 `json:"eventType"`
+	ManagementEvent     bool                   `json:"managementEvent"`
+	EventCategory       string                 `json:"eventCategory"`
+}
+
+type cloudTrailIdentity struct {
+	// Type is `IAMUser`, `AssumedRole` for temporary credentials, or `AWSAccount` for anonymous requests.
+	Type        string `json:"type"`
+	PrincipalID string `json:"principalId"`
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+	AccountID   string `json:"accountId,omitempty"`
+}
+
+type cloudTrailResource struct {
+	Type string `json:"type"`
+	ARN  string `json:"ARN"`
+}
+
+// newCloudTrailEvent describes the request as the client sent it, before any rewrite for the backend, and as
+// CloudTrail would, eg: the region is the one of the credential scope. The operation is classified here since the
+// failing requests are denied before it is.
+func newCloudTrailEvent(req *http.Request, headerName string, domains []string) *cloudTrailEvent {
+	res := resolveResource(req, domains)
+	op := classify(req, res)
+	ev := &cloudTrailEvent{
+		EventVersion:        "1.08",
+		EventSource:         "s3.amazonaws.com",
+		EventName:           op.Name,
+		AWSRegion:           defaultCloudTrailRegion,
+		UserAgent:           req.UserAgent(),
+		RequestParameters:   map[string]string{"Host": req.Host},
+		AdditionalEventData: map[string]interface{}{},
+		EventID:             newEventID(),
+		ReadOnly:            !mutating(req.Method),
+		EventType:           "AwsApiCall",
+		EventCategory:       "Data",
+	}
+	if a, err := parseHeader(req.Header.Get(headerName)); err == nil {
+		ev.AWSRegion = a.Region
+		ev.AdditionalEventData["SignatureVersion"] = "SigV4"
+		ev.AdditionalEventData["AuthenticationMethod"] = "AuthHeader"
+	}
+	if res.Bucket != "" {
+		ev.RequestParameters["bucketName"] = res.Bucket
+		ev.Resources = append(ev.Resources, cloudTrailResource{Type: "AWS::S3::Bucket", ARN: "arn:aws:s3:::" + res.Bucket})
+	}
+	if res.Key != "" {
+		ev.RequestParameters["key"] = res.Key
+		ev.Resources = append(ev.Resources, cloudTrailResource{Type: "AWS::S3::Object", ARN: "arn:aws:s3:::" + res.Bucket + "/" + res.Key})
+	}
+	// Like S3, the bucket level operations are management events, except the listings.
+	if res.Key == "" && !listings[op.Name] {
+		ev.ManagementEvent, ev.EventCategory = true, "Management"
+	}
+	return ev
+}
+
+// complete adds the decision and the identity of the entry, once the request is decided.
+func (ev *cloudTrailEvent) complete(e *decisionLogEntry) {
+	ev.EventTime = e.Time.UTC().Format(time.RFC3339)
+	ev.SourceIPAddress = e.SourceIP
+	if ev.RequestID = e.RequestID; ev.RequestID == "" {
+		ev.RequestID = e.CorrelationID
+	}
+	if e.Decision == "deny" {
+		ev.ErrorCode = e.Reason
+	}
+	switch {
+	case e.AccessKeyID == "":
+		ev.UserIdentity = cloudTrailIdentity{Type: "AWSAccount", AccountID: "anonymous"}
+	case e.user != "" && e.user != e.AccessKeyID:
+		ev.UserIdentity = cloudTrailIdentity{Type: "AssumedRole", PrincipalID: e.user, AccessKeyID: e.AccessKeyID}
+	default:
+		ev.UserIdentity = cloudTrailIdentity{Type: "IAMUser", PrincipalID: e.AccessKeyID, AccessKeyID: e.AccessKeyID}
+	}
+	if e.Tenant != "" {
+		ev.AdditionalEventData["tenant"] = e.Tenant
+	}
+}
+
+// newEventID returns a random UUID, eg: `0f8fad5b-d9cb-469f-a165-70867728950e`.
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
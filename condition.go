@@ -0,0 +1,196 @@
+package traefik_plugin_s3_auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	condStringEquals    = "StringEquals"
+	condStringNotEquals = "StringNotEquals"
+	condStringLike      = "StringLike"
+	condStringNotLike   = "StringNotLike"
+	condNull            = "Null"
+
+	// Set qualifiers for multivalued keys, eg: `ForAllValues:StringEquals` on `s3:RequestObjectTagKeys`.
+	qualForAllValues = "ForAllValues:"
+	qualForAnyValue  = "ForAnyValue:"
+
+	requestObjectTagPrefix = "s3:requestobjecttag/"
+	requestObjectTagKeys   = "s3:requestobjecttagkeys"
+	userAgent              = "aws:useragent"
+	country                = "s3auth:country"
+)
+
+// policyRequest is what a policy is evaluated against.
+type policyRequest struct {
+	action       string
+	resource     string
+	subresources []string
+	header       http.Header
+	// tags of the credential, for `${aws:PrincipalTag/<key>}` variables.
+	tags map[string]string
+	// country of the client, for `s3auth:Country`, when a trusted CDN sets it.
+	country string
+}
+
+// value returns the values of a condition key, eg: `s3:x-amz-server-side-encryption` is the request header of the
+// same name, `s3:RequestObjectTag/project` the `project` tag in `x-amz-tagging` and `aws:UserAgent` the client.
+func (r policyRequest) value(key string) ([]string, bool) {
+	key = strings.ToLower(key)
+	switch {
+	case key == requestObjectTagKeys:
+		tags := r.objectTags()
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		return keys, len(keys) > 0
+	case strings.HasPrefix(key, requestObjectTagPrefix):
+		// Tag keys are case-sensitive, so look them up again in the original case.
+		for k, v := range r.objectTags() {
+			if strings.EqualFold(k, key[len(requestObjectTagPrefix):]) {
+				return v, true
+			}
+		}
+		return nil, false
+	case key == country:
+		return []string{r.country}, r.country != ""
+	case key == userAgent:
+		v := r.header.Get("User-Agent")
+		return []string{v}, v != ""
+	case strings.HasPrefix(key, "s3:x-amz-"):
+		v := r.header.Values(key[len("s3:"):])
+		return v, len(v) > 0
+	default:
+		return nil, false
+	}
+}
+
+// objectTags parses the tags of an upload, eg: `x-amz-tagging: project=tenant-a&tier=cold`.
+func (r policyRequest) objectTags() url.Values {
+	tags, err := url.ParseQuery(r.header.Get("X-Amz-Tagging"))
+	if err != nil {
+		return nil
+	}
+	return tags
+}
+
+// expand replaces the `${aws:PrincipalTag/<key>}` variables with the tags of the credential, in a single pass so the
+// variables in the tag values are never expanded.
+func (r policyRequest) expand(values []string) []string {
+	const variable = "${aws:PrincipalTag/"
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		var b strings.Builder
+		for {
+			start := strings.Index(v, variable)
+			if start < 0 {
+				break
+			}
+			end := strings.Index(v[start:], "}")
+			if end < 0 {
+				break
+			}
+			b.WriteString(v[:start])
+			b.WriteString(r.tags[v[start+len(variable):start+end]])
+			v = v[start+end+1:]
+		}
+		b.WriteString(v)
+		out = append(out, b.String())
+	}
+	return out
+}
+
+func checkCondition(cond map[string]map[string][]string) error {
+	for op, keys := range cond {
+		switch base := strings.TrimPrefix(strings.TrimPrefix(op, qualForAllValues), qualForAnyValue); base {
+		case condStringEquals, condStringNotEquals, condStringLike, condStringNotLike:
+		case condNull:
+			if base != op {
+				return fmt.Errorf("unsupported condition operator: %q", op)
+			}
+		default:
+			return fmt.Errorf("unsupported condition operator: %q", op)
+		}
+		for key, values := range keys {
+			k := strings.ToLower(key)
+			if !strings.HasPrefix(k, "s3:x-amz-") && !strings.HasPrefix(k, requestObjectTagPrefix) && k != requestObjectTagKeys && k != userAgent && k != country {
+				return fmt.Errorf("unsupported condition key: %q", key)
+			}
+			if op == condNull && (len(values) != 1 || (values[0] != "true" && values[0] != "false")) {
+				return fmt.Errorf("the %s condition on %q must be either `true` or `false`", condNull, key)
+			}
+		}
+	}
+	return nil
+}
+
+// matchCondition evaluates the condition the way IAM does: every operator and key must match, and any of the values
+// of a key. A missing key never matches a positive operator and always matches a negated one. With `ForAllValues:`
+// every value of a multivalued key must match, which is also true when the key is missing.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_elements_condition_operators.html
+func matchCondition(cond map[string]map[string][]string, r policyRequest) bool {
+	for op, keys := range cond {
+		forAll := strings.HasPrefix(op, qualForAllValues)
+		base := strings.TrimPrefix(strings.TrimPrefix(op, qualForAllValues), qualForAnyValue)
+		for key, values := range keys {
+			got, present := r.value(key)
+			if base == condNull {
+				if present != (values[0] == "false") {
+					return false
+				}
+				continue
+			}
+			values = r.expand(values)
+			match := func(g string) bool {
+				switch base {
+				case condStringEquals, condStringNotEquals:
+					return anyEqual(values, []string{g})
+				default:
+					return anyWildcard(values, []string{g})
+				}
+			}
+			negated := base == condStringNotEquals || base == condStringNotLike
+			var ok bool
+			if forAll {
+				ok = true
+				for _, g := range got {
+					if match(g) == negated {
+						ok = false
+						break
+					}
+				}
+			} else {
+				found := false
+				for _, g := range got {
+					if match(g) {
+						found = true
+						break
+					}
+				}
+				ok = present && found
+				if negated {
+					ok = !present || !found
+				}
+			}
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func anyEqual(values []string, got []string) bool {
+	for _, g := range got {
+		for _, v := range values {
+			if v == g {
+				return true
+			}
+		}
+	}
+	return false
+}
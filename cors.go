@@ -0,0 +1,151 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultCORSMethods are the methods browser S3 SDKs use.
+var defaultCORSMethods = []string{"GET", "HEAD", "PUT", "POST", "DELETE"}
+
+// CORSConfig answers the preflights and sets the `Access-Control-*` headers of the responses for browser clients,
+// whatever the CORS support of the backend.
+type CORSConfig struct {
+	// AllowedOrigins are the allowed `Origin` headers, wildcards such as `https://*.example.com` are supported.
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+	// AllowedMethods defaults to `GET`, `HEAD`, `PUT`, `POST` and `DELETE`.
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	// AllowedHeaders are the request headers browsers may send, `*` allows any of them.
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	// ExposeHeaders are the response headers scripts may read, eg: `ETag` for multipart uploads.
+	ExposeHeaders []string `json:"exposeHeaders,omitempty"`
+	// MaxAge is how long browsers may cache the preflight, in seconds.
+	MaxAge int `json:"maxAge,omitempty"`
+}
+
+type cors struct {
+	origins []string
+	methods string
+	headers []string
+	expose  string
+	maxAge  string
+}
+
+func newCORS(config *CORSConfig) (*cors, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if len(config.AllowedOrigins) == 0 {
+		return nil, errors.New("must specify the cors `allowedOrigins`")
+	}
+	if config.MaxAge < 0 {
+		return nil, errors.New("the cors `maxAge` must not be negative")
+	}
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	c := &cors{
+		origins: config.AllowedOrigins,
+		methods: strings.ToUpper(strings.Join(methods, ", ")),
+		headers: config.AllowedHeaders,
+		expose:  strings.Join(config.ExposeHeaders, ", "),
+	}
+	if config.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(config.MaxAge)
+	}
+	return c, nil
+}
+
+func (c *cors) allowed(origin string) bool {
+	return origin != "" && matchesWildcard(c.origins, origin, true)
+}
+
+// preflight reports whether the request is a CORS preflight, which never carries a signature.
+func preflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// answer responds to a preflight, the way S3 does for buckets with a CORS configuration. The origin is set by the
+// corsWriter wrapping the responses to allowed origins.
+func (c *cors) answer(rw http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+	requested := req.Header.Get("Access-Control-Request-Headers")
+	if !c.allowed(origin) || !containsToken(c.methods, method) || !c.allowsHeaders(requested) {
+		writeS3Error(rw, req, http.StatusForbidden, "AccessForbidden", "CORSResponse: This CORS request is not allowed.")
+		return
+	}
+	h := rw.Header()
+	h.Set("Access-Control-Allow-Methods", c.methods)
+	if requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	}
+	if c.maxAge != "" {
+		h.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (c *cors) allowsHeaders(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		if h = strings.TrimSpace(h); h != "" && !matchesWildcard(c.headers, h, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// stamp sets the headers of every response to an allowed origin, replacing the ones of the backend.
+func (c *cors) stamp(h http.Header, origin string) {
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Credentials", "true")
+	h.Add("Vary", "Origin")
+	if c.expose != "" {
+		h.Set("Access-Control-Expose-Headers", c.expose)
+	}
+}
+
+func containsToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.TrimSpace(t) == token {
+			return true
+		}
+	}
+	return false
+}
+
+// corsWriter stamps the CORS headers on the response once the backend set its own.
+type corsWriter struct {
+	http.ResponseWriter
+	cors    *cors
+	origin  string
+	stamped bool
+}
+
+func (w *corsWriter) stamp() {
+	if !w.stamped {
+		w.stamped = true
+		w.cors.stamp(w.Header(), w.origin)
+	}
+}
+
+func (w *corsWriter) WriteHeader(status int) {
+	w.stamp()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *corsWriter) Write(p []byte) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *corsWriter) Flush() {
+	w.stamp()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
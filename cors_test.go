@@ -0,0 +1,137 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestCORS(t *testing.T) {
+	tc := []struct {
+		name            string
+		method          string
+		origin          string
+		requestMethod   string
+		requestHeaders  string
+		signed          bool
+		expectedStatus  int
+		expectedOrigin  string
+		expectedMethods string
+	}{
+		{
+			name:            "preflight",
+			method:          http.MethodOptions,
+			origin:          "https://app.example.com",
+			requestMethod:   http.MethodPut,
+			requestHeaders:  "authorization, x-amz-date, x-amz-content-sha256",
+			expectedStatus:  http.StatusOK,
+			expectedOrigin:  "https://app.example.com",
+			expectedMethods: "GET, HEAD, PUT",
+		},
+		{
+			name:           "preflight from another origin",
+			method:         http.MethodOptions,
+			origin:         "https://evil.example.org",
+			requestMethod:  http.MethodGet,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "preflight of a method",
+			method:         http.MethodOptions,
+			origin:         "https://app.example.com",
+			requestMethod:  http.MethodDelete,
+			expectedStatus: http.StatusForbidden,
+			expectedOrigin: "https://app.example.com",
+		},
+		{
+			name:           "preflight of a header",
+			method:         http.MethodOptions,
+			origin:         "https://app.example.com",
+			requestMethod:  http.MethodGet,
+			requestHeaders: "x-custom",
+			expectedStatus: http.StatusForbidden,
+			expectedOrigin: "https://app.example.com",
+		},
+		{
+			name:           "response",
+			method:         http.MethodGet,
+			origin:         "https://app.example.com",
+			signed:         true,
+			expectedStatus: http.StatusOK,
+			expectedOrigin: "https://app.example.com",
+		},
+		{
+			name:           "error",
+			method:         http.MethodGet,
+			origin:         "https://app.example.com",
+			expectedStatus: http.StatusForbidden,
+			expectedOrigin: "https://app.example.com",
+		},
+		{
+			name:           "without origin",
+			method:         http.MethodGet,
+			signed:         true,
+			expectedStatus: http.StatusOK,
+			expectedOrigin: "*",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.CORS = &plugin.CORSConfig{
+				AllowedOrigins: []string{"https://*.example.com"},
+				AllowedMethods: []string{"GET", "HEAD", "PUT"},
+				AllowedHeaders: []string{"authorization", "x-amz-*"},
+				ExposeHeaders:  []string{"ETag"},
+				MaxAge:         600,
+			}
+			// The backend has a poor CORS support.
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Access-Control-Allow-Origin", "*")
+				rw.WriteHeader(http.StatusOK)
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+			p.Now = func() time.Time { return now }
+
+			req := httptest.NewRequest(tt.method, "https://s3.example.com/bucket/key", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.requestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+			if tt.requestHeaders != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.requestHeaders)
+			}
+			if tt.signed {
+				signRequest(t, req, cred, now)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			h := recorder.Header()
+			if got := h.Values("Access-Control-Allow-Origin"); tt.expectedOrigin == "" && len(got) != 0 || tt.expectedOrigin != "" && (len(got) != 1 || got[0] != tt.expectedOrigin) {
+				t.Errorf("expected the origin %q, got %q", tt.expectedOrigin, got)
+			}
+			if got := h.Get("Access-Control-Allow-Methods"); got != tt.expectedMethods {
+				t.Errorf("expected the methods %q, got %q", tt.expectedMethods, got)
+			}
+			if tt.origin != "" && tt.expectedOrigin != "" && h.Get("Access-Control-Expose-Headers") != "ETag" {
+				t.Errorf("expected the exposed headers, got %q", h.Get("Access-Control-Expose-Headers"))
+			}
+		})
+	}
+}
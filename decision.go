@@ -0,0 +1,91 @@
+package traefik_plugin_s3_auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+const defaultDecisionHeader = "X-S3Auth-Decision"
+
+// DecisionHeaderConfig sets a header summarizing the authorization decision, protected by an HMAC so downstream
+// middlewares sharing the secret can trust it.
+type DecisionHeaderConfig struct {
+	// Name of the header, defaults to `X-S3Auth-Decision`.
+	Name string `json:"name,omitempty"`
+	// Secret of the HMAC, or the name of the environment variable holding it.
+	Secret    string `json:"secret,omitempty"`
+	SecretEnv string `json:"secretEnv,omitempty"`
+}
+
+// Decision is the payload of the decision header.
+type Decision struct {
+	AccessKeyID string `json:"accessKeyId"`
+	User        string `json:"user"`
+	Action      string `json:"action"`
+	Operation   string `json:"operation"`
+	Bucket      string `json:"bucket,omitempty"`
+	Key         string `json:"key,omitempty"`
+	// Verdict is what allowed the request: `signature` when no policy applies, `default:<statement>` or
+	// `credential:<statement>` for the allowing statement of a policy, or `grant:<id>`.
+	Verdict string `json:"verdict"`
+	// Time is the unix time of the decision.
+	Time int64 `json:"time"`
+}
+
+type decisionSigner struct {
+	name   string
+	secret []byte
+}
+
+func newDecisionSigner(config *DecisionHeaderConfig) (*decisionSigner, error) {
+	if config == nil {
+		return nil, nil
+	}
+	s := &decisionSigner{name: config.Name, secret: []byte(config.Secret)}
+	if s.name == "" {
+		s.name = defaultDecisionHeader
+	}
+	if config.SecretEnv != "" {
+		s.secret = []byte(os.Getenv(config.SecretEnv))
+	}
+	if len(s.secret) == 0 {
+		return nil, errors.New("must specify the decision header `secret` or `secretEnv`")
+	}
+	return s, nil
+}
+
+// sign returns the header value, ie the base64url encoded JSON decision and its HMAC-SHA256, separated by a `.`.
+func (s *decisionSigner) sign(d Decision) string {
+	b, _ := json.Marshal(d) // Can't fail, it only has strings and integers.
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + decisionMAC(payload, s.secret)
+}
+
+func decisionMAC(payload string, secret []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// VerifyDecision checks the HMAC of a decision header and returns its decision, for downstream middlewares written in
+// Go. Callers should also check its Time is recent.
+func VerifyDecision(header, secret string) (*Decision, error) {
+	payload, mac, ok := strings.Cut(header, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(decisionMAC(payload, []byte(secret)))) {
+		return nil, errors.New("invalid decision header signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("invalid decision header payload")
+	}
+	var d Decision
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, errors.New("invalid decision header payload")
+	}
+	return &d, nil
+}
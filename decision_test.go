@@ -0,0 +1,78 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestDecisionHeader(t *testing.T) {
+	tc := []struct {
+		name            string
+		policy          *plugin.Policy
+		expectedVerdict string
+	}{
+		{
+			name:            "signature",
+			expectedVerdict: "signature",
+		},
+		{
+			name: "policy statement",
+			policy: &plugin.Policy{Statement: []*plugin.PolicyStatement{
+				{Effect: "Deny", Action: []string{"s3:DeleteObject"}, Resource: []string{"*"}},
+				{Effect: "Allow", Action: []string{"s3:GetObject"}, Resource: []string{"arn:aws:s3:::bucket/*"}},
+			}},
+			expectedVerdict: "credential:1",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Policy = tt.policy
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.DecisionHeader = &plugin.DecisionHeaderConfig{Secret: "shared-secret"}
+
+			var header string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				header = req.Header.Get("X-S3Auth-Decision")
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+			p.Now = func() time.Time { return now }
+
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/reports/2025.csv", nil)
+			signRequest(t, req, cred, now)
+			req.Header.Set("X-S3Auth-Decision", "spoofed")
+			p.ServeHTTP(httptest.NewRecorder(), req)
+
+			d, err := plugin.VerifyDecision(header, "shared-secret")
+			if err != nil {
+				t.Fatalf("expected a valid decision header, got %q: %v", header, err)
+			}
+			expected := plugin.Decision{
+				AccessKeyID: cred.AccessKeyID, User: cred.AccessKeyID, Action: "s3:GetObject", Operation: "GetObject",
+				Bucket: "bucket", Key: "reports/2025.csv", Verdict: tt.expectedVerdict, Time: now.Unix(),
+			}
+			if *d != expected {
+				t.Errorf("expected the decision %+v, got %+v", expected, *d)
+			}
+			if _, err := plugin.VerifyDecision(header, "other-secret"); err == nil {
+				t.Error("expected the decision to be rejected with another secret")
+			}
+			payload, mac, _ := strings.Cut(header, ".")
+			if _, err := plugin.VerifyDecision(payload+"x."+mac, "shared-secret"); err == nil {
+				t.Error("expected a tampered decision to be rejected")
+			}
+		})
+	}
+}
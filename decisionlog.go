@@ -0,0 +1,151 @@
+package traefik_plugin_s3_auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const decisionLogStdout = "stdout"
+
+// decisionLogContextKey holds the decision log entry (*decisionLogEntry) of the request, completed as it is decided.
+const decisionLogContextKey contextKey = "s3auth.decisionLog"
+
+// decisionLogEntry is one line of the decision log, never including the credentials.
+type decisionLogEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId,omitempty"`
+	// CorrelationID is the id of the `requestIdHeader`.
+	CorrelationID string `json:"correlationId,omitempty"`
+	AccessKeyID   string `json:"accessKeyId,omitempty"`
+	Tenant        string `json:"tenant,omitempty"`
+	Action        string `json:"action,omitempty"`
+	Method        string `json:"method"`
+	Path          string `json:"path"`
+	// Decision is `allow` or `deny`.
+	Decision string `json:"decision"`
+	// Reason is the verdict of allowed requests, eg: `signature` or `grant:<id>`, and the S3 error code of denied ones.
+	Reason    string  `json:"reason,omitempty"`
+	Status    int     `json:"status,omitempty"`
+	SourceIP  string  `json:"sourceIp"`
+	LatencyMs float64 `json:"latencyMs"`
+
+	started time.Time
+	// user is the parent of temporary credentials, or the access key id.
+	user string
+	// event replaces the entry in the log with the `cloudtrail` format, completed once the request is decided.
+	event *cloudTrailEvent
+}
+
+// decide completes the entry once, the first decision of a request is the one logged.
+func (e *decisionLogEntry) decide(decision, reason string, status int) {
+	if e == nil || e.Decision != "" {
+		return
+	}
+	e.Decision, e.Reason, e.Status = decision, reason, status
+	e.LatencyMs = float64(time.Since(e.started).Microseconds()) / 1000
+}
+
+// decisionLog appends the entries as JSON lines to a file or the standard output, or sends them to a syslog endpoint.
+// The files are shared process-wide by path, so every middleware logging to the same file appends whole lines.
+type decisionLog struct {
+	mu     sync.Mutex
+	path   string
+	w      io.Writer
+	syslog *syslogWriter
+}
+
+var (
+	decisionLogsMu sync.Mutex
+	decisionLogs   = map[string]*decisionLog{}
+)
+
+func sharedDecisionLog(path string) (*decisionLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	decisionLogsMu.Lock()
+	defer decisionLogsMu.Unlock()
+
+	if l, ok := decisionLogs[path]; ok {
+		return l, nil
+	}
+	l := &decisionLog{path: path}
+	switch {
+	case path == decisionLogStdout:
+	case isSyslogURL(path):
+		s, err := newSyslogWriter(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid `decisionLog`: %w", err)
+		}
+		l.syslog = s
+	default:
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the `decisionLog`: %w", err)
+		}
+		l.w = f
+	}
+	decisionLogs[path] = l
+	return l, nil
+}
+
+func (l *decisionLog) write(e *decisionLogEntry) {
+	if e.Decision == "" {
+		// Eg: CORS preflights, which aren't authorized.
+		return
+	}
+	var v interface{} = e
+	if e.event != nil {
+		e.event.complete(e)
+		v = e.event
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		logs.error("failed to encode the decision log entry", "error", err)
+		return
+	}
+	if l.syslog != nil {
+		severity := syslogSeverityInfo
+		if e.Decision == "deny" {
+			severity = syslogSeverityNotice
+		}
+		l.syslog.enqueue(severity, e.Time, b)
+		return
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := l.w
+	if w == nil {
+		w = os.Stdout
+	}
+	if _, err := w.Write(b); err != nil {
+		logs.error("failed to write the decision log", "path", l.path, "error", err)
+	}
+}
+
+// decisionLogNext marks the requests reaching the next handler as allowed.
+type decisionLogNext struct {
+	next http.Handler
+}
+
+func (n decisionLogNext) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if e, ok := req.Context().Value(decisionLogContextKey).(*decisionLogEntry); ok {
+		e.decide("allow", e.Reason, 0)
+	}
+	n.next.ServeHTTP(rw, req)
+}
+
+// denied completes the decision log entry of the request, if any, with the error written to the client.
+func denied(req *http.Request, status int, code string) {
+	if e, ok := req.Context().Value(decisionLogContextKey).(*decisionLogEntry); ok {
+		e.decide("deny", code, status)
+	}
+}
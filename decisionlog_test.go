@@ -0,0 +1,315 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestDecisionLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.jsonl")
+	cred := validCredential()
+	cred.Policy = &plugin.Policy{Statement: []*plugin.PolicyStatement{
+		{Effect: "Allow", Action: []string{"s3:GetObject"}, Resource: []string{"arn:aws:s3:::bucket/reports/*"}},
+	}}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.PublicReadPrefixes = []string{"bucket/site/"}
+	cfg.RequestIDs = true
+	cfg.DecisionLog = path
+	p := newTestPlugin(t, cfg)
+
+	wrong := validCredential()
+	wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+	unknown := validCredential()
+	unknown.AccessKeyID = "AKIAUNKNOWN"
+	tc := []struct {
+		name     string
+		path     string
+		cred     *plugin.Credential
+		expected map[string]interface{}
+	}{
+		{
+			name:     "allowed",
+			path:     "/bucket/reports/2025.csv",
+			cred:     cred,
+			expected: map[string]interface{}{"accessKeyId": "ACCESS_ACCESS_ACCESS", "action": "s3:GetObject", "decision": "allow", "reason": "credential:0"},
+		},
+		{
+			name:     "policy deny",
+			path:     "/bucket/private/secret.txt",
+			cred:     cred,
+			expected: map[string]interface{}{"accessKeyId": "ACCESS_ACCESS_ACCESS", "action": "s3:GetObject", "decision": "deny", "reason": "AccessDenied", "status": float64(http.StatusForbidden)},
+		},
+		{
+			name:     "bad signature",
+			path:     "/bucket/reports/2025.csv",
+			cred:     wrong,
+			expected: map[string]interface{}{"accessKeyId": "ACCESS_ACCESS_ACCESS", "decision": "deny", "reason": "SignatureDoesNotMatch"},
+		},
+		{
+			name:     "unknown key",
+			path:     "/bucket/reports/2025.csv",
+			cred:     unknown,
+			expected: map[string]interface{}{"accessKeyId": "AKIAUNKNOWN", "decision": "deny", "reason": "InvalidAccessKeyId"},
+		},
+		{
+			name:     "public read",
+			path:     "/bucket/site/index.html",
+			expected: map[string]interface{}{"action": "s3:GetObject", "decision": "allow", "reason": "public", "sourceIp": "192.0.2.1"},
+		},
+	}
+	for _, tt := range tc {
+		req := httptest.NewRequest(http.MethodGet, "https://s3.example.com"+tt.path, nil)
+		if tt.cred != nil {
+			signRequest(t, req, tt.cred, p.Now())
+		}
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	if len(lines) != len(tc) {
+		t.Fatalf("expected %d lines, got %d:\n%s", len(tc), len(lines), b)
+	}
+	for i, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var entry map[string]interface{}
+			if err := json.Unmarshal(lines[i], &entry); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.expected {
+				if entry[k] != v {
+					t.Errorf("expected %s to be %v, got %v in %s", k, v, entry[k], lines[i])
+				}
+			}
+			if entry["path"] != tt.path || entry["method"] != http.MethodGet || entry["requestId"] == nil || entry["latencyMs"] == nil {
+				t.Errorf("expected the request fields in %s", lines[i])
+			}
+			if bytes.Contains(lines[i], []byte("SECRET12secret")) || bytes.Contains(lines[i], []byte("Signature=")) {
+				t.Errorf("expected no credentials in %s", lines[i])
+			}
+		})
+	}
+}
+
+func TestDecisionLogCloudTrail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudtrail.jsonl")
+	cred := validCredential()
+	cred.Tenant = "acme"
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.RequestIDs = true
+	cfg.DecisionLog = path
+	cfg.DecisionLogFormat = "cloudtrail"
+	p := newTestPlugin(t, cfg)
+
+	wrong := validCredential()
+	wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+	for _, r := range []struct {
+		method, path string
+		cred         *plugin.Credential
+	}{
+		{http.MethodGet, "/bucket/reports/2025.csv", cred},
+		{http.MethodPut, "/bucket/reports/2026.csv", wrong},
+		{http.MethodGet, "/bucket", nil},
+	} {
+		req := httptest.NewRequest(r.method, "https://s3.example.com"+r.path, nil)
+		req.Header.Set("User-Agent", "aws-sdk-go-v2/1.30.0")
+		if r.cred != nil {
+			signRequest(t, req, r.cred, p.Now())
+		}
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got %d:\n%s", len(lines), b)
+	}
+	type event struct {
+		EventVersion string `json:"eventVersion"`
+		UserIdentity struct {
+			Type        string `json:"type"`
+			AccessKeyID string `json:"accessKeyId"`
+			AccountID   string `json:"accountId"`
+		} `json:"userIdentity"`
+		EventTime         string            `json:"eventTime"`
+		EventSource       string            `json:"eventSource"`
+		EventName         string            `json:"eventName"`
+		AWSRegion         string            `json:"awsRegion"`
+		SourceIPAddress   string            `json:"sourceIPAddress"`
+		UserAgent         string            `json:"userAgent"`
+		ErrorCode         string            `json:"errorCode"`
+		RequestParameters map[string]string `json:"requestParameters"`
+		RequestID         string            `json:"requestID"`
+		EventID           string            `json:"eventID"`
+		ReadOnly          bool              `json:"readOnly"`
+		Resources         []struct {
+			Type string `json:"type"`
+			ARN  string `json:"ARN"`
+		} `json:"resources"`
+		ManagementEvent bool `json:"managementEvent"`
+	}
+	events := make([]event, len(lines))
+	for i, l := range lines {
+		if err := json.Unmarshal(l, &events[i]); err != nil {
+			t.Fatal(err)
+		}
+		ev := events[i]
+		if ev.EventVersion != "1.08" || ev.EventSource != "s3.amazonaws.com" || ev.EventTime != "2025-07-10T05:45:00Z" || ev.SourceIPAddress != "192.0.2.1" ||
+			ev.UserAgent != "aws-sdk-go-v2/1.30.0" || ev.RequestID == "" || len(ev.EventID) != 36 || ev.RequestParameters["Host"] != "s3.example.com" {
+			t.Errorf("expected the common fields in %s", l)
+		}
+	}
+
+	allowed := events[0]
+	if allowed.EventName != "GetObject" || allowed.UserIdentity.Type != "IAMUser" || allowed.UserIdentity.AccessKeyID != "ACCESS_ACCESS_ACCESS" ||
+		allowed.AWSRegion != "us-east-1" || allowed.ErrorCode != "" || !allowed.ReadOnly || allowed.ManagementEvent {
+		t.Errorf("unexpected allowed record %s", lines[0])
+	}
+	if allowed.RequestParameters["bucketName"] != "bucket" || allowed.RequestParameters["key"] != "reports/2025.csv" ||
+		len(allowed.Resources) != 2 || allowed.Resources[1].ARN != "arn:aws:s3:::bucket/reports/2025.csv" {
+		t.Errorf("expected the bucket and key in %s", lines[0])
+	}
+	if !bytes.Contains(lines[0], []byte(`"tenant":"acme"`)) {
+		t.Errorf("expected the tenant in the additional event data of %s", lines[0])
+	}
+	denied := events[1]
+	if denied.EventName != "PutObject" || denied.ErrorCode != "SignatureDoesNotMatch" || denied.ReadOnly || denied.UserIdentity.AccessKeyID != "ACCESS_ACCESS_ACCESS" {
+		t.Errorf("unexpected denied record %s", lines[1])
+	}
+	anonymous := events[2]
+	if anonymous.EventName != "ListObjects" || anonymous.ErrorCode != "AccessDenied" || anonymous.UserIdentity.Type != "AWSAccount" ||
+		anonymous.UserIdentity.AccountID != "anonymous" || anonymous.ManagementEvent {
+		t.Errorf("unexpected anonymous record %s", lines[2])
+	}
+}
+
+func TestDecisionLogSyslog(t *testing.T) {
+	tc := []struct {
+		name    string
+		network string
+	}{
+		{name: "udp", network: "udp"},
+		{name: "tcp", network: "tcp"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := make(chan string, 10)
+			var addr string
+			if tt.network == "udp" {
+				conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				addr = conn.LocalAddr().String()
+				go func() {
+					buf := make([]byte, 64*1024)
+					for {
+						n, _, err := conn.ReadFrom(buf)
+						if err != nil {
+							return
+						}
+						messages <- string(buf[:n])
+					}
+				}()
+			} else {
+				ln, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer ln.Close()
+				addr = ln.Addr().String()
+				go func() {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+					r := bufio.NewReader(conn)
+					for {
+						size, err := r.ReadString(' ')
+						if err != nil {
+							return
+						}
+						n, _ := strconv.Atoi(strings.TrimSpace(size))
+						msg := make([]byte, n)
+						if _, err := io.ReadFull(r, msg); err != nil {
+							return
+						}
+						messages <- string(msg)
+					}
+				}()
+			}
+
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.DecisionLog = tt.network + "://" + addr + "?facility=local0"
+			p := newTestPlugin(t, cfg)
+
+			wrong := validCredential()
+			wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+			for _, c := range []*plugin.Credential{validCredential(), wrong} {
+				req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+				signRequest(t, req, c, p.Now())
+				p.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			// local0 is 16, info 6 and notice 5.
+			for _, prefix := range []string{"<134>1 2025-07-10T05:45:00.000000Z ", "<133>1 2025-07-10T05:45:00.000000Z "} {
+				select {
+				case msg := <-messages:
+					if !strings.HasPrefix(msg, prefix) || !strings.Contains(msg, " s3auth ") || !strings.Contains(msg, " decision - {") {
+						t.Errorf("expected an RFC 5424 message starting with %q, got %q", prefix, msg)
+					}
+					var entry map[string]interface{}
+					if err := json.Unmarshal([]byte(msg[strings.Index(msg, "{"):]), &entry); err != nil || entry["accessKeyId"] != "ACCESS_ACCESS_ACCESS" {
+						t.Errorf("expected the JSON entry in %q: %v", msg, err)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("expected a syslog message starting with %q", prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidDecisionLog(t *testing.T) {
+	for _, path := range []string{"udp://syslog", "tcp://:514", "tls://syslog:6514?facility=mail"} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.DecisionLog = path
+		if _, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "`decisionLog`") {
+			t.Errorf("expected a decisionLog error for %q, got %v", path, err)
+		}
+	}
+	for _, format := range []string{"cef", "cloudtrail"} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.DecisionLogFormat = format
+		if _, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "decision log") && !strings.Contains(err.Error(), "decisionLogFormat") {
+			t.Errorf("expected a decisionLogFormat error for %q, got %v", format, err)
+		}
+	}
+}
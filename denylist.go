@@ -0,0 +1,185 @@
+package traefik_plugin_s3_auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DenylistConfig lists client ranges that are rejected before any signature work, eg: to block an abusive source
+// without touching the credentials.
+type DenylistConfig struct {
+	// CIDRs are always denied, eg: `198.51.100.0/24` or a single `192.0.2.10`.
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Path of a file, or URL to fetch, with one range per line. Empty lines and `#` comments are ignored.
+	Path    string            `json:"path,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// RefreshInterval reloads the file or URL periodically, eg: `1m`.
+	RefreshInterval string `json:"refreshInterval,omitempty"`
+}
+
+// DenylistStatus describes the last load of the denylist and how many requests it rejected.
+type DenylistStatus struct {
+	Entries     int       `json:"entries"`
+	LastRefresh time.Time `json:"lastRefresh"`
+	LastError   string    `json:"lastError,omitempty"`
+	Rejected    uint64    `json:"rejected"`
+}
+
+// denylist is shared process-wide like the credential stores, keyed by its configuration, until no middleware uses it.
+type denylist struct {
+	config *DenylistConfig
+
+	mu     sync.RWMutex
+	nets   []*net.IPNet
+	status DenylistStatus
+}
+
+var denylists = newRegistry()
+
+func sharedDenylist(ctx context.Context, config *DenylistConfig, name string) (*denylist, error) {
+	if config == nil {
+		denylists.leave(name)
+		return nil, nil
+	}
+	if config.Path != "" && config.URL != "" {
+		return nil, errors.New("the denylist can't have both a `path` and a `url`")
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	id := hex.EncodeToString(sum[:])
+
+	v, err := denylists.acquire(ctx, id, name, func() (interface{}, func(), error) {
+		return newDenylist(config)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*denylist), nil
+}
+
+func newDenylist(config *DenylistConfig) (*denylist, func(), error) {
+	var interval time.Duration
+	if config.RefreshInterval != "" {
+		var err error
+		if interval, err = time.ParseDuration(config.RefreshInterval); err != nil || interval <= 0 {
+			return nil, nil, fmt.Errorf("invalid denylist `refreshInterval` %q, eg: `1m`", config.RefreshInterval)
+		}
+	}
+	d := &denylist{config: config}
+	if err := d.reload(); err != nil {
+		return nil, nil, err
+	}
+	if interval <= 0 {
+		return d, nil, nil
+	}
+	stop := make(chan struct{})
+	go d.watch(interval, stop)
+	return d, func() { close(stop) }, nil
+}
+
+func (d *denylist) load() ([]*net.IPNet, error) {
+	cidrs := append([]string{}, d.config.CIDRs...)
+	var b []byte
+	var err error
+	switch {
+	case d.config.Path != "":
+		b, err = readSourceFile(d.config.Path)
+	case d.config.URL != "":
+		b, err = fetchSource(d.config.URL, d.config.Headers)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the denylist: %w", err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" {
+			cidrs = append(cidrs, line)
+		}
+	}
+	cidrs, err = normalizeCIDRs(cidrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denylist: %w", err)
+	}
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, _ := net.ParseCIDR(c)
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// reload loads the denylist again, keeping the previous one on failure.
+func (d *denylist) reload() error {
+	nets, err := d.load()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.status.LastRefresh = time.Now().UTC()
+	if err != nil {
+		d.status.LastError = err.Error()
+		return err
+	}
+	d.status.LastError = ""
+	d.status.Entries = len(nets)
+	d.nets = nets
+	return nil
+}
+
+func (d *denylist) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := d.reload(); err != nil {
+				logs.error("failed to reload the denylist", "error", err)
+			}
+		}
+	}
+}
+
+// denied reports whether the address is in the denylist, counting the rejection.
+func (d *denylist) denied(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	d.mu.RLock()
+	found := false
+	for _, n := range d.nets {
+		if n.Contains(ip) {
+			found = true
+			break
+		}
+	}
+	d.mu.RUnlock()
+	if found {
+		d.mu.Lock()
+		d.status.Rejected++
+		d.mu.Unlock()
+	}
+	return found
+}
+
+func (d *denylist) snapshot() DenylistStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.status
+}
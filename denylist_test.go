@@ -0,0 +1,151 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestDenylist(t *testing.T) {
+	list := "# abusive clients\n198.51.100.0/24\n\n203.0.113.9 # scraper\n"
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = rw.Write([]byte(list))
+	}))
+	defer server.Close()
+
+	configs := []struct {
+		name     string
+		denylist *plugin.DenylistConfig
+	}{
+		{
+			name:     "file",
+			denylist: &plugin.DenylistConfig{CIDRs: []string{"192.0.2.99"}, Path: writeFile(t, "denylist.txt", list)},
+		},
+		{
+			name:     "url",
+			denylist: &plugin.DenylistConfig{CIDRs: []string{"192.0.2.99"}, URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}},
+		},
+	}
+	tc := []struct {
+		name           string
+		remoteAddr     string
+		expectedStatus int
+	}{
+		{
+			name:           "allowed",
+			remoteAddr:     "192.0.2.10:43210",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "inline",
+			remoteAddr:     "192.0.2.99:43210",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "range",
+			remoteAddr:     "198.51.100.7:43210",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "single address with a comment",
+			remoteAddr:     "203.0.113.9:43210",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, c := range configs {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.Denylist = c.denylist
+		p := newTestPlugin(t, cfg)
+
+		for _, tt := range tc {
+			t.Run(c.name+"/"+tt.name, func(t *testing.T) {
+				req := newValidRequest(t)
+				req.RemoteAddr = tt.remoteAddr
+				recorder := httptest.NewRecorder()
+				p.ServeHTTP(recorder, req)
+				if recorder.Code != tt.expectedStatus {
+					t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+				}
+			})
+		}
+	}
+}
+
+func TestDenylistRelease(t *testing.T) {
+	path := writeFile(t, "denylist.txt", "198.51.100.0/24\n")
+	newPlugin := func(refresh string) *plugin.Plugin {
+		t.Helper()
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.Denylist = &plugin.DenylistConfig{Path: path, RefreshInterval: refresh}
+		handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-denylist-release")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := handler.(*plugin.Plugin)
+		p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+		return p
+	}
+	previous := newPlugin("10ms")
+	// Editing the configuration gives the middleware a new denylist, the previous one stops refreshing.
+	current := newPlugin("20ms")
+	if err := os.WriteFile(path, []byte("192.0.2.10\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	for _, tt := range []struct {
+		name           string
+		p              *plugin.Plugin
+		expectedStatus int
+	}{
+		{name: "previous", p: previous, expectedStatus: http.StatusOK},
+		{name: "current", p: current, expectedStatus: http.StatusForbidden},
+	} {
+		recorder := httptest.NewRecorder()
+		tt.p.ServeHTTP(recorder, newValidRequest(t))
+		if recorder.Code != tt.expectedStatus {
+			t.Errorf("%s: expected status code %d, got %d", tt.name, tt.expectedStatus, recorder.Code)
+		}
+	}
+}
+
+func TestInvalidDenylist(t *testing.T) {
+	tc := []struct {
+		name     string
+		denylist *plugin.DenylistConfig
+	}{
+		{
+			name:     "invalid cidr",
+			denylist: &plugin.DenylistConfig{CIDRs: []string{"not-an-ip"}},
+		},
+		{
+			name:     "missing file",
+			denylist: &plugin.DenylistConfig{Path: "/does/not/exist"},
+		},
+		{
+			name:     "invalid refresh interval",
+			denylist: &plugin.DenylistConfig{RefreshInterval: "soon"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.Denylist = tt.denylist
+			if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
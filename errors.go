@@ -0,0 +1,217 @@
+package traefik_plugin_s3_auth
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	errorFormatXML   = "xml"
+	errorFormatJSON  = "json"
+	errorFormatPlain = "plain"
+)
+
+// errorFormatContextKey holds the format of the error bodies (string) when it isn't `xml`.
+const errorFormatContextKey contextKey = "s3auth.errorFormat"
+
+func checkErrorFormat(format string) error {
+	switch format {
+	case "", errorFormatXML, errorFormatJSON, errorFormatPlain:
+		return nil
+	default:
+		return fmt.Errorf("invalid `errorFormat` %q, must be `xml`, `json` or `plain`", format)
+	}
+}
+
+// negotiateErrorFormat returns the error format the `Accept` header of the client prefers, or "" when it accepts
+// none of them specifically, eg: `*/*`.
+func negotiateErrorFormat(accept string) string {
+	format, best := "", 0.0
+	for _, r := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(r), ";")
+		var f string
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/xml", "text/xml":
+			f = errorFormatXML
+		case "application/json":
+			f = errorFormatJSON
+		case "text/plain":
+			f = errorFormatPlain
+		default:
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && strings.EqualFold(k, "q") {
+				if n, err := strconv.ParseFloat(v, 64); err == nil {
+					q = n
+				}
+			}
+		}
+		if q > best {
+			format, best = f, q
+		}
+	}
+	return format
+}
+
+// s3Error is the body S3 returns for failed requests.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html#RESTErrorResponses
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error" json:"-"`
+	Code      string   `xml:"Code" json:"code"`
+	Message   string   `xml:"Message" json:"message"`
+	Resource  string   `xml:"Resource,omitempty" json:"resource,omitempty"`
+	RequestID string   `xml:"RequestId,omitempty" json:"requestId,omitempty"`
+	HostID    string   `xml:"HostId,omitempty" json:"hostId,omitempty"`
+	// The times of RequestTimeTooSkewed errors, which the SDKs use to correct the clock offset of the client.
+	RequestTime                string `xml:"RequestTime,omitempty" json:"requestTime,omitempty"`
+	ServerTime                 string `xml:"ServerTime,omitempty" json:"serverTime,omitempty"`
+	MaxAllowedSkewMilliseconds int64  `xml:"MaxAllowedSkewMilliseconds,omitempty" json:"maxAllowedSkewMilliseconds,omitempty"`
+	// The diagnostics of SignatureDoesNotMatch errors with `debugErrors`.
+	AWSAccessKeyID    string `xml:"AWSAccessKeyId,omitempty" json:"awsAccessKeyId,omitempty"`
+	CanonicalRequest  string `xml:"CanonicalRequest,omitempty" json:"canonicalRequest,omitempty"`
+	StringToSign      string `xml:"StringToSign,omitempty" json:"stringToSign,omitempty"`
+	DivergedComponent string `xml:"DivergedComponent,omitempty" json:"divergedComponent,omitempty"`
+}
+
+func writeS3Error(rw http.ResponseWriter, req *http.Request, status int, code, message string) {
+	writeError(rw, req, status, s3Error{Code: code, Message: message})
+}
+
+// writeError writes the error with the resource and the request ids, in the `errorFormat` or the `errorTemplate`.
+func writeError(rw http.ResponseWriter, req *http.Request, status int, e s3Error) {
+	e.Resource = req.URL.Path
+	e.RequestID, e.HostID = errorIDs(rw, req)
+	denied(req, status, e.Code)
+	kv := []interface{}{"requestId", e.RequestID, "hostId", e.HostID, "status", status, "code", e.Code, "resource", e.Resource}
+	if id := correlationID(req); id != "" {
+		kv = append(kv, "correlationId", id)
+	}
+	logs.info("error response", kv...)
+	if t, ok := req.Context().Value(errorTemplateContextKey).(*errorTemplate); ok {
+		t.write(rw, status, e)
+		return
+	}
+	switch format, _ := req.Context().Value(errorFormatContextKey).(string); format {
+	case errorFormatJSON:
+		b, _ := json.Marshal(e) // Can't fail, it only has strings.
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		_, _ = rw.Write(append(b, '\n'))
+	case errorFormatPlain:
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		rw.WriteHeader(status)
+		_, _ = fmt.Fprintf(rw, "%s: %s\n", e.Code, e.Message)
+	default:
+		writeXML(rw, status, e)
+	}
+}
+
+// authErrors are the S3 errors of the validation failures, with the status codes S3 uses so the SDKs classify them
+// the same, eg: they retry a RequestTimeTooSkewed after correcting their clock, but never a SignatureDoesNotMatch.
+var authErrors = []struct {
+	err     error
+	class   string
+	status  int
+	code    string
+	message string
+}{
+	{errSourcesUnavailable, "", http.StatusServiceUnavailable, "ServiceUnavailable", "The credential sources are unavailable."},
+	{errMalformedAuthorization, "", http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed."},
+	{errInvalidAccessKeyID, failureUnknownKey, http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."},
+	{errSignatureMismatch, failureBadSignature, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided. Check your key and signing method."},
+	{errRequestTimeTooSkewed, failureSkew, http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the current time is too large."},
+	{errInvalidToken, "", http.StatusBadRequest, "InvalidToken", "The provided token is malformed or otherwise invalid."},
+	{errExpiredToken, "", http.StatusBadRequest, "ExpiredToken", "The provided token has expired."},
+}
+
+// The failure classes whose status code `statusCodes` can override.
+const (
+	failureUnknownKey   = "unknownKey"
+	failureBadSignature = "badSignature"
+	failureSkew         = "skew"
+	failurePolicyDeny   = "policyDeny"
+)
+
+// The reasons of the failure metrics, a bounded set so alerts can tell a wrong clock from probing with invalid keys.
+const (
+	reasonMissing     = "missing_auth"
+	reasonParse       = "parse_error"
+	reasonUnknownKey  = "unknown_key"
+	reasonSkew        = "skew"
+	reasonSignature   = "signature_mismatch"
+	reasonToken       = "invalid_token"
+	reasonPolicyDeny  = "policy_deny"
+	reasonUnavailable = "unavailable"
+	reasonOther       = "other"
+)
+
+// failureReason returns the reason of a validation failure, before `collapseKeyErrors` hides it from the client.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, errMissingAuthorization):
+		return reasonMissing
+	case errors.Is(err, errKeyRegion), errors.Is(err, errInvalidAccessKeyID):
+		return reasonUnknownKey
+	case errors.Is(err, errMalformedAuthorization):
+		return reasonParse
+	case errors.Is(err, errRequestTimeTooSkewed):
+		return reasonSkew
+	case errors.Is(err, errSignatureMismatch):
+		return reasonSignature
+	case errors.Is(err, errInvalidToken), errors.Is(err, errExpiredToken):
+		return reasonToken
+	case errors.Is(err, errSourcesUnavailable):
+		return reasonUnavailable
+	}
+	return reasonOther
+}
+
+func checkStatusCodes(codes map[string]int) error {
+	for class, status := range codes {
+		switch class {
+		case failureUnknownKey, failureBadSignature, failureSkew, failurePolicyDeny:
+		default:
+			return fmt.Errorf("invalid `statusCodes` class %q, must be `unknownKey`, `badSignature`, `skew` or `policyDeny`", class)
+		}
+		if status < 400 || status > 599 {
+			return fmt.Errorf("invalid `statusCodes` status %d for %q, must be between 400 and 599", status, class)
+		}
+	}
+	return nil
+}
+
+// authError returns the failure class, the status code and the S3 error of a validation failure, `AccessDenied`
+// for the ones S3 has no specific error for, eg: a missing authorization header.
+func authError(err error) (string, int, s3Error) {
+	for _, a := range authErrors {
+		if errors.Is(err, a.err) {
+			e := s3Error{Code: a.code, Message: a.message}
+			var skew *skewError
+			if errors.As(err, &skew) {
+				e.RequestTime = skew.requestTime
+				e.ServerTime = skew.serverTime.UTC().Format(time.RFC3339)
+				e.MaxAllowedSkewMilliseconds = skew.maxSkew.Milliseconds()
+			}
+			return a.class, a.status, e
+		}
+	}
+	return "", http.StatusForbidden, s3Error{Code: "AccessDenied", Message: "Access Denied"}
+}
+
+// setRetryAfter tells throttled clients when to retry, in whole seconds, so SDKs back off instead of retrying at once.
+func setRetryAfter(rw http.ResponseWriter, d time.Duration) {
+	s := int64((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	rw.Header().Set("Retry-After", strconv.FormatInt(s, 10))
+}
@@ -0,0 +1,538 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestErrorFormat(t *testing.T) {
+	tc := []struct {
+		name                string
+		format              string
+		signed              bool
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "xml",
+			signed:              true,
+			expectedContentType: "text/xml",
+			expectedBody:        `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<Error><Code>AccessDenied</Code><Message>Access Denied</Message><Resource>/bucket/private/secret.txt</Resource><RequestId>{id}</RequestId><HostId>{hostId}</HostId></Error>`,
+		},
+		{
+			name:                "xml validation failure",
+			expectedContentType: "text/xml",
+			expectedBody:        `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<Error><Code>AccessDenied</Code><Message>Access Denied</Message><Resource>/bucket/private/secret.txt</Resource><RequestId>{id}</RequestId><HostId>{hostId}</HostId></Error>`,
+		},
+		{
+			name:                "json",
+			format:              "json",
+			signed:              true,
+			expectedContentType: "application/json",
+			expectedBody:        `{"code":"AccessDenied","message":"Access Denied","resource":"/bucket/private/secret.txt","requestId":"{id}","hostId":"{hostId}"}` + "\n",
+		},
+		{
+			name:                "json validation failure",
+			format:              "json",
+			expectedContentType: "application/json",
+			expectedBody:        `{"code":"AccessDenied","message":"Access Denied","resource":"/bucket/private/secret.txt","requestId":"{id}","hostId":"{hostId}"}` + "\n",
+		},
+		{
+			name:                "plain",
+			format:              "plain",
+			signed:              true,
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        "AccessDenied: Access Denied\n",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Policy = &plugin.Policy{Statement: []*plugin.PolicyStatement{
+				{Effect: "Allow", Action: []string{"s3:GetObject"}, Resource: []string{"arn:aws:s3:::bucket/public/*"}},
+			}}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.ErrorFormat = tt.format
+			p := newTestPlugin(t, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/private/secret.txt", nil)
+			if tt.signed {
+				signRequest(t, req, cred, p.Now())
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, recorder.Code)
+			}
+			if ct := recorder.Header().Get("Content-Type"); ct != tt.expectedContentType {
+				t.Errorf("expected the content type %q, got %q", tt.expectedContentType, ct)
+			}
+			// Without `requestIds`, the ids are minted for the errors.
+			id, hostID := recorder.Header().Get("X-Amz-Request-Id"), recorder.Header().Get("X-Amz-Id-2")
+			if id == "" || hostID == "" {
+				t.Errorf("expected the request ids, got %q and %q", id, hostID)
+			}
+			expected := strings.NewReplacer("{id}", id, "{hostId}", hostID).Replace(tt.expectedBody)
+			if recorder.Body.String() != expected {
+				t.Errorf("expected the body %q, got %q", expected, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestInvalidErrorFormat(t *testing.T) {
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.ErrorFormat = "yaml"
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := plugin.New(context.Background(), next, cfg, "s3-plugin"); err == nil {
+		t.Error("expected an error for an invalid error format")
+	}
+}
+
+func TestAuthErrors(t *testing.T) {
+	tc := []struct {
+		name           string
+		authorization  string
+		modify         func(cred *plugin.Credential)
+		skew           time.Duration
+		statusCode     int
+		collapse       bool
+		expectedStatus int
+		expectedCode   string
+		expectedDetail string
+	}{
+		{
+			name:           "missing authorization",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name:           "malformed authorization",
+			authorization:  "AWS4-HMAC-SHA256 Credential=ACCESS_ACCESS_ACCESS",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "AuthorizationHeaderMalformed",
+		},
+		{
+			name:           "unknown access key id",
+			modify:         func(cred *plugin.Credential) { cred.AccessKeyID = "UNKNOWN_UNKNOWN_UNKN" },
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "InvalidAccessKeyId",
+		},
+		{
+			name:           "unknown access key id collapsed",
+			modify:         func(cred *plugin.Credential) { cred.AccessKeyID = "UNKNOWN_UNKNOWN_UNKN" },
+			collapse:       true,
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "SignatureDoesNotMatch",
+		},
+		{
+			name:           "other region",
+			modify:         func(cred *plugin.Credential) { cred.Region = "eu-west-1" },
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "AuthorizationHeaderMalformed",
+		},
+		{
+			name:           "other region collapsed",
+			modify:         func(cred *plugin.Credential) { cred.Region = "eu-west-1" },
+			collapse:       true,
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "SignatureDoesNotMatch",
+		},
+		{
+			name:           "wrong secret",
+			modify:         func(cred *plugin.Credential) { cred.AccessSecretKey = "OTHER123secret123456OTHER123secret123456" },
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "SignatureDoesNotMatch",
+		},
+		{
+			name:           "skewed time",
+			skew:           -20 * time.Minute,
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "RequestTimeTooSkewed",
+			expectedDetail: "<RequestTime>20250710T052500Z</RequestTime><ServerTime>2025-07-10T05:45:00Z</ServerTime><MaxAllowedSkewMilliseconds>900000</MaxAllowedSkewMilliseconds>",
+		},
+		{
+			name:           "clock ahead",
+			skew:           20 * time.Minute,
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "RequestTimeTooSkewed",
+			expectedDetail: "<RequestTime>20250710T060500Z</RequestTime><ServerTime>2025-07-10T05:45:00Z</ServerTime>",
+		},
+		{
+			name:           "custom status code",
+			modify:         func(cred *plugin.Credential) { cred.AccessSecretKey = "OTHER123secret123456OTHER123secret123456" },
+			statusCode:     http.StatusUnauthorized,
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "SignatureDoesNotMatch",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			if tt.statusCode != 0 {
+				cfg.StatusCode = tt.statusCode
+			}
+			cfg.CollapseKeyErrors = tt.collapse
+			p := newTestPlugin(t, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/reports/2025.csv", nil)
+			if tt.modify != nil || tt.skew != 0 {
+				cred := validCredential()
+				if tt.modify != nil {
+					tt.modify(cred)
+				}
+				signRequest(t, req, cred, p.Now().Add(tt.skew))
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if !strings.Contains(recorder.Body.String(), "<Code>"+tt.expectedCode+"</Code>") {
+				t.Errorf("expected a %s error, got %s", tt.expectedCode, recorder.Body)
+			}
+			if !strings.Contains(recorder.Body.String(), tt.expectedDetail) {
+				t.Errorf("expected the error to contain %s, got %s", tt.expectedDetail, recorder.Body)
+			}
+			if h := req.Header.Get("Authorization"); strings.Contains(h, "Signature=") && strings.Contains(recorder.Body.String(), h[strings.LastIndex(h, "=")+1:]) {
+				t.Errorf("expected the error not to echo the signature, got %s", recorder.Body)
+			}
+		})
+	}
+}
+
+func TestErrorTemplate(t *testing.T) {
+	tc := []struct {
+		name                string
+		config              *plugin.ErrorTemplateConfig
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "html",
+			config:              &plugin.ErrorTemplateConfig{Template: `<h1>{{ .Status }} {{ .Code }}</h1><p>{{ .Message }} ({{ .Resource }}, {{ .RequestId }})</p>`},
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        `<h1>403 AccessDenied</h1><p>Access Denied (/bucket/&lt;b&gt;.txt, {id})</p>`,
+		},
+		{
+			name:                "text",
+			config:              &plugin.ErrorTemplateConfig{Template: `{"error":"{{ .Code }}","path":"{{ .Resource }}","trace":"{{ .HostId }}"}`, ContentType: "application/json"},
+			expectedContentType: "application/json",
+			expectedBody:        `{"error":"AccessDenied","path":"/bucket/<b>.txt","trace":"{hostId}"}`,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.ErrorTemplate = tt.config
+			p := newTestPlugin(t, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/%3Cb%3E.txt", nil)
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, recorder.Code)
+			}
+			if ct := recorder.Header().Get("Content-Type"); ct != tt.expectedContentType {
+				t.Errorf("expected the content type %q, got %q", tt.expectedContentType, ct)
+			}
+			id, hostID := recorder.Header().Get("X-Amz-Request-Id"), recorder.Header().Get("X-Amz-Id-2")
+			expected := strings.NewReplacer("{id}", id, "{hostId}", hostID).Replace(tt.expectedBody)
+			if recorder.Body.String() != expected {
+				t.Errorf("expected the body %q, got %q", expected, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestInvalidErrorTemplate(t *testing.T) {
+	tc := []struct {
+		name   string
+		format string
+		config *plugin.ErrorTemplateConfig
+	}{
+		{
+			name:   "invalid template",
+			config: &plugin.ErrorTemplateConfig{Template: "{{ .Code "},
+		},
+		{
+			name:   "combined with an error format",
+			format: "json",
+			config: &plugin.ErrorTemplateConfig{Template: "{{ .Code }}"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.ErrorFormat = tt.format
+			cfg.ErrorTemplate = tt.config
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := plugin.New(context.Background(), next, cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "errorTemplate") {
+				t.Errorf("expected an errorTemplate error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStatusCodes(t *testing.T) {
+	tc := []struct {
+		name           string
+		modify         func(cred *plugin.Credential)
+		skew           time.Duration
+		path           string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "unknown key",
+			modify:         func(cred *plugin.Credential) { cred.AccessKeyID = "UNKNOWN_UNKNOWN_UNKN" },
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "InvalidAccessKeyId",
+		},
+		{
+			name:           "bad signature",
+			modify:         func(cred *plugin.Credential) { cred.AccessSecretKey = "OTHER123secret123456OTHER123secret123456" },
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "SignatureDoesNotMatch",
+		},
+		{
+			name:           "skew keeps its status",
+			skew:           -20 * time.Minute,
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "RequestTimeTooSkewed",
+		},
+		{
+			name:           "policy deny",
+			path:           "/bucket/private/secret.txt",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "AccessDenied",
+		},
+		{
+			name:           "allowed",
+			path:           "/bucket/public/index.html",
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Policy = &plugin.Policy{Statement: []*plugin.PolicyStatement{
+				{Effect: "Allow", Action: []string{"s3:GetObject"}, Resource: []string{"arn:aws:s3:::bucket/public/*"}},
+			}}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.StatusCodes = map[string]int{"unknownKey": http.StatusUnauthorized, "badSignature": http.StatusUnauthorized, "policyDeny": http.StatusNotFound}
+			p := newTestPlugin(t, cfg)
+
+			path := tt.path
+			if path == "" {
+				path = "/bucket/public/index.html"
+			}
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com"+path, nil)
+			signAs := validCredential()
+			if tt.modify != nil {
+				tt.modify(signAs)
+			}
+			signRequest(t, req, signAs, p.Now().Add(tt.skew))
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedCode != "" && !strings.Contains(recorder.Body.String(), "<Code>"+tt.expectedCode+"</Code>") {
+				t.Errorf("expected a %s error, got %s", tt.expectedCode, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestInvalidStatusCodes(t *testing.T) {
+	for _, codes := range []map[string]int{{"unknown": http.StatusUnauthorized}, {"skew": http.StatusOK}} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.StatusCodes = codes
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+		if _, err := plugin.New(context.Background(), next, cfg, "s3-plugin"); err == nil {
+			t.Errorf("expected an error for the status codes %v", codes)
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	tc := []struct {
+		name              string
+		challenge         bool
+		signed            bool
+		expectedStatus    int
+		expectedChallenge string
+	}{
+		{
+			name:              "missing authorization",
+			challenge:         true,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: "AWS4-HMAC-SHA256",
+		},
+		{
+			name:           "bad signature",
+			challenge:      true,
+			signed:         true,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "disabled",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.Challenge = tt.challenge
+			p := newTestPlugin(t, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/reports/2025.csv", nil)
+			if tt.signed {
+				cred := validCredential()
+				cred.AccessSecretKey = "OTHER123secret123456OTHER123secret123456"
+				signRequest(t, req, cred, p.Now())
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if v := recorder.Header().Get("WWW-Authenticate"); v != tt.expectedChallenge {
+				t.Errorf("expected the challenge %q, got %q", tt.expectedChallenge, v)
+			}
+		})
+	}
+}
+
+func TestDebugErrors(t *testing.T) {
+	tc := []struct {
+		name             string
+		debug            bool
+		dateSkew         time.Duration
+		expectedDetails  []string
+		expectedDiverged string
+	}{
+		{
+			name:             "wrong secret",
+			debug:            true,
+			expectedDetails:  []string{"<AWSAccessKeyId>ACCESS_ACCESS_ACCESS</AWSAccessKeyId>", "<CanonicalRequest>GET\n/bucket/reports/2025.csv\n", "<StringToSign>AWS4-HMAC-SHA256\n20250710T054500Z\n20250710/us-east-1/s3/aws4_request\n"},
+			expectedDiverged: "CanonicalRequestOrSecret",
+		},
+		{
+			name:             "credential scope of another day",
+			debug:            true,
+			dateSkew:         24 * time.Hour,
+			expectedDiverged: "CredentialScope",
+		},
+		{
+			name: "disabled",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.DebugErrors = tt.debug
+			p := newTestPlugin(t, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/reports/2025.csv", nil)
+			cred := validCredential()
+			cred.AccessSecretKey = "OTHER123secret123456OTHER123secret123456"
+			signRequest(t, req, cred, p.Now())
+			if tt.dateSkew != 0 {
+				h := req.Header.Get("Authorization")
+				req.Header.Set("Authorization", strings.Replace(h, "/20250710/", "/"+p.Now().Add(tt.dateSkew).Format("20060102")+"/", 1))
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			body := recorder.Body.String()
+			if !strings.Contains(body, "<Code>SignatureDoesNotMatch</Code>") {
+				t.Fatalf("expected a SignatureDoesNotMatch error, got %s", body)
+			}
+			for _, d := range tt.expectedDetails {
+				if !strings.Contains(body, strings.ReplaceAll(d, "\n", "&#xA;")) {
+					t.Errorf("expected the error to contain %q, got %s", d, body)
+				}
+			}
+			if tt.expectedDiverged != "" && !strings.Contains(body, "<DivergedComponent>"+tt.expectedDiverged+"</DivergedComponent>") {
+				t.Errorf("expected the diverged component %s, got %s", tt.expectedDiverged, body)
+			}
+			if !tt.debug && strings.Contains(body, "CanonicalRequest") {
+				t.Errorf("expected no diagnostics, got %s", body)
+			}
+		})
+	}
+}
+
+func TestNegotiateErrorFormat(t *testing.T) {
+	tc := []struct {
+		name                string
+		format              string
+		accept              string
+		expectedContentType string
+	}{
+		{
+			name:                "json",
+			accept:              "application/json",
+			expectedContentType: "application/json",
+		},
+		{
+			name:                "xml over the default",
+			format:              "json",
+			accept:              "application/xml",
+			expectedContentType: "text/xml",
+		},
+		{
+			name:                "quality",
+			accept:              "application/xml;q=0.5, application/json;q=0.9, */*;q=0.1",
+			expectedContentType: "application/json",
+		},
+		{
+			name:                "wildcard falls back to the default",
+			format:              "plain",
+			accept:              "*/*",
+			expectedContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:                "missing falls back to the default",
+			expectedContentType: "text/xml",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.ErrorFormat = tt.format
+			cfg.NegotiateErrorFormat = true
+			p := newTestPlugin(t, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/reports/2025.csv", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if ct := recorder.Header().Get("Content-Type"); ct != tt.expectedContentType {
+				t.Errorf("expected the content type %q, got %q: %s", tt.expectedContentType, ct, recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+const defaultErrorContentType = "text/html; charset=utf-8"
+
+// errorTemplateContextKey holds the errorTemplate (*errorTemplate) rendering the error bodies.
+const errorTemplateContextKey contextKey = "s3auth.errorTemplate"
+
+// ErrorTemplateConfig renders the error responses with a Go template, eg: a branded error page.
+type ErrorTemplateConfig struct {
+	// Template is executed with the `Status`, `Code`, `Message`, `Resource`, `RequestId` and `HostId` of the error.
+	Template string `json:"template,omitempty"`
+	// ContentType of the rendered errors, defaults to `text/html; charset=utf-8`. The fields are escaped for HTML
+	// content types.
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// errorTemplateData is what the error templates are executed with, eg: `{{ .Code }}`. The ids are named like in the
+// S3 errors.
+type errorTemplateData struct {
+	Status    int
+	Code      string
+	Message   string
+	Resource  string
+	RequestId string //nolint:revive,stylecheck // Named like the S3 error element.
+	HostId    string //nolint:revive,stylecheck // Named like the S3 error element.
+}
+
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+type errorTemplate struct {
+	tmpl        executor
+	contentType string
+}
+
+func newErrorTemplate(config *Config) (*errorTemplate, error) {
+	if config.ErrorTemplate == nil {
+		return nil, nil
+	}
+	if config.ErrorFormat != "" || config.NegotiateErrorFormat {
+		return nil, errors.New("`errorFormat` and `negotiateErrorFormat` can't be combined with `errorTemplate`")
+	}
+	t := &errorTemplate{contentType: config.ErrorTemplate.ContentType}
+	if t.contentType == "" {
+		t.contentType = defaultErrorContentType
+	}
+	var err error
+	if strings.HasPrefix(t.contentType, "text/html") {
+		t.tmpl, err = htmltemplate.New("error").Option("missingkey=zero").Parse(config.ErrorTemplate.Template)
+	} else {
+		t.tmpl, err = template.New("error").Option("missingkey=zero").Parse(config.ErrorTemplate.Template)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid `errorTemplate`: %w", err)
+	}
+	return t, nil
+}
+
+// write renders the error, falling back to the S3 XML error when the template fails.
+func (t *errorTemplate) write(rw http.ResponseWriter, status int, e s3Error) {
+	var b bytes.Buffer
+	data := errorTemplateData{
+		Status: status, Code: e.Code, Message: e.Message, Resource: e.Resource, RequestId: e.RequestID, HostId: e.HostID,
+	}
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		logs.error("failed to render the error template", "code", e.Code, "error", err)
+		writeXML(rw, status, e)
+		return
+	}
+	rw.Header().Set("Content-Type", t.contentType)
+	rw.WriteHeader(status)
+	_, _ = rw.Write(b.Bytes())
+}
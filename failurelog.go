@@ -0,0 +1,70 @@
+package traefik_plugin_s3_auth
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// failureLog appends a line per failed authentication to a file or the standard output, in a stable format that
+// Fail2ban or CrowdSec filters can match, eg:
+//
+//	2025-07-10T05:45:00Z s3auth authentication failure from 192.0.2.1 reason=signature_mismatch accessKeyId="AKIA..." middleware="s3-auth"
+//
+// The client ip and the reason come first and the values the client controls are quoted, so a crafted access key id
+// can't forge a line for another ip. The files are shared process-wide by path, like the decision log.
+type failureLog struct {
+	mu   sync.Mutex
+	path string
+	w    io.Writer
+}
+
+var (
+	failureLogsMu sync.Mutex
+	failureLogs   = map[string]*failureLog{}
+)
+
+func sharedFailureLog(path string) (*failureLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	failureLogsMu.Lock()
+	defer failureLogsMu.Unlock()
+
+	if l, ok := failureLogs[path]; ok {
+		return l, nil
+	}
+	l := &failureLog{path: path}
+	if path != decisionLogStdout {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the `failureLog`: %w", err)
+		}
+		l.w = f
+	}
+	failureLogs[path] = l
+	return l, nil
+}
+
+// write logs the failure of the client ip, the claimed access key id being empty when the header couldn't be parsed.
+// Failures without a client ip have nothing to ban and aren't logged.
+func (l *failureLog) write(now time.Time, ip, reason, accessKeyID, name string) {
+	if ip == "" {
+		return
+	}
+	line := fmt.Sprintf("%s s3auth authentication failure from %s reason=%s accessKeyId=%q middleware=%q\n",
+		now.UTC().Format(time.RFC3339), ip, reason, accessKeyID, name)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := l.w
+	if w == nil {
+		w = os.Stdout
+	}
+	if _, err := io.WriteString(w, line); err != nil {
+		logs.error("failed to write the failure log", "path", l.path, "error", err)
+	}
+}
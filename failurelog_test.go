@@ -0,0 +1,52 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestFailureLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failures.log")
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.FailureLog = path
+	p := newTestPlugin(t, cfg)
+
+	wrong := validCredential()
+	wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+	unknown := validCredential()
+	unknown.AccessKeyID = "AKIAUNKNOWN"
+	// Unsigned and valid requests aren't failures.
+	for _, c := range []*plugin.Credential{nil, validCredential(), wrong, unknown} {
+		req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+		if c != nil {
+			signRequest(t, req, c, p.Now())
+		}
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `2025-07-10T05:45:00Z s3auth authentication failure from 192.0.2.1 reason=signature_mismatch accessKeyId="ACCESS_ACCESS_ACCESS" middleware="s3-plugin"` + "\n" +
+		`2025-07-10T05:45:00Z s3auth authentication failure from 192.0.2.1 reason=unknown_key accessKeyId="AKIAUNKNOWN" middleware="s3-plugin"` + "\n"
+	if string(b) != expected {
+		t.Errorf("expected the failures:\n%s\ngot:\n%s", expected, b)
+	}
+
+	// The failregex of the Fail2ban filter in the README, with `<HOST>` matching the ip.
+	filter := regexp.MustCompile(`^\S+ s3auth authentication failure from (\S+) reason=\S+`)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if m := filter.FindStringSubmatch(line); m == nil || m[1] != "192.0.2.1" {
+			t.Errorf("expected the filter to match %q", line)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GeoConfig trusts the country set by a CDN, eg: Cloudflare's `CF-IPCountry`, for the `s3auth:Country` condition.
+type GeoConfig struct {
+	// Header carrying the ISO 3166-1 alpha-2 country of the client, eg: `CF-IPCountry` or `X-Geo-Country`.
+	Header string `json:"header,omitempty"`
+	// TrustedCIDRs are the peer addresses of the CDN, the header is ignored on requests from anywhere else. It's
+	// always trusted when empty, which is only safe when the CDN is the only way in.
+	TrustedCIDRs []string `json:"trustedCidrs,omitempty"`
+}
+
+type geo struct {
+	header  string
+	trusted []string
+}
+
+func newGeo(config *GeoConfig) (*geo, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.Header == "" {
+		return nil, errors.New("must specify the geo `header`, eg: `CF-IPCountry`")
+	}
+	trusted, err := normalizeCIDRs(config.TrustedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid geo `trustedCidrs`: %w", err)
+	}
+	return &geo{header: config.Header, trusted: trusted}, nil
+}
+
+// country returns the upper case country of the client, or an empty string when it's unknown or can't be trusted.
+func (g *geo) country(req *http.Request) string {
+	if g == nil {
+		return ""
+	}
+	if len(g.trusted) > 0 && !inCIDRs(g.trusted, clientIP(req, 0)) {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSpace(req.Header.Get(g.header)))
+}
@@ -0,0 +1,149 @@
+package traefik_plugin_s3_auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultGrantActions lets a grant without actions read the objects under its prefix.
+var defaultGrantActions = []string{"s3:GetObject"}
+
+// Grant temporarily allows a credential the actions under a prefix, eg: for sharing a dataset without editing the
+// permanent policies. Grants lapse on their own once they expire.
+type Grant struct {
+	// ID identifies the grant in the admin server, it is generated for grants added through it.
+	ID string `json:"id,omitempty"`
+	// AccessKeyID is the granted credential, temporary credentials it issued are also covered.
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+	// Prefix is a `bucket/prefix` entry, eg: `datasets/2025/`. Listings are covered through their `prefix` parameter.
+	Prefix string `json:"prefix,omitempty"`
+	// Actions are the IAM actions, `*` wildcards are supported. Defaults to `s3:GetObject`.
+	Actions []string `json:"actions,omitempty"`
+	// Expires is the RFC3339 timestamp the grant lapses at.
+	Expires string `json:"expires,omitempty"`
+	// Reason is free-form, eg: the ticket of the request.
+	Reason string `json:"reason,omitempty"`
+
+	expires time.Time
+}
+
+func checkGrant(g *Grant) error {
+	if g.AccessKeyID == "" {
+		return errors.New("must specify the `accessKeyId`")
+	}
+	if _, err := normalizeObjectPrefixes([]string{g.Prefix}); err != nil {
+		return err
+	}
+	t, err := time.Parse(time.RFC3339, g.Expires)
+	if err != nil {
+		return fmt.Errorf("invalid `expires`: %w", err)
+	}
+	g.expires = t
+	if len(g.Actions) == 0 {
+		g.Actions = defaultGrantActions
+	}
+	return nil
+}
+
+// covers reports whether the grant allows the request of the credential at the time.
+func (g *Grant) covers(user string, op s3Operation, res s3Resource, q string, now time.Time) bool {
+	if g.AccessKeyID != user || !now.Before(g.expires) || !matchesWildcard(g.Actions, op.Action, true) {
+		return false
+	}
+	name := res.Bucket + "/" + res.Key
+	if listings[op.Name] {
+		name = res.Bucket + "/" + q
+	}
+	return strings.HasPrefix(name, g.Prefix)
+}
+
+// grants are the grants added through the admin server. They outlive the middleware instances that Traefik recreates
+// on every configuration reload.
+type grants struct {
+	mu   sync.RWMutex
+	list []*Grant
+}
+
+func (gs *grants) add(g *Grant) error {
+	if err := checkGrant(g); err != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	g.ID = hex.EncodeToString(id)
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.list = append(gs.list, g)
+	return nil
+}
+
+// revoke removes the grant, it reports whether it existed.
+func (gs *grants) revoke(id string) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for i, g := range gs.list {
+		if g.ID == id {
+			gs.list = append(gs.list[:i], gs.list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// active drops the expired grants and returns the others, sorted by expiry.
+func (gs *grants) active(now time.Time) []*Grant {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	kept := gs.list[:0]
+	for _, g := range gs.list {
+		if now.Before(g.expires) {
+			kept = append(kept, g)
+		}
+	}
+	gs.list = kept
+	out := append([]*Grant(nil), kept...)
+	sort.Slice(out, func(i, j int) bool { return out[i].expires.Before(out[j].expires) })
+	return out
+}
+
+func (gs *grants) find(user string, op s3Operation, res s3Resource, q string, now time.Time) *Grant {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return findGrant(gs.list, user, op, res, q, now)
+}
+
+func findGrant(list []*Grant, user string, op s3Operation, res s3Resource, q string, now time.Time) *Grant {
+	for _, g := range list {
+		if g.covers(user, op, res, q, now) {
+			return g
+		}
+	}
+	return nil
+}
+
+// grant returns the configured or admin grant allowing the request, if any. Grants count as an `Allow` statement:
+// explicit denies still win and the access restrictions of the credential still apply.
+func (p *Plugin) grant(req *http.Request, cred *Credential, op s3Operation, res s3Resource, now time.Time) *Grant {
+	user := cred.AccessKeyID
+	if cred.parent != "" {
+		user = cred.parent
+	}
+	q := req.URL.Query().Get("prefix")
+	if g := findGrant(p.grants, user, op, res, q, now); g != nil {
+		return g
+	}
+	if p.admin != nil {
+		return p.admin.grants.find(user, op, res, q, now)
+	}
+	return nil
+}
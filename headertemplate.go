@@ -0,0 +1,60 @@
+package traefik_plugin_s3_auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// headerTemplateData is what the header templates are executed with, eg: `{{ .Tags.team }}`.
+type headerTemplateData struct {
+	AccessKeyID string
+	Parent      string
+	Tenant      string
+	Roles       []string
+	Groups      []string
+	Tags        map[string]string
+	Operation   string
+	Bucket      string
+	Key         string
+}
+
+type headerTemplate struct {
+	name string
+	tmpl *template.Template
+}
+
+// compileHeaderTemplates parses the Go templates of the headers. Missing tags render as an empty string.
+func compileHeaderTemplates(headers map[string]string) ([]headerTemplate, error) {
+	out := make([]headerTemplate, 0, len(headers))
+	for name, text := range headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return nil, fmt.Errorf("invalid header name: %q", name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for header %q: %w", name, err)
+		}
+		out = append(out, headerTemplate{name: http.CanonicalHeaderKey(name), tmpl: tmpl})
+	}
+	return out, nil
+}
+
+// injectHeaders sets the templated headers, the ones that fail to render or render empty are removed instead.
+func injectHeaders(req *http.Request, templates []headerTemplate, data headerTemplateData) {
+	for _, h := range templates {
+		var b strings.Builder
+		if err := h.tmpl.Execute(&b, data); err != nil {
+			logs.error("failed to render the header", "header", h.name, "accessKeyId", data.AccessKeyID, "error", err)
+			req.Header.Del(h.name)
+			continue
+		}
+		v := b.String()
+		if v == "" || strings.ContainsAny(v, "\r\n") {
+			req.Header.Del(h.name)
+			continue
+		}
+		req.Header.Set(h.name, v)
+	}
+}
@@ -0,0 +1,97 @@
+package traefik_plugin_s3_auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// hygiene flags credentials that are about to expire or have not been used for a while.
+type hygiene struct {
+	expiryWarning time.Duration
+	unusedWarning time.Duration
+	interval      time.Duration
+	started       time.Time
+}
+
+func newHygiene(config *Config) (hygiene, error) {
+	if config.ExpiryWarningDays < 0 || config.UnusedWarningDays < 0 {
+		return hygiene{}, errors.New("`expiryWarningDays` and `unusedWarningDays` must not be negative")
+	}
+	h := hygiene{
+		expiryWarning: time.Duration(config.ExpiryWarningDays) * day,
+		unusedWarning: time.Duration(config.UnusedWarningDays) * day,
+	}
+	if h.enabled() {
+		d, err := time.ParseDuration(config.HygieneInterval)
+		if err != nil || d <= 0 {
+			return hygiene{}, fmt.Errorf("invalid `hygieneInterval` %q, eg: `1h`", config.HygieneInterval)
+		}
+		h.interval = d
+	}
+	return h, nil
+}
+
+func (h hygiene) enabled() bool {
+	return h.expiryWarning > 0 || h.unusedWarning > 0
+}
+
+// check returns the warnings for a single credential.
+func (h hygiene) check(cred *Credential, u usage, now time.Time) []string {
+	var warnings []string
+	if !cred.notAfter.IsZero() {
+		switch left := cred.notAfter.Sub(now); {
+		case left <= 0:
+			warnings = append(warnings, "expired")
+		case h.expiryWarning > 0 && left <= h.expiryWarning:
+			warnings = append(warnings, "expiring")
+		}
+	}
+	if h.unusedWarning > 0 {
+		// Credentials that were never used are measured from when the plugin started.
+		last := u.LastUsed
+		if last.IsZero() {
+			last = h.started
+		}
+		if now.Sub(last) >= h.unusedWarning {
+			warnings = append(warnings, "unused")
+		}
+	}
+	return warnings
+}
+
+// warnHygiene logs the warnings of every credential, and sets the gauges of their counts.
+func (p *Plugin) warnHygiene(now time.Time) {
+	counts := map[string]int64{"expired": 0, "expiring": 0, "unused": 0}
+	for _, cred := range p.store.list() {
+		for _, w := range p.hygiene.check(cred, p.store.usage.get(cred.AccessKeyID), now) {
+			counts[w]++
+			switch w {
+			case "expired":
+				logs.warn("credential expired", "accessKeyId", cred.AccessKeyID, "notAfter", cred.NotAfter)
+			case "expiring":
+				logs.warn("credential expires soon", "accessKeyId", cred.AccessKeyID, "notAfter", cred.NotAfter)
+			case "unused":
+				logs.warn("credential is unused", "accessKeyId", cred.AccessKeyID, "unusedDays", int64(p.hygiene.unusedWarning/day))
+			}
+		}
+	}
+	p.metrics.hygienePassed(counts)
+}
+
+func (p *Plugin) watchHygiene(ctx context.Context) {
+	ticker := time.NewTicker(p.hygiene.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.warnHygiene(p.Now())
+		}
+	}
+}
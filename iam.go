@@ -0,0 +1,304 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIAMHeader    = "X-S3Auth-Iam-Request"
+	defaultIAMEndpoint  = "https://sts.amazonaws.com/"
+	iamServerIDHeader   = "x-s3auth-server-id"
+	getCallerIdentity   = "Action=GetCallerIdentity&Version=2011-06-15"
+	defaultIAMCacheTTL  = 5 * time.Minute
+	maxIAMCacheEntries  = 4096
+	maxIAMResponseBytes = 1 << 20
+	iamARNTag           = "arn"
+)
+
+// IAMConfig authenticates genuine AWS IAM users and roles without sharing their secrets with the proxy. Clients send
+// a signed `sts:GetCallerIdentity` request in a header, which is forwarded untouched to AWS STS, the same way Vault's
+// AWS auth method works. The returned ARN is then mapped to local principals.
+type IAMConfig struct {
+	// Header carrying the base64 encoded JSON request, defaults to `X-S3Auth-Iam-Request`.
+	Header string `json:"header,omitempty"`
+	// Endpoint of AWS STS, defaults to `https://sts.amazonaws.com/`. Requests for other URLs are rejected.
+	Endpoint string `json:"endpoint,omitempty"`
+	// ServerID must be signed into the STS request as `x-s3auth-server-id` so it can't be replayed elsewhere.
+	ServerID string `json:"serverId,omitempty"`
+	// CacheTTL is how long a verified STS request is trusted before it's sent to STS again, defaults to `5m`.
+	CacheTTL string `json:"cacheTtl,omitempty"`
+	// Principals maps ARNs to local metadata, the first match wins.
+	Principals []*IAMPrincipal `json:"principals,omitempty"`
+}
+
+// IAMPrincipal maps ARNs matching a pattern, eg: `arn:aws:sts::123456789012:assumed-role/ci-*/*`, to local tags and
+// restrictions.
+type IAMPrincipal struct {
+	ARN             string            `json:"arn,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	Roles           []string          `json:"roles,omitempty"`
+	Groups          []string          `json:"groups,omitempty"`
+	AllowedPrefixes []string          `json:"allowedPrefixes,omitempty"`
+	AllowedMethods  []string          `json:"allowedMethods,omitempty"`
+	AllowedBuckets  []string          `json:"allowedBuckets,omitempty"`
+	AllowedCIDRs    []string          `json:"allowedCidrs,omitempty"`
+	AllowedKeys     []string          `json:"allowedKeys,omitempty"`
+	Policy          *Policy           `json:"policy,omitempty"`
+	AccessWindows   []*AccessWindow   `json:"accessWindows,omitempty"`
+	Tenant          string            `json:"tenant,omitempty"`
+	// RequireListDelimiter requires listings to set a `delimiter`.
+	RequireListDelimiter bool `json:"requireListDelimiter,omitempty"`
+
+	windows []accessWindow
+}
+
+type iamVerifier struct {
+	header     string
+	endpoint   string
+	serverID   string
+	ttl        time.Duration
+	principals []*IAMPrincipal
+	client     *http.Client
+
+	mu     sync.Mutex
+	cache  map[string]iamIdentity
+	hits   uint64
+	misses uint64
+}
+
+type iamIdentity struct {
+	arn     string
+	expires time.Time
+}
+
+func newIAMVerifier(config *IAMConfig) (*iamVerifier, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.ServerID == "" {
+		return nil, errors.New("must specify the iam `serverId`")
+	}
+	if len(config.Principals) == 0 {
+		return nil, errors.New("must specify at least one iam principal")
+	}
+	for _, p := range config.Principals {
+		if _, err := path.Match(p.ARN, ""); err != nil || p.ARN == "" {
+			return nil, fmt.Errorf("invalid iam principal arn pattern: %q", p.ARN)
+		}
+		prefixes, err := normalizePrefixes(p.AllowedPrefixes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid `allowedPrefixes` for iam principal %q: %w", p.ARN, err)
+		}
+		p.AllowedPrefixes = prefixes
+		if p.AllowedMethods, err = normalizeMethods(p.AllowedMethods); err != nil {
+			return nil, fmt.Errorf("invalid `allowedMethods` for iam principal %q: %w", p.ARN, err)
+		}
+		if p.AllowedBuckets, err = normalizeBuckets(p.AllowedBuckets); err != nil {
+			return nil, fmt.Errorf("invalid `allowedBuckets` for iam principal %q: %w", p.ARN, err)
+		}
+		if p.AllowedCIDRs, err = normalizeCIDRs(p.AllowedCIDRs); err != nil {
+			return nil, fmt.Errorf("invalid `allowedCidrs` for iam principal %q: %w", p.ARN, err)
+		}
+		if p.AllowedKeys, err = normalizeKeys(p.AllowedKeys); err != nil {
+			return nil, fmt.Errorf("invalid `allowedKeys` for iam principal %q: %w", p.ARN, err)
+		}
+		if err := checkPolicy(p.Policy); err != nil {
+			return nil, fmt.Errorf("invalid `policy` for iam principal %q: %w", p.ARN, err)
+		}
+		if p.windows, err = compileWindows(p.AccessWindows); err != nil {
+			return nil, fmt.Errorf("invalid `accessWindows` for iam principal %q: %w", p.ARN, err)
+		}
+		if err := checkTenant(p.Tenant); err != nil {
+			return nil, fmt.Errorf("invalid `tenant` for iam principal %q: %w", p.ARN, err)
+		}
+	}
+	v := &iamVerifier{
+		header:     config.Header,
+		endpoint:   config.Endpoint,
+		serverID:   config.ServerID,
+		ttl:        defaultIAMCacheTTL,
+		principals: config.Principals,
+		client:     &http.Client{Timeout: sourceTimeout},
+		cache:      map[string]iamIdentity{},
+	}
+	if v.header == "" {
+		v.header = defaultIAMHeader
+	}
+	if v.endpoint == "" {
+		v.endpoint = defaultIAMEndpoint
+	}
+	if config.CacheTTL != "" {
+		d, err := time.ParseDuration(config.CacheTTL)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid iam `cacheTtl` %q, eg: `5m`", config.CacheTTL)
+		}
+		v.ttl = d
+	}
+	return v, nil
+}
+
+// iamRequest is the signed `sts:GetCallerIdentity` request sent by the client.
+type iamRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers"`
+}
+
+// verify authenticates the request through AWS STS. The access key id in the S3 `Authorization` header must match the
+// one that signed the STS request, since the S3 signature itself can't be checked without the secret.
+func (v *iamVerifier) verify(req *http.Request, headerName string, now time.Time) (*Credential, error) {
+	raw := req.Header.Get(v.header)
+	b, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("invalid iam request encoding")
+	}
+	var ir iamRequest
+	if err := json.Unmarshal(b, &ir); err != nil {
+		return nil, errors.New("invalid iam request")
+	}
+	if ir.Method != http.MethodPost || ir.URL != v.endpoint || ir.Body != getCallerIdentity {
+		return nil, errors.New("the iam request must be a GetCallerIdentity call to the configured endpoint")
+	}
+	headers := http.Header{}
+	for k, val := range ir.Headers {
+		headers.Set(k, val)
+	}
+	stsAuth, err := parseHeader(headers.Get("Authorization"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the iam request authorization: %w", err)
+	}
+	if !containsFold(stsAuth.SignedHeaders, iamServerIDHeader) || headers.Get(iamServerIDHeader) != v.serverID {
+		return nil, fmt.Errorf("the iam request must sign the %q header with the configured server id", iamServerIDHeader)
+	}
+	a, err := parseHeader(req.Header.Get(headerName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse authorization header: %w", err)
+	}
+	if a.AccessKeyID != stsAuth.AccessKeyID {
+		return nil, errors.New("the iam request was signed by a different access key id")
+	}
+
+	sum := sha256.Sum256(b)
+	id := hex.EncodeToString(sum[:])
+	arn, err := v.callerARN(id, ir, headers, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range v.principals {
+		if ok, _ := path.Match(p.ARN, arn); !ok {
+			continue
+		}
+		tags := map[string]string{iamARNTag: arn}
+		for k, val := range p.Tags {
+			tags[k] = val
+		}
+		return &Credential{
+			AccessKeyID: a.AccessKeyID,
+			Region:      a.Region,
+			Service:     a.Service,
+			Tags:        tags,
+			Roles:       p.Roles,
+			Groups:      p.Groups,
+			Policy:      p.Policy,
+			windows:     p.windows,
+			Tenant:      p.Tenant,
+			scope:       accessScope{Prefixes: p.AllowedPrefixes, Methods: p.AllowedMethods, Buckets: p.AllowedBuckets, CIDRs: p.AllowedCIDRs, Keys: p.AllowedKeys, Delimiter: p.RequireListDelimiter},
+		}, nil
+	}
+	return nil, fmt.Errorf("no iam principal matches %q", arn)
+}
+
+func (v *iamVerifier) callerARN(id string, ir iamRequest, headers http.Header, now time.Time) (string, error) {
+	v.mu.Lock()
+	if c, ok := v.cache[id]; ok && now.Before(c.expires) {
+		v.hits++
+		v.mu.Unlock()
+		return c.arn, nil
+	}
+	v.misses++
+	v.mu.Unlock()
+
+	arn, err := v.getCallerIdentity(ir, headers)
+	if err != nil {
+		return "", err
+	}
+
+	v.mu.Lock()
+	if len(v.cache) >= maxIAMCacheEntries {
+		v.cache = map[string]iamIdentity{}
+	}
+	v.cache[id] = iamIdentity{arn: arn, expires: now.Add(v.ttl)}
+	v.mu.Unlock()
+	return arn, nil
+}
+
+// cacheStatus describes the cache of the verified STS requests.
+func (v *iamVerifier) cacheStatus() CacheStatus {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return newCacheStatus(len(v.cache), v.hits, v.misses)
+}
+
+type getCallerIdentityResponse struct {
+	Arn     string `xml:"GetCallerIdentityResult>Arn"`
+	Account string `xml:"GetCallerIdentityResult>Account"`
+}
+
+// getCallerIdentity forwards the client request to STS as is, so its signature stays valid. STS being down or failing
+// is an `errSourcesUnavailable`, so clients retry rather than treat it as a rejected identity.
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_GetCallerIdentity.html
+func (v *iamVerifier) getCallerIdentity(ir iamRequest, headers http.Header) (string, error) {
+	u, err := url.Parse(ir.URL)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sourceTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, ir.Method, u.String(), bytes.NewReader([]byte(ir.Body)))
+	if err != nil {
+		return "", err
+	}
+	req.Header = headers
+	if h := headers.Get("Host"); h != "" {
+		req.Host = h
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to call sts: %v", errSourcesUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxIAMResponseBytes))
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to read the sts response: %v", errSourcesUnavailable, err)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return "", fmt.Errorf("%w: sts failed with status code %d", errSourcesUnavailable, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("sts rejected the caller identity with status code %d", resp.StatusCode)
+	}
+	var out getCallerIdentityResponse
+	if err := xml.Unmarshal(b, &out); err != nil || out.Arn == "" {
+		return "", errors.New("invalid sts GetCallerIdentity response")
+	}
+	return strings.TrimSpace(out.Arn), nil
+}
@@ -0,0 +1,236 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+// iamProof builds the signed GetCallerIdentity request the client sends along with the S3 request.
+func iamProof(t *testing.T, endpoint, serverID string, cred *plugin.Credential, now time.Time) string {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, endpoint, strings.NewReader("Action=GetCallerIdentity&Version=2011-06-15"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-S3Auth-Server-Id", serverID)
+	signRequest(t, req, cred, now)
+	headers := map[string]string{}
+	for k := range req.Header {
+		headers[k] = req.Header.Get(k)
+	}
+	b, err := json.Marshal(map[string]interface{}{"method": req.Method, "url": endpoint, "body": "Action=GetCallerIdentity&Version=2011-06-15", "headers": headers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestIAM(t *testing.T) {
+	now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+	aws := &plugin.Credential{AccessKeyID: "AKIAREALAWSKEY", AccessSecretKey: "secret-the-proxy-never-sees", Region: "us-east-1", Service: "sts"}
+
+	var calls int32
+	stsServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		b, _ := io.ReadAll(req.Body)
+		if req.Header.Get("X-S3Auth-Server-Id") != "s3.example.com" || string(b) != "Action=GetCallerIdentity&Version=2011-06-15" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = rw.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetCallerIdentityResult>` +
+			`<Arn>arn:aws:sts::123456789012:assumed-role/ci-deploy/build-1</Arn><UserId>AROA:build-1</UserId><Account>123456789012</Account>` +
+			`</GetCallerIdentityResult></GetCallerIdentityResponse>`))
+	}))
+	defer stsServer.Close()
+
+	proof := func(t *testing.T, serverID string) string {
+		return iamProof(t, stsServer.URL+"/", serverID, aws, now)
+	}
+
+	// The proof is a bearer token, so neither OPA nor the backend ever see it.
+	leaked := make(chan string, 10)
+	opa := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if b, _ := io.ReadAll(req.Body); strings.Contains(strings.ToLower(string(b)), "x-s3auth-iam-request") {
+			leaked <- "opa"
+		}
+		_, _ = rw.Write([]byte(`{"result": true}`))
+	}))
+	defer opa.Close()
+
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.OPA = &plugin.OPAConfig{URL: opa.URL}
+	cfg.IAM = &plugin.IAMConfig{
+		Endpoint: stsServer.URL + "/",
+		ServerID: "s3.example.com",
+		Principals: []*plugin.IAMPrincipal{
+			{ARN: "arn:aws:sts::123456789012:assumed-role/ci-*/*", AllowedPrefixes: []string{"/artifacts/"}},
+		},
+	}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-S3Auth-Iam-Request") != "" {
+			leaked <- "backend"
+		}
+	})
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	p.Now = func() time.Time { return now }
+
+	tc := []struct {
+		name           string
+		serverID       string
+		accessKeyID    string
+		path           string
+		expectedStatus int
+	}{
+		{
+			name:           "valid identity",
+			serverID:       "s3.example.com",
+			accessKeyID:    "AKIAREALAWSKEY",
+			path:           "/artifacts/build.zip",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "cached identity",
+			serverID:       "s3.example.com",
+			accessKeyID:    "AKIAREALAWSKEY",
+			path:           "/artifacts/build.zip",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "outside of the principal prefixes",
+			serverID:       "s3.example.com",
+			accessKeyID:    "AKIAREALAWSKEY",
+			path:           "/secrets/key",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "wrong server id",
+			serverID:       "other.example.com",
+			accessKeyID:    "AKIAREALAWSKEY",
+			path:           "/artifacts/build.zip",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "different access key id",
+			serverID:       "s3.example.com",
+			accessKeyID:    "AKIAOTHERKEY",
+			path:           "/artifacts/build.zip",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://s3.example.com"+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-S3Auth-Iam-Request", proof(t, tt.serverID))
+			signRequest(t, req, &plugin.Credential{AccessKeyID: tt.accessKeyID, AccessSecretKey: "unknown", Region: "us-east-1", Service: "s3"}, now)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+	// The proof is identical for the valid requests, so STS is only called once for them.
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("expected 1 sts call, got %d", got)
+	}
+	close(leaked)
+	for to := range leaked {
+		t.Errorf("expected the iam request to never reach the %s", to)
+	}
+}
+
+func TestIAMUnavailable(t *testing.T) {
+	now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+	aws := &plugin.Credential{AccessKeyID: "AKIAREALAWSKEY", AccessSecretKey: "secret-the-proxy-never-sees", Region: "us-east-1", Service: "sts"}
+
+	tc := []struct {
+		name           string
+		status         int
+		down           bool
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "sts failing",
+			status:         http.StatusInternalServerError,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "ServiceUnavailable",
+		},
+		{
+			name:           "sts down",
+			down:           true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "ServiceUnavailable",
+		},
+		{
+			name:           "invalid client token id",
+			status:         http.StatusForbidden,
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "AccessDenied",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			stsServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(tt.status)
+				_, _ = rw.Write([]byte(`<ErrorResponse><Error><Code>InvalidClientTokenId</Code></Error></ErrorResponse>`))
+			}))
+			defer stsServer.Close()
+			endpoint := stsServer.URL + "/"
+			if tt.down {
+				stsServer.Close()
+			}
+
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.IAM = &plugin.IAMConfig{
+				Endpoint:   endpoint,
+				ServerID:   "s3.example.com",
+				Principals: []*plugin.IAMPrincipal{{ARN: "arn:aws:sts::123456789012:*"}},
+			}
+			handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return now }
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://s3.example.com/artifacts/build.zip", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-S3Auth-Iam-Request", iamProof(t, endpoint, "s3.example.com", aws, now))
+			signRequest(t, req, &plugin.Credential{AccessKeyID: "AKIAREALAWSKEY", AccessSecretKey: "unknown", Region: "us-east-1", Service: "s3"}, now)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if body := recorder.Body.String(); !strings.Contains(body, "<Code>"+tt.expectedCode+"</Code>") {
+				t.Errorf("expected a %s error, got %q", tt.expectedCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package traefik_plugin_s3_auth
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// inFlight limits the simultaneous requests of each credential. Slots are keyed by the access key id and the limit, so
+// a changed limit takes effect for new requests once the credentials are reloaded.
+type inFlight struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{slots: map[string]chan struct{}{}}
+}
+
+// acquire takes a slot for the access key id, waiting up to `wait` for one to free up. The returned function releases
+// the slot.
+func (f *inFlight) acquire(ctx context.Context, accessKeyID string, limit int, wait time.Duration) (func(), bool) {
+	id := accessKeyID + "/" + strconv.Itoa(limit)
+	f.mu.Lock()
+	slots, ok := f.slots[id]
+	if !ok {
+		slots = make(chan struct{}, limit)
+		f.slots[id] = slots
+	}
+	f.mu.Unlock()
+
+	release := func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	default:
+	}
+	if wait <= 0 {
+		return nil, false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
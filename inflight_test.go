@@ -0,0 +1,86 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestMaxInFlight(t *testing.T) {
+	tc := []struct {
+		name           string
+		wait           string
+		expectedStatus int
+	}{
+		{
+			name:           "rejected",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "queued",
+			wait:           "5s",
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.AccessKeyID = "AKIAINFLIGHT" + strings.ToUpper(tt.name)
+			cred.MaxInFlight = 1
+			cred.InFlightWait = tt.wait
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+
+			started := make(chan struct{}, 1)
+			unblock := make(chan struct{})
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if req.URL.Path == "/bucket/slow.bin" {
+					started <- struct{}{}
+					<-unblock
+				}
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			slow := newSignedRequest(t, http.MethodGet, "/bucket/slow.bin", cred)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				p.ServeHTTP(httptest.NewRecorder(), slow)
+			}()
+			<-started
+
+			if tt.wait != "" {
+				time.AfterFunc(50*time.Millisecond, func() { close(unblock) })
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, http.MethodGet, "/bucket/fast.bin", cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus == http.StatusServiceUnavailable {
+				if !strings.Contains(recorder.Body.String(), "<Code>SlowDown</Code>") || recorder.Header().Get("Retry-After") != "1" {
+					t.Errorf("expected a SlowDown error with a Retry-After, got %s", recorder.Body)
+				}
+				close(unblock)
+			}
+			<-done
+
+			// The slot is released once the request completes.
+			recorder = httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, http.MethodGet, "/bucket/fast.bin", cred))
+			if recorder.Code != http.StatusOK {
+				t.Errorf("expected status code %d after the release, got %d", http.StatusOK, recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package traefik_plugin_s3_auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the address of the client. With a depth of 0 it is the peer address, otherwise the depth-th
+// address from the right of `X-Forwarded-For`, ie: the one appended by the outermost trusted proxy. Addresses further
+// left are set by the client and can't be trusted.
+func clientIP(req *http.Request, depth int) string {
+	if depth > 0 {
+		var hops []string
+		for _, v := range req.Header.Values("X-Forwarded-For") {
+			for _, h := range strings.Split(v, ",") {
+				if h = strings.TrimSpace(h); h != "" {
+					hops = append(hops, h)
+				}
+			}
+		}
+		if len(hops) > 0 {
+			if depth > len(hops) {
+				depth = len(hops)
+			}
+			return hops[len(hops)-depth]
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// normalizeCIDRs validates the ranges, a single address is accepted as a range of one, eg: `192.0.2.10`.
+func normalizeCIDRs(cidrs []string) ([]string, error) {
+	if len(cidrs) == 0 {
+		return nil, nil
+	}
+	out := make([]string, 0, len(cidrs))
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid cidr: %q", c)
+			}
+			if ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr: %q", c)
+		}
+		out = append(out, n.String())
+	}
+	return out, nil
+}
+
+// inCIDRs reports whether the address is inside one of the already validated ranges.
+func inCIDRs(cidrs []string, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, c := range cidrs {
+		if _, n, err := net.ParseCIDR(c); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// secureTransport reports whether the request arrived over TLS. Once proxies set `X-Forwarded-Proto`, which Traefik
+// does for its own entrypoint, every hop listed there must be `https`.
+func secureTransport(req *http.Request) bool {
+	protos := req.Header.Values("X-Forwarded-Proto")
+	if len(protos) == 0 {
+		return req.TLS != nil
+	}
+	for _, v := range protos {
+		for _, proto := range strings.Split(v, ",") {
+			if !strings.EqualFold(strings.TrimSpace(proto), "https") {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,252 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFormatLogfmt = "logfmt"
+	logFormatJSON   = "json"
+)
+
+const redacted = "[REDACTED]"
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "error": levelError}
+
+func (l logLevel) String() string {
+	return [...]string{"debug", "info", "warn", "error"}[l]
+}
+
+// LogConfig configures the structured logs of the middleware.
+type LogConfig struct {
+	// Level is the least severe level logged, `debug`, `info` (the default), `warn` or `error`.
+	Level string `json:"level,omitempty"`
+	// Format is either `logfmt` (the default) or `json`.
+	Format string `json:"format,omitempty"`
+	// Sampling optionally samples the successful validations and the repeated failures, see LogSamplingConfig.
+	Sampling *LogSamplingConfig `json:"sampling,omitempty"`
+}
+
+// logger writes one structured line per event to the standard output, which Traefik collects with its own logs. It
+// is process-wide, like the admin server, so the shared credential stores log the same way as the middlewares.
+type logger struct {
+	mu      sync.Mutex
+	level   logLevel
+	format  string
+	sampler *logSampler
+	now     func() time.Time
+}
+
+var logs = &logger{level: levelInfo, format: logFormatLogfmt, now: time.Now}
+
+// configureLogs applies the `log` option, the last middleware created with one configures the process-wide logger.
+func configureLogs(config *LogConfig) error {
+	if config == nil {
+		return nil
+	}
+	level := levelInfo
+	if config.Level != "" {
+		l, ok := logLevels[strings.ToLower(config.Level)]
+		if !ok {
+			return fmt.Errorf("unsupported log level: %q", config.Level)
+		}
+		level = l
+	}
+	format := config.Format
+	switch format {
+	case "":
+		format = logFormatLogfmt
+	case logFormatLogfmt, logFormatJSON:
+	default:
+		return fmt.Errorf("unsupported log format: %q", config.Format)
+	}
+	sampler, err := newLogSampler(config.Sampling)
+	if err != nil {
+		return err
+	}
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+
+	logs.level, logs.format, logs.sampler = level, format, sampler
+	return nil
+}
+
+func (l *logger) debug(msg string, kv ...interface{}) { l.log(levelDebug, msg, kv) }
+func (l *logger) info(msg string, kv ...interface{})  { l.log(levelInfo, msg, kv) }
+func (l *logger) warn(msg string, kv ...interface{})  { l.log(levelWarn, msg, kv) }
+func (l *logger) error(msg string, kv ...interface{}) { l.log(levelError, msg, kv) }
+
+// enabled reports whether the level is logged, eg: to skip building expensive debug fields.
+func (l *logger) enabled(level logLevel) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return level >= l.level
+}
+
+// log writes the message with the key value pairs of kv, eg: `"accessKeyId", id`.
+func (l *logger) log(level logLevel, msg string, kv []interface{}) {
+	l.sampled(level, msg, "", kv)
+}
+
+// sampled writes the message unless the sampler drops it, the lines without a sample key are always written.
+func (l *logger) sampled(level logLevel, msg, sample string, kv []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if level < l.level {
+		return
+	}
+	now := l.now()
+	var extra []interface{}
+	if sample != "" && l.sampler != nil {
+		var keep bool
+		if keep, extra = l.sampler.keep(msg, sample, now); !keep {
+			return
+		}
+	}
+	fields := make([]interface{}, 0, len(kv)+len(extra)+6)
+	fields = append(fields, "time", now.UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	fields = append(fields, kv...)
+	fields = append(fields, extra...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+	var b bytes.Buffer
+	if l.format == logFormatJSON {
+		writeJSONLine(&b, fields)
+	} else {
+		writeLogfmtLine(&b, fields)
+	}
+	_, _ = os.Stdout.Write(b.Bytes())
+}
+
+// requestLog adds the middleware name and the request id of the request to the fields of every line.
+type requestLog struct {
+	fields []interface{}
+	sample string
+}
+
+func (p *Plugin) log(req *http.Request) requestLog {
+	fields := []interface{}{"middleware", p.name}
+	if id, ok := req.Context().Value(RequestIDContextKey).(string); ok {
+		fields = append(fields, "requestId", id)
+	}
+	if id := correlationID(req); id != "" {
+		fields = append(fields, "correlationId", id)
+	}
+	return requestLog{fields: fields}
+}
+
+// sampled marks the lines as sampled under the key, see LogSamplingConfig.
+func (r requestLog) sampled(key string) requestLog {
+	r.sample = key
+	return r
+}
+
+func (r requestLog) debug(msg string, kv ...interface{}) {
+	logs.sampled(levelDebug, msg, r.sample, r.with(kv))
+}
+func (r requestLog) info(msg string, kv ...interface{}) {
+	logs.sampled(levelInfo, msg, r.sample, r.with(kv))
+}
+func (r requestLog) warn(msg string, kv ...interface{}) {
+	logs.sampled(levelWarn, msg, r.sample, r.with(kv))
+}
+func (r requestLog) error(msg string, kv ...interface{}) {
+	logs.sampled(levelError, msg, r.sample, r.with(kv))
+}
+
+func (r requestLog) with(kv []interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(r.fields)+len(kv)), r.fields...), kv...)
+}
+
+// secretKey reports whether the values of the key must never be logged, whatever the caller passes.
+func secretKey(key string) bool {
+	k := strings.ToLower(key)
+	for _, s := range []string{"secret", "password", "token", "signature", "authorization"} {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func logValue(key string, v interface{}) interface{} {
+	if secretKey(key) {
+		return redacted
+	}
+	switch x := v.(type) {
+	case error:
+		return x.Error()
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	case time.Duration:
+		return x.String()
+	case fmt.Stringer:
+		return x.String()
+	}
+	return v
+}
+
+func writeLogfmtLine(b *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		key := fmt.Sprint(fields[i])
+		b.WriteString(key)
+		b.WriteByte('=')
+		s := fmt.Sprint(logValue(key, fields[i+1]))
+		if s == "" || strings.ContainsAny(s, " =\"\\\n\t") {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(s)
+	}
+	b.WriteByte('\n')
+}
+
+func writeJSONLine(b *bytes.Buffer, fields []interface{}) {
+	keys := make([]string, 0, len(fields)/2)
+	values := map[string]interface{}{}
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = logValue(key, fields[i+1])
+	}
+	// Keeps the order of the fields, which encoding/json doesn't for maps.
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(values[key])
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(values[key]))
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteString("}\n")
+}
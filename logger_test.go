@@ -0,0 +1,229 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+// captureStdout returns what fn printed to the standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	fn()
+	_ = w.Close()
+	return <-out
+}
+
+func TestLogs(t *testing.T) {
+	tc := []struct {
+		name     string
+		log      *plugin.LogConfig
+		expected []string
+		missing  []string
+	}{
+		{
+			name: "logfmt",
+			log:  &plugin.LogConfig{},
+			expected: []string{
+				`level=info msg="validation failed" middleware=log-plugin requestId=`,
+				`sourceIp=192.0.2.1 reason="invalid access key id: unknown \"AKIAUNKNOWN\"`,
+			},
+			missing: []string{"level=debug", "SECRET12secret"},
+		},
+		{
+			name: "json",
+			log:  &plugin.LogConfig{Level: "debug", Format: "json"},
+			expected: []string{
+				`"level":"debug","msg":"creating the middleware","middleware":"log-plugin"}`,
+				`"level":"info","msg":"validation failed","middleware":"log-plugin","requestId":"`,
+			},
+			missing: []string{"SECRET12secret"},
+		},
+		{
+			name:    "error level",
+			log:     &plugin.LogConfig{Level: "error"},
+			missing: []string{"validation failed", "error response"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			unknown := validCredential()
+			unknown.AccessKeyID = "AKIAUNKNOWN"
+			out := captureStdout(t, func() {
+				cfg := plugin.CreateConfig()
+				cfg.Credentials = []*plugin.Credential{validCredential()}
+				cfg.RequestIDs = true
+				cfg.Log = tt.log
+				handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "log-plugin")
+				if err != nil {
+					t.Fatal(err)
+				}
+				p := handler.(*plugin.Plugin)
+				p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+				req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+				signRequest(t, req, unknown, p.Now())
+				p.ServeHTTP(httptest.NewRecorder(), req)
+			})
+			// Restores the defaults for the other tests.
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.Log = &plugin.LogConfig{}
+			if _, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin"); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range tt.expected {
+				if !strings.Contains(out, e) {
+					t.Errorf("expected %q in the logs:\n%s", e, out)
+				}
+			}
+			for _, m := range tt.missing {
+				if strings.Contains(out, m) {
+					t.Errorf("expected no %q in the logs:\n%s", m, out)
+				}
+			}
+			if tt.log.Format != "json" {
+				return
+			}
+			for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+				var v map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &v); err != nil {
+					t.Errorf("expected a json line, got %q: %v", line, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLogSampling(t *testing.T) {
+	unknown := validCredential()
+	unknown.AccessKeyID = "AKIAUNKNOWN"
+	out := captureStdout(t, func() {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.Log = &plugin.LogConfig{Level: "debug", Sampling: &plugin.LogSamplingConfig{Successes: 2, Failures: 1, Interval: "100ms"}}
+		p := newTestPlugin(t, cfg)
+
+		serve := func(c *plugin.Credential) {
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+			signRequest(t, req, c, p.Now())
+			p.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		for i := 0; i < 4; i++ {
+			serve(validCredential())
+			serve(unknown)
+		}
+		// The next failure opens a new window, reporting the ones suppressed in the previous one.
+		time.Sleep(150 * time.Millisecond)
+		serve(unknown)
+	})
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.Log = &plugin.LogConfig{}
+	if _, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(out, `msg=validated `); n != 2 || !strings.Contains(out, "sampleRate=2") {
+		t.Errorf("expected one in 2 successes logged, got %d:\n%s", n, out)
+	}
+	if n := strings.Count(out, `msg="validation failed"`); n != 2 || !strings.Contains(out, "suppressed=3") {
+		t.Errorf("expected a failure per window and 3 suppressed, got %d:\n%s", n, out)
+	}
+	// Only the validations are sampled.
+	if n := strings.Count(out, `msg="error response"`); n != 5 {
+		t.Errorf("expected every error response logged, got %d:\n%s", n, out)
+	}
+}
+
+func TestInvalidLogs(t *testing.T) {
+	for _, log := range []*plugin.LogConfig{{Level: "verbose"}, {Format: "xml"}, {Sampling: &plugin.LogSamplingConfig{Successes: -1}}, {Sampling: &plugin.LogSamplingConfig{Interval: "often"}}} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.Log = log
+		if _, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "log") {
+			t.Errorf("expected a log error for %+v, got %v", log, err)
+		}
+	}
+}
+
+func TestCanonicalDiff(t *testing.T) {
+	tc := []struct {
+		name     string
+		url      string
+		header   string
+		secret   string
+		expected []string
+	}{
+		{
+			name:   "differing components",
+			url:    "https://s3.example.com/bucket/object.txt?prefix=a%20b",
+			header: "a  b",
+			secret: "WRONG_WRONG_WRONG",
+			expected: []string{
+				`component=query plugin="prefix=a+b" client="prefix=a%20b"`,
+				`component=header:x-amz-meta-note plugin="a  b" client="a b"`,
+			},
+		},
+		{
+			name:     "matching canonical requests",
+			url:      "https://s3.example.com/bucket/object.txt",
+			header:   "a",
+			secret:   "WRONG_WRONG_WRONG",
+			expected: []string{`msg="the canonical request matches the one of the client, check its secret key"`},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := validCredential()
+			signer.AccessSecretKey = tt.secret
+			out := captureStdout(t, func() {
+				cfg := plugin.CreateConfig()
+				cfg.Credentials = []*plugin.Credential{validCredential()}
+				cfg.Log = &plugin.LogConfig{Level: "debug"}
+				p := newTestPlugin(t, cfg)
+
+				req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+				req.Header.Set("X-Amz-Meta-Note", tt.header)
+				signRequest(t, req, signer, p.Now())
+				p.ServeHTTP(httptest.NewRecorder(), req)
+			})
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.Log = &plugin.LogConfig{}
+			if _, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin"); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range tt.expected {
+				if !strings.Contains(out, e) {
+					t.Errorf("expected %q in the logs:\n%s", e, out)
+				}
+			}
+			if strings.Contains(out, "component=signedHeaders") || strings.Contains(out, "component=path") {
+				t.Errorf("expected only the differing components:\n%s", out)
+			}
+		})
+	}
+}
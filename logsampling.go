@@ -0,0 +1,123 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultLogSamplingInterval = time.Minute
+	// maxLogSamplingWindows bounds the identical failures tracked, eg: while a botnet probes random keys.
+	maxLogSamplingWindows = 4096
+
+	// sampleSuccess is the sampling key of the successful validations, the failures are keyed by client ip and reason.
+	sampleSuccess = "success"
+)
+
+// LogSamplingConfig samples the high volume log lines, so debug logging on a busy gateway doesn't flood the disks
+// while still keeping representative lines.
+type LogSamplingConfig struct {
+	// Successes logs one in this many successful validations, eg: `100`. All of them are logged by default.
+	Successes int `json:"successes,omitempty"`
+	// Failures is how many identical failures, of the same client ip and reason, are logged per interval, eg: `5`.
+	// All of them are logged by default.
+	Failures int `json:"failures,omitempty"`
+	// Interval of the failure windows, defaults to `1m`.
+	Interval string `json:"interval,omitempty"`
+}
+
+// logSampler decides which sampled lines are logged, under the lock of the logger.
+type logSampler struct {
+	successes int
+	failures  int
+	interval  time.Duration
+	seen      uint64
+	windows   map[string]*logWindow
+}
+
+// logWindow counts the identical failures logged and suppressed since the start of the window.
+type logWindow struct {
+	start      time.Time
+	logged     int
+	suppressed int
+}
+
+func newLogSampler(config *LogSamplingConfig) (*logSampler, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.Successes < 0 || config.Failures < 0 {
+		return nil, errors.New("the log sampling `successes` and `failures` can't be negative")
+	}
+	s := &logSampler{
+		successes: config.Successes,
+		failures:  config.Failures,
+		interval:  defaultLogSamplingInterval,
+		windows:   map[string]*logWindow{},
+	}
+	if config.Interval != "" {
+		d, err := time.ParseDuration(config.Interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid log sampling `interval` %q, eg: `1m`", config.Interval)
+		}
+		s.interval = d
+	}
+	return s, nil
+}
+
+// keep reports whether the sampled line is logged, with the fields describing the sampling, eg: how many identical
+// failures were suppressed before it.
+func (s *logSampler) keep(msg, key string, now time.Time) (bool, []interface{}) {
+	if key == sampleSuccess {
+		if s.successes <= 1 {
+			return true, nil
+		}
+		s.seen++
+		return (s.seen-1)%uint64(s.successes) == 0, []interface{}{"sampleRate", s.successes}
+	}
+	if s.failures == 0 {
+		return true, nil
+	}
+	id := msg + "\x00" + key
+	w, ok := s.windows[id]
+	if !ok || now.Sub(w.start) >= s.interval {
+		suppressed := 0
+		if ok {
+			suppressed = w.suppressed
+		}
+		s.sweep(now)
+		s.windows[id] = &logWindow{start: now, logged: 1}
+		if suppressed > 0 {
+			return true, []interface{}{"suppressed", suppressed}
+		}
+		return true, nil
+	}
+	if w.logged >= s.failures {
+		w.suppressed++
+		return false, nil
+	}
+	w.logged++
+	return true, nil
+}
+
+// sweep drops the windows that ended, once they are numerous, and every window when they are all active. Their
+// suppressed lines are no longer reported.
+func (s *logSampler) sweep(now time.Time) {
+	if len(s.windows) < maxLogSamplingWindows {
+		return
+	}
+	for id, w := range s.windows {
+		if now.Sub(w.start) >= s.interval {
+			delete(s.windows, id)
+		}
+	}
+	if len(s.windows) >= maxLogSamplingWindows {
+		s.windows = map[string]*logWindow{}
+	}
+}
+
+// failureSample is the sampling key of the identical failures of a client ip, eg: `192.0.2.1/signature_mismatch`.
+func failureSample(ip, reason string) string {
+	return ip + "/" + reason
+}
@@ -0,0 +1,66 @@
+package traefik_plugin_s3_auth
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maintenance is the read-only mode toggled through the admin server. It outlives the middleware instances that
+// Traefik recreates on every configuration reload.
+type maintenance struct {
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+	since   time.Time
+}
+
+// MaintenanceStatus is the read-only mode reported by the admin server.
+type MaintenanceStatus struct {
+	ReadOnly bool       `json:"readOnly"`
+	Reason   string     `json:"reason,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+func (m *maintenance) set(enabled bool, reason string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if enabled && !m.enabled {
+		m.since = now
+	}
+	m.enabled, m.reason = enabled, reason
+	if !enabled {
+		m.reason, m.since = "", time.Time{}
+	}
+}
+
+func (m *maintenance) readOnly() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled
+}
+
+func (m *maintenance) status() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	st := MaintenanceStatus{ReadOnly: m.enabled, Reason: m.reason}
+	if m.enabled {
+		since := m.since
+		st.Since = &since
+	}
+	return st
+}
+
+// mutating reports whether the method may change the backend.
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodPatch:
+		return true
+	}
+	return false
+}
+
+// readOnlyMode reports whether mutating requests are rejected, either by the configuration or through the admin server.
+func (p *Plugin) readOnlyMode() bool {
+	return p.readOnly || (p.admin != nil && p.admin.maintenance.readOnly())
+}
@@ -0,0 +1,264 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMeteringInterval = time.Hour
+	// maxMeteringPending bounds the records kept for a failing export, the oldest ones are dropped first.
+	maxMeteringPending = 100000
+)
+
+// meteringColumns is the header of the CSV export.
+var meteringColumns = []string{"start", "end", "middleware", "access_key_id", "tenant", "requests", "bytes_in", "bytes_out"}
+
+// MeteringConfig accumulates the requests and bytes transferred per access key id and exports them periodically, eg:
+// for billing the tenants of a multi-tenant storage gateway.
+type MeteringConfig struct {
+	// Interval of the exports, defaults to `1h`.
+	Interval string `json:"interval,omitempty"`
+	// Path of a CSV file the records of each interval are appended to.
+	Path string `json:"path,omitempty"`
+	// URL receiving a `POST` with the JSON records of each interval.
+	URL string `json:"url,omitempty"`
+}
+
+// usageRecord is the usage of an access key id over an interval. Temporary credentials are accounted to their parent.
+type usageRecord struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	AccessKeyID string    `json:"accessKeyId"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Requests    int64     `json:"requests"`
+	BytesIn     int64     `json:"bytesIn"`
+	BytesOut    int64     `json:"bytesOut"`
+}
+
+// usageEvent is the payload posted to the webhook.
+type usageEvent struct {
+	Event      string        `json:"event"`
+	Middleware string        `json:"middleware"`
+	Records    []usageRecord `json:"records"`
+}
+
+// metering accumulates the usage of a middleware. The meterings are shared process-wide by middleware name, so the
+// usage survives configuration reloads and is exported by a single goroutine.
+type metering struct {
+	name string
+
+	mu       sync.Mutex
+	interval time.Duration
+	path     string
+	url      string
+	since    time.Time
+	usage    map[string]*usageRecord
+
+	// flushMu serializes the exports and guards the records of the exports that failed, retried with the next one.
+	flushMu     sync.Mutex
+	pendingFile []usageRecord
+	pendingURL  []usageRecord
+}
+
+var (
+	meteringsMu sync.Mutex
+	meterings   = map[string]*metering{}
+)
+
+func sharedMetering(config *MeteringConfig, name string) (*metering, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.Path == "" && config.URL == "" {
+		return nil, errors.New("the metering needs a `path` or a `url`")
+	}
+	if config.URL != "" && !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return nil, fmt.Errorf("invalid metering `url`: %q", config.URL)
+	}
+	interval := defaultMeteringInterval
+	if config.Interval != "" {
+		d, err := time.ParseDuration(config.Interval)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid metering `interval` %q, eg: `1h`", config.Interval)
+		}
+		interval = d
+	}
+
+	meteringsMu.Lock()
+	defer meteringsMu.Unlock()
+
+	// A reload keeps the usage accumulated so far, exported with the new settings.
+	if m, ok := meterings[name]; ok {
+		m.mu.Lock()
+		m.interval, m.path, m.url = interval, config.Path, config.URL
+		m.mu.Unlock()
+		return m, nil
+	}
+	m := &metering{name: name, interval: interval, path: config.Path, url: config.URL, usage: map[string]*usageRecord{}}
+	meterings[name] = m
+	go m.run()
+	return m, nil
+}
+
+// add accounts the requests and the bytes received and sent to the access key id.
+func (m *metering) add(accessKeyID, tenant string, requests, in, out int64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.since.IsZero() {
+		m.since = now
+	}
+	u, ok := m.usage[accessKeyID]
+	if !ok {
+		u = &usageRecord{AccessKeyID: accessKeyID}
+		m.usage[accessKeyID] = u
+	}
+	u.Tenant = tenant
+	u.Requests += requests
+	u.BytesIn += in
+	u.BytesOut += out
+}
+
+func (m *metering) run() {
+	for {
+		m.mu.Lock()
+		interval := m.interval
+		m.mu.Unlock()
+
+		time.Sleep(interval)
+		if err := m.flush(time.Now()); err != nil {
+			logs.error("failed to export the usage", "middleware", m.name, "error", err)
+		}
+	}
+}
+
+// flush exports the usage accumulated since the previous export, along with the records of the exports that failed.
+func (m *metering) flush(now time.Time) error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	records := make([]usageRecord, 0, len(m.usage))
+	for _, u := range m.usage {
+		r := *u
+		r.Start, r.End = m.since.UTC(), now.UTC()
+		records = append(records, r)
+	}
+	m.usage, m.since = map[string]*usageRecord{}, now
+	path, url := m.path, m.url
+	m.mu.Unlock()
+	sort.Slice(records, func(i, j int) bool { return records[i].AccessKeyID < records[j].AccessKeyID })
+
+	var errs []string
+	if path == "" {
+		m.pendingFile = nil
+	} else if batch := append(m.pendingFile, records...); len(batch) > 0 {
+		m.pendingFile = nil
+		if err := appendUsageCSV(path, m.name, batch); err != nil {
+			m.pendingFile = keepPending(batch)
+			errs = append(errs, fmt.Sprintf("failed to append to %q: %v", path, err))
+		}
+	}
+	if url == "" {
+		m.pendingURL = nil
+	} else if batch := append(m.pendingURL, records...); len(batch) > 0 {
+		m.pendingURL = nil
+		if err := postJSON(url, usageEvent{Event: "usage", Middleware: m.name, Records: batch}); err != nil {
+			m.pendingURL = keepPending(batch)
+			errs = append(errs, fmt.Sprintf("failed to post to the webhook: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// keepPending returns the records to retry, the most recent maxMeteringPending ones.
+func keepPending(records []usageRecord) []usageRecord {
+	if n := len(records) - maxMeteringPending; n > 0 {
+		logs.warn("dropped the oldest usage records of a failing export", "dropped", n)
+		records = records[n:]
+	}
+	return records
+}
+
+// appendUsageCSV appends the records in a single write, so middlewares sharing the file append whole rows. The header
+// is only written to new files.
+func appendUsageCSV(path, name string, records []usageRecord) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		_ = w.Write(meteringColumns)
+	}
+	for _, r := range records {
+		_ = w.Write([]string{
+			r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), name, r.AccessKeyID, r.Tenant,
+			strconv.FormatInt(r.Requests, 10), strconv.FormatInt(r.BytesIn, 10), strconv.FormatInt(r.BytesOut, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	return err
+}
+
+// usageMeter accounts the bytes of a request to the usage of its credential as they are transferred, so the bytes of
+// long transfers are exported with the intervals they are transferred in.
+type usageMeter struct {
+	metering *metering
+	user     string
+	tenant   string
+	started  time.Time
+}
+
+type usageBody struct {
+	io.ReadCloser
+	m *usageMeter
+}
+
+func (b *usageBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.m.metering.add(b.m.user, b.m.tenant, 0, int64(n), 0, b.m.started)
+	}
+	return n, err
+}
+
+type usageWriter struct {
+	http.ResponseWriter
+	m *usageMeter
+}
+
+func (w *usageWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if n > 0 {
+		w.m.metering.add(w.m.user, w.m.tenant, 0, 0, int64(n), w.m.started)
+	}
+	return n, err
+}
+
+func (w *usageWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
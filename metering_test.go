@@ -0,0 +1,144 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestMetering(t *testing.T) {
+	var fail int32 = 1
+	events := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// The first export fails, so its records are posted again with the next one.
+		if atomic.CompareAndSwapInt32(&fail, 1, 0) {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var ev map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&ev); err != nil {
+			t.Errorf("invalid usage: %v", err)
+		}
+		events <- ev
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "usage.csv")
+	cred := validCredential()
+	cred.AccessKeyID = "AKIAMETERING"
+	cred.Tenant = "acme"
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.Metering = &plugin.MeteringConfig{Path: path, URL: srv.URL}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.Copy(io.Discard, req.Body)
+		if req.Method == http.MethodGet {
+			_, _ = rw.Write([]byte("123456789012"))
+		}
+	})
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-metering")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+	p.Now = func() time.Time { return now }
+	// The usage is shared by middleware name, so the interval starts now when the test runs again.
+	if err := p.FlushUsage(); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := validCredential()
+	wrong.AccessKeyID = cred.AccessKeyID
+	wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+	// The failed request isn't accounted.
+	for _, r := range []struct {
+		method string
+		body   string
+		cred   *plugin.Credential
+	}{
+		{method: http.MethodPut, body: "12345678", cred: cred},
+		{method: http.MethodGet, cred: cred},
+		{method: http.MethodGet, cred: wrong},
+	} {
+		p.ServeHTTP(httptest.NewRecorder(), newQuotaRequest(t, r.method, r.body, r.cred, now))
+	}
+	now = now.Add(time.Hour)
+	if err := p.FlushUsage(); err == nil || !strings.Contains(err.Error(), "webhook") {
+		t.Errorf("expected the webhook to fail, got %v", err)
+	}
+	p.ServeHTTP(httptest.NewRecorder(), newQuotaRequest(t, http.MethodGet, "", cred, now))
+	now = now.Add(time.Hour)
+	if err := p.FlushUsage(); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is exported without usage.
+	if err := p.FlushUsage(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "start,end,middleware,access_key_id,tenant,requests,bytes_in,bytes_out\n" +
+		"2025-07-10T05:45:00Z,2025-07-10T06:45:00Z,s3-metering,AKIAMETERING,acme,2,8,12\n" +
+		"2025-07-10T06:45:00Z,2025-07-10T07:45:00Z,s3-metering,AKIAMETERING,acme,1,0,12\n"
+	if string(b) != expected {
+		t.Errorf("expected the csv:\n%s\ngot:\n%s", expected, b)
+	}
+
+	var ev map[string]interface{}
+	select {
+	case ev = <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the usage to be posted")
+	}
+	records, _ := ev["records"].([]interface{})
+	if ev["event"] != "usage" || ev["middleware"] != "s3-metering" || len(records) != 2 {
+		t.Fatalf("unexpected usage: %v", ev)
+	}
+	first, _ := records[0].(map[string]interface{})
+	if first["accessKeyId"] != "AKIAMETERING" || first["tenant"] != "acme" || first["requests"] != float64(2) ||
+		first["bytesIn"] != float64(8) || first["bytesOut"] != float64(12) || first["start"] != "2025-07-10T05:45:00Z" {
+		t.Errorf("unexpected record: %v", first)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("expected a single post, got %v", ev)
+	default:
+	}
+}
+
+func TestInvalidMetering(t *testing.T) {
+	tc := []struct {
+		name     string
+		metering *plugin.MeteringConfig
+		expected string
+	}{
+		{name: "target", metering: &plugin.MeteringConfig{}, expected: "`path` or a `url`"},
+		{name: "url", metering: &plugin.MeteringConfig{URL: "ftp://example.com"}, expected: "`url`"},
+		{name: "interval", metering: &plugin.MeteringConfig{Path: "usage.csv", Interval: "1ms"}, expected: "`interval`"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.Metering = tt.metering
+			_, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin")
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected an error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otherKeys is the access_key_id label of the keys over `maxKeys`.
+const otherKeys = "other"
+
+// MetricsConfig configures the metrics exposed by the admin server.
+type MetricsConfig struct {
+	// KeyLabels opts into the per access key id series, which grow with the number of credentials.
+	KeyLabels bool `json:"keyLabels,omitempty"`
+	// KeyAllowlist restricts the per access key id series to these keys.
+	KeyAllowlist []string `json:"keyAllowlist,omitempty"`
+	// MaxKeys caps the number of access key ids with their own series, the others are counted as `other`.
+	MaxKeys int `json:"maxKeys,omitempty"`
+	// StatsD also emits the metrics to a StatsD or DogStatsD agent, see StatsDConfig.
+	StatsD *StatsDConfig `json:"statsd,omitempty"`
+}
+
+// latencyBuckets are the upper bounds in seconds of the validation latency histogram.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// metrics counts the validations of a middleware. They are shared process-wide by its name, so the counters survive
+// the new instances Traefik creates on every configuration reload.
+type metrics struct {
+	name      string
+	mu        sync.Mutex
+	keyLabels bool
+	allowlist map[string]bool
+	maxKeys   int
+	labeled   map[string]bool
+	results   map[string]uint64
+	failures  map[string]uint64
+	keys      map[keyResult]uint64
+	buckets   []uint64
+	exemplars []exemplar
+	count     uint64
+	sum       float64
+	// warnings are the number of credentials with each hygiene warning, eg: `expired`, as of the last hygiene pass.
+	warnings map[string]int64
+	statsd   *statsd
+}
+
+// exemplar is the latest request observed in a histogram bucket, eg: to jump from a slow bucket to its logs.
+type exemplar struct {
+	id    string
+	value float64
+	at    time.Time
+}
+
+type keyResult struct {
+	accessKeyID string
+	result      string
+}
+
+var (
+	metricsMu     sync.Mutex
+	sharedMetrics = map[string]*metrics{}
+)
+
+func metricsOf(name string, config *MetricsConfig) (*metrics, error) {
+	if config == nil {
+		config = &MetricsConfig{}
+	}
+	if config.MaxKeys < 0 {
+		return nil, errors.New("the metrics `maxKeys` can't be negative")
+	}
+	if !config.KeyLabels && (len(config.KeyAllowlist) > 0 || config.MaxKeys > 0) {
+		return nil, errors.New("the metrics `keyAllowlist` and `maxKeys` need `keyLabels`")
+	}
+	sd, err := newStatsD(config.StatsD, name)
+	if err != nil {
+		return nil, err
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	m, ok := sharedMetrics[name]
+	if !ok {
+		m = &metrics{
+			name:     name,
+			labeled:  map[string]bool{},
+			results:  map[string]uint64{},
+			failures: map[string]uint64{},
+			keys:     map[keyResult]uint64{},
+			buckets:  make([]uint64, len(latencyBuckets)),
+			// The last one is the exemplar of the `+Inf` bucket.
+			exemplars: make([]exemplar, len(latencyBuckets)+1),
+		}
+		sharedMetrics[name] = m
+	}
+	// The configuration of the latest instance applies, the keys already labeled keep their series.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keyLabels, m.maxKeys, m.allowlist, m.statsd = config.KeyLabels, config.MaxKeys, nil, sd
+	if len(config.KeyAllowlist) > 0 {
+		m.allowlist = map[string]bool{}
+		for _, k := range config.KeyAllowlist {
+			m.allowlist[k] = true
+		}
+	}
+	return m, nil
+}
+
+// keyLabel returns the access_key_id label of the key, empty when it has no series. Keys keep their label once they
+// have one, so their counters never move to `other`.
+func (m *metrics) keyLabel(accessKeyID string) string {
+	switch {
+	case !m.keyLabels || accessKeyID == "", m.allowlist != nil && !m.allowlist[accessKeyID]:
+		return ""
+	case m.labeled[accessKeyID], m.maxKeys == 0:
+	case len(m.labeled) >= m.maxKeys:
+		return otherKeys
+	}
+	m.labeled[accessKeyID] = true
+	return accessKeyID
+}
+
+// validated records a validation. The reason of failures is one of the bounded failure reasons, and the access key id
+// is only set once it is known to be one of the credentials, so the label values stay bounded. The id of the request,
+// if any, becomes the exemplar of its latency bucket.
+func (m *metrics) validated(accessKeyID, reason string, d time.Duration, id string) {
+	result := "success"
+	if reason != "" {
+		result = "failure"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.results[result]++
+	if reason != "" {
+		m.failures[reason]++
+	}
+	label := m.keyLabel(accessKeyID)
+	if label != "" {
+		m.keys[keyResult{accessKeyID: label, result: result}]++
+	}
+	s := d.Seconds()
+	bucket := len(latencyBuckets)
+	for i, b := range latencyBuckets {
+		if s <= b {
+			m.buckets[i]++
+			if i < bucket {
+				bucket = i
+			}
+		}
+	}
+	if id != "" {
+		m.exemplars[bucket] = exemplar{id: id, value: s, at: time.Now()}
+	}
+	m.count++
+	m.sum += s
+
+	if m.statsd != nil {
+		m.statsd.count("validations", "result", result)
+		if reason != "" {
+			m.statsd.count("failures", "reason", reason)
+		}
+		if label != "" {
+			m.statsd.count("key_validations", "access_key_id", label, "result", result)
+		}
+		m.statsd.timing("validation_duration", d)
+	}
+}
+
+// denied records a request denied after its validation, eg: by a policy.
+func (m *metrics) denied(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures[reason]++
+	if m.statsd != nil {
+		m.statsd.count("failures", "reason", reason)
+	}
+}
+
+// hygienePassed sets the number of credentials with each hygiene warning, `expired`, `expiring` and `unused`.
+func (m *metrics) hygienePassed(warnings map[string]int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.warnings = warnings
+	if m.statsd != nil {
+		for _, w := range sortedGauges(warnings) {
+			m.statsd.gauge("credential_warnings", warnings[w], "warning", w)
+		}
+	}
+}
+
+// writeMetrics writes the metrics in the Prometheus text format, each family listing every middleware. The OpenMetrics
+// format also has the exemplars of the latency buckets, which Prometheus only scrapes in that format.
+func writeMetrics(w io.Writer, all []*metrics, openMetrics bool) {
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+	for _, m := range all {
+		m.mu.Lock()
+	}
+	defer func() {
+		for _, m := range all {
+			m.mu.Unlock()
+		}
+	}()
+
+	// OpenMetrics names the counter families without their `_total` suffix.
+	family := func(name, kind, help string) {
+		if openMetrics {
+			name = strings.TrimSuffix(name, "_total")
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	family("s3auth_validations_total", "counter", "Requests whose signature was validated, by result.")
+	for _, m := range all {
+		for _, r := range sortedCounts(m.results) {
+			fmt.Fprintf(w, "s3auth_validations_total{middleware=%s,result=%s} %d\n", quoteLabel(m.name), quoteLabel(r), m.results[r])
+		}
+	}
+	family("s3auth_failures_total", "counter", "Failed validations and policy denials, by reason.")
+	for _, m := range all {
+		for _, r := range sortedCounts(m.failures) {
+			fmt.Fprintf(w, "s3auth_failures_total{middleware=%s,reason=%s} %d\n", quoteLabel(m.name), quoteLabel(r), m.failures[r])
+		}
+	}
+	family("s3auth_key_validations_total", "counter", "Validations of the known access key ids, by result.")
+	for _, m := range all {
+		keys := make([]keyResult, 0, len(m.keys))
+		for k := range m.keys {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].accessKeyID != keys[j].accessKeyID {
+				return keys[i].accessKeyID < keys[j].accessKeyID
+			}
+			return keys[i].result < keys[j].result
+		})
+		for _, k := range keys {
+			fmt.Fprintf(w, "s3auth_key_validations_total{middleware=%s,access_key_id=%s,result=%s} %d\n", quoteLabel(m.name), quoteLabel(k.accessKeyID), quoteLabel(k.result), m.keys[k])
+		}
+	}
+	family("s3auth_validation_duration_seconds", "histogram", "Time spent validating the requests.")
+	for _, m := range all {
+		name := quoteLabel(m.name)
+		exemplar := func(i int) string {
+			e := m.exemplars[i]
+			if !openMetrics || e.id == "" {
+				return ""
+			}
+			return fmt.Sprintf(" # {request_id=%s} %s %s", quoteLabel(e.id), strconv.FormatFloat(e.value, 'g', -1, 64),
+				strconv.FormatFloat(float64(e.at.UnixNano())/1e9, 'f', 3, 64))
+		}
+		for i, b := range latencyBuckets {
+			fmt.Fprintf(w, "s3auth_validation_duration_seconds_bucket{middleware=%s,le=%q} %d%s\n", name, strconv.FormatFloat(b, 'g', -1, 64), m.buckets[i], exemplar(i))
+		}
+		fmt.Fprintf(w, "s3auth_validation_duration_seconds_bucket{middleware=%s,le=\"+Inf\"} %d%s\n", name, m.count, exemplar(len(latencyBuckets)))
+		fmt.Fprintf(w, "s3auth_validation_duration_seconds_sum{middleware=%s} %s\n", name, strconv.FormatFloat(m.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "s3auth_validation_duration_seconds_count{middleware=%s} %d\n", name, m.count)
+	}
+	family("s3auth_credential_warnings", "gauge", "Credentials with a hygiene warning as of the last hygiene pass, by warning.")
+	for _, m := range all {
+		for _, k := range sortedGauges(m.warnings) {
+			fmt.Fprintf(w, "s3auth_credential_warnings{middleware=%s,warning=%s} %d\n", quoteLabel(m.name), quoteLabel(k), m.warnings[k])
+		}
+	}
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
+
+func sortedCounts(m map[string]uint64) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sortedGauges(m map[string]int64) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// quoteLabel quotes a label value, escaping the backslashes, quotes and newlines.
+func quoteLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// WriteMetrics writes the metrics of the middleware in the Prometheus text format, like the `/metrics` endpoint of the
+// admin server.
+func (p *Plugin) WriteMetrics(w io.Writer) {
+	writeMetrics(w, []*metrics{p.metrics}, false)
+}
+
+// WriteOpenMetrics writes the metrics of the middleware in the OpenMetrics format, with the exemplars.
+func (p *Plugin) WriteOpenMetrics(w io.Writer) {
+	writeMetrics(w, []*metrics{p.metrics}, true)
+}
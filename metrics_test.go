@@ -0,0 +1,275 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestMetrics(t *testing.T) {
+	cred := validCredential()
+	second := validCredential()
+	second.AccessKeyID = "AKIASECOND"
+	wrong := validCredential()
+	wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+	unknown := validCredential()
+	unknown.AccessKeyID = "AKIAUNKNOWN"
+	denied := validCredential()
+	denied.AccessKeyID = "AKIADENIED"
+	denied.Policy = &plugin.Policy{Statement: []*plugin.PolicyStatement{
+		{Effect: "Deny", Action: []string{"s3:*"}, Resource: []string{"*"}},
+	}}
+
+	tc := []struct {
+		name     string
+		metrics  *plugin.MetricsConfig
+		expected []string
+		missing  []string
+	}{
+		{
+			name: "default",
+			expected: []string{
+				`s3auth_validations_total{middleware="default",result="failure"} 3`,
+				`s3auth_validations_total{middleware="default",result="success"} 4`,
+				`s3auth_failures_total{middleware="default",reason="missing_auth"} 1`,
+				`s3auth_failures_total{middleware="default",reason="policy_deny"} 1`,
+				`s3auth_failures_total{middleware="default",reason="signature_mismatch"} 1`,
+				`s3auth_failures_total{middleware="default",reason="unknown_key"} 1`,
+				`s3auth_validation_duration_seconds_bucket{middleware="default",le="+Inf"} 7`,
+				`s3auth_validation_duration_seconds_count{middleware="default"} 7`,
+				"# TYPE s3auth_validation_duration_seconds histogram",
+			},
+			missing: []string{"access_key_id="},
+		},
+		{
+			name:    "key labels",
+			metrics: &plugin.MetricsConfig{KeyLabels: true},
+			expected: []string{
+				`s3auth_key_validations_total{middleware="key labels",access_key_id="ACCESS_ACCESS_ACCESS",result="failure"} 1`,
+				`s3auth_key_validations_total{middleware="key labels",access_key_id="ACCESS_ACCESS_ACCESS",result="success"} 2`,
+				`s3auth_key_validations_total{middleware="key labels",access_key_id="AKIASECOND",result="success"} 1`,
+			},
+			missing: []string{"AKIAUNKNOWN"},
+		},
+		{
+			name:    "allowlist",
+			metrics: &plugin.MetricsConfig{KeyLabels: true, KeyAllowlist: []string{"AKIASECOND"}},
+			expected: []string{
+				`s3auth_key_validations_total{middleware="allowlist",access_key_id="AKIASECOND",result="success"} 1`,
+			},
+			missing: []string{`access_key_id="ACCESS_ACCESS_ACCESS"`},
+		},
+		{
+			name:    "max keys",
+			metrics: &plugin.MetricsConfig{KeyLabels: true, MaxKeys: 1},
+			expected: []string{
+				`s3auth_key_validations_total{middleware="max keys",access_key_id="ACCESS_ACCESS_ACCESS",result="success"} 2`,
+				`s3auth_key_validations_total{middleware="max keys",access_key_id="other",result="success"} 2`,
+			},
+			missing: []string{"AKIASECOND"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred, second, denied}
+			cfg.Metrics = tt.metrics
+			handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			for _, c := range []*plugin.Credential{cred, cred, second, denied, wrong, unknown, nil} {
+				req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+				if c != nil {
+					signRequest(t, req, c, p.Now())
+				}
+				p.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			var b strings.Builder
+			p.WriteMetrics(&b)
+			for _, e := range tt.expected {
+				if !strings.Contains(b.String(), e+"\n") {
+					t.Errorf("expected %q in the metrics:\n%s", e, b.String())
+				}
+			}
+			for _, m := range tt.missing {
+				if strings.Contains(b.String(), m) {
+					t.Errorf("expected no %q in the metrics:\n%s", m, b.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHygieneMetrics(t *testing.T) {
+	expired := validCredential()
+	expired.NotAfter = "2025-07-01T00:00:00Z"
+	expiring := validCredential()
+	expiring.AccessKeyID = "AKIAEXPIRING"
+	expiring.NotAfter = time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	fine := validCredential()
+	fine.AccessKeyID = "AKIAFINE"
+
+	port := freePort(t)
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{expired, expiring, fine}
+	cfg.ExpiryWarningDays = 7
+	cfg.UnusedWarningDays = 30
+	cfg.AdminAddress = "127.0.0.1:" + port
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := plugin.New(ctx, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-hygiene-metrics"); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://127.0.0.1:"+port+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []string{
+		"# TYPE s3auth_credential_warnings gauge",
+		`s3auth_credential_warnings{middleware="s3-hygiene-metrics",warning="expired"} 1`,
+		`s3auth_credential_warnings{middleware="s3-hygiene-metrics",warning="expiring"} 1`,
+		`s3auth_credential_warnings{middleware="s3-hygiene-metrics",warning="unused"} 0`,
+	} {
+		if !strings.Contains(string(b), e+"\n") {
+			t.Errorf("expected %q in the metrics:\n%s", e, b)
+		}
+	}
+}
+
+func TestInvalidMetrics(t *testing.T) {
+	for _, m := range []*plugin.MetricsConfig{{MaxKeys: 5}, {KeyAllowlist: []string{"AKIA"}}, {KeyLabels: true, MaxKeys: -1}} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.Metrics = m
+		if _, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "metrics") {
+			t.Errorf("expected a metrics error for %+v, got %v", m, err)
+		}
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	tc := []struct {
+		name     string
+		statsd   *plugin.StatsDConfig
+		expected []string
+	}{
+		{
+			name:   "dogstatsd",
+			statsd: &plugin.StatsDConfig{Tags: []string{"env:prod"}},
+			expected: []string{
+				"s3auth.validations:1|c|#middleware:dogstatsd,env:prod,result:success",
+				"s3auth.validations:1|c|#middleware:dogstatsd,env:prod,result:failure",
+				"s3auth.failures:1|c|#middleware:dogstatsd,env:prod,reason:signature_mismatch",
+				"s3auth.key_validations:1|c|#middleware:dogstatsd,env:prod,access_key_id:ACCESS_ACCESS_ACCESS,result:success",
+				"s3auth.credential_warnings:0|g|#middleware:dogstatsd,env:prod,warning:expired",
+			},
+		},
+		{
+			name:   "statsd",
+			statsd: &plugin.StatsDConfig{Prefix: "gateway.s3.", Format: "statsd", Tags: []string{"env:prod"}},
+			expected: []string{
+				"gateway.s3.validations.success:1|c",
+				"gateway.s3.validations.failure:1|c",
+				"gateway.s3.failures.signature_mismatch:1|c",
+				"gateway.s3.key_validations.ACCESS_ACCESS_ACCESS.success:1|c",
+				"gateway.s3.credential_warnings.expired:0|g",
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			lines := make(chan string, 100)
+			go func() {
+				buf := make([]byte, 64*1024)
+				for {
+					n, _, err := conn.ReadFrom(buf)
+					if err != nil {
+						return
+					}
+					for _, l := range strings.Split(string(buf[:n]), "\n") {
+						lines <- l
+					}
+				}
+			}()
+
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			tt.statsd.Address = conn.LocalAddr().String()
+			cfg.Metrics = &plugin.MetricsConfig{KeyLabels: true, StatsD: tt.statsd}
+			cfg.UnusedWarningDays = 30
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			handler, err := plugin.New(ctx, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			wrong := validCredential()
+			wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+			for _, c := range []*plugin.Credential{validCredential(), wrong} {
+				req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+				signRequest(t, req, c, p.Now())
+				p.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			got := map[string]bool{}
+			timings := 0
+			deadline := time.After(5 * time.Second)
+			for len(got) < len(tt.expected) || timings < 2 {
+				select {
+				case l := <-lines:
+					if strings.Contains(l, "validation_duration:") && strings.Contains(l, "|ms") {
+						timings++
+					}
+					for _, e := range tt.expected {
+						if l == e {
+							got[e] = true
+						}
+					}
+				case <-deadline:
+					t.Fatalf("expected the lines %q and 2 timings, got %v and %d", tt.expected, got, timings)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidStatsD(t *testing.T) {
+	for _, sd := range []*plugin.StatsDConfig{{Address: "localhost"}, {Address: "localhost:8125", Format: "graphite"}, {Address: "localhost:8125", Tags: []string{"a|b"}}} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.Metrics = &plugin.MetricsConfig{StatsD: sd}
+		if _, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "statsd") {
+			t.Errorf("expected a statsd error for %+v, got %v", sd, err)
+		}
+	}
+}
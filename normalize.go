@@ -0,0 +1,39 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// checkNormalize ensures normalized requests are never forwarded with the signature of the client, which covers the
+// path as the client sent it.
+func checkNormalize(config *Config) error {
+	if config.NormalizeRequests && config.Upstream == nil && !config.StripAuthHeaders {
+		return errors.New("`normalizeRequests` must be combined with `upstream` or `stripAuthHeaders`")
+	}
+	return nil
+}
+
+// normalizeRequest lowercases the host, collapses duplicate slashes and resolves the dot segments of the path, so the
+// bucket and key the request is authorized for are the ones the backend resolves.
+func normalizeRequest(req *http.Request) {
+	req.Host = strings.ToLower(req.Host)
+	req.URL.Host = strings.ToLower(req.URL.Host)
+	if p := normalizePath(req.URL.Path); p != req.URL.Path {
+		req.URL.Path = p
+		req.URL.RawPath = ""
+	}
+}
+
+// normalizePath is path.Clean keeping the trailing slash, which tells prefixes apart from objects, eg: `/a//b/../c/`
+// is `/a/c/`.
+func normalizePath(p string) string {
+	dir := strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")
+	c := path.Clean("/" + p)
+	if dir && c != "/" {
+		c += "/"
+	}
+	return c
+}
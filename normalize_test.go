@@ -0,0 +1,122 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestNormalizeRequests(t *testing.T) {
+	tc := []struct {
+		name           string
+		url            string
+		signed         bool
+		expectedStatus int
+		expectedHost   string
+		expectedPath   string
+	}{
+		{
+			name:           "duplicate slashes",
+			url:            "https://s3.example.com/bucket//reports///2025.csv",
+			signed:         true,
+			expectedStatus: http.StatusOK,
+			expectedHost:   "s3.example.com",
+			expectedPath:   "/bucket/reports/2025.csv",
+		},
+		{
+			name:           "dot segments",
+			url:            "https://s3.example.com/bucket/reports/./drafts/../2025.csv",
+			signed:         true,
+			expectedStatus: http.StatusOK,
+			expectedHost:   "s3.example.com",
+			expectedPath:   "/bucket/reports/2025.csv",
+		},
+		{
+			name:           "trailing slash",
+			url:            "https://s3.example.com/bucket//reports/",
+			signed:         true,
+			expectedStatus: http.StatusOK,
+			expectedHost:   "s3.example.com",
+			expectedPath:   "/bucket/reports/",
+		},
+		{
+			name:           "uppercase host",
+			url:            "https://S3.Example.COM/bucket/reports/2025.csv",
+			signed:         true,
+			expectedStatus: http.StatusOK,
+			expectedHost:   "s3.example.com",
+			expectedPath:   "/bucket/reports/2025.csv",
+		},
+		{
+			name:           "authorized for the resolved key",
+			url:            "https://s3.example.com/bucket/reports/../private/secret.txt",
+			signed:         true,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "public read escaping its prefix",
+			url:            "https://s3.example.com/bucket/site/../private/secret.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "public read",
+			url:            "https://s3.example.com/bucket/site//index.html",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "s3.example.com",
+			expectedPath:   "/bucket/site/index.html",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Policy = &plugin.Policy{Statement: []*plugin.PolicyStatement{
+				{Effect: "Allow", Action: []string{"s3:GetObject", "s3:ListBucket"}, Resource: []string{"arn:aws:s3:::bucket", "arn:aws:s3:::bucket/reports/*"}},
+			}}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.PublicReadPrefixes = []string{"bucket/site/"}
+			cfg.StripAuthHeaders = true
+			cfg.NormalizeRequests = true
+			var forwarded *http.Request
+			handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req
+			}), cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.signed {
+				signRequest(t, req, cred, p.Now())
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status code %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if forwarded.Host != tt.expectedHost || forwarded.URL.Path != tt.expectedPath || forwarded.URL.RawPath != "" {
+				t.Errorf("expected %s%s, got %s%s (raw %q)", tt.expectedHost, tt.expectedPath, forwarded.Host, forwarded.URL.Path, forwarded.URL.RawPath)
+			}
+		})
+	}
+}
+
+func TestInvalidNormalizeRequests(t *testing.T) {
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.NormalizeRequests = true
+	_, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin")
+	if err == nil || !strings.Contains(err.Error(), "normalizeRequests") {
+		t.Errorf("expected an error about normalizeRequests, got %v", err)
+	}
+}
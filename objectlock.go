@@ -0,0 +1,74 @@
+package traefik_plugin_s3_auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	lockGovernance = "GOVERNANCE"
+	lockCompliance = "COMPLIANCE"
+)
+
+// ObjectLockRule requires the uploads under a prefix to set an object lock retention, so compliance retention can't
+// be bypassed by clients that forget the headers.
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/object-lock.html
+type ObjectLockRule struct {
+	// Prefix of the objects, as `bucket/key-prefix`, eg: `records/2025/`.
+	Prefix string `json:"prefix,omitempty"`
+	// Mode is the required `x-amz-object-lock-mode`, either `GOVERNANCE` or `COMPLIANCE`. Any mode is accepted when
+	// empty, as long as one is set.
+	Mode string `json:"mode,omitempty"`
+	// MinRetentionDays is the minimum time left until the `x-amz-object-lock-retain-until-date`, in days.
+	MinRetentionDays int `json:"minRetentionDays,omitempty"`
+}
+
+func checkObjectLockRules(rules []*ObjectLockRule) error {
+	for _, r := range rules {
+		if _, err := normalizeObjectPrefixes([]string{r.Prefix}); err != nil {
+			return err
+		}
+		if r.Mode = strings.ToUpper(r.Mode); r.Mode != "" && r.Mode != lockGovernance && r.Mode != lockCompliance {
+			return fmt.Errorf("unsupported object lock mode %q for prefix %q", r.Mode, r.Prefix)
+		}
+		if r.MinRetentionDays < 0 {
+			return fmt.Errorf("invalid `minRetentionDays` for prefix %q: must not be negative", r.Prefix)
+		}
+	}
+	return nil
+}
+
+// lockedUploads are the operations creating objects, multipart uploads set the retention when they are created.
+var lockedUploads = map[string]bool{"PutObject": true, "CreateMultipartUpload": true}
+
+// checkObjectLock verifies the retention headers of uploads under the prefix of any rule.
+func checkObjectLock(rules []*ObjectLockRule, req *http.Request, res s3Resource, op s3Operation, now time.Time) error {
+	if !lockedUploads[op.Name] {
+		return nil
+	}
+	name := res.Bucket + "/" + res.Key
+	for _, r := range rules {
+		if !strings.HasPrefix(name, r.Prefix) {
+			continue
+		}
+		mode := strings.ToUpper(req.Header.Get("X-Amz-Object-Lock-Mode"))
+		switch {
+		case mode == "":
+			return fmt.Errorf("uploads to %q must set `x-amz-object-lock-mode`", r.Prefix)
+		case r.Mode != "" && mode != r.Mode:
+			return fmt.Errorf("uploads to %q must use the %s object lock mode", r.Prefix, r.Mode)
+		case mode != lockGovernance && mode != lockCompliance:
+			return fmt.Errorf("unsupported object lock mode: %q", mode)
+		}
+		until, err := time.Parse(time.RFC3339, req.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		if err != nil {
+			return fmt.Errorf("uploads to %q must set a valid `x-amz-object-lock-retain-until-date`", r.Prefix)
+		}
+		if earliest := now.AddDate(0, 0, r.MinRetentionDays); until.Before(earliest) {
+			return fmt.Errorf("uploads to %q must be retained until at least %s", r.Prefix, earliest.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestObjectLockRules(t *testing.T) {
+	tc := []struct {
+		name           string
+		method         string
+		target         string
+		mode           string
+		until          string
+		expectedStatus int
+	}{
+		{
+			name:           "retained upload",
+			method:         http.MethodPut,
+			target:         "/records/2025/ledger.csv",
+			mode:           "COMPLIANCE",
+			until:          "2025-12-31T00:00:00Z",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "retained multipart upload",
+			method:         http.MethodPost,
+			target:         "/records/2025/ledger.csv?uploads",
+			mode:           "COMPLIANCE",
+			until:          "2025-08-09T05:45:00.000Z",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing mode",
+			method:         http.MethodPut,
+			target:         "/records/2025/ledger.csv",
+			until:          "2025-12-31T00:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "wrong mode",
+			method:         http.MethodPut,
+			target:         "/records/2025/ledger.csv",
+			mode:           "GOVERNANCE",
+			until:          "2025-12-31T00:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing retention",
+			method:         http.MethodPut,
+			target:         "/records/2025/ledger.csv",
+			mode:           "COMPLIANCE",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "short retention",
+			method:         http.MethodPut,
+			target:         "/records/2025/ledger.csv",
+			mode:           "COMPLIANCE",
+			until:          "2025-08-01T00:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "outside of the prefixes",
+			method:         http.MethodPut,
+			target:         "/records/scratch.csv",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "parts are not checked",
+			method:         http.MethodPut,
+			target:         "/records/2025/ledger.csv?partNumber=1&uploadId=abc",
+			expectedStatus: http.StatusOK,
+		},
+	}
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.ObjectLockRules = []*plugin.ObjectLockRule{{Prefix: "records/2025/", Mode: "compliance", MinRetentionDays: 30}}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, "https://s3.example.com"+tt.target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.mode != "" {
+				req.Header.Set("X-Amz-Object-Lock-Mode", tt.mode)
+			}
+			if tt.until != "" {
+				req.Header.Set("X-Amz-Object-Lock-Retain-Until-Date", tt.until)
+			}
+			signRequest(t, req, cred, p.Now())
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestInvalidObjectLockRules(t *testing.T) {
+	for _, r := range []*plugin.ObjectLockRule{{Prefix: "records"}, {Prefix: "records/", Mode: "FOREVER"}, {Prefix: "records/", MinRetentionDays: -1}} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.ObjectLockRules = []*plugin.ObjectLockRule{r}
+		if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "objectLockRules") {
+			t.Errorf("expected an objectLockRules error, got %v", err)
+		}
+	}
+}
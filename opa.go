@@ -0,0 +1,294 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOPATimeout       = time.Second
+	maxDecisionCacheEntries = 4096
+)
+
+// errDecisionUnavailable is returned when an external authorizer can't be reached.
+var errDecisionUnavailable = errors.New("the authorization decision is unavailable")
+
+// OPAConfig delegates the authorization of validated requests to an Open Policy Agent.
+// https://www.openpolicyagent.org/docs/latest/rest-api/#get-a-document-with-input
+type OPAConfig struct {
+	// URL of the decision, eg: `http://opa:8181/v1/data/s3/allow`. The result is either a boolean or an object with an
+	// `allow` boolean.
+	URL string `json:"url,omitempty"`
+	// Headers are extra request headers, eg: a bearer token.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout bounds each decision, defaults to `1s`.
+	Timeout string `json:"timeout,omitempty"`
+	// CacheTTL caches the decisions of identical requests, eg: `30s`. Disabled by default.
+	CacheTTL string `json:"cacheTtl,omitempty"`
+	// FailOpen lets requests through when OPA is unreachable, they are rejected by default.
+	FailOpen bool `json:"failOpen,omitempty"`
+}
+
+type opaClient struct {
+	url      string
+	headers  map[string]string
+	failOpen bool
+	client   *http.Client
+	cache    *decisionCache
+}
+
+func newOPAClient(config *OPAConfig) (*opaClient, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return nil, fmt.Errorf("invalid opa `url`: %q", config.URL)
+	}
+	timeout := defaultOPATimeout
+	if config.Timeout != "" {
+		d, err := time.ParseDuration(config.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid opa `timeout` %q, eg: `1s`", config.Timeout)
+		}
+		timeout = d
+	}
+	cache, err := newDecisionCache(config.CacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid opa `cacheTtl` %q, eg: `30s`", config.CacheTTL)
+	}
+	return &opaClient{url: config.URL, headers: config.Headers, failOpen: config.FailOpen, client: &http.Client{Timeout: timeout}, cache: cache}, nil
+}
+
+// opaInput is the decision input document.
+type opaInput struct {
+	AccessKeyID string            `json:"accessKeyId"`
+	Parent      string            `json:"parent,omitempty"`
+	Roles       []string          `json:"roles,omitempty"`
+	Groups      []string          `json:"groups,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Operation   string            `json:"operation"`
+	Action      string            `json:"action"`
+	Bucket      string            `json:"bucket,omitempty"`
+	Key         string            `json:"key,omitempty"`
+	Method      string            `json:"method"`
+	Path        string            `json:"path"`
+	Query       string            `json:"query,omitempty"`
+	Headers     map[string]string `json:"headers"`
+	ClientIP    string            `json:"clientIp"`
+}
+
+// opaHiddenHeaders are never sent to OPA.
+var opaHiddenHeaders = map[string]bool{"Authorization": true, "X-Amz-Security-Token": true, "Cookie": true}
+
+// newOPAInput returns the decision input of the request, without the hidden headers nor the credential headers, eg:
+// the `iam` header.
+func newOPAInput(req *http.Request, cred *Credential, op s3Operation, res s3Resource, ip string, credentialHeaders []string) opaInput {
+	hidden := make(map[string]bool, len(credentialHeaders))
+	for _, h := range credentialHeaders {
+		hidden[http.CanonicalHeaderKey(h)] = true
+	}
+	headers := make(map[string]string, len(req.Header))
+	for k, v := range req.Header {
+		if !opaHiddenHeaders[k] && !hidden[k] {
+			headers[strings.ToLower(k)] = strings.Join(v, ", ")
+		}
+	}
+	return opaInput{
+		AccessKeyID: cred.AccessKeyID,
+		Parent:      cred.parent,
+		Roles:       cred.Roles,
+		Groups:      cred.Groups,
+		Tags:        cred.Tags,
+		Operation:   op.Name,
+		Action:      op.Action,
+		Bucket:      res.Bucket,
+		Key:         res.Key,
+		Method:      req.Method,
+		Path:        req.URL.Path,
+		Query:       req.URL.RawQuery,
+		Headers:     headers,
+		ClientIP:    ip,
+	}
+}
+
+// decide asks OPA whether the request is allowed. Errors wrap errDecisionUnavailable unless the client fails open.
+func (o *opaClient) decide(ctx context.Context, input opaInput, now time.Time) error {
+	key, err := o.cache.key(input)
+	if err != nil {
+		return err
+	}
+	reason, ok := o.cache.get(key, now)
+	if !ok {
+		allowed, err := o.query(ctx, input)
+		if err != nil {
+			if o.failOpen {
+				logs.warn("opa is unavailable, failing open", "error", err)
+				return nil
+			}
+			return fmt.Errorf("%w: %s", errDecisionUnavailable, err.Error())
+		}
+		if reason = ""; !allowed {
+			reason = "denied by opa"
+		}
+		o.cache.put(key, reason, now)
+	}
+	if reason != "" {
+		return errors.New(reason)
+	}
+	return nil
+}
+
+func (o *opaClient) query(ctx context.Context, input opaInput) (bool, error) {
+	b, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var out struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSourceBytes)).Decode(&out); err != nil {
+		return false, fmt.Errorf("invalid opa response: %w", err)
+	}
+	// An undefined decision has no result and denies the request.
+	if len(out.Result) == 0 {
+		return false, nil
+	}
+	var allowed bool
+	if err := json.Unmarshal(out.Result, &allowed); err == nil {
+		return allowed, nil
+	}
+	var obj struct {
+		Allow bool `json:"allow"`
+	}
+	if err := json.Unmarshal(out.Result, &obj); err != nil {
+		return false, fmt.Errorf("invalid opa result: %s", out.Result)
+	}
+	return obj.Allow, nil
+}
+
+// decisionCache caches the decisions of an external authorizer, so repeated requests, eg: the GETs of the same object
+// by the same credential, don't wait for it every time.
+type decisionCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedDecision
+	hits    uint64
+	misses  uint64
+}
+
+// cachedDecision is the reason for denying the request, empty when it is allowed.
+type cachedDecision struct {
+	reason  string
+	expires time.Time
+}
+
+// newDecisionCache returns a cache for the ttl, eg: `30s`. Nothing is cached without one.
+func newDecisionCache(ttl string) (*decisionCache, error) {
+	c := &decisionCache{entries: map[string]cachedDecision{}}
+	if ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid ttl: %q", ttl)
+		}
+		c.ttl = d
+	}
+	return c, nil
+}
+
+// decisionKey is the part of the input the cached decisions depend on. The headers are left out, since the SDKs send
+// headers that change on every request, eg: `amz-sdk-invocation-id`, and nothing would ever be cached otherwise.
+type decisionKey struct {
+	AccessKeyID string `json:"accessKeyId"`
+	Operation   string `json:"operation"`
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	Query       string `json:"query"`
+	ClientIP    string `json:"clientIp"`
+}
+
+// key identifies the decision of the input, it is empty when nothing is cached.
+func (c *decisionCache) key(input opaInput) (string, error) {
+	if c.ttl == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(decisionKey{
+		AccessKeyID: input.AccessKeyID,
+		Operation:   input.Operation,
+		Bucket:      input.Bucket,
+		Key:         input.Key,
+		Query:       input.Query,
+		ClientIP:    input.ClientIP,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (c *decisionCache) get(key string, now time.Time) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.entries[key]
+	if !ok || !now.Before(d.expires) {
+		c.misses++
+		return "", false
+	}
+	c.hits++
+	return d.reason, true
+}
+
+// status describes the cache, nil when nothing is cached.
+func (c *decisionCache) status() *CacheStatus {
+	if c.ttl == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := newCacheStatus(len(c.entries), c.hits, c.misses)
+	return &s
+}
+
+func (c *decisionCache) put(key, reason string, now time.Time) {
+	if key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxDecisionCacheEntries {
+		c.entries = map[string]cachedDecision{}
+	}
+	c.entries[key] = cachedDecision{reason: reason, expires: now.Add(c.ttl)}
+}
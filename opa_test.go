@@ -0,0 +1,198 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestOPA(t *testing.T) {
+	var input map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer opa-token" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Input map[string]interface{} `json:"input"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		input = body.Input
+		switch req.URL.Path {
+		case "/v1/data/s3/allow":
+			_, _ = rw.Write([]byte(`{"result": ` + strconv.FormatBool(body.Input["operation"] == "GetObject") + `}`))
+		case "/v1/data/s3/decision":
+			_, _ = rw.Write([]byte(`{"result": {"allow": ` + strconv.FormatBool(body.Input["bucket"] == "public") + `}}`))
+		case "/v1/data/s3/undefined":
+			_, _ = rw.Write([]byte(`{}`))
+		default:
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	tc := []struct {
+		name           string
+		path           string
+		failOpen       bool
+		method         string
+		target         string
+		expectedStatus int
+	}{
+		{
+			name:           "boolean allow",
+			path:           "/v1/data/s3/allow",
+			method:         http.MethodGet,
+			target:         "/bucket/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "boolean deny",
+			path:           "/v1/data/s3/allow",
+			method:         http.MethodPut,
+			target:         "/bucket/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "object allow",
+			path:           "/v1/data/s3/decision",
+			method:         http.MethodPut,
+			target:         "/public/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "object deny",
+			path:           "/v1/data/s3/decision",
+			method:         http.MethodPut,
+			target:         "/private/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "undefined decision",
+			path:           "/v1/data/s3/undefined",
+			method:         http.MethodGet,
+			target:         "/bucket/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unavailable",
+			path:           "/v1/data/s3/broken",
+			method:         http.MethodGet,
+			target:         "/bucket/object.txt",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "unavailable failing open",
+			path:           "/v1/data/s3/broken",
+			failOpen:       true,
+			method:         http.MethodGet,
+			target:         "/bucket/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Roles = []string{"reader"}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.OPA = &plugin.OPAConfig{URL: server.URL + tt.path, Headers: map[string]string{"Authorization": "Bearer opa-token"}, FailOpen: tt.failOpen}
+			p := newTestPlugin(t, cfg)
+
+			input = nil
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, tt.target, cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if input == nil {
+				t.Fatal("expected a decision input")
+			}
+			if input["accessKeyId"] != cred.AccessKeyID || input["clientIp"] != "192.0.2.10" || input["method"] != tt.method {
+				t.Errorf("unexpected decision input: %v", input)
+			}
+			headers, _ := input["headers"].(map[string]interface{})
+			if _, ok := headers["authorization"]; ok || headers["x-amz-date"] == nil {
+				t.Errorf("expected the headers without the authorization, got %v", headers)
+			}
+			if roles, _ := input["roles"].([]interface{}); len(roles) != 1 || roles[0] != "reader" {
+				t.Errorf("expected the roles in the decision input, got %v", input["roles"])
+			}
+		})
+	}
+}
+
+func TestOPACache(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var body struct {
+			Input map[string]interface{} `json:"input"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"result": ` + strconv.FormatBool(body.Input["operation"] == "GetObject") + `}`))
+	}))
+	defer server.Close()
+
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.OPA = &plugin.OPAConfig{URL: server.URL, CacheTTL: "1m"}
+	p := newTestPlugin(t, cfg)
+	now := p.Now()
+
+	tc := []struct {
+		method         string
+		invocationID   string
+		elapsed        time.Duration
+		expectedStatus int
+		expectedCalls  int32
+	}{
+		{method: http.MethodGet, invocationID: "8e1a7a4c-0001", expectedStatus: http.StatusOK, expectedCalls: 1},
+		// The headers the SDKs change on every request don't miss the cache.
+		{method: http.MethodGet, invocationID: "8e1a7a4c-0002", expectedStatus: http.StatusOK, expectedCalls: 1},
+		{method: http.MethodPut, expectedStatus: http.StatusForbidden, expectedCalls: 2},
+		{method: http.MethodPut, expectedStatus: http.StatusForbidden, expectedCalls: 2},
+		{method: http.MethodGet, elapsed: 2 * time.Minute, expectedStatus: http.StatusOK, expectedCalls: 3},
+	}
+	for i, tt := range tc {
+		p.Now = func() time.Time { return now.Add(tt.elapsed) }
+		recorder := httptest.NewRecorder()
+		req := newSignedRequest(t, tt.method, "/bucket/object.txt", cred)
+		if tt.invocationID != "" {
+			req.Header.Set("Amz-Sdk-Invocation-Id", tt.invocationID)
+		}
+		p.ServeHTTP(recorder, req)
+		if recorder.Code != tt.expectedStatus {
+			t.Errorf("request %d: expected status code %d, got %d", i, tt.expectedStatus, recorder.Code)
+		}
+		if got := atomic.LoadInt32(&calls); got != tt.expectedCalls {
+			t.Errorf("request %d: expected %d opa calls, got %d", i, tt.expectedCalls, got)
+		}
+	}
+}
+
+func TestInvalidOPA(t *testing.T) {
+	for _, c := range []*plugin.OPAConfig{{URL: "opa:8181"}, {URL: "http://opa:8181/v1/data/s3/allow", Timeout: "soon"}, {URL: "http://opa:8181/v1/data/s3/allow", CacheTTL: "-1s"}} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.OPA = c
+		if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "opa") {
+			t.Errorf("expected an opa error, got %v", err)
+		}
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,6 +16,181 @@ type Config struct {
 	HeaderName  string        `json:"headerName,omitempty"`
 	StatusCode  int           `json:"statusCode,omitempty"`
 	Credentials []*Credential `json:"credentials,omitempty"`
+	// Sources loads additional credentials, eg: from a MinIO user export.
+	Sources []*Source `json:"sources,omitempty"`
+	// Groups restricts the middleware to credentials belonging to at least one of these groups.
+	Groups []string `json:"groups,omitempty"`
+	// VirtualHostDomains are the domains of virtual-host-style requests, eg: `s3.example.com` for
+	// `bucket.s3.example.com`. Requests for other hosts are treated as path-style.
+	VirtualHostDomains []string `json:"virtualHostDomains,omitempty"`
+	// RequireTLS rejects requests that didn't arrive over TLS, or whose `X-Forwarded-Proto` isn't `https`.
+	RequireTLS bool `json:"requireTls,omitempty"`
+	// DefaultPolicy applies to every credential, their own policy takes precedence when one of its statements matches.
+	DefaultPolicy *Policy `json:"defaultPolicy,omitempty"`
+	// DefaultDeny requires a matching `Allow` statement for every request, a valid signature alone is not enough.
+	// Credentials without a policy are then denied unless the `defaultPolicy` or the Cedar policies allow them.
+	DefaultDeny bool `json:"defaultDeny,omitempty"`
+	// PublicReadPrefixes are `bucket/prefix` entries, eg: `site/`, whose objects anyone can `GET` and `HEAD` without a
+	// signature. Every other request, and signed ones, still require a valid signature.
+	PublicReadPrefixes []string `json:"publicReadPrefixes,omitempty"`
+	// Grants temporarily allow credentials actions under a prefix, see Grant. More can be added through the admin
+	// server.
+	Grants []*Grant `json:"grants,omitempty"`
+	// Geo optionally trusts the country set by a CDN for policy conditions, see GeoConfig.
+	Geo *GeoConfig `json:"geo,omitempty"`
+	// ForwardedForDepth is the number of trusted proxies in front of Traefik appending to `X-Forwarded-For`, used to
+	// find the real client ip. With 0, the default, the peer address is used.
+	ForwardedForDepth int `json:"forwardedForDepth,omitempty"`
+	// Denylist rejects clients from these ranges before validating the signature, see DenylistConfig.
+	Denylist *DenylistConfig `json:"denylist,omitempty"`
+	// Alerts optionally posts to a webhook when an access key id or a client ip fails too often, see AlertsConfig.
+	Alerts *AlertsConfig `json:"alerts,omitempty"`
+	// FailureThrottle slows down and caps the failed authentications of each client ip, see FailureThrottleConfig.
+	FailureThrottle *FailureThrottleConfig `json:"failureThrottle,omitempty"`
+	// Cedar optionally authorizes requests with Cedar policies, see CedarConfig.
+	Cedar *CedarConfig `json:"cedar,omitempty"`
+	// OPA optionally delegates the authorization of validated requests to an Open Policy Agent, see OPAConfig.
+	OPA *OPAConfig `json:"opa,omitempty"`
+	// AuthWebhook optionally asks an external service to authorize validated requests, see AuthWebhookConfig.
+	AuthWebhook *AuthWebhookConfig `json:"authWebhook,omitempty"`
+	// Tenancy optionally isolates the tenants of the credentials under their own key prefix, see TenancyConfig.
+	Tenancy *TenancyConfig `json:"tenancy,omitempty"`
+	// WriteOncePrefixes are `bucket/prefix` entries, eg: `backups/daily/`, whose objects can't be deleted or overwritten
+	// once written through the middleware, whatever the credential.
+	WriteOncePrefixes []string `json:"writeOncePrefixes,omitempty"`
+	// ObjectLockRules require the uploads under their prefix to set an object lock retention, see ObjectLockRule.
+	ObjectLockRules []*ObjectLockRule `json:"objectLockRules,omitempty"`
+	// Redis optionally persists the request quota counters, see RedisConfig.
+	Redis *RedisConfig `json:"redis,omitempty"`
+	// CredentialsDir is a mounted secrets directory, a shortcut for a `dir` source in `us-east-1` for `s3`.
+	CredentialsDir string `json:"credentialsDir,omitempty"`
+	// InlinePriority is the priority of the inline credentials when merging them with the sources.
+	InlinePriority int `json:"inlinePriority,omitempty"`
+	// ConflictPolicy is either `priority` (the default) or `error` for credentials defined by several sources.
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+	// UnavailablePolicy is either `lastKnownGood` (the default), `failClosed` or `inline` for when loading the
+	// sources fails.
+	UnavailablePolicy string `json:"unavailablePolicy,omitempty"`
+	// RefreshInterval reloads the sources periodically, eg: `1m`.
+	RefreshInterval string `json:"refreshInterval,omitempty"`
+	// ReloadWebhook is an optional URL receiving a summary whenever the credential set changes.
+	ReloadWebhook string `json:"reloadWebhook,omitempty"`
+	// STS optionally vends temporary credentials, see STSConfig.
+	STS *STSConfig `json:"sts,omitempty"`
+	// RolesHeader is an optional request header set to the comma separated roles of the validated credential.
+	RolesHeader string `json:"rolesHeader,omitempty"`
+	// IdentityHeader is an optional request header set to the validated access key id, or the one of the parent of
+	// temporary credentials, eg: `X-S3Auth-AccessKeyId`.
+	IdentityHeader string `json:"identityHeader,omitempty"`
+	// ClientUsername sets the user of the request URL to the validated access key id, which Traefik reports as the
+	// `ClientUsername` of its access logs.
+	ClientUsername bool `json:"clientUsername,omitempty"`
+	// TenantHeader is an optional request header set to the tenant of the validated credential, see Credential.Tenant.
+	TenantHeader string `json:"tenantHeader,omitempty"`
+	// AccessLogHeaderPrefix is an optional prefix, eg: `X-S3Auth-`, of the request headers set to the access key id,
+	// tenant, action, decision and reason of every request, for the Traefik access logs to pick up.
+	AccessLogHeaderPrefix string `json:"accessLogHeaderPrefix,omitempty"`
+	// RequestIDs mints S3 style `x-amz-request-id` and `x-amz-id-2` ids for every request, set on the forwarded requests
+	// and on the responses, so client and server logs can be correlated.
+	RequestIDs bool `json:"requestIds,omitempty"`
+	// RequestIDHeader is an optional header, eg: `X-Request-Id`, carrying the correlation id of the request. The one
+	// the client sent is kept, or a new one is minted, and it is attached to the logs, the metric exemplars, the
+	// forwarded request and the response.
+	RequestIDHeader string `json:"requestIdHeader,omitempty"`
+	// DecisionHeader optionally sets an HMAC protected summary of the decision, see DecisionHeaderConfig.
+	DecisionHeader *DecisionHeaderConfig `json:"decisionHeader,omitempty"`
+	// InjectHeaders are request headers set to Go templates over the validated credential and the request, eg:
+	// `X-Tenant: {{ .Tags.tenant }}`.
+	InjectHeaders map[string]string `json:"injectHeaders,omitempty"`
+	// StripAuthHeaders removes the authorization header and the other signing headers of validated requests, so the
+	// backend never sees the client credentials.
+	StripAuthHeaders bool `json:"stripAuthHeaders,omitempty"`
+	// OriginalAuthHeader is an optional request header set to the authorization header the client sent, before it is
+	// stripped or re-signed, eg: `X-Original-Authorization` so the backend can archive it.
+	OriginalAuthHeader string `json:"originalAuthHeader,omitempty"`
+	// BackendHost is the host validated requests are sent with, eg: `minio.internal:9000` for path-style requests or
+	// `{bucket}.s3.eu-central-1.amazonaws.com` for virtual-host-style ones.
+	BackendHost string `json:"backendHost,omitempty"`
+	// BucketMappings map the logical buckets clients sign for to the backend bucket, and optional key prefix, they are
+	// stored in, eg: `photos: media-eu/photos/`.
+	BucketMappings map[string]string `json:"bucketMappings,omitempty"`
+	// MaxBufferedBodyBytes bounds the bodies hashed in memory for requests without `x-amz-content-sha256`, defaults to
+	// 1 MiB. Larger bodies are rejected unless BodySpillDir is set.
+	MaxBufferedBodyBytes int64 `json:"maxBufferedBodyBytes,omitempty"`
+	// BodySpillDir is an optional directory larger bodies are spilled to while hashing them, eg: `/tmp`.
+	BodySpillDir string `json:"bodySpillDir,omitempty"`
+	// MaxSpilledBodyBytes bounds the bodies spilled to BodySpillDir, defaults to 5 GiB.
+	MaxSpilledBodyBytes int64 `json:"maxSpilledBodyBytes,omitempty"`
+	// PassThrough only validates requests and forwards them untouched, keeping the signature of the client for backends
+	// checking it again. It can't be combined with the options rewriting requests, eg: `upstream`.
+	PassThrough bool `json:"passThrough,omitempty"`
+	// NormalizeRequests collapses duplicate slashes, resolves dot segments and lowercases the host of the requests once
+	// their signature is validated, before authorizing and forwarding them. It requires Upstream or StripAuthHeaders.
+	NormalizeRequests bool `json:"normalizeRequests,omitempty"`
+	// Upstream optionally re-signs validated requests with the backend credentials, see UpstreamConfig.
+	Upstream *UpstreamConfig `json:"upstream,omitempty"`
+	// Audit optionally mirrors the validated requests to an audit sink, see AuditConfig.
+	Audit *AuditConfig `json:"audit,omitempty"`
+	// UpgradePolicy is how requests switching protocols, eg: WebSockets, are handled: `validate` (the default) like any
+	// other request, `reject`, `bypass` without any validation, or `validateIfSigned`, bypassing unsigned ones.
+	UpgradePolicy string `json:"upgradePolicy,omitempty"`
+	// Challenge answers requests without an authorization header with a `401` and a `WWW-Authenticate:
+	// AWS4-HMAC-SHA256` challenge instead, for generic HTTP clients and probes.
+	Challenge bool `json:"challenge,omitempty"`
+	// CollapseKeyErrors reports unknown access key ids as signature mismatches, so clients can't enumerate the keys.
+	CollapseKeyErrors bool `json:"collapseKeyErrors,omitempty"`
+	// DebugSignatures logs the canonical request and the string to sign of the signature mismatches, never the
+	// signatures themselves, to debug clients. They include the signed header values, so only enable it while
+	// debugging.
+	DebugSignatures bool `json:"debugSignatures,omitempty"`
+	// DebugErrors includes the canonical request, the string to sign and the component that likely diverged in the
+	// SignatureDoesNotMatch errors, to debug client integrations. Only enable it in staging.
+	DebugErrors bool `json:"debugErrors,omitempty"`
+	// ErrorFormat is the body of the error responses: `xml` (the default) for the S3 errors, `json` or `plain`.
+	ErrorFormat string `json:"errorFormat,omitempty"`
+	// NegotiateErrorFormat returns the error format the `Accept` header of the client prefers, among `xml`, `json` and
+	// `plain`, falling back to ErrorFormat.
+	NegotiateErrorFormat bool `json:"negotiateErrorFormat,omitempty"`
+	// StatusCodes overrides the status code of failure classes, ie `unknownKey`, `badSignature`, `skew` and
+	// `policyDeny`, eg: for CDNs caching some statuses but not others.
+	StatusCodes map[string]int `json:"statusCodes,omitempty"`
+	// ErrorTemplate optionally renders the error responses with a Go template instead, see ErrorTemplateConfig.
+	ErrorTemplate *ErrorTemplateConfig `json:"errorTemplate,omitempty"`
+	// CORS optionally answers the preflights and sets the CORS headers of the responses, see CORSConfig.
+	CORS *CORSConfig `json:"cors,omitempty"`
+	// UnsignedClients optionally accepts unsigned requests from trusted networks, see UnsignedClientsConfig.
+	UnsignedClients *UnsignedClientsConfig `json:"unsignedClients,omitempty"`
+	// IAM optionally authenticates AWS IAM identities through AWS STS, see IAMConfig.
+	IAM *IAMConfig `json:"iam,omitempty"`
+	// AdminAddress is an optional listen address (eg: `127.0.0.1:8089`) for the
+	// internal admin server exposing the `/status` endpoint.
+	AdminAddress string `json:"adminAddress,omitempty"`
+	// AdminToken is the bearer token of the admin endpoints changing the state of the middlewares or evaluating
+	// requests. Without one, they are only served on a loopback `adminAddress`.
+	AdminToken string `json:"adminToken,omitempty"`
+	// Log configures the level and the format of the logs, see LogConfig.
+	Log *LogConfig `json:"log,omitempty"`
+	// Metrics configures the metrics of the `/metrics` endpoint of the admin server, see MetricsConfig.
+	Metrics *MetricsConfig `json:"metrics,omitempty"`
+	// Metering optionally exports the requests and bytes of each access key id periodically, see MeteringConfig.
+	Metering *MeteringConfig `json:"metering,omitempty"`
+	// DecisionLog is an optional file path, `stdout`, or a `udp://`, `tcp://` or `tls://` syslog endpoint, receiving a
+	// JSON line per authorization decision, eg: for shipping them to a SIEM.
+	DecisionLog string `json:"decisionLog,omitempty"`
+	// DecisionLogFormat is `json`, the default, or `cloudtrail` for records in the schema of the CloudTrail S3 events.
+	DecisionLogFormat string `json:"decisionLogFormat,omitempty"`
+	// FailureLog is an optional file path or `stdout`, receiving a line per failed authentication in a stable format
+	// for Fail2ban or CrowdSec filters, eg: for banning brute-force sources at the network level.
+	FailureLog string `json:"failureLog,omitempty"`
+	// ReadOnly rejects every mutating request with a `503`, regardless of the credentials, eg: during a backend
+	// maintenance. It can also be toggled through the admin server.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// ExpiryWarningDays warns about credentials whose `notAfter` is within this many days.
+	ExpiryWarningDays int `json:"expiryWarningDays,omitempty"`
+	// UnusedWarningDays warns about credentials that have not been used for this many days.
+	UnusedWarningDays int `json:"unusedWarningDays,omitempty"`
+	// HygieneInterval is how often the credential warnings are re-evaluated, eg: `1h`.
+	HygieneInterval string `json:"hygieneInterval,omitempty"`
 }
 
 type Credential struct {
@@ -19,60 +198,1133 @@ type Credential struct {
 	AccessSecretKey string `json:"accessSecretKey,omitempty"`
 	Region          string `json:"region,omitempty"`
 	Service         string `json:"service,omitempty"`
+	// NotAfter is an optional RFC3339 timestamp after which the credential is rejected.
+	NotAfter string `json:"notAfter,omitempty"`
+	// Tags is free-form metadata, eg: the MinIO policy a credential was imported with.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Roles are coarse-grained role names, eg: `admin` or `read-only`, passed on to downstream middlewares.
+	Roles []string `json:"roles,omitempty"`
+	// Groups the credential belongs to, so middlewares sharing a catalog can each accept a subset of it.
+	Groups []string `json:"groups,omitempty"`
+	// AllowedPrefixes restricts the credential to paths starting with one of these prefixes, eg: `/tenant-a/`.
+	AllowedPrefixes []string `json:"allowedPrefixes,omitempty"`
+	// AllowedMethods restricts the credential to these HTTP methods, eg: `GET` and `HEAD` for read-only keys.
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	// AllowedBuckets restricts the credential to these buckets, glob patterns such as `logs-*` are supported.
+	AllowedBuckets []string `json:"allowedBuckets,omitempty"`
+	// AllowedKeys restricts the object keys of the credential, as globs such as `logs/2*.gz` or regular expressions
+	// prefixed with `regex:`.
+	AllowedKeys []string `json:"allowedKeys,omitempty"`
+	// RequireListDelimiter requires listings to set a `delimiter`, eg: so they only return one level of keys.
+	RequireListDelimiter bool `json:"requireListDelimiter,omitempty"`
+	// Policy is an optional IAM-like policy document evaluated against the inferred S3 action, see Policy.
+	Policy *Policy `json:"policy,omitempty"`
+	// AllowedCIDRs restricts the credential to clients from these ranges, eg: `10.0.0.0/8`.
+	AllowedCIDRs []string `json:"allowedCidrs,omitempty"`
+	// AccessWindows restricts the credential to recurring periods, eg: the schedule of a backup job.
+	AccessWindows []*AccessWindow `json:"accessWindows,omitempty"`
+
+	// MaxInFlight caps the simultaneous requests of the credential, eg: for huge multipart uploads. Requests over the
+	// limit wait up to InFlightWait, eg: `5s`, for a slot and are otherwise rejected with `SlowDown`.
+	MaxInFlight  int    `json:"maxInFlight,omitempty"`
+	InFlightWait string `json:"inFlightWait,omitempty"`
+	// ByteQuota limits the bytes uploaded and downloaded per day or month, see ByteQuota.
+	ByteQuota *ByteQuota `json:"byteQuota,omitempty"`
+	// MaxUploadSize is the largest request body in bytes the credential can upload, eg: `104857600` for 100 MiB.
+	MaxUploadSize int64 `json:"maxUploadSize,omitempty"`
+	// RequestQuota limits the number of requests per day or month, see RequestQuota.
+	RequestQuota *RequestQuota `json:"requestQuota,omitempty"`
+	// WriteOnce rejects the deletes and the overwrites of every object of the credential, see Config.WriteOncePrefixes.
+	WriteOnce bool `json:"writeOnce,omitempty"`
+	// Tenant confines the credential to the key prefix of this tenant id, see TenancyConfig.
+	Tenant string `json:"tenant,omitempty"`
+
+	notAfter     time.Time
+	windows      []accessWindow
+	inFlightWait time.Duration
+	// parent is the access key id that issued a temporary credential.
+	parent string
+	scope  accessScope
 }
 
 func CreateConfig() *Config {
 	return &Config{
-		HeaderName: "Authorization",
-		StatusCode: http.StatusForbidden,
+		HeaderName:      "Authorization",
+		StatusCode:      http.StatusForbidden,
+		HygieneInterval: "1h",
 	}
 }
 
+type contextKey string
+
+// RolesContextKey holds the roles ([]string) of the validated credential in the request context.
+const RolesContextKey contextKey = "s3auth.roles"
+
+// OperationContextKey holds the inferred S3 operation (string) of the request in the request context, eg: `PutObject`.
+const OperationContextKey contextKey = "s3auth.operation"
+
+// IdentityContextKey holds the Identity (*Identity) of validated requests in the request context.
+const IdentityContextKey contextKey = "s3auth.identity"
+
+// Identity is the validated credential, never including its secret, and what the request does.
+type Identity struct {
+	AccessKeyID string
+	// Parent is the access key id of the parent of temporary credentials.
+	Parent  string
+	Region  string
+	Service string
+	Tenant  string
+	Roles   []string
+	Groups  []string
+	Tags    map[string]string
+	// Action is the IAM action of the request, eg: `s3:GetObject`, and Operation its S3 operation, eg: `GetObject`.
+	Action    string
+	Operation string
+	Bucket    string
+	Key       string
+}
+
 type Plugin struct {
-	next        http.Handler
-	headerName  string
-	statusCode  int
-	credentials []*Credential
-	Now         func() time.Time
+	next           http.Handler
+	headerName     string
+	statusCode     int
+	store          *credentialStore
+	sts            *stsIssuer
+	bodies         *bodyBuffer
+	iam            *iamVerifier
+	rolesHeader    string
+	identityHeader string
+	tenantHeader   string
+	clientUsername bool
+	accessLog      string
+	normalize      bool
+	injectHeaders  []headerTemplate
+	decision       *decisionSigner
+	requestIDs     bool
+	correlation    string
+	stripAuth      bool
+	originalAuth   string
+	backendHost    string
+	buckets        map[string]bucketMapping
+	upstream       *upstream
+	unsigned       *unsignedClients
+	cors           *cors
+	audit          *auditor
+	upgradePolicy  string
+	errorFormat    string
+	negotiate      bool
+	statusCodes    map[string]int
+	challenge      bool
+	debugSigning   bool
+	collapseKeys   bool
+	debugErrors    bool
+	errorTemplate  *errorTemplate
+	groups         []string
+	domains        []string
+	depth          int
+	denylist       *denylist
+	decisionLog    *decisionLog
+	cloudTrail     bool
+	failureLog     *failureLog
+	throttle       *failureThrottle
+	alerts         *alerts
+	metering       *metering
+	requests       counters
+	opa            *opaClient
+	cedar          []cedarPolicy
+	authWebhook    *authWebhook
+	tenancy        *tenancy
+	writeOnce      *writeOnce
+	objectLock     []*ObjectLockRule
+	geo            *geo
+	defaultPolicy  *Policy
+	defaultDeny    bool
+	publicPrefixes []string
+	grants         []*Grant
+	readOnly       bool
+	requireTLS     bool
+	admin          *adminServer
+	operations     *operationCounter
+	metrics        *metrics
+	name           string
+	hygiene        hygiene
+	Now            func() time.Time
 }
 
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	fmt.Printf("Creating plugin: %s instance: %+v, ctx: %+v\n", name, *config, ctx)
+	if err := configureLogs(config.Log); err != nil {
+		return nil, err
+	}
+	// Never log the configuration, it holds the secrets of the credentials.
+	logs.debug("creating the middleware", "middleware", name)
 
-	// Check for empty credentials.
-	if len(config.Credentials) == 0 {
-		return nil, errors.New("must specify at least one valid credential")
+	// Check the authorization header is not empty.
+	if config.HeaderName == "" {
+		return nil, errors.New("must specify the authorization header name")
+	}
+	if err := checkPassThrough(config); err != nil {
+		return nil, err
+	}
+	if err := checkNormalize(config); err != nil {
+		return nil, err
+	}
+	hy, err := newHygiene(config)
+	if err != nil {
+		return nil, err
+	}
+	store, err := sharedStore(ctx, config, name)
+	if err != nil {
+		return nil, err
 	}
-	for _, cred := range config.Credentials {
-		if cred.AccessKeyID == "" || cred.AccessSecretKey == "" {
-			return nil, errors.New("must specify both `keyId` and `secretKey` for each credential")
+	bodies, err := newBodyBuffer(config)
+	if err != nil {
+		return nil, err
+	}
+	sts, err := newSTSIssuer(config.STS)
+	if err != nil {
+		return nil, err
+	}
+	iam, err := newIAMVerifier(config.IAM)
+	if err != nil {
+		return nil, err
+	}
+	denylist, err := sharedDenylist(ctx, config.Denylist, name)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := newCounters(config.Redis, name)
+	if err != nil {
+		return nil, err
+	}
+	throttle, err := newFailureThrottle(config.FailureThrottle, requests)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := newAlerts(config.Alerts, name)
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := metricsOf(name, config.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	metering, err := sharedMetering(config.Metering, name)
+	if err != nil {
+		return nil, err
+	}
+	decisionLog, err := sharedDecisionLog(config.DecisionLog)
+	if err != nil {
+		return nil, err
+	}
+	switch config.DecisionLogFormat {
+	case "", decisionLogFormatJSON:
+	case decisionLogFormatCloudTrail:
+		if decisionLog == nil {
+			return nil, errors.New("the `cloudtrail` decision log format needs a `decisionLog`")
 		}
-		if cred.Region == "" {
-			return nil, errors.New("must specify the region for each credential, eg: `us-east-1`")
+	default:
+		return nil, fmt.Errorf("unsupported `decisionLogFormat`: %q, must be `json` or `cloudtrail`", config.DecisionLogFormat)
+	}
+	failureLog, err := sharedFailureLog(config.FailureLog)
+	if err != nil {
+		return nil, err
+	}
+	if decisionLog != nil || config.AccessLogHeaderPrefix != "" {
+		next = decisionLogNext{next: next}
+	}
+	opa, err := newOPAClient(config.OPA)
+	if err != nil {
+		return nil, err
+	}
+	cedar, err := loadCedar(config.Cedar)
+	if err != nil {
+		return nil, err
+	}
+	authWebhook, err := newAuthWebhook(config.AuthWebhook)
+	if err != nil {
+		return nil, err
+	}
+	tenancy, err := newTenancy(config.Tenancy)
+	if err != nil {
+		return nil, err
+	}
+	wormPrefixes, err := normalizeObjectPrefixes(config.WriteOncePrefixes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid `writeOncePrefixes`: %w", err)
+	}
+	if err := checkObjectLockRules(config.ObjectLockRules); err != nil {
+		return nil, fmt.Errorf("invalid `objectLockRules`: %w", err)
+	}
+	geo, err := newGeo(config.Geo)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPolicy(config.DefaultPolicy); err != nil {
+		return nil, fmt.Errorf("invalid `defaultPolicy`: %w", err)
+	}
+	publicPrefixes, err := normalizeObjectPrefixes(config.PublicReadPrefixes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid `publicReadPrefixes`: %w", err)
+	}
+	injectHeaders, err := compileHeaderTemplates(config.InjectHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("invalid `injectHeaders`: %w", err)
+	}
+	decision, err := newDecisionSigner(config.DecisionHeader)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBackendHost(config.BackendHost); err != nil {
+		return nil, fmt.Errorf("invalid `backendHost`: %w", err)
+	}
+	buckets, err := compileBucketMappings(config.BucketMappings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid `bucketMappings`: %w", err)
+	}
+	upstream, err := newUpstream(config.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	unsigned, err := newUnsignedClients(config.UnsignedClients, upstream)
+	if err != nil {
+		return nil, err
+	}
+	cors, err := newCORS(config.CORS)
+	if err != nil {
+		return nil, err
+	}
+	audit, err := newAuditor(config.Audit)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUpgradePolicy(config.UpgradePolicy); err != nil {
+		return nil, err
+	}
+	if err := checkErrorFormat(config.ErrorFormat); err != nil {
+		return nil, err
+	}
+	if err := checkStatusCodes(config.StatusCodes); err != nil {
+		return nil, err
+	}
+	if config.DebugErrors {
+		logs.warn("the middleware returns the canonical requests of signature mismatches, only use `debugErrors` in staging", "middleware", name)
+	}
+	errorTemplate, err := newErrorTemplate(config)
+	if err != nil {
+		return nil, err
+	}
+	for i, g := range config.Grants {
+		if err := checkGrant(g); err != nil {
+			return nil, fmt.Errorf("invalid grant %d: %w", i, err)
 		}
-		if cred.Service == "" {
-			return nil, errors.New("must specify the service for each credential, eg: `s3`")
+	}
+	p := &Plugin{
+		next:           next,
+		store:          store,
+		sts:            sts,
+		bodies:         bodies,
+		iam:            iam,
+		rolesHeader:    config.RolesHeader,
+		identityHeader: config.IdentityHeader,
+		tenantHeader:   config.TenantHeader,
+		clientUsername: config.ClientUsername,
+		accessLog:      config.AccessLogHeaderPrefix,
+		normalize:      config.NormalizeRequests,
+		injectHeaders:  injectHeaders,
+		decision:       decision,
+		requestIDs:     config.RequestIDs,
+		correlation:    http.CanonicalHeaderKey(config.RequestIDHeader),
+		stripAuth:      config.StripAuthHeaders,
+		originalAuth:   config.OriginalAuthHeader,
+		backendHost:    config.BackendHost,
+		buckets:        buckets,
+		upstream:       upstream,
+		unsigned:       unsigned,
+		cors:           cors,
+		audit:          audit,
+		upgradePolicy:  config.UpgradePolicy,
+		errorFormat:    config.ErrorFormat,
+		negotiate:      config.NegotiateErrorFormat,
+		statusCodes:    config.StatusCodes,
+		challenge:      config.Challenge,
+		debugSigning:   config.DebugSignatures,
+		collapseKeys:   config.CollapseKeyErrors,
+		debugErrors:    config.DebugErrors,
+		errorTemplate:  errorTemplate,
+		groups:         config.Groups,
+		domains:        config.VirtualHostDomains,
+		depth:          config.ForwardedForDepth,
+		denylist:       denylist,
+		decisionLog:    decisionLog,
+		cloudTrail:     config.DecisionLogFormat == decisionLogFormatCloudTrail,
+		failureLog:     failureLog,
+		throttle:       throttle,
+		alerts:         alerts,
+		metering:       metering,
+		requests:       requests,
+		opa:            opa,
+		cedar:          cedar,
+		authWebhook:    authWebhook,
+		tenancy:        tenancy,
+		writeOnce:      &writeOnce{store: requests, prefixes: wormPrefixes},
+		objectLock:     config.ObjectLockRules,
+		geo:            geo,
+		defaultPolicy:  config.DefaultPolicy,
+		defaultDeny:    config.DefaultDeny,
+		publicPrefixes: publicPrefixes,
+		grants:         config.Grants,
+		operations:     newOperationCounter(),
+		metrics:        metrics,
+		name:           name,
+		headerName:     config.HeaderName,
+		statusCode:     config.StatusCode,
+		readOnly:       config.ReadOnly,
+		requireTLS:     config.RequireTLS,
+		hygiene:        hy,
+		Now:            time.Now,
+	}
+	if hy.enabled() {
+		p.hygiene.started = p.Now()
+	}
+	if config.AdminToken != "" && len(config.AdminToken) < 32 {
+		return nil, errors.New("the `adminToken` must be at least 32 characters long")
+	}
+	if config.AdminAddress != "" {
+		if p.admin, err = registerAdmin(config.AdminAddress, config.AdminToken, name, p); err != nil {
+			return nil, fmt.Errorf("failed to start admin server: %w", err)
 		}
 	}
-	// Check the authorization header is not empty.
-	if config.HeaderName == "" {
-		return nil, errors.New("must specify the authorization header name")
+	// Only once nothing can fail anymore, so a rejected configuration doesn't leak the goroutine.
+	if hy.enabled() {
+		p.warnHygiene(p.Now())
+		go p.watchHygiene(ctx)
+	}
+	return p, nil
+}
+
+func checkCredential(cred *Credential) error {
+	if cred.AccessKeyID == "" || cred.AccessSecretKey == "" {
+		return errors.New("must specify both `keyId` and `secretKey` for each credential")
 	}
-	return &Plugin{
-		next:        next,
-		credentials: config.Credentials,
-		headerName:  config.HeaderName,
-		statusCode:  config.StatusCode,
-		Now:         time.Now,
-	}, nil
+	if cred.Region == "" {
+		return errors.New("must specify the region for each credential, eg: `us-east-1`")
+	}
+	if cred.Service == "" {
+		return errors.New("must specify the service for each credential, eg: `s3`")
+	}
+	if cred.NotAfter != "" {
+		t, err := time.Parse(time.RFC3339, cred.NotAfter)
+		if err != nil {
+			return fmt.Errorf("invalid `notAfter` for access key id %q: %w", cred.AccessKeyID, err)
+		}
+		cred.notAfter = t
+	}
+	prefixes, err := normalizePrefixes(cred.AllowedPrefixes)
+	if err != nil {
+		return fmt.Errorf("invalid `allowedPrefixes` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	methods, err := normalizeMethods(cred.AllowedMethods)
+	if err != nil {
+		return fmt.Errorf("invalid `allowedMethods` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	buckets, err := normalizeBuckets(cred.AllowedBuckets)
+	if err != nil {
+		return fmt.Errorf("invalid `allowedBuckets` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	cidrs, err := normalizeCIDRs(cred.AllowedCIDRs)
+	if err != nil {
+		return fmt.Errorf("invalid `allowedCidrs` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	if err := checkPolicy(cred.Policy); err != nil {
+		return fmt.Errorf("invalid `policy` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	if cred.windows, err = compileWindows(cred.AccessWindows); err != nil {
+		return fmt.Errorf("invalid `accessWindows` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	if cred.MaxInFlight < 0 {
+		return fmt.Errorf("invalid `maxInFlight` for access key id %q: must not be negative", cred.AccessKeyID)
+	}
+	if err := checkQuota(cred.ByteQuota); err != nil {
+		return fmt.Errorf("invalid `byteQuota` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	if err := checkTenant(cred.Tenant); err != nil {
+		return fmt.Errorf("invalid `tenant` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	if cred.MaxUploadSize < 0 {
+		return fmt.Errorf("invalid `maxUploadSize` for access key id %q: must not be negative", cred.AccessKeyID)
+	}
+	if err := checkRequestQuota(cred.RequestQuota); err != nil {
+		return fmt.Errorf("invalid `requestQuota` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	if cred.InFlightWait != "" {
+		if cred.inFlightWait, err = time.ParseDuration(cred.InFlightWait); err != nil {
+			return fmt.Errorf("invalid `inFlightWait` for access key id %q: %w", cred.AccessKeyID, err)
+		}
+	}
+	keys, err := normalizeKeys(cred.AllowedKeys)
+	if err != nil {
+		return fmt.Errorf("invalid `allowedKeys` for access key id %q: %w", cred.AccessKeyID, err)
+	}
+	cred.scope = accessScope{Prefixes: prefixes, Methods: methods, Buckets: buckets, CIDRs: cidrs, Keys: keys, Delimiter: cred.RequireListDelimiter}
+	return nil
 }
 
 func (p *Plugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	if err := validateHeader(req, p.headerName, p.credentials, p.Now()); err != nil {
-		fmt.Printf("%q header validation failed: %v\n", p.headerName, err)
-		http.Error(rw, http.StatusText(p.statusCode), p.statusCode)
+	now := p.Now()
+	if p.errorTemplate != nil {
+		req = req.WithContext(context.WithValue(req.Context(), errorTemplateContextKey, p.errorTemplate))
+	} else if format := p.errorFormatOf(req); format != "" && format != errorFormatXML {
+		req = req.WithContext(context.WithValue(req.Context(), errorFormatContextKey, format))
+	}
+	if origin := req.Header.Get("Origin"); p.cors != nil && p.cors.allowed(origin) {
+		rw = &corsWriter{ResponseWriter: rw, cors: p.cors, origin: origin}
+	}
+	if p.requestIDs || p.correlation != "" {
+		w := &requestIDWriter{ResponseWriter: rw, correlationHeader: p.correlation}
+		if p.requestIDs {
+			w.id, w.hostID = newRequestIDs()
+			req.Header.Set(requestIDHeader, w.id)
+			req.Header.Set(hostIDHeader, w.hostID)
+			ctx := context.WithValue(req.Context(), RequestIDContextKey, w.id)
+			req = req.WithContext(context.WithValue(ctx, hostIDContextKey, w.hostID))
+		}
+		if p.correlation != "" {
+			// Ids the client sent that can't be logged safely are replaced, preferably by the S3 request id.
+			if w.correlationID = req.Header.Get(p.correlation); !validCorrelationID(w.correlationID) {
+				if w.correlationID = w.id; w.correlationID == "" {
+					w.correlationID, _ = newRequestIDs()
+				}
+			}
+			req.Header.Set(p.correlation, w.correlationID)
+			req = req.WithContext(context.WithValue(req.Context(), CorrelationIDContextKey, w.correlationID))
+		}
+		rw = w
+	}
+	// Never trust the identity headers sent by the client.
+	for _, h := range []string{p.rolesHeader, p.identityHeader, p.tenantHeader, p.originalAuth} {
+		if h != "" {
+			req.Header.Del(h)
+		}
+	}
+	for _, h := range p.injectHeaders {
+		req.Header.Del(h.name)
+	}
+	if p.decision != nil {
+		req.Header.Del(p.decision.name)
+	}
+	if p.accessLog != "" {
+		for _, f := range accessLogFields {
+			req.Header.Del(p.accessLog + f)
+		}
+	}
+	if p.clientUsername {
+		req.URL.User = nil
+	}
+	ip := clientIP(req, p.depth)
+	var entry *decisionLogEntry
+	if p.decisionLog != nil || p.accessLog != "" {
+		entry = &decisionLogEntry{Time: now.UTC(), Method: req.Method, Path: req.URL.Path, SourceIP: ip, started: time.Now()}
+		entry.RequestID, _ = req.Context().Value(RequestIDContextKey).(string)
+		entry.CorrelationID = correlationID(req)
+		if p.cloudTrail {
+			entry.event = newCloudTrailEvent(req, p.headerName, p.domains)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), decisionLogContextKey, entry))
+		header := req.Header
+		defer func() {
+			if p.decisionLog != nil {
+				p.decisionLog.write(entry)
+			}
+			if p.accessLog != "" {
+				setAccessLogHeaders(header, p.accessLog, entry)
+			}
+		}()
+	}
+	if p.denylist != nil && p.denylist.denied(ip) {
+		p.log(req).info("access denied", "sourceIp", ip, "reason", "denylisted")
+		writeS3Error(rw, req, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+	if p.requireTLS && !secureTransport(req) {
+		p.log(req).info("access denied", "sourceIp", ip, "reason", "the request didn't use tls")
+		writeS3Error(rw, req, http.StatusForbidden, "AccessDenied", "Requests must use TLS.")
+		return
+	}
+	if p.upgradePolicy != "" && p.upgradePolicy != upgradeValidate && isUpgrade(req) {
+		switch signed := req.Header.Get(p.headerName) != ""; {
+		case p.upgradePolicy == upgradeReject:
+			p.log(req).info("rejected the upgrade request", "sourceIp", ip, "upgrade", req.Header.Get("Upgrade"))
+			writeS3Error(rw, req, http.StatusBadRequest, "InvalidRequest", "Upgrade requests are not supported.")
+			return
+		case p.upgradePolicy == upgradeBypass, !signed:
+			p.stripIAMRequest(req)
+			p.next.ServeHTTP(rw, req)
+			return
+		}
+	}
+	if p.cors != nil && preflight(req) {
+		p.cors.answer(rw, req)
+		return
+	}
+	if p.normalize && !p.signed(req) {
+		// Anonymous requests aren't validated, so they are normalized before checking the public read prefixes.
+		normalizeRequest(req)
+	}
+	if op, ok := p.publicRead(req); ok {
+		if entry != nil {
+			entry.Action, entry.Reason = op.Action, "public"
+		}
+		if !p.toBackend(rw, req, op, now) {
+			return
+		}
+		p.operations.record(op)
+		p.next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), OperationContextKey, op.Name)))
+		return
+	}
+
+	if p.throttle != nil {
+		if wait, blocked := p.throttle.blocked(ip, now); blocked {
+			p.log(req).warn("throttled", "sourceIp", ip, "reason", "too many failed authentications")
+			setRetryAfter(rw, wait)
+			writeS3Error(rw, req, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.")
+			return
+		}
+	}
+
+	var cred *Credential
+	var err error
+	started := time.Now()
+	if p.iam != nil && req.Header.Get(p.iam.header) != "" {
+		cred, err = p.iam.verify(req, p.headerName, now)
+	} else if p.unsigned != nil && p.unsigned.covers(req, p.headerName, ip) {
+		cred, err = p.unsigned.credential(p.store, now)
+	} else {
+		cred, err = validateHeader(req, p.headerName, p.store, p.sts, p.bodies, now)
+	}
+	p.stripIAMRequest(req)
+	if b, ok := req.Body.(*spilledBody); ok {
+		// Removes the temporary file even when the request is denied or the body is never read.
+		defer b.Close()
+	}
+	if err == nil && !inGroups(cred, p.groups) {
+		err = fmt.Errorf("access key id %q is not in any of the groups %q", cred.AccessKeyID, p.groups)
+	}
+	if err != nil {
+		reason := failureReason(err)
+		p.log(req).sampled(failureSample(ip, reason)).info("validation failed", "header", p.headerName, "sourceIp", ip, "reason", err)
+		var se *signatureError
+		if errors.As(err, &se) && p.debugSigning {
+			p.log(req).info("signature mismatch", "accessKeyId", se.accessKeyID, "canonicalRequest", se.canonicalRequest, "stringToSign", se.stringToSign)
+		}
+		if se != nil {
+			p.logCanonicalDiff(req, se)
+		}
+		if p.collapseKeys && (errors.Is(err, errInvalidAccessKeyID) || errors.Is(err, errKeyRegion)) {
+			err = fmt.Errorf("%w: %v", errSignatureMismatch, err)
+		}
+		class, status, e := authError(err)
+		keyID := ""
+		if se != nil {
+			keyID = se.accessKeyID
+		}
+		p.metrics.validated(keyID, reason, time.Since(started), traceID(req))
+		// The access key id the client claimed, even when it is unknown.
+		claimed := ""
+		if a, err := parseHeader(req.Header.Get(p.headerName)); err == nil {
+			claimed = a.AccessKeyID
+		}
+		if entry != nil {
+			entry.AccessKeyID = claimed
+		}
+		if p.debugErrors && se != nil {
+			e.AWSAccessKeyID, e.CanonicalRequest, e.StringToSign, e.DivergedComponent = se.accessKeyID, se.canonicalRequest, se.stringToSign, se.diverged
+		}
+		switch s, ok := p.statusCodes[class]; {
+		case ok:
+			status = s
+		case p.challenge && errors.Is(err, errMissingAuthorization):
+			status = http.StatusUnauthorized
+			rw.Header().Set("WWW-Authenticate", authChallenge)
+		case status == http.StatusForbidden:
+			status = p.statusCode
+		}
+		// Requests without a signature or failing on the side of the middleware can't guess anything.
+		if reason != reasonMissing && reason != reasonUnavailable {
+			if p.alerts != nil {
+				p.alerts.failed(claimed, ip, reason, now)
+			}
+			if p.throttle != nil {
+				p.throttle.fail(req.Context(), ip, now)
+			}
+			if p.failureLog != nil {
+				p.failureLog.write(now, ip, reason, claimed, p.name)
+			}
+		}
+		writeError(rw, req, status, e)
 		return
 	}
+	user := cred.AccessKeyID
+	if cred.parent != "" {
+		user = cred.parent
+	}
+	p.metrics.validated(user, "", time.Since(started), traceID(req))
+	p.log(req).sampled(sampleSuccess).debug("validated", "accessKeyId", cred.AccessKeyID, "sourceIp", ip)
+	err = cred.scope.checkSource(ip)
+	if err == nil && !inWindows(cred.windows, now) {
+		err = errors.New("outside of the access windows")
+	}
+	if err != nil {
+		p.log(req).info("access denied", "accessKeyId", cred.AccessKeyID, "sourceIp", ip, "reason", err)
+		writeS3Error(rw, req, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+	if p.normalize {
+		normalizeRequest(req)
+	}
+	p.store.usage.record(user, now, ip)
+	if entry != nil {
+		entry.AccessKeyID, entry.Tenant, entry.user = cred.AccessKeyID, cred.Tenant, user
+	}
+	if p.sts != nil && req.URL.Path == p.sts.path {
+		entry.decide("allow", "sts", 0)
+		p.sts.serve(rw, req, cred, now)
+		return
+	}
+	if mutating(req.Method) && p.readOnlyMode() {
+		p.log(req).info("rejected", "accessKeyId", cred.AccessKeyID, "method", req.Method, "reason", "read-only maintenance mode")
+		writeS3Error(rw, req, http.StatusServiceUnavailable, "ServiceUnavailable", "The service is in read-only maintenance mode.")
+		return
+	}
+	res := resolveResource(req, p.domains)
+	op := classify(req, res)
+	if entry != nil {
+		entry.Action = op.Action
+	}
+	err = cred.scope.check(req, res, op)
+	verdict := "signature"
+	if err == nil {
+		var d policyDecision
+		d, err = evaluatePolicies(p.defaultPolicy, cred.Policy, p.requireAllow(), policyRequest{action: op.Action, resource: res.arn(), subresources: subresources(req), header: req.Header, tags: cred.Tags, country: p.geo.country(req)})
+		if d.index >= 0 {
+			verdict = d.policy + ":" + strconv.Itoa(d.index)
+		}
+		// Only an explicit deny decides with a statement.
+		if err != nil && d.index < 0 {
+			if g := p.grant(req, cred, op, res, now); g != nil {
+				p.log(req).info("allowed by grant", "accessKeyId", cred.AccessKeyID, "operation", op.Name, "grant", g.ID)
+				err = nil
+				verdict = "grant:" + g.ID
+			}
+		}
+	}
+	if err == nil {
+		// Copies also read their source.
+		var d policyDecision
+		if d, err = p.authorizeCopySource(req, cred, now); err != nil && d.index >= 0 {
+			verdict = d.policy + ":" + strconv.Itoa(d.index)
+		}
+	}
+	if err == nil && p.cedar != nil {
+		err = evaluateCedar(p.cedar, cedarRequest{cred: cred, action: op.Action, res: res})
+	}
+	if err == nil && p.opa != nil {
+		err = p.opa.decide(req.Context(), newOPAInput(req, cred, op, res, ip, p.credentialHeaders()), now)
+	}
+	if err == nil && p.authWebhook != nil {
+		err = p.authWebhook.decide(req.Context(), newOPAInput(req, cred, op, res, ip, p.credentialHeaders()), now)
+	}
+	if err == nil && p.tenancy != nil && cred.Tenant != "" {
+		err = p.tenancy.apply(req, res, op, cred.Tenant)
+	}
+	if errors.Is(err, errDecisionUnavailable) {
+		p.log(req).error("authorization unavailable", "accessKeyId", cred.AccessKeyID, "operation", op.Name, "reason", err)
+		writeS3Error(rw, req, http.StatusServiceUnavailable, "ServiceUnavailable", "The authorization service is unavailable.")
+		return
+	}
+	if err != nil {
+		p.log(req).info("access denied", "accessKeyId", cred.AccessKeyID, "operation", op.Name, "reason", err)
+		p.metrics.denied(reasonPolicyDeny)
+		status := http.StatusForbidden
+		if s, ok := p.statusCodes[failurePolicyDeny]; ok {
+			status = s
+		}
+		writeS3Error(rw, req, status, "AccessDenied", "Access Denied")
+		return
+	}
+	// The tenancy may have rewritten the key.
+	stored := resolveResource(req, p.domains)
+	var reserved, written bool
+	if p.writeOnce.covers(cred, stored) {
+		if reserved, err = p.writeOnce.check(op, stored); errors.Is(err, errWriteOnce) {
+			p.log(req).info("access denied", "accessKeyId", user, "reason", err)
+			writeS3Error(rw, req, http.StatusForbidden, "AccessDenied", "The object is write-once.")
+			return
+		} else if err != nil {
+			p.log(req).error("failed to check the write-once object", "object", stored.Bucket+"/"+stored.Key, "error", err)
+			writeS3Error(rw, req, http.StatusServiceUnavailable, "ServiceUnavailable", "The write-once store is unavailable.")
+			return
+		}
+	}
+	if reserved {
+		// Also when the request is rejected before reaching the backend.
+		defer func() {
+			if !written {
+				p.writeOnce.release(stored)
+			}
+		}()
+	}
+	if err := checkObjectLock(p.objectLock, req, stored, op, now); err != nil {
+		p.log(req).info("rejected the upload", "accessKeyId", user, "reason", err)
+		writeS3Error(rw, req, http.StatusBadRequest, "InvalidRequest", "The upload must set a valid object lock retention.")
+		return
+	}
+	if isAWSChunked(req) {
+		n, err := decodedLength(req)
+		if err != nil {
+			p.log(req).info("rejected the upload", "accessKeyId", user, "reason", err)
+			writeS3Error(rw, req, http.StatusLengthRequired, "MissingContentLength", "You must provide the x-amz-decoded-content-length HTTP header.")
+			return
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = &chunkedBody{ReadCloser: req.Body, declared: n}
+		}
+	}
+	if cred.MaxUploadSize > 0 {
+		if err := checkUploadSize(req, cred.MaxUploadSize); err != nil {
+			p.log(req).info("access denied", "accessKeyId", user, "reason", err)
+			writeS3Error(rw, req, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.")
+			return
+		}
+		// The chunked body already fails past its decoded length, which is within the limit.
+		if req.Body != nil && req.Body != http.NoBody && !isAWSChunked(req) {
+			req.Body = &limitedBody{ReadCloser: req.Body, left: cred.MaxUploadSize}
+		}
+	}
+	if cred.MaxInFlight > 0 {
+		release, ok := p.store.inFlight.acquire(req.Context(), user, cred.MaxInFlight, cred.inFlightWait)
+		if !ok {
+			p.log(req).warn("too many in-flight requests", "accessKeyId", user, "limit", cred.MaxInFlight)
+			setRetryAfter(rw, time.Second)
+			writeS3Error(rw, req, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.")
+			return
+		}
+		defer release()
+	}
+	if q := cred.RequestQuota; q != nil {
+		period, ttl := q.window(now)
+		if n, err := p.requests.incr(requestsKey(user, period), ttl); err != nil {
+			// Quotas fail open, an unreachable Redis must not take the gateway down.
+			p.log(req).error("failed to count the request", "accessKeyId", user, "error", err)
+		} else if n > q.Limit {
+			p.log(req).info("access denied", "accessKeyId", user, "reason", "request quota exceeded", "limit", q.Limit)
+			setRetryAfter(rw, periodEnd(q.Period, now).Sub(now))
+			writeS3Error(rw, req, http.StatusForbidden, "QuotaExceeded", "The request quota of the access key is exceeded.")
+			return
+		}
+	}
+	if q := cred.ByteQuota; q != nil {
+		m := &meter{quota: q, usage: p.store.quotas, user: user, period: q.period(now), reject: q.Mode != quotaFlag}
+		if err := m.admit(req); err != nil {
+			if m.reject {
+				p.log(req).info("access denied", "accessKeyId", user, "reason", err)
+				setRetryAfter(rw, periodEnd(q.Period, now).Sub(now))
+				writeS3Error(rw, req, http.StatusForbidden, "QuotaExceeded", "The byte quota of the access key is exceeded.")
+				return
+			}
+			p.log(req).warn("over the byte quota", "accessKeyId", user, "reason", err)
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = &meteredBody{ReadCloser: req.Body, m: m}
+		}
+		rw = &meteredWriter{ResponseWriter: rw, m: m}
+	}
+	if p.metering != nil {
+		m := &usageMeter{metering: p.metering, user: user, tenant: cred.Tenant, started: now}
+		p.metering.add(user, cred.Tenant, 1, 0, 0, now)
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = &usageBody{ReadCloser: req.Body, m: m}
+		}
+		rw = &usageWriter{ResponseWriter: rw, m: m}
+	}
+	p.operations.record(op)
+	ctx := context.WithValue(req.Context(), OperationContextKey, op.Name)
+	ctx = context.WithValue(ctx, IdentityContextKey, &Identity{
+		AccessKeyID: cred.AccessKeyID, Parent: cred.parent, Region: cred.Region, Service: cred.Service, Tenant: cred.Tenant,
+		Roles: cred.Roles, Groups: cred.Groups, Tags: cred.Tags, Action: op.Action, Operation: op.Name, Bucket: res.Bucket,
+		Key: res.Key,
+	})
+	if len(cred.Roles) > 0 {
+		if p.rolesHeader != "" {
+			req.Header.Set(p.rolesHeader, strings.Join(cred.Roles, ","))
+		}
+		ctx = context.WithValue(ctx, RolesContextKey, cred.Roles)
+	}
+	if p.identityHeader != "" {
+		req.Header.Set(p.identityHeader, user)
+	}
+	if p.clientUsername {
+		// Traefik reads it once the request is served, when no authentication middleware reported a user.
+		req.URL.User = url.User(user)
+	}
+	if p.tenantHeader != "" && cred.Tenant != "" {
+		req.Header.Set(p.tenantHeader, cred.Tenant)
+	}
+	if len(p.injectHeaders) > 0 {
+		injectHeaders(req, p.injectHeaders, headerTemplateData{
+			AccessKeyID: cred.AccessKeyID, Parent: cred.parent, Tenant: cred.Tenant, Roles: cred.Roles, Groups: cred.Groups,
+			Tags: cred.Tags, Operation: op.Name, Bucket: stored.Bucket, Key: stored.Key,
+		})
+	}
+	if entry != nil {
+		entry.Reason = verdict
+	}
+	if p.decision != nil {
+		req.Header.Set(p.decision.name, p.decision.sign(Decision{
+			AccessKeyID: cred.AccessKeyID, User: user, Action: op.Action, Operation: op.Name, Bucket: res.Bucket, Key: res.Key,
+			Verdict: verdict, Time: now.Unix(),
+		}))
+	}
+	req = req.WithContext(ctx)
+	var audit *auditRecord
+	var body *auditedBody
+	if p.audit != nil && p.audit.covers(req.Method) {
+		audit = p.audit.record(req, cred, user, op, res, ip, p.credentialHeaders(), now)
+		if p.audit.maxBody > 0 && req.Body != nil && req.Body != http.NoBody {
+			body = &auditedBody{ReadCloser: req.Body, max: p.audit.maxBody}
+			req.Body = body
+		}
+	}
+	if p.originalAuth != "" {
+		if h := req.Header.Get(p.headerName); h != "" {
+			req.Header.Set(p.originalAuth, h)
+		}
+	}
+	if p.stripAuth {
+		p.stripAuthHeaders(req)
+	}
+	if !p.toBackend(rw, req, op, now) {
+		return
+	}
+
+	if !reserved && audit == nil {
+		p.next.ServeHTTP(rw, req)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: rw}
+	p.next.ServeHTTP(sw, req)
+	written = sw.status == 0 || (sw.status >= 200 && sw.status < 300)
+	if audit != nil {
+		if audit.Status = sw.status; audit.Status == 0 {
+			audit.Status = http.StatusOK
+		}
+		if body != nil {
+			audit.Body, audit.BodyTruncated = body.buf.Bytes(), body.truncated
+		}
+		p.audit.enqueue(audit)
+	}
+}
+
+// checkPassThrough rejects the options modifying the forwarded requests when passing them through untouched.
+func checkPassThrough(config *Config) error {
+	if !config.PassThrough {
+		return nil
+	}
+	options := map[string]bool{
+		"stripAuthHeaders":      config.StripAuthHeaders,
+		"originalAuthHeader":    config.OriginalAuthHeader != "",
+		"upstream":              config.Upstream != nil,
+		"backendHost":           config.BackendHost != "",
+		"bucketMappings":        len(config.BucketMappings) > 0,
+		"tenancy.inject":        config.Tenancy != nil && config.Tenancy.Inject,
+		"rolesHeader":           config.RolesHeader != "",
+		"identityHeader":        config.IdentityHeader != "",
+		"tenantHeader":          config.TenantHeader != "",
+		"accessLogHeaderPrefix": config.AccessLogHeaderPrefix != "",
+		"injectHeaders":         len(config.InjectHeaders) > 0,
+		"decisionHeader":        config.DecisionHeader != nil,
+		"clientUsername":        config.ClientUsername,
+		"normalizeRequests":     config.NormalizeRequests,
+		"requestIds":            config.RequestIDs,
+		"requestIdHeader":       config.RequestIDHeader != "",
+	}
+	var set []string
+	for name, ok := range options {
+		if ok {
+			set = append(set, "`"+name+"`")
+		}
+	}
+	if len(set) > 0 {
+		sort.Strings(set)
+		return fmt.Errorf("`passThrough` can't be combined with %s, they modify the requests", strings.Join(set, ", "))
+	}
+	return nil
+}
+
+// logCanonicalDiff logs the components of the canonical request the client built differently, one line each, or that
+// the canonical requests match, which points at the secret key of the client.
+func (p *Plugin) logCanonicalDiff(req *http.Request, se *signatureError) {
+	if !logs.enabled(levelDebug) {
+		return
+	}
+	if len(se.diff) == 0 {
+		p.log(req).debug("the canonical request matches the one of the client, check its secret key", "accessKeyId", se.accessKeyID)
+		return
+	}
+	for _, f := range se.diff {
+		p.log(req).debug("the canonical request differs", "accessKeyId", se.accessKeyID, "component", f.name, "plugin", f.plugin, "client", f.client)
+	}
+}
+
+// toBackend maps the bucket, rewrites the host and re-signs the validated request for the backend, when configured. It
+// reports whether the request can be forwarded, the error is already written otherwise.
+func (p *Plugin) toBackend(rw http.ResponseWriter, req *http.Request, op s3Operation, now time.Time) bool {
+	if p.buckets != nil {
+		if err := mapBucket(req, resolveResource(req, p.domains), op, p.buckets, p.domains); err != nil {
+			p.log(req).info("access denied", "operation", op.Name, "reason", err)
+			writeS3Error(rw, req, http.StatusForbidden, "AccessDenied", "Access Denied")
+			return false
+		}
+	}
+	if p.backendHost != "" {
+		rewriteHost(req, p.backendHost, p.domains)
+	}
+	if p.upstream == nil {
+		return true
+	}
+	if err := p.upstream.sign(req, now); err != nil {
+		p.log(req).error("failed to re-sign the request for the backend", "error", err)
+		writeS3Error(rw, req, http.StatusNotImplemented, "NotImplemented", "Streaming signed uploads are not supported, use an unsigned payload.")
+		return false
+	}
+	return true
+}
+
+// errorFormatOf returns the format of the errors of the request, the configured one unless negotiated.
+func (p *Plugin) errorFormatOf(req *http.Request) string {
+	if p.negotiate {
+		if f := negotiateErrorFormat(req.Header.Get("Accept")); f != "" {
+			return f
+		}
+	}
+	return p.errorFormat
+}
 
-	p.next.ServeHTTP(rw, req)
+// signingHeaders are only used to validate the signature. `X-Amz-Content-Sha256` is kept since it also describes the
+// payload, eg: the `aws-chunked` encoding of streaming uploads.
+var signingHeaders = []string{"X-Amz-Security-Token", "X-Amz-Date"}
+
+// stripAuthHeaders removes the authorization and signing headers once the request is validated.
+func (p *Plugin) stripAuthHeaders(req *http.Request) {
+	for _, h := range p.credentialHeaders() {
+		req.Header.Del(h)
+	}
+	for _, h := range signingHeaders {
+		req.Header.Del(h)
+	}
+}
+
+// stripIAMRequest removes the `iam` header. The S3 request isn't bound to the signature of the STS request, so whoever
+// sees the header can use it until its verification expires, and it never reaches the authorizers or the backend.
+func (p *Plugin) stripIAMRequest(req *http.Request) {
+	if p.iam != nil {
+		req.Header.Del(p.iam.header)
+	}
+}
+
+// credentialHeaders are the headers carrying the client credentials, ie the authorization and `iam` headers.
+func (p *Plugin) credentialHeaders() []string {
+	if p.iam != nil {
+		return []string{p.headerName, p.iam.header}
+	}
+	return []string{p.headerName}
+}
+
+// CredentialStatus returns the status of every configured credential, never including secrets.
+func (p *Plugin) CredentialStatus() []CredentialStatus {
+	now := p.Now()
+	creds := p.store.list()
+	statuses := make([]CredentialStatus, 0, len(creds))
+	for _, cred := range creds {
+		if !inGroups(cred, p.groups) {
+			continue
+		}
+		u := p.store.usage.get(cred.AccessKeyID)
+		warnings := p.hygiene.check(cred, u, now)
+		var quota *QuotaStatus
+		if q := cred.ByteQuota; q != nil {
+			bu := p.store.quotas.get(cred.AccessKeyID, q.period(now))
+			quota = &QuotaStatus{Period: bu.period, Uploaded: bu.uploaded, Downloaded: bu.downloaded}
+			if q.over(bu) != "" {
+				warnings = append(warnings, "over-quota")
+			}
+		}
+		var requests *RequestQuotaStatus
+		if q := cred.RequestQuota; q != nil {
+			period, _ := q.window(now)
+			requests = &RequestQuotaStatus{Period: period, Limit: q.Limit}
+			var err error
+			if requests.Count, err = p.requests.get(requestsKey(cred.AccessKeyID, period)); err != nil {
+				requests.Error = err.Error()
+			}
+			if requests.Count >= q.Limit && !containsFold(warnings, "over-quota") {
+				warnings = append(warnings, "over-quota")
+			}
+		}
+		statuses = append(statuses, CredentialStatus{
+			AccessKeyID:  cred.AccessKeyID,
+			Region:       cred.Region,
+			Service:      cred.Service,
+			NotAfter:     cred.NotAfter,
+			Roles:        cred.Roles,
+			Groups:       cred.Groups,
+			LastUsed:     u.LastUsed,
+			LastSourceIP: u.SourceIP,
+			Uses:         u.Count,
+			Quota:        quota,
+			Requests:     requests,
+			Warnings:     warnings,
+		})
+	}
+	return statuses
+}
+
+// CacheStatus describes the caches of the middleware by name: `signingKeys`, and the `iam`, `opa` and `authWebhook`
+// ones when they are enabled.
+func (p *Plugin) CacheStatus() map[string]CacheStatus {
+	caches := map[string]CacheStatus{"signingKeys": p.store.signingKeyStatus()}
+	if p.iam != nil {
+		caches["iam"] = p.iam.cacheStatus()
+	}
+	if p.opa != nil {
+		if s := p.opa.cache.status(); s != nil {
+			caches["opa"] = *s
+		}
+	}
+	if p.authWebhook != nil {
+		if s := p.authWebhook.cache.status(); s != nil {
+			caches["authWebhook"] = *s
+		}
+	}
+	return caches
+}
+
+// FlushUsage exports the usage accumulated since the previous export right away, eg: before a shutdown.
+func (p *Plugin) FlushUsage() error {
+	if p.metering == nil {
+		return nil
+	}
+	return p.metering.flush(p.Now())
+}
+
+// inGroups reports whether the credential belongs to one of the groups, always true without groups.
+func inGroups(cred *Credential, groups []string) bool {
+	if len(groups) == 0 {
+		return true
+	}
+	for _, g := range cred.Groups {
+		if containsFold(groups, g) {
+			return true
+		}
+	}
+	return false
 }
@@ -2,9 +2,17 @@ package traefik_plugin_s3_auth_test
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,6 +34,73 @@ func setHeaders(t *testing.T, h http.Header) {
 	h.Set("x-amz-user-agent", "aws-sdk-js/3.675.0 ua/2.1 os/macOS#10.15.7 lang/js md/browser#Electron_33.3.2 api/s3#3.675.0 m/E,e")
 }
 
+// signRequest signs the request with AWS SigV4, covering the host and every `x-amz-*` and `x-s3auth-*` header. Requests without an
+// `x-amz-content-sha256` header get their body hashed, the way SDKs sign non S3 calls.
+func signRequest(t *testing.T, req *http.Request, cred *plugin.Credential, at time.Time) {
+	t.Helper()
+
+	date := at.UTC().Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", date)
+	payload := req.Header.Get("x-amz-content-sha256")
+	if payload == "" {
+		var body []byte
+		if req.GetBody != nil {
+			rc, err := req.GetBody()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, err = io.ReadAll(rc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sum := sha256.Sum256(body)
+		payload = hex.EncodeToString(sum[:])
+	}
+
+	names := []string{"host"}
+	for k := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || strings.HasPrefix(k, "x-s3auth-") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	headers := make([]string, 0, len(names))
+	for _, n := range names {
+		v := req.Host
+		if n != "host" {
+			v = strings.Join(req.Header.Values(n), ", ")
+		}
+		headers = append(headers, n+":"+v)
+	}
+
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	query := make([]string, 0, len(keys))
+	for _, k := range keys {
+		query = append(query, url.QueryEscape(k)+"="+url.QueryEscape(strings.Join(q[k], ",")))
+	}
+
+	canonical := strings.Join([]string{
+		req.Method, req.URL.Path, strings.Join(query, "&"), strings.Join(headers, "\n") + "\n", strings.Join(names, ";"), payload,
+	}, "\n")
+	scope := date[:8] + "/" + cred.Region + "/" + cred.Service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + cred.AccessSecretKey)
+	for _, v := range []string{date[:8], cred.Region, cred.Service, "aws4_request", toSign} {
+		m := hmac.New(sha256.New, key)
+		m.Write([]byte(v))
+		key = m.Sum(nil)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+cred.AccessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(names, ";")+", Signature="+hex.EncodeToString(key))
+}
+
 func TestPlugin(t *testing.T) {
 	tc := []struct {
 		name           string
@@ -64,6 +139,20 @@ func TestPlugin(t *testing.T) {
 			expectedStatus: http.StatusForbidden,
 			expectedError:  "unknown access key id: \"ACCESS_ACCESS_ACCESS\"",
 		},
+		{
+			name: "other region",
+			crds: []*plugin.Credential{
+				{
+					AccessKeyID:     "ACCESS_ACCESS_ACCESS",
+					AccessSecretKey: "SECRET12secret123456SECRET12secret123456",
+					Region:          "eu-central-1",
+					Service:         "s3",
+				},
+			},
+			method:         http.MethodGet,
+			authorization:  validAuthorization,
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name: "invalid header",
 			crds: []*plugin.Credential{
@@ -131,3 +220,1576 @@ func TestPlugin(t *testing.T) {
 		})
 	}
 }
+
+const validAuthorization = "AWS4-HMAC-SHA256 Credential=ACCESS_ACCESS_ACCESS/20250710/us-east-1/s3/aws4_request, SignedHeaders=amz-sdk-invocation-id;amz-sdk-request;content-length;content-type;host;x-amz-content-sha256;x-amz-date;x-amz-meta-ctime;x-amz-meta-mtime;x-amz-user-agent, Signature=1a9426204df8f5e35f275a2cfd5e5bd70b82fe8893fb7a9cb56154aa43c8e81e"
+
+func validCredential() *plugin.Credential {
+	return &plugin.Credential{
+		AccessKeyID:     "ACCESS_ACCESS_ACCESS",
+		AccessSecretKey: "SECRET12secret123456SECRET12secret123456",
+		Region:          "us-east-1",
+		Service:         "s3",
+	}
+}
+
+func newTestPlugin(t *testing.T, cfg *plugin.Config) *plugin.Plugin {
+	t.Helper()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+	return p
+}
+
+func newValidRequest(t *testing.T) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://s3.example.com/foo/bar/?x=y&z=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.10:43210"
+	req.Header.Set("Authorization", validAuthorization)
+	setHeaders(t, req.Header)
+	return req
+}
+
+func TestCredentialStatus(t *testing.T) {
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	p := newTestPlugin(t, cfg)
+
+	// Credential stores are shared process-wide, so other tests may have used the same credential.
+	before := p.CredentialStatus()[0].Uses
+
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, newValidRequest(t))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	s := p.CredentialStatus()
+	if len(s) != 1 {
+		t.Fatalf("expected 1 credential, got %d", len(s))
+	}
+	if s[0].Uses != before+1 {
+		t.Errorf("expected %d uses, got %d", before+1, s[0].Uses)
+	}
+	if want := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC); !s[0].LastUsed.Equal(want) {
+		t.Errorf("expected last used %v, got %v", want, s[0].LastUsed)
+	}
+	if s[0].LastSourceIP != "192.0.2.10" {
+		t.Errorf("expected source ip %q, got %q", "192.0.2.10", s[0].LastSourceIP)
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	cred := validCredential()
+	cred.AccessKeyID = "AKIACACHESTATUS"
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+		signRequest(t, req, cred, p.Now())
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+		}
+	}
+
+	caches := p.CacheStatus()
+	if len(caches) != 1 {
+		t.Errorf("expected only the signing key cache, got %v", caches)
+	}
+	keys := caches["signingKeys"]
+	if keys.Entries != 1 || keys.Misses != 1 || keys.Hits != 2 || keys.HitRate < 0.66 || keys.HitRate > 0.67 {
+		t.Errorf("expected 1 miss and 2 hits of the signing keys, got %+v", keys)
+	}
+}
+
+func TestCredentialHygiene(t *testing.T) {
+	tc := []struct {
+		name             string
+		notAfter         string
+		expiryDays       int
+		unusedDays       int
+		statusAt         time.Time
+		expectedStatus   int
+		expectedWarnings []string
+	}{
+		{
+			name:           "no warnings",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:             "expiring",
+			notAfter:         "2025-07-12T00:00:00Z",
+			expiryDays:       7,
+			expectedStatus:   http.StatusOK,
+			expectedWarnings: []string{"expiring"},
+		},
+		{
+			name:             "expired",
+			notAfter:         "2025-07-01T00:00:00Z",
+			expiryDays:       7,
+			expectedStatus:   http.StatusForbidden,
+			expectedWarnings: []string{"expired"},
+		},
+		{
+			name:             "unused",
+			unusedDays:       30,
+			statusAt:         time.Date(2025, 9, 10, 0, 0, 0, 0, time.UTC),
+			expectedStatus:   http.StatusOK,
+			expectedWarnings: []string{"unused"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.NotAfter = tt.notAfter
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.ExpiryWarningDays = tt.expiryDays
+			cfg.UnusedWarningDays = tt.unusedDays
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			handler, err := plugin.New(ctx, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newValidRequest(t))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+
+			if !tt.statusAt.IsZero() {
+				p.Now = func() time.Time { return tt.statusAt }
+			}
+			s := p.CredentialStatus()
+			if got := s[0].Warnings; strings.Join(got, ",") != strings.Join(tt.expectedWarnings, ",") {
+				t.Errorf("expected warnings %v, got %v", tt.expectedWarnings, got)
+			}
+		})
+	}
+}
+
+func TestSharedCredentialStore(t *testing.T) {
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	a := newTestPlugin(t, cfg)
+
+	other := plugin.CreateConfig()
+	other.Credentials = []*plugin.Credential{validCredential()}
+	b := newTestPlugin(t, other)
+
+	before := b.CredentialStatus()[0].Uses
+	recorder := httptest.NewRecorder()
+	a.ServeHTTP(recorder, newValidRequest(t))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if got := b.CredentialStatus()[0].Uses; got != before+1 {
+		t.Errorf("expected instances to share usage, got %d uses instead of %d", got, before+1)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	tc := []struct {
+		name           string
+		roles          []string
+		expectedHeader string
+	}{
+		{
+			name:           "roles",
+			roles:          []string{"admin", "backups"},
+			expectedHeader: "admin,backups",
+		},
+		{
+			name: "no roles",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Roles = tt.roles
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.RolesHeader = "X-S3Auth-Roles"
+
+			var header string
+			var roles []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				header = req.Header.Get("X-S3Auth-Roles")
+				roles, _ = req.Context().Value(plugin.RolesContextKey).([]string)
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			req := newValidRequest(t)
+			req.Header.Set("X-S3Auth-Roles", "spoofed")
+			p.ServeHTTP(httptest.NewRecorder(), req)
+			if header != tt.expectedHeader {
+				t.Errorf("expected roles header %q, got %q", tt.expectedHeader, header)
+			}
+			if strings.Join(roles, ",") != strings.Join(tt.roles, ",") {
+				t.Errorf("expected roles %v in the context, got %v", tt.roles, roles)
+			}
+		})
+	}
+}
+
+func TestIdentityHeaders(t *testing.T) {
+	tc := []struct {
+		name             string
+		tenant           string
+		expectedIdentity string
+		expectedTenant   string
+	}{
+		{
+			name:             "access key id",
+			expectedIdentity: "ACCESS_ACCESS_ACCESS",
+		},
+		{
+			name:             "with a tenant",
+			tenant:           "acme",
+			expectedIdentity: "ACCESS_ACCESS_ACCESS",
+			expectedTenant:   "acme",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Tenant = tt.tenant
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.IdentityHeader = "X-S3Auth-AccessKeyId"
+			cfg.TenantHeader = "X-S3Auth-Tenant"
+			cfg.ClientUsername = true
+
+			var identity, tenant, username string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				identity, tenant = req.Header.Get("X-S3Auth-AccessKeyId"), req.Header.Get("X-S3Auth-Tenant")
+				username = req.URL.User.Username()
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			req := newValidRequest(t)
+			req.Header.Set("X-S3Auth-AccessKeyId", "spoofed")
+			req.Header.Set("X-S3Auth-Tenant", "spoofed")
+			req.URL.User = url.User("spoofed")
+			p.ServeHTTP(httptest.NewRecorder(), req)
+			if identity != tt.expectedIdentity || tenant != tt.expectedTenant {
+				t.Errorf("expected identity %q and tenant %q, got %q and %q", tt.expectedIdentity, tt.expectedTenant, identity, tenant)
+			}
+			if username != tt.expectedIdentity {
+				t.Errorf("expected the client username %q, got %q", tt.expectedIdentity, username)
+			}
+		})
+	}
+}
+
+func TestAccessLogHeaders(t *testing.T) {
+	cred := validCredential()
+	cred.Tenant = "acme"
+	wrong := validCredential()
+	wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+	tc := []struct {
+		name     string
+		cred     *plugin.Credential
+		expected map[string]string
+	}{
+		{
+			name: "allowed",
+			cred: cred,
+			expected: map[string]string{
+				"X-S3Auth-Access-Key-Id": "ACCESS_ACCESS_ACCESS", "X-S3Auth-Tenant": "acme", "X-S3Auth-Action": "s3:GetObject",
+				"X-S3Auth-Decision": "allow", "X-S3Auth-Reason": "signature",
+			},
+		},
+		{
+			name: "denied",
+			cred: wrong,
+			expected: map[string]string{
+				"X-S3Auth-Access-Key-Id": "ACCESS_ACCESS_ACCESS", "X-S3Auth-Tenant": "", "X-S3Auth-Action": "",
+				"X-S3Auth-Decision": "deny", "X-S3Auth-Reason": "SignatureDoesNotMatch",
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.AccessLogHeaderPrefix = "X-S3Auth-"
+
+			var forwarded string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req.Header.Get("X-S3Auth-Tenant") + req.Header.Get("X-S3Auth-Decision")
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			req := newSignedRequest(t, http.MethodGet, "/bucket/object.txt", tt.cred)
+			req.Header.Set("X-S3Auth-Tenant", "spoofed")
+			p.ServeHTTP(httptest.NewRecorder(), req)
+			// Traefik reads the request headers of the access logs once the request is served.
+			for k, v := range tt.expected {
+				if got := req.Header.Get(k); got != v {
+					t.Errorf("expected %s to be %q, got %q", k, v, got)
+				}
+			}
+			if forwarded != "" {
+				t.Errorf("expected no access log headers forwarded to the backend, got %q", forwarded)
+			}
+		})
+	}
+}
+
+func TestIdentityContext(t *testing.T) {
+	cred := validCredential()
+	cred.Tenant = "acme"
+	cred.Roles = []string{"reader"}
+	cred.Tags = map[string]string{"team": "data"}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+
+	var identity *plugin.Identity
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		identity, _ = req.Context().Value(plugin.IdentityContextKey).(*plugin.Identity)
+	})
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+	p.ServeHTTP(httptest.NewRecorder(), newValidRequest(t))
+	expected := &plugin.Identity{
+		AccessKeyID: "ACCESS_ACCESS_ACCESS", Region: "us-east-1", Service: "s3", Tenant: "acme", Roles: []string{"reader"},
+		Tags: map[string]string{"team": "data"}, Action: "s3:GetObject", Operation: "GetObject", Bucket: "foo", Key: "bar/",
+	}
+	if !reflect.DeepEqual(identity, expected) {
+		t.Errorf("expected the identity %+v, got %+v", expected, identity)
+	}
+}
+
+func TestStripAuthHeaders(t *testing.T) {
+	tc := []struct {
+		name     string
+		strip    bool
+		original string
+		expected []string
+	}{
+		{
+			name:     "kept by default",
+			expected: []string{"Authorization", "X-Amz-Date", "X-Amz-Content-Sha256", "X-Amz-Meta-Ctime"},
+		},
+		{
+			name:     "stripped",
+			strip:    true,
+			expected: []string{"X-Amz-Content-Sha256", "X-Amz-Meta-Ctime"},
+		},
+		{
+			name:     "stripped keeping the original",
+			strip:    true,
+			original: "X-Original-Authorization",
+			expected: []string{"X-Amz-Content-Sha256", "X-Amz-Meta-Ctime"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.StripAuthHeaders = tt.strip
+			cfg.OriginalAuthHeader = tt.original
+
+			var header http.Header
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				header = req.Header.Clone()
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			p.ServeHTTP(httptest.NewRecorder(), newValidRequest(t))
+			if header == nil {
+				t.Fatal("expected the request to be forwarded")
+			}
+			var got []string
+			for _, h := range []string{"Authorization", "X-Amz-Date", "X-Amz-Content-Sha256", "X-Amz-Meta-Ctime"} {
+				if header.Get(h) != "" {
+					got = append(got, h)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("expected the headers %v to be forwarded, got %v", tt.expected, got)
+			}
+			if tt.original != "" && header.Get(tt.original) != validAuthorization {
+				t.Errorf("expected the original authorization in %s, got %q", tt.original, header.Get(tt.original))
+			}
+		})
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	tc := []struct {
+		name        string
+		configure   func(cfg *plugin.Config)
+		expectedErr string
+	}{
+		{
+			name:      "untouched",
+			configure: func(cfg *plugin.Config) {},
+		},
+		{
+			name: "rewriting options",
+			configure: func(cfg *plugin.Config) {
+				cfg.StripAuthHeaders = true
+				cfg.BackendHost = "minio.internal:9000"
+			},
+			expectedErr: "`passThrough` can't be combined with `backendHost`, `stripAuthHeaders`",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.PassThrough = true
+			tt.configure(cfg)
+
+			var forwarded *http.Request
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("expected error %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			req := newValidRequest(t)
+			header, uri, host := req.Header.Clone(), req.URL.RequestURI(), req.Host
+			p.ServeHTTP(httptest.NewRecorder(), req)
+			if forwarded == nil {
+				t.Fatal("expected the request to be forwarded")
+			}
+			if !reflect.DeepEqual(forwarded.Header, header) || forwarded.URL.RequestURI() != uri || forwarded.Host != host {
+				t.Errorf("expected the request to be untouched, got %s%s with %v", forwarded.Host, forwarded.URL.RequestURI(), forwarded.Header)
+			}
+		})
+	}
+}
+
+func TestUpgradePolicy(t *testing.T) {
+	tc := []struct {
+		name           string
+		policy         string
+		signed         bool
+		expectedStatus int
+		expectedNext   bool
+	}{
+		{
+			name:           "validated by default",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "rejected",
+			policy:         "reject",
+			signed:         true,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bypassed",
+			policy:         "bypass",
+			expectedStatus: http.StatusOK,
+			expectedNext:   true,
+		},
+		{
+			name:           "unsigned bypassed",
+			policy:         "validateIfSigned",
+			expectedStatus: http.StatusOK,
+			expectedNext:   true,
+		},
+		{
+			name:           "signed validated",
+			policy:         "validateIfSigned",
+			signed:         true,
+			expectedStatus: http.StatusOK,
+			expectedNext:   true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.UpgradePolicy = tt.policy
+
+			called := false
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				called = true
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+			p.Now = func() time.Time { return now }
+
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/events", nil)
+			req.Header.Set("Connection", "keep-alive, Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			if tt.signed {
+				signRequest(t, req, cred, now)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus || called != tt.expectedNext {
+				t.Errorf("expected status code %d and next called %t, got %d and %t", tt.expectedStatus, tt.expectedNext, recorder.Code, called)
+			}
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	tc := []struct {
+		name           string
+		groups         []string
+		expectedStatus int
+	}{
+		{
+			name:           "no groups",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "member",
+			groups:         []string{"ci", "backups"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "not a member",
+			groups:         []string{"ci"},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Groups = []string{"backups"}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.Groups = tt.groups
+			p := newTestPlugin(t, cfg)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newValidRequest(t))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+// newSignedRequest returns a request signed by cred at the fixed test time.
+func newSignedRequest(t *testing.T, method, path string, cred *plugin.Credential) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), method, "https://s3.example.com"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.10:43210"
+	signRequest(t, req, cred, time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC))
+	return req
+}
+
+func TestAllowedPrefixes(t *testing.T) {
+	tc := []struct {
+		name           string
+		path           string
+		copySource     string
+		expectedStatus int
+	}{
+		{
+			name:           "inside the prefix",
+			path:           "/tenant-a/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "inside the wildcard prefix",
+			path:           "/backups/2025/07/10.tar.gz",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "outside of the prefixes",
+			path:           "/tenant-b/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "escaping with dot segments",
+			path:           "/tenant-a/../tenant-b/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "copy inside the prefixes",
+			path:           "/tenant-a/copy.txt",
+			copySource:     "/backups/2025/07/10.tar.gz",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "copy from outside of the prefixes",
+			path:           "/tenant-a/copy.txt",
+			copySource:     "/tenant-b/secret.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "copy escaping with dot segments",
+			path:           "/tenant-a/copy.txt",
+			copySource:     "/tenant-a/%2E%2E/tenant-b/secret.txt?versionId=1",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	cred := validCredential()
+	cred.AllowedPrefixes = []string{"/tenant-a/", "/backups/*"}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newSignedRequest(t, http.MethodGet, tt.path, cred)
+			if tt.copySource != "" {
+				req = newSignedRequest(t, http.MethodPut, tt.path, cred)
+				req.Header.Set("X-Amz-Copy-Source", tt.copySource)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	tc := []struct {
+		name           string
+		method         string
+		expectedStatus int
+	}{
+		{
+			name:           "get",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "head",
+			method:         http.MethodHead,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "put",
+			method:         http.MethodPut,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "delete",
+			method:         http.MethodDelete,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	cred := validCredential()
+	cred.AllowedMethods = []string{"get", "HEAD"}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, "/bucket/object.txt", cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus == http.StatusForbidden && !strings.Contains(recorder.Body.String(), "<Code>AccessDenied</Code>") {
+				t.Errorf("expected an AccessDenied error, got %s", recorder.Body)
+			}
+		})
+	}
+}
+
+func TestAllowedBuckets(t *testing.T) {
+	tc := []struct {
+		name           string
+		host           string
+		path           string
+		copySource     string
+		expectedStatus int
+	}{
+		{
+			name:           "path-style",
+			host:           "s3.example.com",
+			path:           "/backups/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "path-style glob",
+			host:           "s3.example.com",
+			path:           "/logs-2025/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "path-style other bucket",
+			host:           "s3.example.com",
+			path:           "/private/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "virtual-host-style",
+			host:           "logs-2025.s3.example.com",
+			path:           "/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "virtual-host-style other bucket",
+			host:           "private.s3.example.com",
+			path:           "/backups/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unknown domain is path-style",
+			host:           "private.other.example.com",
+			path:           "/backups/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "copy from an allowed bucket",
+			host:           "s3.example.com",
+			path:           "/backups/copy.txt",
+			copySource:     "/logs-2025/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "copy from another bucket",
+			host:           "s3.example.com",
+			path:           "/backups/copy.txt",
+			copySource:     "/private/secret.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	cred := validCredential()
+	cred.AllowedBuckets = []string{"backups", "logs-*"}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.VirtualHostDomains = []string{"s3.example.com"}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodGet
+			if tt.copySource != "" {
+				method = http.MethodPut
+			}
+			req, err := http.NewRequestWithContext(context.Background(), method, "https://"+tt.host+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.copySource != "" {
+				req.Header.Set("X-Amz-Copy-Source", tt.copySource)
+			}
+			signRequest(t, req, cred, time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC))
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestAllowedCIDRs(t *testing.T) {
+	tc := []struct {
+		name           string
+		depth          int
+		remoteAddr     string
+		forwardedFor   string
+		expectedStatus int
+	}{
+		{
+			name:           "peer inside the range",
+			remoteAddr:     "10.1.2.3:43210",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "single address",
+			remoteAddr:     "192.0.2.10:43210",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "peer outside the range",
+			remoteAddr:     "198.51.100.7:43210",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "forwarded for is ignored without a depth",
+			remoteAddr:     "198.51.100.7:43210",
+			forwardedFor:   "10.1.2.3",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "forwarded for inside the range",
+			depth:          1,
+			remoteAddr:     "172.16.0.1:43210",
+			forwardedFor:   "198.51.100.7, 10.1.2.3",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "spoofed forwarded for",
+			depth:          1,
+			remoteAddr:     "172.16.0.1:43210",
+			forwardedFor:   "10.1.2.3, 198.51.100.7",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "two trusted proxies",
+			depth:          2,
+			remoteAddr:     "172.16.0.1:43210",
+			forwardedFor:   "198.51.100.7, 10.1.2.3, 172.16.0.2",
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.AllowedCIDRs = []string{"10.0.0.0/8", "192.0.2.10"}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.ForwardedForDepth = tt.depth
+			p := newTestPlugin(t, cfg)
+
+			req := newSignedRequest(t, http.MethodGet, "/bucket/object.txt", cred)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestAccessWindows(t *testing.T) {
+	tc := []struct {
+		name           string
+		at             time.Time
+		expectedStatus int
+	}{
+		{
+			name:           "weekday night",
+			at:             time.Date(2025, 7, 10, 23, 0, 0, 0, time.UTC),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "weekday morning after midnight",
+			at:             time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "weekday afternoon",
+			at:             time.Date(2025, 7, 10, 15, 0, 0, 0, time.UTC),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "saturday morning after a friday night",
+			at:             time.Date(2025, 7, 12, 5, 0, 0, 0, time.UTC),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "saturday night",
+			at:             time.Date(2025, 7, 12, 23, 0, 0, 0, time.UTC),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "sunday in the second window",
+			at:             time.Date(2025, 7, 13, 12, 0, 0, 0, time.UTC),
+			expectedStatus: http.StatusOK,
+		},
+	}
+	cred := validCredential()
+	cred.AccessWindows = []*plugin.AccessWindow{
+		{Days: []string{"Mon", "Tue", "Wednesday", "Thu", "Fri"}, Start: "22:00", End: "06:00"},
+		{Days: []string{"sun"}},
+	}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			p.Now = func() time.Time { return tt.at }
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signRequest(t, req, cred, tt.at)
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestAllowedKeys(t *testing.T) {
+	tc := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		copySource     string
+		expectedStatus int
+	}{
+		{
+			name:           "glob",
+			method:         http.MethodPut,
+			path:           "/bucket/logs/2025-07-10.gz",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "glob mismatch",
+			method:         http.MethodPut,
+			path:           "/bucket/logs/1999-07-10.gz",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "glob does not cross slashes",
+			method:         http.MethodPut,
+			path:           "/bucket/logs/2025/07/10.gz",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "regex",
+			method:         http.MethodPut,
+			path:           "/bucket/metrics/2025/07/10.json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "regex mismatch",
+			method:         http.MethodPut,
+			path:           "/bucket/metrics/latest.json",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "bucket requests are not restricted",
+			method:         http.MethodGet,
+			path:           "/bucket",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "delete objects",
+			method:         http.MethodPost,
+			path:           "/bucket?delete",
+			body:           `<Delete><Object><Key>logs/2025-07-10.gz</Key></Object></Delete>`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "delete objects mismatch",
+			method:         http.MethodPost,
+			path:           "/bucket?delete",
+			body:           `<Delete><Object><Key>logs/2025-07-10.gz</Key></Object><Object><Key>secret.txt</Key></Object></Delete>`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "post object",
+			method:         http.MethodPost,
+			path:           "/bucket",
+			body:           "key=logs/2025-07-10.gz",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "copy",
+			method:         http.MethodPut,
+			path:           "/bucket/logs/2025-07-11.gz",
+			copySource:     "/bucket/logs/2025-07-10.gz",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "copy source mismatch",
+			method:         http.MethodPut,
+			path:           "/bucket/logs/2025-07-11.gz",
+			copySource:     "/bucket/secret.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "upload part copy source mismatch",
+			method:         http.MethodPut,
+			path:           "/bucket/logs/2025-07-11.gz?partNumber=1&uploadId=1",
+			copySource:     "/bucket/secret.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	cred := validCredential()
+	cred.AllowedKeys = []string{"logs/2*.gz", `regex:^metrics/[0-9]{4}/[0-9]{2}/[0-9]{2}\.json$`}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, "https://s3.example.com"+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.copySource != "" {
+				req.Header.Set("X-Amz-Copy-Source", tt.copySource)
+			}
+			signRequest(t, req, cred, time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC))
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tc := []struct {
+		method         string
+		expectedStatus int
+	}{
+		{method: http.MethodGet, expectedStatus: http.StatusOK},
+		{method: http.MethodHead, expectedStatus: http.StatusOK},
+		{method: http.MethodPut, expectedStatus: http.StatusServiceUnavailable},
+		{method: http.MethodPost, expectedStatus: http.StatusServiceUnavailable},
+		{method: http.MethodDelete, expectedStatus: http.StatusServiceUnavailable},
+	}
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.ReadOnly = true
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.method, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, "/bucket/object.txt", cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestRequireTLS(t *testing.T) {
+	tc := []struct {
+		name           string
+		tls            bool
+		proto          []string
+		expectedStatus int
+	}{
+		{
+			name:           "tls entrypoint",
+			tls:            true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "forwarded https",
+			proto:          []string{"https"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "plaintext entrypoint",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "forwarded http",
+			tls:            true,
+			proto:          []string{"http"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "plaintext hop",
+			proto:          []string{"https, http"},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.RequireTLS = true
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newSignedRequest(t, http.MethodGet, "/bucket/object.txt", cred)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for _, v := range tt.proto {
+				req.Header.Add("X-Forwarded-Proto", v)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestPublicReadPrefixes(t *testing.T) {
+	tc := []struct {
+		name           string
+		method         string
+		path           string
+		signed         bool
+		expectedStatus int
+	}{
+		{
+			name:           "anonymous read",
+			method:         http.MethodGet,
+			path:           "/site/index.html",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "anonymous head",
+			method:         http.MethodHead,
+			path:           "/site/css/main.css",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "anonymous write",
+			method:         http.MethodPut,
+			path:           "/site/index.html",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "anonymous listing",
+			method:         http.MethodGet,
+			path:           "/site",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "anonymous read outside of the prefixes",
+			method:         http.MethodGet,
+			path:           "/private/index.html",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "signed write",
+			method:         http.MethodPut,
+			path:           "/site/index.html",
+			signed:         true,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.PublicReadPrefixes = []string{"site/"}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newSignedRequest(t, tt.method, tt.path, cred)
+			if !tt.signed {
+				req.Header.Del("Authorization")
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestListingPrefixes(t *testing.T) {
+	tc := []struct {
+		name           string
+		delimiter      bool
+		path           string
+		expectedStatus int
+	}{
+		{
+			name:           "inside the prefix",
+			path:           "/shared?list-type=2&prefix=tenant-a%2F",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "deeper than the prefix",
+			path:           "/shared?prefix=tenant-a%2F2025%2F",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "without a prefix",
+			path:           "/shared?list-type=2",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "outside of the prefix",
+			path:           "/shared?versions&prefix=tenant-b%2F",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "escaping with dot segments",
+			path:           "/shared?uploads&prefix=tenant-a%2F..%2Ftenant-b%2F",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "with the required delimiter",
+			delimiter:      true,
+			path:           "/shared?list-type=2&prefix=tenant-a%2F&delimiter=%2F",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "without the required delimiter",
+			delimiter:      true,
+			path:           "/shared?list-type=2&prefix=tenant-a%2F",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.AllowedPrefixes = []string{"/shared/tenant-a/"}
+			cred.RequireListDelimiter = tt.delimiter
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			p := newTestPlugin(t, cfg)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, http.MethodGet, tt.path, cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestInjectHeaders(t *testing.T) {
+	tc := []struct {
+		name     string
+		headers  map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "credential fields",
+			headers:  map[string]string{"X-Tenant": "{{ .Tags.tenant }}", "X-Route": "{{ .AccessKeyID }}/{{ .Operation }}"},
+			expected: map[string]string{"X-Tenant": "acme", "X-Route": "ACCESS_ACCESS_ACCESS/GetObject"},
+		},
+		{
+			name:     "missing tag",
+			headers:  map[string]string{"X-Team": "{{ .Tags.team }}"},
+			expected: map[string]string{"X-Team": ""},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Tags = map[string]string{"tenant": "acme"}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.InjectHeaders = tt.headers
+
+			var header http.Header
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				header = req.Header.Clone()
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			req := newValidRequest(t)
+			for k := range tt.headers {
+				req.Header.Set(k, "spoofed")
+			}
+			p.ServeHTTP(httptest.NewRecorder(), req)
+			for k, v := range tt.expected {
+				if got := header.Get(k); got != v {
+					t.Errorf("expected header %s to be %q, got %q", k, v, got)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidInjectHeaders(t *testing.T) {
+	for _, headers := range []map[string]string{{"X-Roles": `{{ join .Roles "," }}`}, {"X-Tenant": "{{ .Tags.tenant"}, {"X Tenant": "acme"}} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.InjectHeaders = headers
+		if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "injectHeaders") {
+			t.Errorf("expected an injectHeaders error for %v, got %v", headers, err)
+		}
+	}
+}
+
+func TestBackendHost(t *testing.T) {
+	tc := []struct {
+		name         string
+		backendHost  string
+		host         string
+		path         string
+		expectedHost string
+		expectedPath string
+	}{
+		{
+			name:         "virtual-host-style to path-style",
+			backendHost:  "minio.internal:9000",
+			host:         "photos.s3.example.com",
+			path:         "/2025/cat.jpg",
+			expectedHost: "minio.internal:9000",
+			expectedPath: "/photos/2025/cat.jpg",
+		},
+		{
+			name:         "path-style",
+			backendHost:  "minio.internal:9000",
+			host:         "s3.example.com",
+			path:         "/photos/2025/cat.jpg",
+			expectedHost: "minio.internal:9000",
+			expectedPath: "/photos/2025/cat.jpg",
+		},
+		{
+			name:         "virtual-host-style bucket listing",
+			backendHost:  "minio.internal:9000",
+			host:         "photos.s3.example.com",
+			path:         "/",
+			expectedHost: "minio.internal:9000",
+			expectedPath: "/photos/",
+		},
+		{
+			name:         "path-style to virtual-host-style",
+			backendHost:  "{bucket}.s3.eu-central-1.amazonaws.com",
+			host:         "s3.example.com",
+			path:         "/photos/2025/cat.jpg",
+			expectedHost: "photos.s3.eu-central-1.amazonaws.com",
+			expectedPath: "/2025/cat.jpg",
+		},
+		{
+			name:         "virtual-host-style",
+			backendHost:  "{bucket}.s3.eu-central-1.amazonaws.com",
+			host:         "photos.s3.example.com",
+			path:         "/2025/cat.jpg",
+			expectedHost: "photos.s3.eu-central-1.amazonaws.com",
+			expectedPath: "/2025/cat.jpg",
+		},
+		{
+			name:         "service",
+			backendHost:  "{bucket}.s3.eu-central-1.amazonaws.com",
+			host:         "s3.example.com",
+			path:         "/",
+			expectedHost: "s3.eu-central-1.amazonaws.com",
+			expectedPath: "/",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.VirtualHostDomains = []string{"s3.example.com"}
+			cfg.BackendHost = tt.backendHost
+
+			var host, path string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				host, path = req.Host, req.URL.Path
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://"+tt.host+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signRequest(t, req, cred, time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC))
+			p.ServeHTTP(httptest.NewRecorder(), req)
+			if host != tt.expectedHost || path != tt.expectedPath {
+				t.Errorf("expected %s%s, got %s%s", tt.expectedHost, tt.expectedPath, host, path)
+			}
+		})
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	tc := []struct {
+		name           string
+		backendID      string
+		method         string
+		expectedStatus int
+		expectedID     string
+	}{
+		{
+			name:           "forwarded",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "kept from the backend",
+			backendID:      "BACKEND0000000001",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedID:     "BACKEND0000000001",
+		},
+		{
+			name:           "error response",
+			method:         http.MethodPut,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.AllowedMethods = []string{http.MethodGet}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.RequestIDs = true
+
+			var forwarded, fromContext string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req.Header.Get("X-Amz-Request-Id")
+				fromContext, _ = req.Context().Value(plugin.RequestIDContextKey).(string)
+				if tt.backendID != "" {
+					rw.Header().Set("X-Amz-Request-Id", tt.backendID)
+				}
+				rw.WriteHeader(http.StatusOK)
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, "/bucket/object.txt", cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			id := recorder.Header().Get("X-Amz-Request-Id")
+			switch {
+			case tt.expectedID != "" && id != tt.expectedID:
+				t.Errorf("expected the request id %q of the backend, got %q", tt.expectedID, id)
+			case tt.expectedID == "" && (len(id) != 16 || recorder.Header().Get("X-Amz-Id-2") == ""):
+				t.Errorf("expected minted request ids, got %q and %q", id, recorder.Header().Get("X-Amz-Id-2"))
+			}
+			if tt.expectedStatus == http.StatusOK && (fromContext == "" || forwarded != fromContext) {
+				t.Errorf("expected the request id %q to be forwarded, got %q", fromContext, forwarded)
+			}
+			if tt.expectedStatus != http.StatusOK && !strings.Contains(recorder.Body.String(), "<RequestId>"+id+"</RequestId>") {
+				t.Errorf("expected the request id in the error, got %s", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestCorrelationIDs(t *testing.T) {
+	tc := []struct {
+		name       string
+		sent       string
+		requestIDs bool
+		expected   string
+	}{
+		{name: "kept", sent: "0f8fad5b-d9cb-469f-a165-70867728950e", expected: "0f8fad5b-d9cb-469f-a165-70867728950e"},
+		{name: "minted"},
+		{name: "unsafe", sent: "id\" level=error"},
+		{name: "request id", requestIDs: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.RequestIDs = tt.requestIDs
+			cfg.RequestIDHeader = "x-request-id"
+
+			var forwarded, fromContext, requestID string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req.Header.Get("X-Request-Id")
+				fromContext, _ = req.Context().Value(plugin.CorrelationIDContextKey).(string)
+				requestID = req.Header.Get("X-Amz-Request-Id")
+				rw.WriteHeader(http.StatusOK)
+			})
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			req := newSignedRequest(t, http.MethodGet, "/bucket/object.txt", validCredential())
+			if tt.sent != "" {
+				req.Header.Set("X-Request-Id", tt.sent)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+
+			id := recorder.Header().Get("X-Request-Id")
+			switch {
+			case tt.expected != "" && id != tt.expected:
+				t.Errorf("expected the correlation id %q, got %q", tt.expected, id)
+			case tt.requestIDs && id != requestID:
+				t.Errorf("expected the request id %q as the correlation id, got %q", requestID, id)
+			case id == "" || id == tt.sent && tt.expected == "":
+				t.Errorf("expected a minted correlation id, got %q", id)
+			}
+			if forwarded != id || fromContext != id {
+				t.Errorf("expected the correlation id %q to be forwarded, got %q and %q", id, forwarded, fromContext)
+			}
+
+			var b strings.Builder
+			p.WriteOpenMetrics(&b)
+			if !strings.Contains(b.String(), `# {request_id="`+id+`"}`) || !strings.HasSuffix(b.String(), "# EOF\n") {
+				t.Errorf("expected an exemplar for %q in the metrics:\n%s", id, b.String())
+			}
+		})
+	}
+}
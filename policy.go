@@ -0,0 +1,214 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	effectAllow = "Allow"
+	effectDeny  = "Deny"
+)
+
+// Policy is a simplified IAM policy document, eg:
+//
+//	{"Statement": [{"Effect": "Allow", "Action": ["s3:GetObject"], "Resource": ["arn:aws:s3:::backups/*"]}]}
+//
+// Principals are not supported.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_elements.html
+type Policy struct {
+	Version   string             `json:"version,omitempty"`
+	Statement []*PolicyStatement `json:"statement,omitempty"`
+}
+
+// PolicyStatement allows or denies the actions on the resources, both support `*` and `?` wildcards.
+type PolicyStatement struct {
+	Sid      string   `json:"sid,omitempty"`
+	Effect   string   `json:"effect,omitempty"`
+	Action   []string `json:"action,omitempty"`
+	Resource []string `json:"resource,omitempty"`
+	// SubResources is a condition limiting the statement to requests with one of these query sub-resources, eg:
+	// `acl`, `policy`, `lifecycle` or `versioning`, and `*` for any of them.
+	SubResources []string `json:"subResources,omitempty"`
+	// Condition limits the statement to requests matching it, eg: `{"StringNotEquals": {"s3:x-amz-acl": ["private"]}}`.
+	// The `StringEquals`, `StringNotEquals`, `StringLike`, `StringNotLike` and `Null` operators are supported on the
+	// `s3:x-amz-*` request headers, the `s3:RequestObjectTag/<key>` and `s3:RequestObjectTagKeys` upload tags,
+	// `aws:UserAgent` and `s3auth:Country`.
+	Condition map[string]map[string][]string `json:"condition,omitempty"`
+}
+
+func checkPolicy(p *Policy) error {
+	if p == nil {
+		return nil
+	}
+	if len(p.Statement) == 0 {
+		return errors.New("policy must have at least one statement")
+	}
+	for i, s := range p.Statement {
+		if s.Effect != effectAllow && s.Effect != effectDeny {
+			return fmt.Errorf("statement %d: effect must be %q or %q, got %q", i, effectAllow, effectDeny, s.Effect)
+		}
+		if len(s.Action) == 0 || len(s.Resource) == 0 {
+			return fmt.Errorf("statement %d: must specify both `action` and `resource`", i)
+		}
+		for _, sr := range s.SubResources {
+			if !knownSubresources[sr] && !strings.ContainsAny(sr, "*?") {
+				return fmt.Errorf("statement %d: unknown sub-resource: %q", i, sr)
+			}
+		}
+		if err := checkCondition(s.Condition); err != nil {
+			return fmt.Errorf("statement %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// decide checks the action on the resource the way IAM does: an explicit deny wins, otherwise an allow is required.
+// It returns the index of the statement that decided, ie: the explicit deny or the first matching allow, or -1 when
+// none did. A nil policy allows everything.
+func (p *Policy) decide(r policyRequest) (int, error) {
+	if p == nil {
+		return -1, nil
+	}
+	i, effect := p.match(r)
+	switch effect {
+	case effectDeny:
+		return i, fmt.Errorf("%s on %q is explicitly denied", r.action, r.resource)
+	case effectAllow:
+		return i, nil
+	default:
+		return -1, fmt.Errorf("%s on %q is not allowed by the policy", r.action, r.resource)
+	}
+}
+
+// match returns the first matching deny statement, otherwise the first matching allow, or -1 and an empty effect.
+func (p *Policy) match(r policyRequest) (int, string) {
+	allowed := -1
+	for i, s := range p.Statement {
+		if !matchesWildcard(s.Action, r.action, true) || !matchesWildcard(s.Resource, r.resource, false) {
+			continue
+		}
+		if len(s.SubResources) > 0 && !anyWildcard(s.SubResources, r.subresources) {
+			continue
+		}
+		if !matchCondition(s.Condition, r) {
+			continue
+		}
+		if s.Effect == effectDeny {
+			return i, effectDeny
+		}
+		if allowed < 0 {
+			allowed = i
+		}
+	}
+	if allowed < 0 {
+		return -1, ""
+	}
+	return allowed, effectAllow
+}
+
+const (
+	credentialPolicy = "credential"
+	defaultPolicy    = "default"
+)
+
+// policyDecision is the policy, `credential` or `default`, and the index of the statement that decided, if any.
+type policyDecision struct {
+	policy string
+	index  int
+}
+
+// evaluatePolicies applies the credential policy over the default one. A matching statement of the credential policy
+// decides, be it a deny or an allow, otherwise the default policy does. When neither is set everything is allowed,
+// unless requireAllow is set.
+func evaluatePolicies(defaults, own *Policy, requireAllow bool, r policyRequest) (policyDecision, error) {
+	if own == nil && defaults == nil && requireAllow {
+		return policyDecision{index: -1}, fmt.Errorf("%s on %q is not allowed by any policy", r.action, r.resource)
+	}
+	if own != nil {
+		if _, effect := own.match(r); effect != "" || defaults == nil {
+			i, err := own.decide(r)
+			return policyDecision{policy: credentialPolicy, index: i}, err
+		}
+	}
+	i, err := defaults.decide(r)
+	return policyDecision{policy: defaultPolicy, index: i}, err
+}
+
+func matchesWildcard(patterns []string, v string, fold bool) bool {
+	if fold {
+		v = strings.ToLower(v)
+	}
+	for _, p := range patterns {
+		if fold {
+			p = strings.ToLower(p)
+		}
+		if wildcardMatch(p, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyWildcard(patterns []string, values []string) bool {
+	for _, v := range values {
+		if matchesWildcard(patterns, v, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches IAM style patterns where `*` is any sequence, including slashes, and `?` any character.
+func wildcardMatch(pattern, v string) bool {
+	px, vx := 0, 0
+	star, next := -1, 0
+	for vx < len(v) {
+		switch {
+		case px < len(pattern) && (pattern[px] == '?' || pattern[px] == v[vx]):
+			px++
+			vx++
+		case px < len(pattern) && pattern[px] == '*':
+			star, next = px, vx
+			px++
+		case star >= 0:
+			px = star + 1
+			next++
+			vx = next
+		default:
+			return false
+		}
+	}
+	for px < len(pattern) && pattern[px] == '*' {
+		px++
+	}
+	return px == len(pattern)
+}
+
+// requireAllow reports whether requests without any policy are denied. Cedar policies already require a `permit`.
+// copySourceOperation is the read of the `x-amz-copy-source` of copies.
+var copySourceOperation = s3Operation{Name: "GetObject", Action: "s3:GetObject"}
+
+// authorizeCopySource evaluates the policies and the grants for reading the source of copies, since S3 requires
+// `s3:GetObject` on the source on top of `s3:PutObject` on the destination.
+func (p *Plugin) authorizeCopySource(req *http.Request, cred *Credential, now time.Time) (policyDecision, error) {
+	src, copied, err := copySource(req)
+	if err != nil || !copied {
+		return policyDecision{index: -1}, err
+	}
+	d, err := evaluatePolicies(p.defaultPolicy, cred.Policy, p.requireAllow(), policyRequest{action: copySourceOperation.Action, resource: src.arn(), header: req.Header, tags: cred.Tags, country: p.geo.country(req)})
+	if err != nil && d.index < 0 && p.grant(req, cred, copySourceOperation, src, now) != nil {
+		return d, nil
+	}
+	if err != nil {
+		return d, fmt.Errorf("copy source: %w", err)
+	}
+	return d, nil
+}
+
+func (p *Plugin) requireAllow() bool {
+	return p.defaultDeny && p.cedar == nil
+}
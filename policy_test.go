@@ -0,0 +1,686 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+// testPolicy is written the way AWS users would, field names are matched case-insensitively.
+const testPolicy = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{"Effect": "Allow", "Action": ["s3:Get*", "s3:ListBucket"], "Resource": ["arn:aws:s3:::backups", "arn:aws:s3:::backups/*"]},
+		{"Effect": "Allow", "Action": ["s3:PutObject"], "Resource": ["arn:aws:s3:::backups/uploads/*"]},
+		{"Effect": "Deny", "Action": ["s3:*"], "Resource": ["arn:aws:s3:::backups/secret/*"]}
+	]
+}`
+
+func TestPolicy(t *testing.T) {
+	tc := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{
+			name:           "get object",
+			method:         http.MethodGet,
+			path:           "/backups/2025/db.tar",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "list bucket",
+			method:         http.MethodGet,
+			path:           "/backups",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "put object outside of uploads",
+			method:         http.MethodPut,
+			path:           "/backups/2025/db.tar",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "put object in uploads",
+			method:         http.MethodPut,
+			path:           "/backups/uploads/db.tar",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "explicit deny wins",
+			method:         http.MethodGet,
+			path:           "/backups/secret/key.pem",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "delete is not allowed",
+			method:         http.MethodDelete,
+			path:           "/backups/uploads/db.tar",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "other bucket",
+			method:         http.MethodGet,
+			path:           "/private/db.tar",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	cred := validCredential()
+	if err := json.Unmarshal([]byte(testPolicy), &cred.Policy); err != nil {
+		t.Fatal(err)
+	}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, tt.path, cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	tc := []struct {
+		name   string
+		policy *plugin.Policy
+	}{
+		{
+			name:   "no statements",
+			policy: &plugin.Policy{},
+		},
+		{
+			name:   "invalid effect",
+			policy: &plugin.Policy{Statement: []*plugin.PolicyStatement{{Effect: "Maybe", Action: []string{"s3:*"}, Resource: []string{"*"}}}},
+		},
+		{
+			name:   "unknown sub-resource",
+			policy: &plugin.Policy{Statement: []*plugin.PolicyStatement{{Effect: "Deny", Action: []string{"s3:*"}, Resource: []string{"*"}, SubResources: []string{"acls"}}}},
+		},
+		{
+			name:   "unsupported condition operator",
+			policy: &plugin.Policy{Statement: []*plugin.PolicyStatement{{Effect: "Deny", Action: []string{"s3:*"}, Resource: []string{"*"}, Condition: map[string]map[string][]string{"NumericLessThan": {"s3:x-amz-acl": {"1"}}}}}},
+		},
+		{
+			name:   "unsupported condition key",
+			policy: &plugin.Policy{Statement: []*plugin.PolicyStatement{{Effect: "Deny", Action: []string{"s3:*"}, Resource: []string{"*"}, Condition: map[string]map[string][]string{"StringEquals": {"aws:SourceIp": {"10.0.0.1"}}}}}},
+		},
+		{
+			name:   "missing resource",
+			policy: &plugin.Policy{Statement: []*plugin.PolicyStatement{{Effect: "Allow", Action: []string{"s3:*"}}}},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.Policy = tt.policy
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "test"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestPolicySubResources(t *testing.T) {
+	tc := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{
+			name:           "put object",
+			method:         http.MethodPut,
+			path:           "/bucket/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "get acl",
+			method:         http.MethodGet,
+			path:           "/bucket?acl",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "put acl",
+			method:         http.MethodPut,
+			path:           "/bucket?acl",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "put object acl",
+			method:         http.MethodPut,
+			path:           "/bucket/object.txt?acl",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "put lifecycle",
+			method:         http.MethodPut,
+			path:           "/bucket?lifecycle",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "delete policy",
+			method:         http.MethodDelete,
+			path:           "/bucket?policy",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "put versioning",
+			method:         http.MethodPut,
+			path:           "/bucket?versioning",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	cred := validCredential()
+	cred.Policy = &plugin.Policy{Statement: []*plugin.PolicyStatement{
+		{Effect: "Allow", Action: []string{"s3:*"}, Resource: []string{"*"}},
+		{Effect: "Deny", Action: []string{"s3:Put*", "s3:Delete*"}, Resource: []string{"*"}, SubResources: []string{"acl", "policy", "lifecycle", "versioning"}},
+	}}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, tt.path, cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestPolicyConditions(t *testing.T) {
+	tc := []struct {
+		name           string
+		method         string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{
+			name:           "encrypted private upload",
+			method:         http.MethodPut,
+			headers:        map[string]string{"X-Amz-Server-Side-Encryption": "AES256", "X-Amz-Acl": "private"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "kms encrypted upload without an acl",
+			method:         http.MethodPut,
+			headers:        map[string]string{"X-Amz-Server-Side-Encryption": "aws:kms"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unencrypted upload",
+			method:         http.MethodPut,
+			headers:        map[string]string{"X-Amz-Acl": "private"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "public upload",
+			method:         http.MethodPut,
+			headers:        map[string]string{"X-Amz-Server-Side-Encryption": "AES256", "X-Amz-Acl": "public-read"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "reads are not affected",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	const policy = `{
+		"Statement": [
+			{"Effect": "Allow", "Action": ["s3:*"], "Resource": ["*"]},
+			{"Effect": "Deny", "Action": ["s3:PutObject"], "Resource": ["*"],
+			 "Condition": {"StringNotEquals": {"s3:x-amz-server-side-encryption": ["AES256", "aws:kms"]}}},
+			{"Effect": "Deny", "Action": ["s3:PutObject"], "Resource": ["*"],
+			 "Condition": {"Null": {"s3:x-amz-acl": ["false"]}, "StringNotLike": {"s3:x-amz-acl": ["private", "bucket-owner-*"]}}}
+		]
+	}`
+	cred := validCredential()
+	if err := json.Unmarshal([]byte(policy), &cred.Policy); err != nil {
+		t.Fatal(err)
+	}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, "https://s3.example.com/bucket/object.txt", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			signRequest(t, req, cred, p.Now())
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestPolicyTagConditions(t *testing.T) {
+	tc := []struct {
+		name           string
+		tagging        string
+		expectedStatus int
+	}{
+		{
+			name:           "tenant tag",
+			tagging:        "project=tenant-a",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "tenant and tier tags",
+			tagging:        "project=tenant-a&tier=cold",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "other tenant",
+			tagging:        "project=tenant-b",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "missing tags",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unexpected tag key",
+			tagging:        "project=tenant-a&owner=mallory",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	const policy = `{
+		"Statement": [
+			{"Effect": "Allow", "Action": ["s3:PutObject"], "Resource": ["*"],
+			 "Condition": {
+				"StringEquals": {"s3:RequestObjectTag/project": ["${aws:PrincipalTag/tenant}"]},
+				"ForAllValues:StringEquals": {"s3:RequestObjectTagKeys": ["project", "tier"]}
+			 }}
+		]
+	}`
+	cred := validCredential()
+	cred.Tags = map[string]string{"tenant": "tenant-a"}
+	if err := json.Unmarshal([]byte(policy), &cred.Policy); err != nil {
+		t.Fatal(err)
+	}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPut, "https://s3.example.com/bucket/object.txt", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tagging != "" {
+				req.Header.Set("X-Amz-Tagging", tt.tagging)
+			}
+			signRequest(t, req, cred, p.Now())
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestPolicyCopySource(t *testing.T) {
+	tc := []struct {
+		name           string
+		copySource     string
+		expectedStatus int
+	}{
+		{
+			name:           "source allowed",
+			copySource:     "/a/report.pdf",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "source not allowed",
+			copySource:     "/b/secret.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "source explicitly denied",
+			copySource:     "/a/private/key.pem",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	const policy = `{
+		"Statement": [
+			{"Effect": "Allow", "Action": ["s3:*"], "Resource": ["arn:aws:s3:::a/*"]},
+			{"Effect": "Deny", "Action": ["s3:GetObject"], "Resource": ["arn:aws:s3:::a/private/*"]}
+		]
+	}`
+	cred := validCredential()
+	if err := json.Unmarshal([]byte(policy), &cred.Policy); err != nil {
+		t.Fatal(err)
+	}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newSignedRequest(t, http.MethodPut, "/a/copy.pdf", cred)
+			req.Header.Set("X-Amz-Copy-Source", tt.copySource)
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+
+			r, err := p.Simulate(plugin.SimulationRequest{AccessKeyID: cred.AccessKeyID, Method: http.MethodPut, Path: "/a/copy.pdf", Headers: map[string]string{"X-Amz-Copy-Source": tt.copySource}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Allowed != (tt.expectedStatus == http.StatusOK) {
+				t.Errorf("expected the simulation to match, got %+v", r)
+			}
+		})
+	}
+}
+
+func TestPolicyTagVariables(t *testing.T) {
+	const policy = `{
+		"Statement": [
+			{"Effect": "Allow", "Action": ["s3:PutObject"], "Resource": ["*"],
+			 "Condition": {"StringEquals": {"s3:RequestObjectTag/project": ["${aws:PrincipalTag/tenant}-${aws:PrincipalTag/tier}"]}}}
+		]
+	}`
+	cred := validCredential()
+	// The variables in the tag values are kept as is.
+	cred.Tags = map[string]string{"tenant": "${aws:PrincipalTag/tenant}", "tier": "cold"}
+	if err := json.Unmarshal([]byte(policy), &cred.Policy); err != nil {
+		t.Fatal(err)
+	}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPut, "https://s3.example.com/bucket/object.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Amz-Tagging", "project="+url.QueryEscape("${aws:PrincipalTag/tenant}-cold"))
+	signRequest(t, req, cred, p.Now())
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+}
+
+func TestPolicyUserAgentConditions(t *testing.T) {
+	tc := []struct {
+		name           string
+		userAgent      string
+		expectedStatus int
+	}{
+		{
+			name:           "allowed tool",
+			userAgent:      "restic/0.16.4 (linux/amd64)",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "other tool",
+			userAgent:      "aws-cli/2.15.0 Python/3.11.6",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "denylisted version",
+			userAgent:      "restic/0.9.0 (linux/amd64)",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "missing user agent",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	const policy = `{
+		"Statement": [
+			{"Effect": "Allow", "Action": ["s3:*"], "Resource": ["*"], "Condition": {"StringLike": {"aws:UserAgent": ["restic/*"]}}},
+			{"Effect": "Deny", "Action": ["s3:*"], "Resource": ["*"], "Condition": {"StringLike": {"aws:UserAgent": ["restic/0.9.*"]}}}
+		]
+	}`
+	cred := validCredential()
+	if err := json.Unmarshal([]byte(policy), &cred.Policy); err != nil {
+		t.Fatal(err)
+	}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("User-Agent", tt.userAgent)
+			signRequest(t, req, cred, p.Now())
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestPolicyCountryConditions(t *testing.T) {
+	tc := []struct {
+		name           string
+		remoteAddr     string
+		country        string
+		expectedStatus int
+	}{
+		{
+			name:           "allowed country",
+			remoteAddr:     "198.51.100.7:443",
+			country:        "PT",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "lower case country",
+			remoteAddr:     "198.51.100.7:443",
+			country:        "es",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "other country",
+			remoteAddr:     "198.51.100.7:443",
+			country:        "US",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "missing country",
+			remoteAddr:     "198.51.100.7:443",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "untrusted peer",
+			remoteAddr:     "192.0.2.10:43210",
+			country:        "PT",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	const policy = `{
+		"Statement": [
+			{"Effect": "Allow", "Action": ["s3:*"], "Resource": ["*"], "Condition": {"StringEquals": {"s3auth:Country": ["PT", "ES"]}}}
+		]
+	}`
+	cred := validCredential()
+	if err := json.Unmarshal([]byte(policy), &cred.Policy); err != nil {
+		t.Fatal(err)
+	}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.Geo = &plugin.GeoConfig{Header: "CF-IPCountry", TrustedCIDRs: []string{"198.51.100.0/24"}}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newSignedRequest(t, http.MethodGet, "/bucket/object.txt", cred)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.country != "" {
+				req.Header.Set("CF-IPCountry", tt.country)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	tc := []struct {
+		name           string
+		policy         string
+		method         string
+		target         string
+		expectedStatus int
+	}{
+		{
+			name:           "default allow",
+			method:         http.MethodGet,
+			target:         "/bucket/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "default deny",
+			method:         http.MethodPut,
+			target:         "/bucket?policy",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "default implicit deny",
+			method:         http.MethodPut,
+			target:         "/bucket/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "credential allow overrides",
+			policy:         `{"Statement": [{"Effect": "Allow", "Action": ["s3:PutBucketPolicy", "s3:PutObject"], "Resource": ["*"]}]}`,
+			method:         http.MethodPut,
+			target:         "/bucket?policy",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "credential deny overrides",
+			policy:         `{"Statement": [{"Effect": "Deny", "Action": ["s3:GetObject"], "Resource": ["arn:aws:s3:::bucket/*"]}]}`,
+			method:         http.MethodGet,
+			target:         "/bucket/object.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "falls back to the default",
+			policy:         `{"Statement": [{"Effect": "Allow", "Action": ["s3:PutObject"], "Resource": ["*"]}]}`,
+			method:         http.MethodGet,
+			target:         "/bucket/object.txt",
+			expectedStatus: http.StatusOK,
+		},
+	}
+	const defaults = `{
+		"Statement": [
+			{"Effect": "Allow", "Action": ["s3:Get*", "s3:List*"], "Resource": ["*"]},
+			{"Effect": "Deny", "Action": ["s3:*"], "Resource": ["*"], "SubResources": ["acl", "policy", "lifecycle"]}
+		]
+	}`
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			if tt.policy != "" {
+				if err := json.Unmarshal([]byte(tt.policy), &cred.Policy); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			if err := json.Unmarshal([]byte(defaults), &cfg.DefaultPolicy); err != nil {
+				t.Fatal(err)
+			}
+			p := newTestPlugin(t, cfg)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, tt.target, cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestDefaultDeny(t *testing.T) {
+	tc := []struct {
+		name           string
+		policy         string
+		cedar          string
+		expectedStatus int
+	}{
+		{
+			name:           "without a policy",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "allowed by the policy",
+			policy:         `{"Statement": [{"Effect": "Allow", "Action": ["s3:GetObject"], "Resource": ["*"]}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "not allowed by the policy",
+			policy:         `{"Statement": [{"Effect": "Allow", "Action": ["s3:PutObject"], "Resource": ["*"]}]}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "permitted by cedar",
+			cedar:          `permit (principal, action == S3::Action::"GetObject", resource);`,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			if tt.policy != "" {
+				if err := json.Unmarshal([]byte(tt.policy), &cred.Policy); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.DefaultDeny = true
+			if tt.cedar != "" {
+				cfg.Cedar = &plugin.CedarConfig{Policies: tt.cedar}
+			}
+			p := newTestPlugin(t, cfg)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, http.MethodGet, "/bucket/object.txt", cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
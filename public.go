@@ -0,0 +1,34 @@
+package traefik_plugin_s3_auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// publicReads are the operations anonymous clients can make under the public read prefixes.
+var publicReads = map[string]bool{"GetObject": true, "HeadObject": true}
+
+// publicRead returns the operation of an unsigned request reading an object under one of the prefixes, eg: `site/`
+// or `assets/public/`. Signed requests are always validated, even under the prefixes.
+func (p *Plugin) publicRead(req *http.Request) (s3Operation, bool) {
+	if len(p.publicPrefixes) == 0 || p.signed(req) {
+		return s3Operation{}, false
+	}
+	res := resolveResource(req, p.domains)
+	op := classify(req, res)
+	if !publicReads[op.Name] {
+		return op, false
+	}
+	name := res.Bucket + "/" + res.Key
+	for _, prefix := range p.publicPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return op, true
+		}
+	}
+	return op, false
+}
+
+// signed reports whether the request carries credentials, ie the authorization or the `iam` header.
+func (p *Plugin) signed(req *http.Request) bool {
+	return req.Header.Get(p.headerName) != "" || (p.iam != nil && req.Header.Get(p.iam.header) != "")
+}
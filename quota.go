@@ -0,0 +1,185 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	quotaDay    = "day"
+	quotaMonth  = "month"
+	quotaReject = "reject"
+	quotaFlag   = "flag"
+)
+
+var errQuotaExceeded = errors.New("byte quota exceeded")
+
+// ByteQuota limits the bytes uploaded and downloaded by a credential each UTC day or month.
+type ByteQuota struct {
+	// Period is either `day` (the default) or `month`.
+	Period string `json:"period,omitempty"`
+	// Upload and Download are the allowed bytes per period, unlimited when 0.
+	Upload   int64 `json:"upload,omitempty"`
+	Download int64 `json:"download,omitempty"`
+	// Mode is either `reject` (the default) to reject requests over the quota, or `flag` to only report them.
+	Mode string `json:"mode,omitempty"`
+}
+
+func checkQuota(q *ByteQuota) error {
+	if q == nil {
+		return nil
+	}
+	if q.Period != "" && q.Period != quotaDay && q.Period != quotaMonth {
+		return fmt.Errorf("unsupported quota period: %q", q.Period)
+	}
+	if q.Mode != "" && q.Mode != quotaReject && q.Mode != quotaFlag {
+		return fmt.Errorf("unsupported quota mode: %q", q.Mode)
+	}
+	if q.Upload < 0 || q.Download < 0 {
+		return errors.New("quotas must not be negative")
+	}
+	return nil
+}
+
+// periodEnd returns when the current UTC day or month of a quota ends, ie when its counters reset.
+func periodEnd(period string, now time.Time) time.Time {
+	now = now.UTC()
+	if period == quotaMonth {
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// period returns the current period, eg: `2025-07-10` or `2025-07`.
+func (q *ByteQuota) period(now time.Time) string {
+	if q.Period == quotaMonth {
+		return now.UTC().Format("2006-01")
+	}
+	return now.UTC().Format("2006-01-02")
+}
+
+type byteUsage struct {
+	period     string
+	uploaded   int64
+	downloaded int64
+}
+
+// quotaTracker accounts the bytes of each access key for the current period.
+type quotaTracker struct {
+	mu    sync.Mutex
+	usage map[string]*byteUsage
+}
+
+func newQuotaTracker() *quotaTracker {
+	return &quotaTracker{usage: map[string]*byteUsage{}}
+}
+
+// add accounts the bytes and returns the totals for the period.
+func (t *quotaTracker) add(accessKeyID, period string, uploaded, downloaded int64) byteUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.usage[accessKeyID]
+	if !ok || u.period != period {
+		u = &byteUsage{period: period}
+		t.usage[accessKeyID] = u
+	}
+	u.uploaded += uploaded
+	u.downloaded += downloaded
+	return *u
+}
+
+func (t *quotaTracker) get(accessKeyID, period string) byteUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if u, ok := t.usage[accessKeyID]; ok && u.period == period {
+		return *u
+	}
+	return byteUsage{period: period}
+}
+
+// over reports which side of the quota the usage exceeds, if any.
+func (q *ByteQuota) over(u byteUsage) string {
+	switch {
+	case q.Upload > 0 && u.uploaded > q.Upload:
+		return "upload"
+	case q.Download > 0 && u.downloaded > q.Download:
+		return "download"
+	default:
+		return ""
+	}
+}
+
+// QuotaStatus is the byte usage of a credential for the current period.
+type QuotaStatus struct {
+	Period     string `json:"period"`
+	Uploaded   int64  `json:"uploaded"`
+	Downloaded int64  `json:"downloaded"`
+}
+
+// meter accounts the bytes of a single request. With the `reject` mode, uploads are rejected upfront when their
+// `Content-Length` would exceed the quota, and streamed uploads fail once they do.
+type meter struct {
+	quota  *ByteQuota
+	usage  *quotaTracker
+	user   string
+	period string
+	reject bool
+}
+
+// admit checks the request before it is forwarded. Downloads are only rejected once the quota is used up since their
+// size is unknown upfront.
+func (m *meter) admit(req *http.Request) error {
+	u := m.usage.get(m.user, m.period)
+	q := m.quota
+	switch {
+	case q.Upload > 0 && req.ContentLength > 0 && u.uploaded+req.ContentLength > q.Upload:
+		return fmt.Errorf("%w: upload of %d bytes", errQuotaExceeded, req.ContentLength)
+	case q.Upload > 0 && req.ContentLength < 0 && u.uploaded >= q.Upload:
+		return fmt.Errorf("%w: streamed upload", errQuotaExceeded)
+	case q.Download > 0 && req.Method == http.MethodGet && u.downloaded >= q.Download:
+		return fmt.Errorf("%w: download", errQuotaExceeded)
+	default:
+		return nil
+	}
+}
+
+type meteredBody struct {
+	io.ReadCloser
+	m *meter
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		u := b.m.usage.add(b.m.user, b.m.period, int64(n), 0)
+		if b.m.reject && b.m.quota.Upload > 0 && u.uploaded > b.m.quota.Upload {
+			return n, errQuotaExceeded
+		}
+	}
+	return n, err
+}
+
+type meteredWriter struct {
+	http.ResponseWriter
+	m *meter
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if n > 0 {
+		w.m.usage.add(w.m.user, w.m.period, 0, int64(n))
+	}
+	return n, err
+}
+
+func (w *meteredWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
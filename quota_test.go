@@ -0,0 +1,136 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+// newQuotaPlugin returns a plugin whose backend reads the whole body and returns 12 bytes for each `GET`.
+func newQuotaPlugin(t *testing.T, cred *plugin.Credential) *plugin.Plugin {
+	t.Helper()
+
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.Copy(io.Discard, req.Body)
+		if req.Method == http.MethodGet {
+			_, _ = rw.Write([]byte("123456789012"))
+		}
+	})
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+	return p
+}
+
+func newQuotaRequest(t *testing.T, method, body string, cred *plugin.Credential, at time.Time) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), method, "https://s3.example.com/bucket/object.txt", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signRequest(t, req, cred, at)
+	return req
+}
+
+func TestByteQuota(t *testing.T) {
+	tc := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "upload within the quota",
+			method:         http.MethodPut,
+			body:           "12345678",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "upload over the quota",
+			method:         http.MethodPut,
+			body:           "12345",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "download past the quota",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "download over the quota",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	cred := validCredential()
+	cred.AccessKeyID = "AKIAQUOTA"
+	cred.ByteQuota = &plugin.ByteQuota{Upload: 10, Download: 10}
+	p := newQuotaPlugin(t, cred)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newQuotaRequest(t, tt.method, tt.body, cred, p.Now()))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus == http.StatusForbidden && !strings.Contains(recorder.Body.String(), "<Code>QuotaExceeded</Code>") {
+				t.Errorf("expected a QuotaExceeded error, got %s", recorder.Body)
+			}
+		})
+	}
+
+	s := p.CredentialStatus()
+	if len(s) != 1 || s[0].Quota == nil {
+		t.Fatalf("expected a quota status, got %+v", s)
+	}
+	if s[0].Quota.Period != "2025-07-10" || s[0].Quota.Uploaded != 8 || s[0].Quota.Downloaded != 12 {
+		t.Errorf("unexpected quota status: %+v", *s[0].Quota)
+	}
+	if strings.Join(s[0].Warnings, ",") != "over-quota" {
+		t.Errorf("expected an over-quota warning, got %v", s[0].Warnings)
+	}
+
+	// The quota resets on the next day.
+	next := p.Now().Add(24 * time.Hour)
+	p.Now = func() time.Time { return next }
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, newQuotaRequest(t, http.MethodGet, "", cred, next))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status code %d on the next day, got %d", http.StatusOK, recorder.Code)
+	}
+}
+
+func TestByteQuotaFlag(t *testing.T) {
+	cred := validCredential()
+	cred.AccessKeyID = "AKIAQUOTAFLAG"
+	cred.ByteQuota = &plugin.ByteQuota{Period: "month", Upload: 4, Mode: "flag"}
+	p := newQuotaPlugin(t, cred)
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, newQuotaRequest(t, http.MethodPut, "12345678", cred, p.Now()))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+		}
+	}
+	s := p.CredentialStatus()
+	if len(s) != 1 || s[0].Quota == nil || s[0].Quota.Period != "2025-07" {
+		t.Fatalf("expected a monthly quota status, got %+v", s)
+	}
+	if strings.Join(s[0].Warnings, ",") != "over-quota" {
+		t.Errorf("expected an over-quota warning, got %v", s[0].Warnings)
+	}
+}
@@ -0,0 +1,176 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRedisTimeout   = time.Second
+	defaultRedisKeyPrefix = "s3auth:"
+	redisPoolSize         = 8
+)
+
+// RedisConfig points at a Redis server persisting the request quota counters, so they survive restarts and are shared
+// across replicas.
+type RedisConfig struct {
+	// Address of the server, eg: `redis:6379`.
+	Address  string `json:"address,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+	// KeyPrefix is prepended to every key, defaults to `s3auth:`.
+	KeyPrefix string `json:"keyPrefix,omitempty"`
+	// Timeout bounds each command, defaults to `1s`.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// redisClient is a minimal RESP client with a small connection pool, the plugin can only use the standard library.
+// https://redis.io/docs/latest/develop/reference/protocol-spec/
+type redisClient struct {
+	config  *RedisConfig
+	timeout time.Duration
+	conns   chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newRedisClient(config *RedisConfig) (*redisClient, error) {
+	if config.Address == "" {
+		return nil, errors.New("must specify the redis `address`, eg: `redis:6379`")
+	}
+	c := &redisClient{config: config, timeout: defaultRedisTimeout, conns: make(chan *redisConn, redisPoolSize)}
+	if config.Timeout != "" {
+		d, err := time.ParseDuration(config.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid redis `timeout` %q, eg: `1s`", config.Timeout)
+		}
+		c.timeout = d
+	}
+	return c, nil
+}
+
+func (c *redisClient) dial() (*redisConn, error) {
+	nc, err := net.DialTimeout("tcp", c.config.Address, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	var setup [][]string
+	switch {
+	case c.config.Username != "":
+		setup = append(setup, []string{"AUTH", c.config.Username, c.config.Password})
+	case c.config.Password != "":
+		setup = append(setup, []string{"AUTH", c.config.Password})
+	}
+	if c.config.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.config.DB)})
+	}
+	for _, args := range setup {
+		if _, err := conn.do(c.timeout, args...); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// do runs a command on a pooled connection, connections that fail are dropped.
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	var conn *redisConn
+	select {
+	case conn = <-c.conns:
+	default:
+		var err error
+		if conn, err = c.dial(); err != nil {
+			return nil, err
+		}
+	}
+	v, err := conn.do(c.timeout, args...)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		_ = conn.Close()
+		return nil, err
+	}
+	select {
+	case c.conns <- conn:
+	default:
+		_ = conn.Close()
+	}
+	return v, err
+}
+
+// redisError is an error reply, the connection is still usable.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		b.WriteString("$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n")
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read parses a single reply: a string, an integer, nil or a list of those.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		out := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply: %q", line)
+	}
+}
@@ -0,0 +1,103 @@
+package traefik_plugin_s3_auth
+
+import (
+	"context"
+	"sync"
+)
+
+// registry shares process-wide resources between the middleware instances of the same configuration, eg: the
+// credential stores and their refresh. It counts the instances using each resource by middleware name, and stops and
+// drops a resource once none uses it: when the context of the last one is done, or when a configuration change moves
+// their name to another resource. Otherwise every configuration reload would leave a resource refreshing forever.
+type registry struct {
+	mu      sync.Mutex
+	entries map[string]*registryEntry
+	// owners is the id of the resource each middleware name uses.
+	owners map[string]string
+}
+
+type registryEntry struct {
+	value interface{}
+	stop  func()
+	users map[string]int
+}
+
+func newRegistry() *registry {
+	return &registry{entries: map[string]*registryEntry{}, owners: map[string]string{}}
+}
+
+// acquire returns the resource of the id for the middleware, creating it on first use. The stop function returned by
+// create, if any, is called once the resource is dropped.
+func (r *registry) acquire(ctx context.Context, id, name string, create func() (interface{}, func(), error)) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[id]
+	if !ok {
+		v, stop, err := create()
+		if err != nil {
+			return nil, err
+		}
+		e = &registryEntry{value: v, stop: stop, users: map[string]int{}}
+		r.entries[id] = e
+	}
+	if prev, ok := r.owners[name]; ok && prev != id {
+		r.leaveLocked(name)
+	}
+	r.owners[name] = id
+	e.users[name]++
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			r.release(id, name, e)
+		}()
+	}
+	return e.value, nil
+}
+
+// leave drops the uses of the resource of the middleware name, eg: once its configuration no longer has a denylist.
+func (r *registry) leave(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.leaveLocked(name)
+}
+
+func (r *registry) leaveLocked(name string) {
+	id, ok := r.owners[name]
+	if !ok {
+		return
+	}
+	delete(r.owners, name)
+	if e := r.entries[id]; e != nil {
+		delete(e.users, name)
+		r.dropUnused(id, e)
+	}
+}
+
+// release drops a use of the resource by the middleware, unless its name already moved to another resource.
+func (r *registry) release(id, name string, e *registryEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.entries[id] != e || e.users[name] == 0 {
+		return
+	}
+	if e.users[name]--; e.users[name] == 0 {
+		delete(e.users, name)
+		if r.owners[name] == id {
+			delete(r.owners, name)
+		}
+	}
+	r.dropUnused(id, e)
+}
+
+func (r *registry) dropUnused(id string, e *registryEntry) {
+	if len(e.users) > 0 {
+		return
+	}
+	delete(r.entries, id)
+	if e.stop != nil {
+		e.stop()
+	}
+}
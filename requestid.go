@@ -0,0 +1,123 @@
+package traefik_plugin_s3_auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// RequestIDContextKey holds the request id (string) minted for the request in the request context, eg:
+// `4442587FB7D0A2F9`.
+const RequestIDContextKey contextKey = "s3auth.requestId"
+
+// hostIDContextKey holds the extended request id (string) minted along the request id.
+const hostIDContextKey contextKey = "s3auth.hostId"
+
+// CorrelationIDContextKey holds the correlation id (string) of the request in the request context, the one the client
+// sent in the `requestIdHeader` or a minted one.
+const CorrelationIDContextKey contextKey = "s3auth.correlationId"
+
+const (
+	requestIDHeader = "X-Amz-Request-Id"
+	hostIDHeader    = "X-Amz-Id-2"
+)
+
+// maxCorrelationIDLength keeps the correlation ids usable as metric exemplars, whose labels are limited to 128
+// characters.
+const maxCorrelationIDLength = 64
+
+// newRequestIDs returns an S3 style request id and extended request id, eg: `4442587FB7D0A2F9`.
+func newRequestIDs() (string, string) {
+	b := make([]byte, 40)
+	if _, err := rand.Read(b); err != nil {
+		return "", ""
+	}
+	return strings.ToUpper(hex.EncodeToString(b[:8])), base64.StdEncoding.EncodeToString(b[8:])
+}
+
+// validCorrelationID reports whether the correlation id a client sent can be logged and forwarded as is, eg: a UUID.
+func validCorrelationID(id string) bool {
+	if id == "" || len(id) > maxCorrelationIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("-_.:/+=@", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// correlationID returns the correlation id of the request, empty without a `requestIdHeader`.
+func correlationID(req *http.Request) string {
+	id, _ := req.Context().Value(CorrelationIDContextKey).(string)
+	return id
+}
+
+// traceID returns the id identifying the request across the logs, eg: for the metric exemplars. The correlation id
+// is preferred, since the clients know it.
+func traceID(req *http.Request) string {
+	if id := correlationID(req); id != "" {
+		return id
+	}
+	id, _ := req.Context().Value(RequestIDContextKey).(string)
+	return id
+}
+
+// errorIDs returns the request ids of the request, or new ones for its error response when `requestIds` is disabled so
+// every error can still be traced in the logs.
+func errorIDs(rw http.ResponseWriter, req *http.Request) (string, string) {
+	if id, ok := req.Context().Value(RequestIDContextKey).(string); ok {
+		hostID, _ := req.Context().Value(hostIDContextKey).(string)
+		return id, hostID
+	}
+	id, hostID := newRequestIDs()
+	rw.Header().Set(requestIDHeader, id)
+	rw.Header().Set(hostIDHeader, hostID)
+	return id, hostID
+}
+
+// requestIDWriter sets the request ids and the correlation id on the response, unless the backend already set its own.
+type requestIDWriter struct {
+	http.ResponseWriter
+	id, hostID        string
+	correlationHeader string
+	correlationID     string
+	stamped           bool
+}
+
+func (w *requestIDWriter) stamp() {
+	if w.stamped {
+		return
+	}
+	w.stamped = true
+	h := w.Header()
+	if w.id != "" && h.Get(requestIDHeader) == "" {
+		h.Set(requestIDHeader, w.id)
+		h.Set(hostIDHeader, w.hostID)
+	}
+	if w.correlationHeader != "" && h.Get(w.correlationHeader) == "" {
+		h.Set(w.correlationHeader, w.correlationID)
+	}
+}
+
+func (w *requestIDWriter) WriteHeader(status int) {
+	w.stamp()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *requestIDWriter) Write(p []byte) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *requestIDWriter) Flush() {
+	w.stamp()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,208 @@
+package traefik_plugin_s3_auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestQuota limits the number of requests of a credential each UTC day or month.
+type RequestQuota struct {
+	// Period is either `day` (the default) or `month`.
+	Period string `json:"period,omitempty"`
+	// Limit is the allowed number of requests per period, eg: `1000000`.
+	Limit int64 `json:"limit,omitempty"`
+}
+
+func checkRequestQuota(q *RequestQuota) error {
+	if q == nil {
+		return nil
+	}
+	if q.Period != "" && q.Period != quotaDay && q.Period != quotaMonth {
+		return fmt.Errorf("unsupported quota period: %q", q.Period)
+	}
+	if q.Limit <= 0 {
+		return errors.New("the request quota `limit` must be positive")
+	}
+	return nil
+}
+
+// window returns the current period, eg: `2025-07`, and how long its counter must be kept.
+func (q *RequestQuota) window(now time.Time) (string, time.Duration) {
+	now = now.UTC()
+	end := periodEnd(q.Period, now)
+	if q.Period == quotaMonth {
+		return now.Format("2006-01"), end.Sub(now) + day
+	}
+	return now.Format("2006-01-02"), end.Sub(now) + day
+}
+
+// counters persist the request quota counters, in memory or in Redis.
+type counters interface {
+	// incr increments the counter and returns its new value, the counter is dropped after ttl unless it's 0.
+	incr(key string, ttl time.Duration) (int64, error)
+	get(key string) (int64, error)
+	del(key string) error
+}
+
+type memoryCounters struct {
+	mu        sync.Mutex
+	counts    map[string]memoryCount
+	lastSweep time.Time
+}
+
+type memoryCount struct {
+	n       int64
+	expires time.Time
+}
+
+func (m *memoryCounters) incr(key string, ttl time.Duration) (int64, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Counters of past periods are never read again, drop them once in a while.
+	if now.Sub(m.lastSweep) > time.Hour {
+		for k, c := range m.counts {
+			if !c.expires.IsZero() && now.After(c.expires) {
+				delete(m.counts, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	c := m.counts[key]
+	c.n++
+	if c.n == 1 && ttl > 0 {
+		c.expires = now.Add(ttl)
+	}
+	m.counts[key] = c
+	return c.n, nil
+}
+
+func (m *memoryCounters) get(key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counts[key].n, nil
+}
+
+func (m *memoryCounters) del(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.counts, key)
+	return nil
+}
+
+type redisCounters struct {
+	client *redisClient
+	prefix string
+}
+
+func (r *redisCounters) incr(key string, ttl time.Duration) (int64, error) {
+	v, err := r.client.do("INCR", r.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected INCR reply: %v", v)
+	}
+	if n == 1 && ttl > 0 {
+		if _, err := r.client.do("EXPIRE", r.prefix+key, strconv.Itoa(int(ttl.Seconds()))); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (r *redisCounters) get(key string) (int64, error) {
+	v, err := r.client.do("GET", r.prefix+key)
+	if err != nil || v == nil {
+		return 0, err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected GET reply: %v", v)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func (r *redisCounters) del(key string) error {
+	_, err := r.client.do("DEL", r.prefix+key)
+	return err
+}
+
+// namespacedCounters scopes the keys of counters shared with other middlewares, so the quotas and the write-once keys
+// of a middleware don't collide with those of another one using the same Redis, eg: in front of another backend.
+type namespacedCounters struct {
+	counters
+	namespace string
+}
+
+func (n *namespacedCounters) incr(key string, ttl time.Duration) (int64, error) {
+	return n.counters.incr(n.namespace+key, ttl)
+}
+
+func (n *namespacedCounters) get(key string) (int64, error) {
+	return n.counters.get(n.namespace + key)
+}
+
+func (n *namespacedCounters) del(key string) error {
+	return n.counters.del(n.namespace + key)
+}
+
+var (
+	countersMu sync.Mutex
+	// sharedCounters are keyed by the Redis connection settings, or empty for the in-memory counters.
+	sharedCounters = map[string]counters{}
+)
+
+// newCounters returns the counters of the middleware name, its keys are prefixed with the name, eg:
+// `s3auth:s3-auth:requests:AKIA...:2025-07`. Middlewares with the same name, eg: the instances of each router and
+// replica, share them.
+func newCounters(config *RedisConfig, name string) (counters, error) {
+	id := ""
+	if config != nil {
+		password := sha256.Sum256([]byte(config.Password))
+		id = strings.Join([]string{config.Address, strconv.Itoa(config.DB), config.Username, hex.EncodeToString(password[:]), config.Timeout, config.KeyPrefix}, "/")
+	}
+	countersMu.Lock()
+	defer countersMu.Unlock()
+
+	c, ok := sharedCounters[id]
+	if !ok {
+		c = &memoryCounters{counts: map[string]memoryCount{}}
+		if config != nil {
+			client, err := newRedisClient(config)
+			if err != nil {
+				return nil, err
+			}
+			prefix := config.KeyPrefix
+			if prefix == "" {
+				prefix = defaultRedisKeyPrefix
+			}
+			c = &redisCounters{client: client, prefix: prefix}
+		}
+		sharedCounters[id] = c
+	}
+	return &namespacedCounters{counters: c, namespace: name + ":"}, nil
+}
+
+// RequestQuotaStatus is the request count of a credential for the current period.
+type RequestQuotaStatus struct {
+	Period string `json:"period"`
+	Count  int64  `json:"count"`
+	Limit  int64  `json:"limit"`
+	Error  string `json:"error,omitempty"`
+}
+
+func requestsKey(accessKeyID, period string) string {
+	return "requests:" + accessKeyID + ":" + period
+}
@@ -0,0 +1,250 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+// fakeRedis speaks just enough RESP for the request quota counters.
+type fakeRedis struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	values   map[string]int64
+	expires  map[string]string
+	password string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{ln: ln, values: map[string]int64{}, expires: map[string]string{}, password: password}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	authed := r.password == ""
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			if _, err := br.ReadString('\n'); err != nil {
+				return
+			}
+			arg, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			args = append(args, strings.TrimSuffix(arg, "\r\n"))
+		}
+		r.mu.Lock()
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			authed = args[len(args)-1] == r.password
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "INCR":
+			r.values[args[1]]++
+			reply = ":" + strconv.FormatInt(r.values[args[1]], 10) + "\r\n"
+		case args[0] == "EXPIRE":
+			r.expires[args[1]] = args[2]
+			reply = ":1\r\n"
+		case args[0] == "GET":
+			v, ok := r.values[args[1]]
+			reply = "$-1\r\n"
+			if ok {
+				s := strconv.FormatInt(v, 10)
+				reply = "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		r.mu.Unlock()
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func TestRequestQuota(t *testing.T) {
+	redis := newFakeRedis(t, "secret")
+	tc := []struct {
+		name  string
+		redis *plugin.RedisConfig
+	}{
+		{
+			name: "memory",
+		},
+		{
+			name:  "redis",
+			redis: &plugin.RedisConfig{Address: redis.ln.Addr().String(), Password: "secret"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.AccessKeyID = "AKIAREQUESTS" + strings.ToUpper(tt.name)
+			cred.RequestQuota = &plugin.RequestQuota{Period: "month", Limit: 2}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.Redis = tt.redis
+			p := newTestPlugin(t, cfg)
+
+			for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusForbidden} {
+				recorder := httptest.NewRecorder()
+				p.ServeHTTP(recorder, newSignedRequest(t, http.MethodGet, "/bucket/object.txt", cred))
+				if recorder.Code != expected {
+					t.Errorf("request %d: expected status code %d, got %d", i, expected, recorder.Code)
+				}
+				if expected == http.StatusForbidden && recorder.Header().Get("Retry-After") != "1880100" {
+					t.Errorf("expected to retry once the month ends, got %q", recorder.Header().Get("Retry-After"))
+				}
+			}
+			s := p.CredentialStatus()
+			if len(s) != 1 || s[0].Requests == nil {
+				t.Fatalf("expected a request quota status, got %+v", s)
+			}
+			if r := *s[0].Requests; r.Period != "2025-07" || r.Count != 3 || r.Limit != 2 || r.Error != "" {
+				t.Errorf("unexpected request quota status: %+v", r)
+			}
+		})
+	}
+
+	key := "s3auth:s3-plugin:requests:AKIAREQUESTSREDIS:2025-07"
+	redis.mu.Lock()
+	defer redis.mu.Unlock()
+	if redis.values[key] != 3 {
+		t.Errorf("expected 3 requests in redis, got %d", redis.values[key])
+	}
+	// Until the end of July plus a day.
+	if redis.expires[key] != "1966500" {
+		t.Errorf("expected the counter to expire after 1966500s, got %q", redis.expires[key])
+	}
+}
+
+func TestRequestQuotaNamespaces(t *testing.T) {
+	redis := newFakeRedis(t, "secret")
+	tc := []struct {
+		name  string
+		redis *plugin.RedisConfig
+		other *plugin.RedisConfig
+	}{
+		{
+			name: "memory",
+		},
+		{
+			name:  "redis",
+			redis: &plugin.RedisConfig{Address: redis.ln.Addr().String(), Password: "secret"},
+			// Same server but another password, it must not reuse the authenticated connections.
+			other: &plugin.RedisConfig{Address: redis.ln.Addr().String(), Password: "wrong"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.AccessKeyID = "AKIANAMESPACES" + strings.ToUpper(tt.name)
+			cred.RequestQuota = &plugin.RequestQuota{Limit: 1}
+			newPlugin := func(name string, redis *plugin.RedisConfig) *plugin.Plugin {
+				cfg := plugin.CreateConfig()
+				cfg.Credentials = []*plugin.Credential{cred}
+				cfg.Redis = redis
+				handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p := handler.(*plugin.Plugin)
+				p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+				return p
+			}
+			// The instances of the same middleware, eg: one per router, share the counters, other middlewares don't.
+			a, sameName, other := newPlugin("router-a", tt.redis), newPlugin("router-a", tt.redis), newPlugin("router-b", tt.redis)
+			for i, step := range []struct {
+				p        *plugin.Plugin
+				expected int
+			}{
+				{p: a, expected: http.StatusOK},
+				{p: sameName, expected: http.StatusForbidden},
+				{p: other, expected: http.StatusOK},
+			} {
+				recorder := httptest.NewRecorder()
+				step.p.ServeHTTP(recorder, newSignedRequest(t, http.MethodGet, "/bucket/object.txt", cred))
+				if recorder.Code != step.expected {
+					t.Errorf("request %d: expected status code %d, got %d", i, step.expected, recorder.Code)
+				}
+			}
+
+			if tt.other == nil {
+				return
+			}
+			s := newPlugin("router-c", tt.other).CredentialStatus()
+			if len(s) != 1 || s[0].Requests == nil || s[0].Requests.Error == "" {
+				t.Errorf("expected the redis authentication error in the status, got %+v", s)
+			}
+		})
+	}
+}
+
+func TestRequestQuotaRedisUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	cred := validCredential()
+	cred.AccessKeyID = "AKIAREQUESTSDOWN"
+	cred.RequestQuota = &plugin.RequestQuota{Limit: 1}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.Redis = &plugin.RedisConfig{Address: addr, Timeout: "100ms"}
+	p := newTestPlugin(t, cfg)
+
+	// Quotas fail open.
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, newSignedRequest(t, http.MethodGet, "/bucket/object.txt", cred))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+		}
+	}
+	if s := p.CredentialStatus(); len(s) != 1 || s[0].Requests == nil || s[0].Requests.Error == "" {
+		t.Errorf("expected the redis error in the status, got %+v", s)
+	}
+}
@@ -0,0 +1,89 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// s3Resource is the bucket and object key a request targets.
+type s3Resource struct {
+	Bucket string
+	Key    string
+}
+
+// resolveResource extracts the bucket and key from virtual-host-style requests, eg: `bucket.s3.example.com/key`
+// when `s3.example.com` is one of the domains, or from path-style requests otherwise, eg: `s3.example.com/bucket/key`.
+func resolveResource(req *http.Request, domains []string) s3Resource {
+	p := strings.TrimPrefix(req.URL.Path, "/")
+	if bucket, ok := virtualHostBucket(req, domains); ok {
+		return s3Resource{Bucket: bucket, Key: p}
+	}
+	bucket, key, _ := strings.Cut(p, "/")
+	return s3Resource{Bucket: bucket, Key: key}
+}
+
+// copySource returns the source of copies in the `x-amz-copy-source` header, eg: `/bucket/key?versionId=1`, and false
+// when the request isn't a copy.
+func copySource(req *http.Request) (s3Resource, bool, error) {
+	h := req.Header.Get("X-Amz-Copy-Source")
+	if h == "" {
+		return s3Resource{}, false, nil
+	}
+	src, _, _ := strings.Cut(strings.TrimPrefix(h, "/"), "?")
+	src, err := url.PathUnescape(src)
+	if err != nil {
+		return s3Resource{}, true, errors.New("invalid copy source")
+	}
+	bucket, key, _ := strings.Cut(src, "/")
+	return s3Resource{Bucket: bucket, Key: key}, true, nil
+}
+
+// virtualHostBucket returns the bucket of virtual-host-style requests for one of the domains.
+func virtualHostBucket(req *http.Request, domains []string) (string, bool) {
+	host := strings.ToLower(req.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, d := range domains {
+		if bucket := strings.TrimSuffix(host, "."+strings.ToLower(d)); bucket != host && bucket != "" {
+			return bucket, true
+		}
+	}
+	return "", false
+}
+
+// bucketPlaceholder is replaced by the bucket in virtual-host-style backend hosts, eg: `{bucket}.s3.amazonaws.com`.
+const bucketPlaceholder = "{bucket}"
+
+func checkBackendHost(host string) error {
+	if strings.ContainsAny(host, "/?# ") || strings.Count(host, bucketPlaceholder) > 1 || strings.HasSuffix(host, bucketPlaceholder) {
+		return fmt.Errorf("invalid host %q, eg: `minio.internal:9000` or `{bucket}.s3.eu-central-1.amazonaws.com`", host)
+	}
+	return nil
+}
+
+// rewriteHost points a validated request at the backend host. With a `{bucket}` placeholder requests are sent
+// virtual-host-style, otherwise path-style, whatever the style the client signed with.
+func rewriteHost(req *http.Request, host string, domains []string) {
+	res := resolveResource(req, domains)
+	p := "/" + res.Bucket
+	if res.Key != "" || (res.Bucket != "" && strings.HasSuffix(req.URL.Path, "/")) {
+		p += "/" + res.Key
+	}
+	if strings.Contains(host, bucketPlaceholder) {
+		if res.Bucket == "" {
+			host = strings.TrimPrefix(strings.Replace(host, bucketPlaceholder, "", 1), ".")
+		} else {
+			host = strings.Replace(host, bucketPlaceholder, res.Bucket, 1)
+		}
+		p = "/" + res.Key
+	}
+	req.Host = host
+	req.URL.Host = host
+	req.URL.Path = p
+	req.URL.RawPath = ""
+}
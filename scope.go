@@ -0,0 +1,271 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// accessScope restricts what a credential can do once its signature is valid. An empty list allows everything.
+type accessScope struct {
+	Prefixes []string `json:"pp,omitempty"`
+	Methods  []string `json:"pm,omitempty"`
+	Buckets  []string `json:"pb,omitempty"`
+	CIDRs    []string `json:"pc,omitempty"`
+	Keys     []string `json:"pk,omitempty"`
+	// Delimiter requires listings to set a `delimiter`.
+	Delimiter bool `json:"pd,omitempty"`
+}
+
+// checkSource is separate from check since it also applies to STS calls.
+func (s accessScope) checkSource(ip string) error {
+	if len(s.CIDRs) > 0 && !inCIDRs(s.CIDRs, ip) {
+		return fmt.Errorf("source ip %q is outside of the allowed cidrs", ip)
+	}
+	return nil
+}
+
+// check verifies the request is within the scope. Listings are checked as the path of their `prefix` parameter, eg:
+// `GET /bucket?prefix=tenant-a/` as `/bucket/tenant-a/`, so they can't enumerate keys outside of the prefixes, and
+// copies as both their destination and their `x-amz-copy-source`, so they can't read objects outside of them.
+func (s accessScope) check(req *http.Request, res s3Resource, op s3Operation) error {
+	src, copied, err := copySource(req)
+	if err != nil {
+		return err
+	}
+	if len(s.Methods) > 0 && !containsFold(s.Methods, req.Method) {
+		return fmt.Errorf("method %s is not allowed", req.Method)
+	}
+	if len(s.Buckets) > 0 && !matchesAny(s.Buckets, res.Bucket) {
+		return fmt.Errorf("bucket %q is not allowed", res.Bucket)
+	}
+	if copied && len(s.Buckets) > 0 && !matchesAny(s.Buckets, src.Bucket) {
+		return fmt.Errorf("copy source bucket %q is not allowed", src.Bucket)
+	}
+	if len(s.Keys) > 0 {
+		if err := s.checkKeys(req, res, op); err != nil {
+			return err
+		}
+	}
+	p := req.URL.Path
+	if listings[op.Name] {
+		q := req.URL.Query()
+		if s.Delimiter && q.Get("delimiter") == "" {
+			return fmt.Errorf("%s must set a delimiter", op.Name)
+		}
+		p = strings.TrimSuffix(p, "/") + "/" + q.Get("prefix")
+	}
+	if len(s.Prefixes) > 0 && !pathHasAnyPrefix(p, s.Prefixes) {
+		return fmt.Errorf("path %q is outside of the allowed prefixes", p)
+	}
+	if sp := "/" + src.Bucket + "/" + src.Key; copied && len(s.Prefixes) > 0 && !pathHasAnyPrefix(sp, s.Prefixes) {
+		return fmt.Errorf("copy source %q is outside of the allowed prefixes", sp)
+	}
+	return nil
+}
+
+// checkKeys verifies the keys of the request match the allowed keys, including the bucket requests writing keys: the
+// keys of a `DeleteObjects` body are checked each, and `PostObject` is denied since its key is in a form field. The
+// key of the `x-amz-copy-source` of copies must match too.
+func (s accessScope) checkKeys(req *http.Request, res s3Resource, op s3Operation) error {
+	src, copied, err := copySource(req)
+	if err != nil {
+		return err
+	}
+	if copied && !matchesAnyKey(s.Keys, src.Key) {
+		return fmt.Errorf("copy source key %q does not match the allowed keys", src.Key)
+	}
+	switch {
+	case op.Name == "PostObject":
+		return errors.New("PostObject is not allowed with allowed keys")
+	case op.Name == "DeleteObjects":
+		d, _, err := readDelete(req)
+		if err != nil {
+			return err
+		}
+		for _, o := range d.Objects {
+			if !matchesAnyKey(s.Keys, o.Key) {
+				return fmt.Errorf("key %q does not match the allowed keys", o.Key)
+			}
+		}
+	case res.Key != "" && !matchesAnyKey(s.Keys, res.Key):
+		return fmt.Errorf("key %q does not match the allowed keys", res.Key)
+	}
+	return nil
+}
+
+// pathHasAnyPrefix checks both the raw and the cleaned path, so dot segments can't escape a prefix on backends that
+// resolve them, eg: `/tenant-a/../tenant-b/key`.
+func pathHasAnyPrefix(p string, prefixes []string) bool {
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return hasAnyPrefix(p, prefixes) && hasAnyPrefix(cleaned, prefixes)
+}
+
+// normalizePrefixes validates the prefixes and drops a trailing `*`, eg: `/tenant-a/*` becomes `/tenant-a/`.
+func normalizePrefixes(prefixes []string) ([]string, error) {
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+	out := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("prefix must start with a slash: %q", p)
+		}
+		out = append(out, strings.TrimSuffix(p, "*"))
+	}
+	return out, nil
+}
+
+// narrow returns a scope that is at most as permissive as both s and child. The cidrs, keys and delimiter are always
+// inherited.
+func (s accessScope) narrow(child accessScope) (accessScope, error) {
+	out := s
+	if len(child.Methods) > 0 {
+		for _, m := range child.Methods {
+			if len(s.Methods) > 0 && !containsFold(s.Methods, m) {
+				return accessScope{}, fmt.Errorf("method %s is not allowed for the parent credential", m)
+			}
+		}
+		out.Methods = child.Methods
+	}
+	if len(child.Prefixes) > 0 {
+		for _, p := range child.Prefixes {
+			if len(s.Prefixes) > 0 && !hasAnyPrefix(p, s.Prefixes) {
+				return accessScope{}, fmt.Errorf("prefix %q is outside of the parent credential prefixes", p)
+			}
+		}
+		out.Prefixes = child.Prefixes
+	}
+	if len(child.Buckets) > 0 {
+		for _, b := range child.Buckets {
+			if len(s.Buckets) > 0 && !narrowsBucket(s.Buckets, b) {
+				return accessScope{}, fmt.Errorf("bucket %q is not allowed for the parent credential", b)
+			}
+		}
+		out.Buckets = child.Buckets
+	}
+	return out, nil
+}
+
+// narrowsBucket reports whether the child bucket pattern is within the parent ones: either a literal name matching one
+// of them, or exactly one of them. Matching a pattern against another one would let `logs-*` through `logs-?`.
+func narrowsBucket(patterns []string, b string) bool {
+	if strings.ContainsAny(b, `*?[\`) {
+		for _, p := range patterns {
+			if p == b {
+				return true
+			}
+		}
+		return false
+	}
+	return matchesAny(patterns, b)
+}
+
+// matchesAny reports whether v matches one of the glob patterns, eg: `logs-*`.
+func matchesAny(patterns []string, v string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeBuckets validates the bucket glob patterns.
+func normalizeBuckets(buckets []string) ([]string, error) {
+	for _, b := range buckets {
+		if _, err := path.Match(b, ""); err != nil || b == "" || strings.Contains(b, "/") {
+			return nil, fmt.Errorf("invalid bucket pattern: %q", b)
+		}
+	}
+	return buckets, nil
+}
+
+// regexPrefix marks key patterns that are regular expressions rather than globs, eg: `regex:^logs/[0-9]{4}/`.
+const regexPrefix = "regex:"
+
+// keyRegexps caches the compiled key patterns, scopes are also decoded from session tokens on every request.
+var keyRegexps sync.Map
+
+// normalizeKeys validates the key glob patterns, eg: `logs/2*.gz`, and regular expressions.
+func normalizeKeys(keys []string) ([]string, error) {
+	for _, k := range keys {
+		if re := strings.TrimPrefix(k, regexPrefix); re != k {
+			if _, err := regexp.Compile(re); err != nil {
+				return nil, fmt.Errorf("invalid key regex %q: %w", re, err)
+			}
+			continue
+		}
+		if _, err := path.Match(k, ""); err != nil || k == "" {
+			return nil, fmt.Errorf("invalid key pattern: %q", k)
+		}
+	}
+	return keys, nil
+}
+
+func matchesAnyKey(patterns []string, key string) bool {
+	for _, p := range patterns {
+		re := strings.TrimPrefix(p, regexPrefix)
+		if re == p {
+			if ok, _ := path.Match(p, key); ok {
+				return true
+			}
+			continue
+		}
+		v, ok := keyRegexps.Load(re)
+		if !ok {
+			c, err := regexp.Compile(re)
+			if err != nil {
+				continue
+			}
+			v, _ = keyRegexps.LoadOrStore(re, c)
+		}
+		if v.(*regexp.Regexp).MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, v string) bool {
+	for _, s := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeMethods validates and upper-cases the methods.
+func normalizeMethods(methods []string) ([]string, error) {
+	if len(methods) == 0 {
+		return nil, nil
+	}
+	out := make([]string, 0, len(methods))
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		switch m {
+		case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodOptions, http.MethodPatch:
+			out = append(out, m)
+		default:
+			return nil, fmt.Errorf("unsupported method: %q", m)
+		}
+	}
+	return out, nil
+}
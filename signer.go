@@ -17,29 +17,74 @@ import (
 	"time"
 )
 
-func validateHeader(req *http.Request, headerName string, creds []*Credential, now time.Time) error {
+// authChallenge is the `WWW-Authenticate` challenge of the requests without an authorization header.
+const authChallenge = "AWS4-HMAC-SHA256"
+
+// The validation failures, mapped to the S3 errors in authErrors.
+var (
+	errMissingAuthorization   = errors.New("missing authorization header")
+	errMalformedAuthorization = errors.New("malformed authorization header")
+	errInvalidAccessKeyID     = errors.New("invalid access key id")
+	errSignatureMismatch      = errors.New("signature mismatch")
+	errRequestTimeTooSkewed   = errors.New("request time too skewed")
+	// errKeyRegion is a known access key used in another region, telling the client the key exists.
+	errKeyRegion = fmt.Errorf("%w: wrong region", errMalformedAuthorization)
+)
+
+func validateHeader(req *http.Request, headerName string, store *credentialStore, sts *stsIssuer, bodies *bodyBuffer, now time.Time) (*Credential, error) {
 	h := req.Header.Get(headerName)
 
 	// First check if the header can be parsed.
+	if h == "" {
+		return nil, errMissingAuthorization
+	}
 	a, err := parseHeader(h)
 	if err != nil {
-		return fmt.Errorf("failed to parse authorization header: %w", err)
+		return nil, fmt.Errorf("%w: %v", errMalformedAuthorization, err)
 	}
 
+	creds, err := store.active()
+	if err != nil {
+		return nil, err
+	}
 	var cred *Credential
-	for _, c := range creds {
-		if c.AccessKeyID == a.AccessKeyID && c.Region == a.Region && c.Service == a.Service {
-			cred = c
-			break
+	service := a.Service
+	switch token := req.Header.Get("X-Amz-Security-Token"); {
+	case token != "" && sts != nil:
+		if cred, err = sts.temporary(token, a.AccessKeyID, creds, now); err != nil {
+			return nil, err
+		}
+		if cred.Region != a.Region || cred.Service != a.Service {
+			return nil, fmt.Errorf("%w: temporary credential scope mismatch, region: %q, service: %q", errMalformedAuthorization, a.Region, a.Service)
 		}
+	case sts != nil && a.Service == "sts" && req.URL.Path == sts.path:
+		// SDKs sign STS calls for the `sts` service, so accept any credential with the same access key id and region.
+		for _, c := range creds {
+			if c.AccessKeyID == a.AccessKeyID && c.Region == a.Region {
+				cred = c
+				break
+			}
+		}
+	default:
+		cred = store.lookup(creds, a.AccessKeyID, a.Region, a.Service)
 	}
 	if cred == nil {
-		return fmt.Errorf("unknown access key id: %q, region: %q, service: %q", a.AccessKeyID, a.Region, a.Service)
+		// Regions are matched exactly, so a key can't be used with a scope naming another region. Say so, since it is
+		// usually a misconfigured client rather than a stolen key.
+		for _, c := range creds {
+			if c.AccessKeyID == a.AccessKeyID && c.Service == a.Service {
+				return nil, fmt.Errorf("%w: access key id %q is not allowed in region %q, only in %q", errKeyRegion, a.AccessKeyID, a.Region, c.Region)
+			}
+		}
+		return nil, fmt.Errorf("%w: unknown %q, region: %q, service: %q", errInvalidAccessKeyID, a.AccessKeyID, a.Region, a.Service)
+	}
+	if !cred.notAfter.IsZero() && now.After(cred.notAfter) {
+		return nil, fmt.Errorf("%w: %q expired at %s", errInvalidAccessKeyID, cred.AccessKeyID, cred.NotAfter)
 	}
 
 	q, err := url.ParseQuery(req.URL.RawQuery)
 	if err != nil {
-		return fmt.Errorf("failed to parse query parameters: %w", err)
+		return nil, fmt.Errorf("failed to parse query parameters: %w", err)
 	}
 	qp := map[string]string{}
 	for k, v := range q {
@@ -50,37 +95,108 @@ func validateHeader(req *http.Request, headerName string, creds []*Credential, n
 	for _, k := range a.SignedHeaders {
 		v, ok := resolveValue(k, req)
 		if !ok {
-			return fmt.Errorf("missing signed header: %q", k)
+			return nil, fmt.Errorf("%w: missing signed header %q", errSignatureMismatch, k)
 		}
 		sh[k] = v
 	}
 	// Check if x-amz-date is present in the signed headers.
 	if d := sh["x-amz-date"]; d != "" {
-		if err := checkTime(d, now, 15*time.Minute); err != nil {
-			return fmt.Errorf("request time too skewed: %w", err)
+		if err := checkTime(d, now, maxSkew); err != nil {
+			return nil, err
 		}
 	}
 
+	payloadHash, ok := sh["x-amz-content-sha256"]
+	if !ok {
+		if payloadHash, err = bodies.hash(req); err != nil {
+			return nil, fmt.Errorf("failed to hash payload: %w", err)
+		}
+	}
+
+	signAs := *cred
+	signAs.Service = service
 	s3 := &s3request{
-		cred:          *cred,
+		cred:          signAs,
+		keys:          store,
 		method:        req.Method,
 		uri:           req.URL.Path,
 		date:          a.Date,
 		queryParams:   qp,
 		signedHeaders: sh,
+		payloadHash:   payloadHash,
 	}
 
-	// Then try to recreate the authorization header.
+	// Then try to recreate the authorization header. The comparisons take constant time so the signature can't be
+	// guessed byte by byte.
 	newa := s3.sign()
-	if nh, nhs := newa.ToString(""), newa.ToString(" "); h != nh && h != nhs {
-		for k, v := range sh {
-			fmt.Printf("- signed header %s: %s\n", k, v)
+	if nh, nhs := newa.ToString(""), newa.ToString(" "); !hmac.Equal([]byte(h), []byte(nh)) && !hmac.Equal([]byte(h), []byte(nhs)) {
+		se := &signatureError{
+			accessKeyID:      a.AccessKeyID,
+			canonicalRequest: s3.redactedRequestString(),
+			stringToSign:     s3.stringToSignV4(),
+			diverged:         divergedComponent(a, sh),
+		}
+		if logs.enabled(levelDebug) {
+			se.diff = canonicalDiff(s3, req, a)
 		}
-		return fmt.Errorf("signature mismatch: expected %q or %q, got %q", nh, nhs, h)
+		return nil, se
 	}
 
 	// Signature is valid.
-	return nil
+	return cred, nil
+}
+
+// signatureError is a SignatureDoesNotMatch failure. It never includes the signatures, only the canonical request and
+// the string to sign, which are logged with `debugSignatures` and returned with `debugErrors`.
+type signatureError struct {
+	accessKeyID      string
+	canonicalRequest string
+	stringToSign     string
+	// diverged is the likeliest component the client computed differently.
+	diverged string
+	// diff lists the components of the canonical request the client likely built differently, with debug logs.
+	diff []canonicalField
+}
+
+// divergedComponent guesses what the client signed differently: the date of the credential scope when it isn't the
+// day of `x-amz-date`, otherwise the canonical request or the secret.
+func divergedComponent(a authorization, signed map[string]string) string {
+	if d := signed["x-amz-date"]; len(d) >= 8 && d[:8] != a.Date {
+		return "CredentialScope"
+	}
+	if _, ok := signed["host"]; !ok {
+		return "SignedHeaders"
+	}
+	return "CanonicalRequestOrSecret"
+}
+
+func (e *signatureError) Error() string {
+	return fmt.Sprintf("%v for access key id %q", errSignatureMismatch, e.accessKeyID)
+}
+
+func (e *signatureError) Unwrap() error {
+	return errSignatureMismatch
+}
+
+// emptyHash is the hex encoded sha256 of an empty payload.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// maxSkew is how far the `x-amz-date` of a request can be from the current time, like S3.
+const maxSkew = 15 * time.Minute
+
+// skewError is a RequestTimeTooSkewed failure, with the times the SDKs use to correct the clock offset of the client.
+type skewError struct {
+	requestTime string
+	serverTime  time.Time
+	maxSkew     time.Duration
+}
+
+func (e *skewError) Error() string {
+	return fmt.Sprintf("%v: request time %s, server time %s", errRequestTimeTooSkewed, e.requestTime, e.serverTime.UTC().Format(time.RFC3339))
+}
+
+func (e *skewError) Unwrap() error {
+	return errRequestTimeTooSkewed
 }
 
 func checkTime(date string, now time.Time, max time.Duration) error {
@@ -88,9 +204,10 @@ func checkTime(date string, now time.Time, max time.Duration) error {
 	if err != nil {
 		return fmt.Errorf("failed to parse time from header: %w", err)
 	}
-	// Check if the difference between the current time and the header is less than the threshold.
-	if nmt := now.Sub(t); nmt > max {
-		return fmt.Errorf("request timestamp is too old: %v", nmt)
+	// Check if the difference between the current time and the header is less than the threshold, either way since
+	// the clock of the client may be ahead.
+	if d := now.Sub(t); d > max || d < -max {
+		return &skewError{requestTime: date, serverTime: now, maxSkew: max}
 	}
 	return nil
 }
@@ -175,10 +292,12 @@ const regexHeaderGroups = 8
 // https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
 type s3request struct {
 	cred          Credential
+	keys          *credentialStore
 	method        string
 	date          string
 	queryParams   map[string]string
 	signedHeaders map[string]string
+	payloadHash   string
 	uri           string
 }
 
@@ -187,11 +306,25 @@ func (s *s3request) requestString() string {
 	queryString := canonString(s.queryParams, "=", "&", true)
 	headers := canonString(s.signedHeaders, ":", "\n", false)
 	signedHeaders := strings.Join(sortedKeys(s.signedHeaders), ";")
-	hashedPayload := s.signedHeaders["x-amz-content-sha256"]
+	hashedPayload := s.payloadHash
 
 	return fmt.Sprintf("%s\n%s\n%s\n%s\n\n%s\n%s", s.method, s.uri, queryString, headers, signedHeaders, hashedPayload)
 }
 
+// redactedRequestString is the canonical request without the session token, for debugging.
+func (s *s3request) redactedRequestString() string {
+	if _, ok := s.signedHeaders["x-amz-security-token"]; !ok {
+		return s.requestString()
+	}
+	r := *s
+	r.signedHeaders = make(map[string]string, len(s.signedHeaders))
+	for k, v := range s.signedHeaders {
+		r.signedHeaders[k] = v
+	}
+	r.signedHeaders["x-amz-security-token"] = "REDACTED"
+	return r.requestString()
+}
+
 // https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html#create-string-to-sign
 func (s *s3request) stringToSignV4() string {
 	algorithm := "AWS4-HMAC-SHA256"
@@ -218,19 +351,14 @@ func (s *s3request) signatureV4() string {
 		date = amzDate
 	}
 
-	dateKey := hmac.New(sha256.New, []byte("AWS4"+s.cred.AccessSecretKey))
-	dateKey.Write([]byte(date[:8]))
-
-	dateRegionKey := hmac.New(sha256.New, dateKey.Sum(nil))
-	dateRegionKey.Write([]byte(s.cred.Region))
-
-	dateRegionServiceKey := hmac.New(sha256.New, dateRegionKey.Sum(nil))
-	dateRegionServiceKey.Write([]byte(s.cred.Service))
-
-	signingKey := hmac.New(sha256.New, dateRegionServiceKey.Sum(nil))
-	signingKey.Write([]byte("aws4_request"))
+	var signingKey []byte
+	if s.keys != nil {
+		signingKey = s.keys.signingKey(&s.cred, date[:8])
+	} else {
+		signingKey = deriveSigningKey(s.cred.AccessSecretKey, date[:8], s.cred.Region, s.cred.Service)
+	}
 
-	signatureV4 := hmac.New(sha256.New, signingKey.Sum(nil))
+	signatureV4 := hmac.New(sha256.New, signingKey)
 	signatureV4.Write([]byte(s.stringToSignV4()))
 
 	return hex.EncodeToString(signatureV4.Sum(nil))
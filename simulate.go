@@ -0,0 +1,126 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SimulationRequest is a request to dry-run, like the IAM policy simulator. The signature is not needed.
+type SimulationRequest struct {
+	AccessKeyID string `json:"accessKeyId"`
+	Method      string `json:"method"`
+	// Path with the optional query, eg: `/bucket/key?acl`.
+	Path string `json:"path"`
+	// Host is needed for virtual-host-style requests, eg: `bucket.s3.example.com`.
+	Host    string            `json:"host,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// ClientIP checks the `allowedCidrs` and the trusted CDN addresses of the geo header when set.
+	ClientIP string `json:"clientIp,omitempty"`
+}
+
+// SimulationResult is the decision for a simulated request.
+type SimulationResult struct {
+	Allowed   bool   `json:"allowed"`
+	Operation string `json:"operation,omitempty"`
+	Action    string `json:"action,omitempty"`
+	Resource  string `json:"resource,omitempty"`
+	// Stage is the check that denied the request: `credential`, `source`, `window`, `scope`, `policy` or `cedar`.
+	Stage  string `json:"stage,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Statement is the policy statement that decided, if any.
+	Statement *MatchedStatement `json:"statement,omitempty"`
+	// Grant is the id of the grant that allowed the request otherwise denied by the policies, if any.
+	Grant string `json:"grant,omitempty"`
+}
+
+// MatchedStatement identifies a statement of the `credential` or the `default` policy.
+type MatchedStatement struct {
+	Policy string `json:"policy"`
+	Index  int    `json:"index"`
+	Sid    string `json:"sid,omitempty"`
+	Effect string `json:"effect"`
+}
+
+// Simulate evaluates the access restrictions, the policy, the grants and the Cedar policies of the credential for the
+// request, without forwarding it. OPA and the authorization webhook are never called.
+func (p *Plugin) Simulate(r SimulationRequest) (SimulationResult, error) {
+	if r.Method == "" || !strings.HasPrefix(r.Path, "/") {
+		return SimulationResult{}, errors.New("must specify the `method` and the `path`, eg: `GET` and `/bucket/key`")
+	}
+	u, err := url.ParseRequestURI(r.Path)
+	if err != nil {
+		return SimulationResult{}, fmt.Errorf("invalid path: %w", err)
+	}
+	req := &http.Request{Method: strings.ToUpper(r.Method), URL: u, Host: r.Host, Header: http.Header{}, RemoteAddr: r.ClientIP}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+
+	var cred *Credential
+	for _, c := range p.store.list() {
+		if c.AccessKeyID == r.AccessKeyID && inGroups(c, p.groups) {
+			cred = c
+			break
+		}
+	}
+	if cred == nil {
+		return SimulationResult{Stage: "credential", Reason: fmt.Sprintf("unknown access key id %q", r.AccessKeyID)}, nil
+	}
+	res := resolveResource(req, p.domains)
+	op := classify(req, res)
+	result := SimulationResult{Operation: op.Name, Action: op.Action, Resource: res.arn()}
+	deny := func(stage string, err error) (SimulationResult, error) {
+		result.Stage, result.Reason = stage, err.Error()
+		return result, nil
+	}
+
+	if r.ClientIP != "" {
+		if err := cred.scope.checkSource(r.ClientIP); err != nil {
+			return deny("source", err)
+		}
+	}
+	if !inWindows(cred.windows, p.Now()) {
+		return deny("window", errors.New("outside of the access windows"))
+	}
+	if err := cred.scope.check(req, res, op); err != nil {
+		return deny("scope", err)
+	}
+	d, err := evaluatePolicies(p.defaultPolicy, cred.Policy, p.requireAllow(), policyRequest{action: op.Action, resource: res.arn(), subresources: subresources(req), header: req.Header, tags: cred.Tags, country: p.geo.country(req)})
+	if d.index >= 0 {
+		result.Statement = p.matchedStatement(cred, d)
+	}
+	if err != nil && d.index < 0 {
+		if g := p.grant(req, cred, op, res, p.Now()); g != nil {
+			result.Grant, err = g.ID, nil
+		}
+	}
+	if err != nil {
+		return deny("policy", err)
+	}
+	if d, err := p.authorizeCopySource(req, cred, p.Now()); err != nil {
+		if d.index >= 0 {
+			result.Statement = p.matchedStatement(cred, d)
+		}
+		return deny("policy", err)
+	}
+	if p.cedar != nil {
+		if err := evaluateCedar(p.cedar, cedarRequest{cred: cred, action: op.Action, res: res}); err != nil {
+			return deny("cedar", err)
+		}
+	}
+	result.Allowed = true
+	return result, nil
+}
+
+// matchedStatement describes the statement that decided.
+func (p *Plugin) matchedStatement(cred *Credential, d policyDecision) *MatchedStatement {
+	policy := cred.Policy
+	if d.policy == defaultPolicy {
+		policy = p.defaultPolicy
+	}
+	s := policy.Statement[d.index]
+	return &MatchedStatement{Policy: d.policy, Index: d.index, Sid: s.Sid, Effect: s.Effect}
+}
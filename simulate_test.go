@@ -0,0 +1,88 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestSimulate(t *testing.T) {
+	tc := []struct {
+		name     string
+		request  plugin.SimulationRequest
+		expected plugin.SimulationResult
+	}{
+		{
+			name:    "allowed by a statement",
+			request: plugin.SimulationRequest{Method: http.MethodGet, Path: "/backups/db.tar"},
+			expected: plugin.SimulationResult{
+				Allowed: true, Operation: "GetObject", Action: "s3:GetObject", Resource: "arn:aws:s3:::backups/db.tar",
+				Statement: &plugin.MatchedStatement{Policy: "credential", Index: 0, Sid: "read", Effect: "Allow"},
+			},
+		},
+		{
+			name:    "explicitly denied",
+			request: plugin.SimulationRequest{Method: http.MethodPut, Path: "/backups/db.tar", Headers: map[string]string{"X-Amz-Acl": "public-read"}},
+			expected: plugin.SimulationResult{
+				Operation: "PutObject", Action: "s3:PutObject", Resource: "arn:aws:s3:::backups/db.tar",
+				Stage: "policy", Reason: `s3:PutObject on "arn:aws:s3:::backups/db.tar" is explicitly denied`,
+				Statement: &plugin.MatchedStatement{Policy: "credential", Index: 2, Sid: "private", Effect: "Deny"},
+			},
+		},
+		{
+			name:    "implicitly denied",
+			request: plugin.SimulationRequest{Method: http.MethodDelete, Path: "/backups/db.tar"},
+			expected: plugin.SimulationResult{
+				Operation: "DeleteObject", Action: "s3:DeleteObject", Resource: "arn:aws:s3:::backups/db.tar",
+				Stage: "policy", Reason: `s3:DeleteObject on "arn:aws:s3:::backups/db.tar" is not allowed by the policy`,
+			},
+		},
+		{
+			name:    "outside of the buckets",
+			request: plugin.SimulationRequest{Method: http.MethodGet, Path: "/logs/app.log"},
+			expected: plugin.SimulationResult{
+				Operation: "GetObject", Action: "s3:GetObject", Resource: "arn:aws:s3:::logs/app.log",
+				Stage: "scope", Reason: `bucket "logs" is not allowed`,
+			},
+		},
+		{
+			name:     "unknown access key",
+			request:  plugin.SimulationRequest{AccessKeyID: "UNKNOWN", Method: http.MethodGet, Path: "/backups/db.tar"},
+			expected: plugin.SimulationResult{Stage: "credential", Reason: `unknown access key id "UNKNOWN"`},
+		},
+	}
+	const policy = `{
+		"Statement": [
+			{"Sid": "read", "Effect": "Allow", "Action": ["s3:GetObject"], "Resource": ["*"]},
+			{"Sid": "write", "Effect": "Allow", "Action": ["s3:PutObject"], "Resource": ["*"]},
+			{"Sid": "private", "Effect": "Deny", "Action": ["s3:PutObject"], "Resource": ["*"], "Condition": {"StringNotEquals": {"s3:x-amz-acl": ["private"]}}}
+		]
+	}`
+	cred := validCredential()
+	cred.AllowedBuckets = []string{"backups"}
+	if err := json.Unmarshal([]byte(policy), &cred.Policy); err != nil {
+		t.Fatal(err)
+	}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	p := newTestPlugin(t, cfg)
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.request.AccessKeyID == "" {
+				tt.request.AccessKeyID = cred.AccessKeyID
+			}
+			got, err := p.Simulate(tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g, _ := json.Marshal(got)
+			e, _ := json.Marshal(tt.expected)
+			if string(g) != string(e) {
+				t.Errorf("expected %s, got %s", e, g)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package traefik_plugin_s3_auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sourceTimeout  = 10 * time.Second
+	maxSourceBytes = 10 << 20
+)
+
+// Source describes where to load additional credentials from.
+type Source struct {
+	// Type of the source: `file` (the default), `dir` or `http`.
+	Type string `json:"type,omitempty"`
+	// Path of the file or directory to load, a leading `~/` is expanded to the home directory.
+	Path string `json:"path,omitempty"`
+	// URL to fetch the credentials from for `http` sources, with optional request headers, eg: a bearer token.
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Format of the file: `json` (a list of credentials, the default), `minio` or `aws`.
+	Format string `json:"format,omitempty"`
+	// Region and Service are used for loaded credentials that don't specify their own.
+	Region  string `json:"region,omitempty"`
+	Service string `json:"service,omitempty"`
+	// Priority decides which source wins when several define the same credential, the highest wins.
+	Priority int `json:"priority,omitempty"`
+}
+
+func (src *Source) name() string {
+	if src.Type == "http" {
+		return src.URL
+	}
+	return src.Path
+}
+
+func loadSource(src *Source) ([]*Credential, error) {
+	var b []byte
+	var creds []*Credential
+	var err error
+	switch src.Type {
+	case "", "file":
+		b, err = readSourceFile(src.Path)
+	case "dir":
+		creds, err = readSecretsDir(expandHome(src.Path))
+	case "http":
+		b, err = fetchSource(src.URL, src.Headers)
+	default:
+		return nil, fmt.Errorf("unsupported source type: %q", src.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case src.Type == "dir":
+		// Directories have a single layout rather than a format.
+	case src.Format == "" || src.Format == "json":
+		if err := json.Unmarshal(b, &creds); err != nil {
+			return nil, fmt.Errorf("invalid credentials json: %w", err)
+		}
+	case src.Format == "minio":
+		if creds, err = parseMinIO(b); err != nil {
+			return nil, err
+		}
+	case src.Format == "aws":
+		if creds, err = parseAWSCredentials(b); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported source format: %q", src.Format)
+	}
+
+	for _, c := range creds {
+		if c.Region == "" {
+			c.Region = src.Region
+		}
+		if c.Service == "" {
+			c.Service = src.Service
+		}
+	}
+	return creds, nil
+}
+
+func readSourceFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("must specify the path for each file source")
+	}
+	return os.ReadFile(expandHome(path))
+}
+
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+func fetchSource(url string, headers map[string]string) ([]byte, error) {
+	if url == "" {
+		return nil, errors.New("must specify the url for each http source")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sourceTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSourceBytes))
+}
+
+// prioritized is a credential together with the priority of the source it came from.
+type prioritized struct {
+	cred     *Credential
+	priority int
+	origin   string
+}
+
+// mergeCredentials resolves credentials defined by several sources for the same access key id, region and service.
+// With the `priority` policy the highest priority wins and ties go to the first one listed, with the `error` policy
+// conflicting definitions fail the load.
+func mergeCredentials(all []prioritized, policy string) ([]*Credential, error) {
+	if policy != "" && policy != "priority" && policy != "error" {
+		return nil, fmt.Errorf("unsupported conflict policy: %q", policy)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].priority > all[j].priority })
+
+	seen := map[string]prioritized{}
+	creds := make([]*Credential, 0, len(all))
+	for _, p := range all {
+		id := p.cred.AccessKeyID + "/" + p.cred.Region + "/" + p.cred.Service
+		if first, ok := seen[id]; ok {
+			if policy == "error" && first.cred.AccessSecretKey != p.cred.AccessSecretKey {
+				return nil, fmt.Errorf("access key id %q is defined by both %s and %s", p.cred.AccessKeyID, first.origin, p.origin)
+			}
+			continue
+		}
+		seen[id] = p
+		creds = append(creds, p.cred)
+	}
+	return creds, nil
+}
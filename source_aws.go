@@ -0,0 +1,61 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// parseAWSCredentials parses an INI-style shared credentials file, eg: `~/.aws/credentials`. Each profile becomes a
+// credential tagged with its profile name, and an optional `region` key overrides the source region.
+func parseAWSCredentials(b []byte) ([]*Credential, error) {
+	var creds []*Credential
+	var cur *Credential
+
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("invalid profile on line %d", n)
+			}
+			// The config file format prefixes profile names, but the credentials file doesn't.
+			profile := strings.TrimPrefix(strings.TrimSpace(line[1:len(line)-1]), "profile ")
+			cur = &Credential{Tags: map[string]string{"profile": profile}}
+			creds = append(creds, cur)
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid key value pair on line %d", n)
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("key outside of a profile on line %d", n)
+		}
+		v = strings.TrimSpace(v)
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "aws_access_key_id":
+			cur.AccessKeyID = v
+		case "aws_secret_access_key":
+			cur.AccessSecretKey = v
+		case "region":
+			cur.Region = v
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	// Profiles without keys, eg: role or sso profiles, have nothing to validate against.
+	out := creds[:0]
+	for _, c := range creds {
+		if c.AccessKeyID != "" || c.AccessSecretKey != "" {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
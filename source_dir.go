@@ -0,0 +1,63 @@
+package traefik_plugin_s3_auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readSecretsDir loads a directory where each file is named after an access key id and contains its secret, the way
+// Docker and Kubernetes mount secrets. An optional `<accessKeyId>.json` sidecar holds the other credential fields, eg:
+// `{"region":"us-east-1","service":"s3"}`. Hidden entries such as the `..data` links Kubernetes creates are skipped.
+func readSecretsDir(dir string) ([]*Credential, error) {
+	if dir == "" {
+		return nil, errors.New("must specify the path for each dir source")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".json") {
+			continue
+		}
+		// Mounted secrets are usually symlinks, so stat the target rather than trusting the entry type.
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	creds := make([]*Credential, 0, len(names))
+	for _, name := range names {
+		c := &Credential{}
+		sidecar, err := os.ReadFile(filepath.Join(dir, name+".json"))
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(sidecar, c); err != nil {
+				return nil, fmt.Errorf("invalid sidecar for %q: %w", name, err)
+			}
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+		secret, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		c.AccessKeyID = name
+		c.AccessSecretKey = strings.TrimSpace(string(secret))
+		creds = append(creds, c)
+	}
+	return creds, nil
+}
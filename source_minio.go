@@ -0,0 +1,77 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// minioUser matches both the `iam-assets/users.json` entries of `mc admin cluster iam export` and the lines
+// printed by `mc admin user list --json`.
+type minioUser struct {
+	AccessKey  string `json:"accessKey"`
+	SecretKey  string `json:"secretKey"`
+	Policy     string `json:"policy"`
+	PolicyName string `json:"policyName"`
+	Status     string `json:"status"`
+	UserStatus string `json:"userStatus"`
+}
+
+// parseMinIO accepts either a `users.json` object keyed by access key or one JSON user per line. Disabled users are
+// skipped. `mc admin user list` never prints secrets, so its entries need a `secretKey` added before importing.
+func parseMinIO(b []byte) ([]*Credential, error) {
+	b = bytes.TrimSpace(b)
+
+	var users []minioUser
+	var keyed map[string]minioUser
+	if err := json.Unmarshal(b, &keyed); err == nil {
+		for k, u := range keyed {
+			u.AccessKey = k
+			users = append(users, u)
+		}
+		sort.Slice(users, func(i, j int) bool { return users[i].AccessKey < users[j].AccessKey })
+	} else {
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		for n := 1; sc.Scan(); n++ {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var u minioUser
+			if err := json.Unmarshal(line, &u); err != nil {
+				return nil, fmt.Errorf("invalid minio user on line %d: %w", n, err)
+			}
+			users = append(users, u)
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	creds := make([]*Credential, 0, len(users))
+	for _, u := range users {
+		status := u.UserStatus
+		if status == "" {
+			status = u.Status
+		}
+		if strings.EqualFold(status, "disabled") {
+			continue
+		}
+		if u.SecretKey == "" {
+			return nil, fmt.Errorf("minio user %q has no `secretKey`", u.AccessKey)
+		}
+		c := &Credential{AccessKeyID: u.AccessKey, AccessSecretKey: u.SecretKey}
+		policy := u.Policy
+		if policy == "" {
+			policy = u.PolicyName
+		}
+		if policy != "" {
+			c.Tags = map[string]string{"policy": policy}
+		}
+		creds = append(creds, c)
+	}
+	return creds, nil
+}
@@ -0,0 +1,450 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSources(t *testing.T) {
+	tc := []struct {
+		name           string
+		format         string
+		content        string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "json",
+			content:        `[{"accessKeyId":"ACCESS_ACCESS_ACCESS","accessSecretKey":"SECRET12secret123456SECRET12secret123456"}]`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "minio users.json",
+			format:         "minio",
+			content:        `{"ACCESS_ACCESS_ACCESS":{"secretKey":"SECRET12secret123456SECRET12secret123456","policy":"readwrite","status":"enabled"},"OTHER":{"secretKey":"other","status":"enabled"}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "minio user list",
+			format: "minio",
+			content: `{"status":"success","accessKey":"ACCESS_ACCESS_ACCESS","secretKey":"SECRET12secret123456SECRET12secret123456","policyName":"readonly","userStatus":"enabled"}
+{"status":"success","accessKey":"OTHER","secretKey":"other","userStatus":"enabled"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "minio disabled user",
+			format:         "minio",
+			content:        `{"ACCESS_ACCESS_ACCESS":{"secretKey":"SECRET12secret123456SECRET12secret123456","status":"disabled"},"OTHER":{"secretKey":"other","status":"enabled"}}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "aws shared credentials",
+			format: "aws",
+			content: `# Comments are ignored.
+[default]
+aws_access_key_id = OTHER
+aws_secret_access_key = other
+
+[backups]
+aws_access_key_id=ACCESS_ACCESS_ACCESS
+aws_secret_access_key=SECRET12secret123456SECRET12secret123456
+aws_session_token=ignored
+
+[profile sso]
+sso_start_url = https://example.awsapps.com/start`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "aws profile region",
+			format:         "aws",
+			content:        "[backups]\naws_access_key_id=ACCESS_ACCESS_ACCESS\naws_secret_access_key=SECRET12secret123456SECRET12secret123456\nregion=eu-west-1\n",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:          "aws invalid line",
+			format:        "aws",
+			content:       "[default]\naws_access_key_id\n",
+			expectedError: "invalid key value pair on line 2",
+		},
+		{
+			name:          "minio user list without secrets",
+			format:        "minio",
+			content:       `{"status":"success","accessKey":"ACCESS_ACCESS_ACCESS","policyName":"readonly","userStatus":"enabled"}`,
+			expectedError: "minio user \"ACCESS_ACCESS_ACCESS\" has no `secretKey`",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "credentials", tt.content)
+			cfg := plugin.CreateConfig()
+			cfg.Sources = []*plugin.Source{{Path: path, Format: tt.format, Region: "us-east-1", Service: "s3"}}
+
+			if tt.expectedError != "" {
+				next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+				_, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+				if err == nil || !strings.HasSuffix(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			p := newTestPlugin(t, cfg)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newValidRequest(t))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestReloadWebhook(t *testing.T) {
+	events := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var ev map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer srv.Close()
+
+	path := writeFile(t, "credentials.json", `[{"accessKeyId":"OTHER","accessSecretKey":"other"}]`)
+	cfg := plugin.CreateConfig()
+	cfg.Sources = []*plugin.Source{{Path: path, Region: "us-east-1", Service: "s3"}}
+	cfg.RefreshInterval = "10ms"
+	cfg.ReloadWebhook = srv.URL
+	p := newTestPlugin(t, cfg)
+
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, newValidRequest(t))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d, got %d", http.StatusForbidden, recorder.Code)
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"accessKeyId":"ACCESS_ACCESS_ACCESS","accessSecretKey":"SECRET12secret123456SECRET12secret123456"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-events:
+		if ev["event"] != "credentials.reloaded" {
+			t.Errorf("unexpected event: %v", ev)
+		}
+		if got := fmt.Sprint(ev["added"], ev["removed"], ev["changed"]); got != "[ACCESS_ACCESS_ACCESS] [OTHER] []" {
+			t.Errorf("unexpected diff: %s", got)
+		}
+		if b, _ := json.Marshal(ev); strings.Contains(string(b), "SECRET") {
+			t.Errorf("event must not contain secrets: %s", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reload webhook")
+	}
+
+	recorder = httptest.NewRecorder()
+	p.ServeHTTP(recorder, newValidRequest(t))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	events := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var ev map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer srv.Close()
+
+	old := validCredential()
+	path := writeFile(t, "credentials.json", `[{"accessKeyId":"ACCESS_ACCESS_ACCESS","accessSecretKey":"`+old.AccessSecretKey+`"}]`)
+	cfg := plugin.CreateConfig()
+	cfg.Sources = []*plugin.Source{{Path: path, Region: "us-east-1", Service: "s3"}}
+	cfg.RefreshInterval = "10ms"
+	cfg.ReloadWebhook = srv.URL
+	p := newTestPlugin(t, cfg)
+
+	serve := func(cred *plugin.Credential) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+		signRequest(t, req, cred, p.Now())
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	// Caches the signing key derived from the old secret.
+	if code := serve(old); code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+	}
+
+	rotated := validCredential()
+	rotated.AccessSecretKey = "ROTATED_ROTATED_ROTATED_ROTATED_ROTATED1"
+	if err := os.WriteFile(path, []byte(`[{"accessKeyId":"ACCESS_ACCESS_ACCESS","accessSecretKey":"`+rotated.AccessSecretKey+`"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reload webhook")
+	}
+
+	if code := serve(old); code != http.StatusForbidden {
+		t.Errorf("expected the old secret to be rejected with %d, got %d", http.StatusForbidden, code)
+	}
+	if code := serve(rotated); code != http.StatusOK {
+		t.Errorf("expected the rotated secret to be accepted with %d, got %d", http.StatusOK, code)
+	}
+}
+
+func TestStoreRelease(t *testing.T) {
+	events := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var ev map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer srv.Close()
+
+	path := writeFile(t, "credentials.json", `[{"accessKeyId":"OTHER","accessSecretKey":"other"}]`)
+	cfg := plugin.CreateConfig()
+	cfg.Sources = []*plugin.Source{{Path: path, Region: "us-east-1", Service: "s3"}}
+	cfg.RefreshInterval = "10ms"
+	cfg.ReloadWebhook = srv.URL
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := plugin.New(context.Background(), next, cfg, "s3-store-release"); err != nil {
+		t.Fatal(err)
+	}
+	// Editing the configuration gives the middleware a new store, the previous one stops refreshing.
+	cfg.RefreshInterval = "20ms"
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := plugin.New(ctx, next, cfg, "s3-store-release"); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(id string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(`[{"accessKeyId":"`+id+`","accessSecretKey":"secret"}]`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("FIRST")
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reload webhook")
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("expected a single reload, got %v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// The store stops refreshing once the last middleware using it is gone.
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	write("SECOND")
+	select {
+	case ev := <-events:
+		t.Errorf("expected no reload once the middleware is gone, got %v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSourcePriority(t *testing.T) {
+	valid := `[{"accessKeyId":"ACCESS_ACCESS_ACCESS","accessSecretKey":"SECRET12secret123456SECRET12secret123456"}]`
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = rw.Write([]byte(valid))
+	}))
+	defer srv.Close()
+
+	tc := []struct {
+		name           string
+		inlinePriority int
+		sourcePriority int
+		conflictPolicy string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "source wins",
+			sourcePriority: 10,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "inline wins",
+			inlinePriority: 10,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "ties go to inline",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "conflicts are errors",
+			conflictPolicy: "error",
+			expectedError:  "access key id \"ACCESS_ACCESS_ACCESS\" is defined by both inline credentials and \"" + srv.URL + "\"",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := validCredential()
+			cred.AccessSecretKey = "break-glass"
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.InlinePriority = tt.inlinePriority
+			cfg.ConflictPolicy = tt.conflictPolicy
+			cfg.Sources = []*plugin.Source{{
+				Type:     "http",
+				URL:      srv.URL,
+				Headers:  map[string]string{"Authorization": "Bearer token"},
+				Region:   "us-east-1",
+				Service:  "s3",
+				Priority: tt.sourcePriority,
+			}}
+			if tt.expectedError != "" {
+				next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+				_, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+				if err == nil || err.Error() != tt.expectedError {
+					t.Fatalf("expected error %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			p := newTestPlugin(t, cfg)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newValidRequest(t))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestUnavailablePolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	tc := []struct {
+		name           string
+		policy         string
+		inline         bool
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:          "last known good",
+			policy:        "lastKnownGood",
+			expectedError: "failed to load credentials from \"" + srv.URL + "\": unexpected status code: 500",
+		},
+		{
+			name:           "fail closed",
+			policy:         "failClosed",
+			inline:         true,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "inline",
+			policy:         "inline",
+			inline:         true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:          "inline without credentials",
+			policy:        "inline",
+			expectedError: "the `inline` unavailable policy requires inline credentials",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			if tt.inline {
+				cfg.Credentials = []*plugin.Credential{validCredential()}
+			}
+			cfg.Sources = []*plugin.Source{{Type: "http", URL: srv.URL, Region: "us-east-1", Service: "s3"}}
+			cfg.UnavailablePolicy = tt.policy
+			if tt.expectedError != "" {
+				next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+				_, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+				if err == nil || err.Error() != tt.expectedError {
+					t.Fatalf("expected error %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			p := newTestPlugin(t, cfg)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newValidRequest(t))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestCredentialsDir(t *testing.T) {
+	dir := t.TempDir()
+	// Mimic a Kubernetes secret mount, where the files are symlinks into a hidden timestamped directory.
+	data := filepath.Join(dir, "..2025_07_10_05_45_00.000000000")
+	if err := os.Mkdir(data, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"ACCESS_ACCESS_ACCESS":      "SECRET12secret123456SECRET12secret123456\n",
+		"ACCESS_ACCESS_ACCESS.json": `{"region":"us-east-1","service":"s3","tags":{"team":"backups"}}`,
+		"OTHER":                     "other",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(data, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(data, name), filepath.Join(dir, name)); err != nil {
+			t.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+	if err := os.Symlink(data, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := plugin.CreateConfig()
+	cfg.CredentialsDir = dir
+	p := newTestPlugin(t, cfg)
+
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, newValidRequest(t))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if s := p.CredentialStatus(); len(s) != 2 || s[1].AccessKeyID != "OTHER" || s[1].Region != "us-east-1" {
+		t.Errorf("unexpected credentials: %+v", s)
+	}
+}
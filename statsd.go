@@ -0,0 +1,199 @@
+package traefik_plugin_s3_auth
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultStatsDPrefix = "s3auth."
+	statsdDogStatsD     = "dogstatsd"
+	statsdPlain         = "statsd"
+	statsdQueueSize     = 4096
+	// statsdMaxPacket keeps the datagrams under the usual Ethernet MTU.
+	statsdMaxPacket = 1432
+)
+
+// StatsDConfig emits the metrics to a StatsD or DogStatsD agent over UDP, eg: for Datadog or Telegraf setups that
+// don't scrape Prometheus.
+type StatsDConfig struct {
+	// Address of the agent, eg: `localhost:8125`.
+	Address string `json:"address,omitempty"`
+	// Prefix of the metric names, defaults to `s3auth.`.
+	Prefix string `json:"prefix,omitempty"`
+	// Tags are added to every metric, eg: `env:prod`. Ignored by the `statsd` format.
+	Tags []string `json:"tags,omitempty"`
+	// Format is `dogstatsd`, the default, tagging the metrics, or `statsd`, which puts the labels in the metric names.
+	Format string `json:"format,omitempty"`
+}
+
+// statsd emits the metrics of a middleware.
+type statsd struct {
+	client *statsdClient
+	prefix string
+	plain  bool
+	// tags is the `|#` suffix with the middleware and the configured tags.
+	tags string
+}
+
+// statsdClient batches the lines into datagrams for an agent. The clients are shared process-wide by address, so
+// configuration reloads don't leak sockets.
+type statsdClient struct {
+	addr    string
+	queue   chan string
+	dropped uint64
+}
+
+var (
+	statsdClientsMu sync.Mutex
+	statsdClients   = map[string]*statsdClient{}
+)
+
+func newStatsD(config *StatsDConfig, name string) (*statsd, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if _, port, err := net.SplitHostPort(config.Address); err != nil || port == "" {
+		return nil, fmt.Errorf("invalid metrics statsd `address` %q, eg: `localhost:8125`", config.Address)
+	}
+	s := &statsd{prefix: defaultStatsDPrefix}
+	if config.Prefix != "" {
+		s.prefix = config.Prefix
+	}
+	switch config.Format {
+	case "", statsdDogStatsD:
+	case statsdPlain:
+		s.plain = true
+	default:
+		return nil, fmt.Errorf("invalid metrics statsd `format` %q, must be `dogstatsd` or `statsd`", config.Format)
+	}
+	tags := []string{"middleware:" + statsdValue(name)}
+	for _, t := range config.Tags {
+		if t == "" || strings.ContainsAny(t, ",|#\n") {
+			return nil, fmt.Errorf("invalid metrics statsd tag %q, eg: `env:prod`", t)
+		}
+		tags = append(tags, t)
+	}
+	s.tags = "|#" + strings.Join(tags, ",")
+
+	statsdClientsMu.Lock()
+	defer statsdClientsMu.Unlock()
+
+	c, ok := statsdClients[config.Address]
+	if !ok {
+		c = &statsdClient{addr: config.Address, queue: make(chan string, statsdQueueSize)}
+		go c.run()
+		statsdClients[config.Address] = c
+	}
+	s.client = c
+	return s, nil
+}
+
+// statsdValue replaces the characters the StatsD line protocol reserves.
+func statsdValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '@', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// count increments the counter, each label being a name and a value, eg: `result` and `success`.
+func (s *statsd) count(name string, labels ...string) {
+	s.emit(name, "1|c", labels)
+}
+
+// gauge sets the value of the gauge, each label being a name and a value.
+func (s *statsd) gauge(name string, v int64, labels ...string) {
+	s.emit(name, strconv.FormatInt(v, 10)+"|g", labels)
+}
+
+// timing records a duration in milliseconds.
+func (s *statsd) timing(name string, d time.Duration) {
+	s.emit(name, strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64)+"|ms", nil)
+}
+
+func (s *statsd) emit(name, value string, labels []string) {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	if s.plain {
+		for i := 1; i < len(labels); i += 2 {
+			b.WriteString(".")
+			b.WriteString(strings.ReplaceAll(statsdValue(labels[i]), ".", "_"))
+		}
+		b.WriteString(":")
+		b.WriteString(value)
+	} else {
+		b.WriteString(":")
+		b.WriteString(value)
+		b.WriteString(s.tags)
+		for i := 1; i < len(labels); i += 2 {
+			b.WriteString(",")
+			b.WriteString(labels[i-1])
+			b.WriteString(":")
+			b.WriteString(statsdValue(labels[i]))
+		}
+	}
+	s.client.enqueue(b.String())
+}
+
+// enqueue hands the line to the agent, dropping it when the queue is full.
+func (c *statsdClient) enqueue(line string) {
+	select {
+	case c.queue <- line:
+	default:
+		if n := atomic.AddUint64(&c.dropped, 1); n%1000 == 1 {
+			logs.warn("statsd queue is full, dropped the metrics", "address", c.addr, "dropped", n)
+		}
+	}
+}
+
+// run sends the queued lines, batching those waiting into datagrams of up to statsdMaxPacket bytes.
+func (c *statsdClient) run() {
+	var conn net.Conn
+	buf := make([]byte, 0, statsdMaxPacket)
+	for line := range c.queue {
+		buf = append(buf[:0], line...)
+	batch:
+		for {
+			select {
+			case next := <-c.queue:
+				if len(buf)+1+len(next) > statsdMaxPacket {
+					conn = c.send(conn, buf)
+					buf = buf[:0]
+				} else {
+					buf = append(buf, '\n')
+				}
+				buf = append(buf, next...)
+			default:
+				break batch
+			}
+		}
+		conn = c.send(conn, buf)
+	}
+}
+
+// send writes the datagram, returning the connection to reuse, nil once it failed so the next one dials again.
+func (c *statsdClient) send(conn net.Conn, b []byte) net.Conn {
+	if conn == nil {
+		var err error
+		if conn, err = net.Dial("udp", c.addr); err != nil {
+			logs.error("failed to dial the statsd agent", "address", c.addr, "error", err)
+			return nil
+		}
+	}
+	if _, err := conn.Write(b); err != nil {
+		logs.debug("failed to send the statsd metrics", "address", c.addr, "error", err)
+		_ = conn.Close()
+		return nil
+	}
+	return conn
+}
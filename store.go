@@ -0,0 +1,347 @@
+package traefik_plugin_s3_auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxSigningKeys bounds the derived signing key cache. Keys are scoped to a single day so the cache is simply
+// reset when it fills up.
+const maxSigningKeys = 1024
+
+// credentialStore holds a credential set together with the state derived from it. Traefik creates one middleware
+// instance per router and per configuration reload, so stores are shared process-wide through the registry below,
+// keyed by the configuration the credentials are loaded from, until no middleware uses them.
+type credentialStore struct {
+	config   storeConfig
+	usage    *usageTracker
+	inFlight *inFlight
+	quotas   *quotaTracker
+
+	credMu      sync.RWMutex
+	credentials []*Credential
+	inline      []*Credential
+	sources     SourceStatus
+
+	mu     sync.Mutex
+	keys   map[string][]byte
+	hits   uint64
+	misses uint64
+}
+
+// CacheStatus describes the use of a cache since the process started.
+type CacheStatus struct {
+	Entries int     `json:"entries"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+}
+
+func newCacheStatus(entries int, hits, misses uint64) CacheStatus {
+	c := CacheStatus{Entries: entries, Hits: hits, Misses: misses}
+	if hits+misses > 0 {
+		c.HitRate = float64(hits) / float64(hits+misses)
+	}
+	return c
+}
+
+const (
+	unavailableLastKnownGood = "lastKnownGood"
+	unavailableFailClosed    = "failClosed"
+	unavailableInline        = "inline"
+)
+
+var errSourcesUnavailable = errors.New("credential sources are unavailable")
+
+// SourceStatus describes the last load of the credential sources and how requests were served while it failed.
+type SourceStatus struct {
+	LastRefresh         time.Time `json:"lastRefresh"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastError           string    `json:"lastError,omitempty"`
+	ServedLastKnownGood uint64    `json:"servedLastKnownGood"`
+	ServedInline        uint64    `json:"servedInline"`
+	RejectedUnavailable uint64    `json:"rejectedUnavailable"`
+}
+
+// storeConfig is the part of the configuration that determines the credential set.
+type storeConfig struct {
+	Credentials     []*Credential `json:"credentials"`
+	Sources         []*Source     `json:"sources"`
+	InlinePriority  int           `json:"inlinePriority"`
+	ConflictPolicy  string        `json:"conflictPolicy"`
+	Unavailable     string        `json:"unavailablePolicy"`
+	RefreshInterval string        `json:"refreshInterval"`
+	ReloadWebhook   string        `json:"reloadWebhook"`
+}
+
+var stores = newRegistry()
+
+// sharedStore returns the store for the given configuration, loading the credentials on first use. The refresh stops
+// once no middleware uses the store anymore.
+func sharedStore(ctx context.Context, config *Config, name string) (*credentialStore, error) {
+	sources := config.Sources
+	if config.CredentialsDir != "" {
+		sources = append(append([]*Source{}, sources...), &Source{
+			Type:    "dir",
+			Path:    config.CredentialsDir,
+			Region:  "us-east-1",
+			Service: "s3",
+		})
+	}
+	sc := storeConfig{
+		Credentials:     config.Credentials,
+		Sources:         sources,
+		InlinePriority:  config.InlinePriority,
+		ConflictPolicy:  config.ConflictPolicy,
+		Unavailable:     config.UnavailablePolicy,
+		RefreshInterval: config.RefreshInterval,
+		ReloadWebhook:   config.ReloadWebhook,
+	}
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	id := hex.EncodeToString(sum[:])
+
+	v, err := stores.acquire(ctx, id, name, func() (interface{}, func(), error) {
+		return newStore(sc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*credentialStore), nil
+}
+
+func newStore(sc storeConfig) (*credentialStore, func(), error) {
+	var interval time.Duration
+	var err error
+	if sc.RefreshInterval != "" {
+		if interval, err = time.ParseDuration(sc.RefreshInterval); err != nil || interval <= 0 {
+			return nil, nil, fmt.Errorf("invalid `refreshInterval` %q, eg: `1m`", sc.RefreshInterval)
+		}
+	}
+	switch sc.Unavailable {
+	case "", unavailableLastKnownGood, unavailableFailClosed:
+	case unavailableInline:
+		if len(sc.Credentials) == 0 {
+			return nil, nil, errors.New("the `inline` unavailable policy requires inline credentials")
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported unavailable policy: %q", sc.Unavailable)
+	}
+	for _, cred := range sc.Credentials {
+		if err := checkCredential(cred); err != nil {
+			return nil, nil, err
+		}
+	}
+	s := &credentialStore{
+		config:   sc,
+		usage:    newUsageTracker(),
+		inFlight: newInFlight(),
+		quotas:   newQuotaTracker(),
+		inline:   sc.Credentials,
+		keys:     map[string][]byte{},
+	}
+	if _, err := s.reload("startup"); err != nil {
+		// Without a last known good credential set there is nothing to serve from, so the middleware can't start.
+		// The other policies keep running degraded until a refresh succeeds.
+		if sc.Unavailable == "" || sc.Unavailable == unavailableLastKnownGood {
+			return nil, nil, err
+		}
+		logs.error("failed to load the credentials", "unavailablePolicy", sc.Unavailable, "error", err)
+	}
+	if interval <= 0 {
+		return s, nil, nil
+	}
+	stop := make(chan struct{})
+	go s.watch(interval, stop)
+	return s, func() { close(stop) }, nil
+}
+
+// load reads and validates the inline credentials and every source, then merges them by priority.
+func (s *credentialStore) load() ([]*Credential, error) {
+	all := make([]prioritized, 0, len(s.config.Credentials))
+	for _, c := range s.config.Credentials {
+		all = append(all, prioritized{cred: c, priority: s.config.InlinePriority, origin: "inline credentials"})
+	}
+	for _, src := range s.config.Sources {
+		loaded, err := loadSource(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials from %q: %w", src.name(), err)
+		}
+		for _, c := range loaded {
+			all = append(all, prioritized{cred: c, priority: src.Priority, origin: fmt.Sprintf("%q", src.name())})
+		}
+	}
+	creds, err := mergeCredentials(all, s.config.ConflictPolicy)
+	if err != nil {
+		return nil, err
+	}
+	// Check for empty credentials.
+	if len(creds) == 0 {
+		return nil, errors.New("must specify at least one valid credential")
+	}
+	for _, cred := range creds {
+		if err := checkCredential(cred); err != nil {
+			return nil, err
+		}
+	}
+	return creds, nil
+}
+
+// reload loads the credentials again and swaps them in if they changed. On failure the previous credentials are kept.
+func (s *credentialStore) reload(reason string) (credentialDiff, error) {
+	creds, err := s.load()
+
+	s.credMu.Lock()
+	s.sources.LastRefresh = time.Now().UTC()
+	if err != nil {
+		s.sources.LastError = err.Error()
+		s.credMu.Unlock()
+		return credentialDiff{}, err
+	}
+	s.sources.LastError = ""
+	s.sources.LastSuccess = s.sources.LastRefresh
+	startup := s.credentials == nil
+	diff := diffCredentials(s.credentials, creds)
+	if !diff.empty() {
+		s.credentials = creds
+	}
+	s.credMu.Unlock()
+
+	if !diff.empty() && !startup {
+		logs.info("credentials reloaded", "reason", reason, "added", len(diff.Added), "removed", len(diff.Removed), "changed", len(diff.Changed))
+		if s.config.ReloadWebhook != "" {
+			go notifyReload(s.config.ReloadWebhook, reason, diff, len(creds))
+		}
+	}
+	return diff, nil
+}
+
+func (s *credentialStore) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := s.reload("refresh"); err != nil {
+				logs.error("failed to reload the credentials", "error", err)
+			}
+		}
+	}
+}
+
+func (s *credentialStore) list() []*Credential {
+	s.credMu.RLock()
+	defer s.credMu.RUnlock()
+
+	return s.credentials
+}
+
+// active returns the credentials to validate against, applying the unavailable policy while the last load failed.
+func (s *credentialStore) active() ([]*Credential, error) {
+	s.credMu.Lock()
+	defer s.credMu.Unlock()
+
+	if s.sources.LastError == "" {
+		return s.credentials, nil
+	}
+	switch s.config.Unavailable {
+	case unavailableFailClosed:
+		s.sources.RejectedUnavailable++
+		return nil, errSourcesUnavailable
+	case unavailableInline:
+		s.sources.ServedInline++
+		return s.inline, nil
+	default:
+		if s.credentials == nil {
+			s.sources.RejectedUnavailable++
+			return nil, errSourcesUnavailable
+		}
+		s.sources.ServedLastKnownGood++
+		return s.credentials, nil
+	}
+}
+
+func (s *credentialStore) sourceStatus() SourceStatus {
+	s.credMu.RLock()
+	defer s.credMu.RUnlock()
+
+	return s.sources
+}
+
+func (s *credentialStore) lookup(creds []*Credential, accessKeyID, region, service string) *Credential {
+	for _, c := range creds {
+		if c.AccessKeyID == accessKeyID && c.Region == region && c.Service == service {
+			return c
+		}
+	}
+	return nil
+}
+
+// signingKey returns the derived SigV4 signing key for the credential and day, eg: `20250710`. The keys are cached by
+// a hash of the secret too, so a rotated secret never validates against the key derived from the previous one.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html#derive-signing-key
+func (s *credentialStore) signingKey(cred *Credential, day string) []byte {
+	secret := sha256.Sum256([]byte(cred.AccessSecretKey))
+	id := cred.AccessKeyID + "/" + day + "/" + cred.Region + "/" + cred.Service + "/" + hex.EncodeToString(secret[:])
+
+	s.mu.Lock()
+	if k, ok := s.keys[id]; ok {
+		s.hits++
+		s.mu.Unlock()
+		return k
+	}
+	s.misses++
+	s.mu.Unlock()
+
+	k := deriveSigningKey(cred.AccessSecretKey, day, cred.Region, cred.Service)
+
+	s.mu.Lock()
+	if len(s.keys) >= maxSigningKeys {
+		s.keys = map[string][]byte{}
+	}
+	s.keys[id] = k
+	s.mu.Unlock()
+	return k
+}
+
+// signingKeyStatus describes the derived signing key cache.
+func (s *credentialStore) signingKeyStatus() CacheStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return newCacheStatus(len(s.keys), s.hits, s.misses)
+}
+
+func deriveSigningKey(secret, day, region, service string) []byte {
+	return deriveKey("AWS4", "aws4_request", secret, day, region, service)
+}
+
+// deriveKey derives the signing key of the SigV4 like schemes, eg: `GOOG4` for the GCS XML API.
+func deriveKey(prefix, terminator, secret, day, region, service string) []byte {
+	dateKey := hmac.New(sha256.New, []byte(prefix+secret))
+	dateKey.Write([]byte(day))
+
+	dateRegionKey := hmac.New(sha256.New, dateKey.Sum(nil))
+	dateRegionKey.Write([]byte(region))
+
+	dateRegionServiceKey := hmac.New(sha256.New, dateRegionKey.Sum(nil))
+	dateRegionServiceKey.Write([]byte(service))
+
+	signingKey := hmac.New(sha256.New, dateRegionServiceKey.Sum(nil))
+	signingKey.Write([]byte(terminator))
+
+	return signingKey.Sum(nil)
+}
@@ -0,0 +1,313 @@
+package traefik_plugin_s3_auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stsNamespace          = "https://sts.amazonaws.com/doc/2011-06-15/"
+	defaultSessionTimeout = time.Hour
+	maxSessionTimeout     = 12 * time.Hour
+	minSessionTimeout     = 15 * time.Minute
+)
+
+// STSConfig enables a tiny STS compatible endpoint vending temporary credentials.
+type STSConfig struct {
+	// Path of the `GetSessionToken` endpoint, eg: `/sts`, handled by the middleware instead of the backend.
+	Path string `json:"path,omitempty"`
+	// SigningKey protects the session tokens. Use the same value on every replica so they accept each other's tokens.
+	SigningKey string `json:"signingKey,omitempty"`
+	// DefaultDuration and MaxDuration bound the lifetime of the issued credentials, eg: `1h` and `12h`.
+	DefaultDuration string `json:"defaultDuration,omitempty"`
+	MaxDuration     string `json:"maxDuration,omitempty"`
+}
+
+// stsIssuer issues stateless temporary credentials. The session token carries the temporary access key id, the
+// parent access key id and the expiry, protected by an HMAC, and the temporary secret is derived from the token so
+// nothing needs to be stored.
+type stsIssuer struct {
+	path            string
+	key             []byte
+	defaultDuration time.Duration
+	maxDuration     time.Duration
+}
+
+func newSTSIssuer(config *STSConfig) (*stsIssuer, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.Path == "" || !strings.HasPrefix(config.Path, "/") {
+		return nil, errors.New("must specify the sts path, eg: `/sts`")
+	}
+	if len(config.SigningKey) < 32 {
+		return nil, errors.New("the sts signing key must be at least 32 characters long")
+	}
+	s := &stsIssuer{
+		path:            config.Path,
+		key:             []byte(config.SigningKey),
+		defaultDuration: defaultSessionTimeout,
+		maxDuration:     maxSessionTimeout,
+	}
+	var err error
+	if config.DefaultDuration != "" {
+		if s.defaultDuration, err = time.ParseDuration(config.DefaultDuration); err != nil {
+			return nil, fmt.Errorf("invalid sts `defaultDuration`: %w", err)
+		}
+	}
+	if config.MaxDuration != "" {
+		if s.maxDuration, err = time.ParseDuration(config.MaxDuration); err != nil {
+			return nil, fmt.Errorf("invalid sts `maxDuration`: %w", err)
+		}
+	}
+	if s.defaultDuration < minSessionTimeout || s.defaultDuration > s.maxDuration {
+		return nil, fmt.Errorf("the sts `defaultDuration` must be between %v and `maxDuration`", minSessionTimeout)
+	}
+	return s, nil
+}
+
+// session is the payload of a session token.
+type session struct {
+	AccessKeyID string      `json:"k"`
+	Parent      string      `json:"p"`
+	Region      string      `json:"r"`
+	Service     string      `json:"s"`
+	Expiration  int64       `json:"e"`
+	Scope       accessScope `json:"a"`
+}
+
+func (s *stsIssuer) mac(payload string) string {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (s *stsIssuer) secret(token string) string {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte("secret:" + token))
+	return base64.StdEncoding.EncodeToString(m.Sum(nil))[:40]
+}
+
+// issue returns a temporary access key id, secret and session token for the parent credential, restricted to scope.
+func (s *stsIssuer) issue(parent *Credential, scope accessScope, expiration time.Time) (stsCredentials, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return stsCredentials{}, err
+	}
+	ss := session{
+		AccessKeyID: "ASIA" + strings.ToUpper(hex.EncodeToString(id)),
+		Parent:      parent.AccessKeyID,
+		Region:      parent.Region,
+		Service:     parent.Service,
+		Expiration:  expiration.Unix(),
+		Scope:       scope,
+	}
+	b, err := json.Marshal(ss)
+	if err != nil {
+		return stsCredentials{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	token := payload + "." + s.mac(payload)
+	return stsCredentials{
+		AccessKeyID:     ss.AccessKeyID,
+		SecretAccessKey: s.secret(token),
+		SessionToken:    token,
+		Expiration:      expiration.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+var (
+	errInvalidToken = errors.New("invalid security token")
+	errExpiredToken = errors.New("expired security token")
+)
+
+// resolve verifies a session token and returns the temporary credential it describes.
+func (s *stsIssuer) resolve(token string, now time.Time) (*Credential, error) {
+	payload, mac, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.mac(payload))) {
+		return nil, errInvalidToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	var ss session
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return nil, errInvalidToken
+	}
+	expiration := time.Unix(ss.Expiration, 0).UTC()
+	if !now.Before(expiration) {
+		return nil, fmt.Errorf("%w: expired at %s", errExpiredToken, expiration.Format(time.RFC3339))
+	}
+	return &Credential{
+		AccessKeyID:     ss.AccessKeyID,
+		AccessSecretKey: s.secret(token),
+		Region:          ss.Region,
+		Service:         ss.Service,
+		NotAfter:        expiration.Format(time.RFC3339),
+		notAfter:        expiration,
+		parent:          ss.Parent,
+		scope:           ss.Scope,
+	}, nil
+}
+
+// temporary returns the temporary credential for a request carrying a session token, as long as its parent is still
+// one of the active credentials and hasn't expired.
+func (s *stsIssuer) temporary(token string, accessKeyID string, creds []*Credential, now time.Time) (*Credential, error) {
+	cred, err := s.resolve(token, now)
+	if err != nil {
+		return nil, err
+	}
+	if cred.AccessKeyID != accessKeyID {
+		return nil, fmt.Errorf("%w: it doesn't match the access key id", errInvalidToken)
+	}
+	for _, c := range creds {
+		if c.AccessKeyID == cred.parent && c.Region == cred.Region && c.Service == cred.Service {
+			if !c.notAfter.IsZero() && !now.Before(c.notAfter) {
+				return nil, fmt.Errorf("%w: parent access key id %q expired", errInvalidToken, cred.parent)
+			}
+			cred.Tags = c.Tags
+			cred.Roles = c.Roles
+			cred.Groups = c.Groups
+			cred.Policy = c.Policy
+			cred.windows = c.windows
+			cred.MaxInFlight = c.MaxInFlight
+			cred.inFlightWait = c.inFlightWait
+			cred.ByteQuota = c.ByteQuota
+			cred.RequestQuota = c.RequestQuota
+			cred.MaxUploadSize = c.MaxUploadSize
+			cred.Tenant = c.Tenant
+			cred.WriteOnce = c.WriteOnce
+			return cred, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: parent access key id %q is no longer valid", errInvalidToken, cred.parent)
+}
+
+type stsCredentials struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+// stsResponse is named after the action, eg: `GetSessionTokenResponse` containing a `GetSessionTokenResult`.
+type stsResponse struct {
+	XMLName xml.Name
+	Xmlns   string `xml:"xmlns,attr"`
+	Result  stsResult
+}
+
+type stsResult struct {
+	XMLName     xml.Name
+	Credentials stsCredentials `xml:"Credentials"`
+}
+
+type stsErrorResponse struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Type    string   `xml:"Error>Type"`
+	Code    string   `xml:"Error>Code"`
+	Message string   `xml:"Error>Message"`
+}
+
+// serve handles `GetSessionToken` and `AssumeRole` for an already authenticated caller. `AssumeRole` mints scoped
+// child credentials restricted by the repeatable `AllowedPrefix`, `AllowedMethod` and `AllowedBucket` parameters,
+// within the scope of the caller.
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_GetSessionToken.html
+func (s *stsIssuer) serve(rw http.ResponseWriter, req *http.Request, caller *Credential, now time.Time) {
+	if err := req.ParseForm(); err != nil {
+		writeSTSError(rw, http.StatusBadRequest, "InvalidParameterValue", "failed to parse the request")
+		return
+	}
+	action := req.Form.Get("Action")
+	if action != "GetSessionToken" && action != "AssumeRole" {
+		writeSTSError(rw, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("unsupported action: %q", action))
+		return
+	}
+	if caller.parent != "" || caller.AccessSecretKey == "" {
+		writeSTSError(rw, http.StatusForbidden, "AccessDenied", "temporary or iam credentials can't be used to get new credentials")
+		return
+	}
+	scope := caller.scope
+	if action == "AssumeRole" {
+		prefixes, err := normalizePrefixes(req.Form["AllowedPrefix"])
+		if err != nil {
+			writeSTSError(rw, http.StatusBadRequest, "ValidationError", "AllowedPrefix "+err.Error())
+			return
+		}
+		methods, err := normalizeMethods(req.Form["AllowedMethod"])
+		if err != nil {
+			writeSTSError(rw, http.StatusBadRequest, "ValidationError", "AllowedMethod "+err.Error())
+			return
+		}
+		buckets, err := normalizeBuckets(req.Form["AllowedBucket"])
+		if err != nil {
+			writeSTSError(rw, http.StatusBadRequest, "ValidationError", "AllowedBucket "+err.Error())
+			return
+		}
+		child := accessScope{Prefixes: prefixes, Methods: methods, Buckets: buckets}
+		if scope, err = caller.scope.narrow(child); err != nil {
+			writeSTSError(rw, http.StatusForbidden, "AccessDenied", err.Error())
+			return
+		}
+	}
+	d := s.defaultDuration
+	if v := req.Form.Get("DurationSeconds"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || time.Duration(secs)*time.Second < minSessionTimeout || time.Duration(secs)*time.Second > s.maxDuration {
+			writeSTSError(rw, http.StatusBadRequest, "ValidationError", fmt.Sprintf("DurationSeconds must be between %d and %d", int(minSessionTimeout.Seconds()), int(s.maxDuration.Seconds())))
+			return
+		}
+		d = time.Duration(secs) * time.Second
+	}
+
+	// The session never outlives its parent.
+	expiration := now.Add(d)
+	if !caller.notAfter.IsZero() && caller.notAfter.Before(expiration) {
+		expiration = caller.notAfter
+	}
+	creds, err := s.issue(caller, scope, expiration)
+	if err != nil {
+		logs.error("failed to issue temporary credentials", "error", err)
+		writeSTSError(rw, http.StatusInternalServerError, "InternalFailure", "failed to issue temporary credentials")
+		return
+	}
+	writeXML(rw, http.StatusOK, stsResponse{
+		XMLName: xml.Name{Local: action + "Response"},
+		Xmlns:   stsNamespace,
+		Result:  stsResult{XMLName: xml.Name{Local: action + "Result"}, Credentials: creds},
+	})
+}
+
+func writeSTSError(rw http.ResponseWriter, status int, code, message string) {
+	typ := "Sender"
+	if status >= http.StatusInternalServerError {
+		typ = "Receiver"
+	}
+	writeXML(rw, status, stsErrorResponse{Xmlns: stsNamespace, Type: typ, Code: code, Message: message})
+}
+
+func writeXML(rw http.ResponseWriter, status int, v interface{}) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		logs.error("failed to encode the xml response", "error", err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/xml")
+	rw.WriteHeader(status)
+	_, _ = rw.Write([]byte(xml.Header))
+	_, _ = rw.Write(b)
+}
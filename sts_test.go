@@ -0,0 +1,283 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+type sessionTokenResponse struct {
+	Credentials struct {
+		AccessKeyID     string `xml:"AccessKeyId"`
+		SecretAccessKey string `xml:"SecretAccessKey"`
+		SessionToken    string `xml:"SessionToken"`
+		Expiration      string `xml:"Expiration"`
+	} `xml:"GetSessionTokenResult>Credentials"`
+}
+
+type assumeRoleResponse struct {
+	Credentials struct {
+		AccessKeyID     string `xml:"AccessKeyId"`
+		SecretAccessKey string `xml:"SecretAccessKey"`
+		SessionToken    string `xml:"SessionToken"`
+	} `xml:"AssumeRoleResult>Credentials"`
+}
+
+func newSTSPlugin(t *testing.T, now time.Time) *plugin.Plugin {
+	t.Helper()
+
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{validCredential()}
+	cfg.STS = &plugin.STSConfig{Path: "/sts", SigningKey: strings.Repeat("k", 32)}
+	p := newTestPlugin(t, cfg)
+	p.Now = func() time.Time { return now }
+	return p
+}
+
+// callSTS makes an STS call signed for the `sts` service like the SDKs do and decodes the response into v.
+func callSTS(t *testing.T, p *plugin.Plugin, body string, now time.Time, v interface{}) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "https://s3.example.com/sts", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	sts := validCredential()
+	sts.Service = "sts"
+	signRequest(t, req, sts, now)
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+	if err := xml.Unmarshal(recorder.Body.Bytes(), v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSTS(t *testing.T) {
+	now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+	p := newSTSPlugin(t, now)
+
+	var resp sessionTokenResponse
+	callSTS(t, p, "Action=GetSessionToken&DurationSeconds=900&Version=2011-06-15", now, &resp)
+	if resp.Credentials.Expiration != "2025-07-10T06:00:00Z" {
+		t.Errorf("unexpected expiration: %q", resp.Credentials.Expiration)
+	}
+	temp := &plugin.Credential{
+		AccessKeyID:     resp.Credentials.AccessKeyID,
+		AccessSecretKey: resp.Credentials.SecretAccessKey,
+		Region:          "us-east-1",
+		Service:         "s3",
+	}
+
+	tc := []struct {
+		name           string
+		token          string
+		at             time.Time
+		expectedStatus int
+	}{
+		{
+			name:           "valid session",
+			token:          resp.Credentials.SessionToken,
+			at:             now.Add(time.Minute),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "expired session",
+			token:          resp.Credentials.SessionToken,
+			at:             now.Add(20 * time.Minute),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "tampered session",
+			token:          "x" + resp.Credentials.SessionToken,
+			at:             now.Add(time.Minute),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing session",
+			at:             now.Add(time.Minute),
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			p.Now = func() time.Time { return tt.at }
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("X-Amz-Security-Token", tt.token)
+			}
+			signRequest(t, req, temp, tt.at)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestSTSAssumeRole(t *testing.T) {
+	now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+	p := newSTSPlugin(t, now)
+
+	var resp assumeRoleResponse
+	callSTS(t, p, "Action=AssumeRole&AllowedPrefix=/bucket/workers/&AllowedMethod=GET&AllowedMethod=HEAD", now, &resp)
+	child := &plugin.Credential{
+		AccessKeyID:     resp.Credentials.AccessKeyID,
+		AccessSecretKey: resp.Credentials.SecretAccessKey,
+		Region:          "us-east-1",
+		Service:         "s3",
+	}
+
+	tc := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{
+			name:           "allowed",
+			method:         http.MethodGet,
+			path:           "/bucket/workers/1.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "outside of the prefix",
+			method:         http.MethodGet,
+			path:           "/bucket/other.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPut,
+			path:           "/bucket/workers/1.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, "https://s3.example.com"+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Amz-Security-Token", resp.Credentials.SessionToken)
+			signRequest(t, req, child, now)
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus == http.StatusForbidden && !strings.Contains(recorder.Body.String(), "<Code>AccessDenied</Code>") {
+				t.Errorf("expected an AccessDenied error, got %s", recorder.Body)
+			}
+		})
+	}
+}
+
+func TestSTSAssumeRoleBuckets(t *testing.T) {
+	now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+	parent := validCredential()
+	parent.AllowedBuckets = []string{"logs-?"}
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{parent}
+	cfg.STS = &plugin.STSConfig{Path: "/sts", SigningKey: strings.Repeat("k", 32)}
+	p := newTestPlugin(t, cfg)
+	p.Now = func() time.Time { return now }
+
+	tc := []struct {
+		name           string
+		bucket         string
+		expectedStatus int
+	}{
+		{name: "same pattern", bucket: "logs-?", expectedStatus: http.StatusOK},
+		{name: "matching name", bucket: "logs-a", expectedStatus: http.StatusOK},
+		{name: "wider pattern", bucket: "logs-*", expectedStatus: http.StatusForbidden},
+		{name: "class pattern", bucket: "logs-[a-z]", expectedStatus: http.StatusForbidden},
+		{name: "other name", bucket: "logs-abc", expectedStatus: http.StatusForbidden},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			body := "Action=AssumeRole&AllowedBucket=" + url.QueryEscape(tt.bucket)
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "https://s3.example.com/sts", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			sts := validCredential()
+			sts.Service = "sts"
+			signRequest(t, req, sts, now)
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestSTSParentExpiration(t *testing.T) {
+	now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+	parent := validCredential()
+	parent.NotAfter = "2025-07-10T05:55:00Z"
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{parent}
+	cfg.STS = &plugin.STSConfig{Path: "/sts", SigningKey: strings.Repeat("k", 32)}
+	p := newTestPlugin(t, cfg)
+	p.Now = func() time.Time { return now }
+
+	var resp sessionTokenResponse
+	callSTS(t, p, "Action=GetSessionToken&DurationSeconds=3600&Version=2011-06-15", now, &resp)
+	if resp.Credentials.Expiration != "2025-07-10T05:55:00Z" {
+		t.Errorf("expected the session to expire with its parent, got %q", resp.Credentials.Expiration)
+	}
+	temp := &plugin.Credential{
+		AccessKeyID:     resp.Credentials.AccessKeyID,
+		AccessSecretKey: resp.Credentials.SecretAccessKey,
+		Region:          "us-east-1",
+		Service:         "s3",
+	}
+	at := now.Add(11 * time.Minute)
+	p.Now = func() time.Time { return at }
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Amz-Security-Token", resp.Credentials.SessionToken)
+	signRequest(t, req, temp, at)
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, req)
+	if recorder.Code == http.StatusOK {
+		t.Errorf("expected the session to be rejected once its parent expired")
+	}
+
+	// A session issued before the parent got a `notAfter`, eg: through a reload, stops working once it passes.
+	long := newSTSPlugin(t, now)
+	callSTS(t, long, "Action=GetSessionToken&DurationSeconds=3600&Version=2011-06-15", now, &resp)
+	temp.AccessKeyID, temp.AccessSecretKey = resp.Credentials.AccessKeyID, resp.Credentials.SecretAccessKey
+	req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Amz-Security-Token", resp.Credentials.SessionToken)
+	signRequest(t, req, temp, at)
+	recorder = httptest.NewRecorder()
+	p.ServeHTTP(recorder, req)
+	if recorder.Code == http.StatusOK {
+		t.Errorf("expected the session to be rejected once its parent expired")
+	}
+}
@@ -0,0 +1,143 @@
+package traefik_plugin_s3_auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	syslogAppName   = "s3auth"
+	syslogQueueSize = 1024
+	syslogTimeout   = 5 * time.Second
+
+	syslogSeverityNotice = 5
+	syslogSeverityInfo   = 6
+)
+
+// syslogFacilities are the facility names accepted in the `facility` query parameter, `authpriv` by default.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogWriter sends RFC 5424 messages to a syslog endpoint, eg: `udp://syslog:514`, `tcp://syslog:514` or
+// `tls://syslog:6514?facility=local0`. The stream transports use the RFC 6587 octet counting framing. Messages are
+// sent asynchronously, so a slow endpoint never slows the requests down, and dropped once the queue is full.
+type syslogWriter struct {
+	network  string
+	addr     string
+	tls      *tls.Config
+	facility int
+	hostname string
+	queue    chan []byte
+	conn     net.Conn
+	dropped  uint64
+}
+
+// isSyslogURL reports whether the value is a syslog endpoint rather than a file path.
+func isSyslogURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "udp" || u.Scheme == "tcp" || u.Scheme == "tls")
+}
+
+func newSyslogWriter(raw string) (*syslogWriter, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog endpoint %q: %w", raw, err)
+	}
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil || host == "" {
+		return nil, fmt.Errorf("invalid syslog endpoint %q, eg: `udp://syslog:514`", raw)
+	}
+	s := &syslogWriter{
+		network:  u.Scheme,
+		addr:     u.Host,
+		facility: syslogFacilities["authpriv"],
+		hostname: "-",
+		queue:    make(chan []byte, syslogQueueSize),
+	}
+	if f := u.Query().Get("facility"); f != "" {
+		facility, ok := syslogFacilities[f]
+		if !ok {
+			return nil, fmt.Errorf("invalid syslog `facility` %q, eg: `authpriv` or `local0`", f)
+		}
+		s.facility = facility
+	}
+	if s.network == "tls" {
+		s.network = "tcp"
+		s.tls = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		s.hostname = h
+	}
+	go s.run()
+	return s, nil
+}
+
+// format renders the RFC 5424 message, whose `MSGID` is `decision`, framed for the transport.
+func (s *syslogWriter) format(severity int, now time.Time, msg []byte) []byte {
+	header := fmt.Sprintf("<%d>1 %s %s %s %d decision - ", s.facility*8+severity,
+		now.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, syslogAppName, os.Getpid())
+	b := make([]byte, 0, len(header)+len(msg)+8)
+	if s.network == "tcp" {
+		b = append(b, strconv.Itoa(len(header)+len(msg))...)
+		b = append(b, ' ')
+	}
+	b = append(b, header...)
+	return append(b, msg...)
+}
+
+// enqueue hands the message to the endpoint, dropping it when the queue is full.
+func (s *syslogWriter) enqueue(severity int, now time.Time, msg []byte) {
+	select {
+	case s.queue <- s.format(severity, now, msg):
+	default:
+		n := atomic.AddUint64(&s.dropped, 1)
+		logs.warn("syslog queue is full, dropped the message", "address", s.addr, "dropped", n)
+	}
+}
+
+func (s *syslogWriter) run() {
+	for b := range s.queue {
+		if err := s.send(b); err != nil {
+			logs.error("failed to send the syslog message", "address", s.addr, "error", err)
+		}
+	}
+}
+
+// send writes the message, reconnecting once when the connection was lost, eg: after the endpoint restarted.
+func (s *syslogWriter) send(b []byte) error {
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			conn, err := s.dial()
+			if err != nil {
+				return err
+			}
+			s.conn = conn
+		}
+		_ = s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		_, err := s.conn.Write(b)
+		if err == nil {
+			return nil
+		}
+		_ = s.conn.Close()
+		s.conn = nil
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+func (s *syslogWriter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	if s.tls != nil {
+		return tls.DialWithDialer(dialer, s.network, s.addr, s.tls)
+	}
+	return dialer.Dial(s.network, s.addr)
+}
@@ -0,0 +1,199 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultTenantPrefix = "{tenant}/"
+	maxDeleteBodyBytes  = 2 << 20
+)
+
+// TenancyConfig isolates the tenants sharing a bucket under their own key prefix.
+type TenancyConfig struct {
+	// Prefix of the keys of each tenant, `{tenant}` is replaced by the tenant id of the credential. Defaults to
+	// `{tenant}/` and must end with a `/`.
+	Prefix string `json:"prefix,omitempty"`
+	// Inject prepends the prefix to the keys sent by the clients instead of requiring them to include it.
+	Inject bool `json:"inject,omitempty"`
+}
+
+type tenancy struct {
+	prefix string
+	inject bool
+}
+
+func newTenancy(config *TenancyConfig) (*tenancy, error) {
+	if config == nil {
+		return nil, nil
+	}
+	t := &tenancy{prefix: config.Prefix, inject: config.Inject}
+	if t.prefix == "" {
+		t.prefix = defaultTenantPrefix
+	}
+	if !strings.Contains(t.prefix, "{tenant}") || !strings.HasSuffix(t.prefix, "/") || strings.HasPrefix(t.prefix, "/") {
+		return nil, fmt.Errorf("invalid tenancy `prefix` %q, eg: `tenants/{tenant}/`", t.prefix)
+	}
+	return t, nil
+}
+
+func checkTenant(tenant string) error {
+	if tenant == "." || tenant == ".." || strings.ContainsAny(tenant, "/*?{}") {
+		return errors.New("must not be `.`, `..` or contain any of `/*?{}`")
+	}
+	return nil
+}
+
+// hasDotSegment reports whether the key has a `.` or `..` segment, eg: `acme/../globex/secret`, which backends resolving
+// them would serve from outside of the tenant prefix.
+func hasDotSegment(key string) bool {
+	for _, s := range strings.Split(key, "/") {
+		if s == "." || s == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// confined reports an error unless the key is within the prefix, or can be injected into it.
+func (t *tenancy) confined(what, key, prefix string) error {
+	if hasDotSegment(key) {
+		return fmt.Errorf("%s %q has dot segments", what, key)
+	}
+	if !t.inject && !strings.HasPrefix(key, prefix) {
+		return fmt.Errorf("%s %q is outside of the tenant prefix %q", what, key, prefix)
+	}
+	return nil
+}
+
+// apply confines the request to the prefix of the tenant, rewriting it when injecting. Bucket operations other than
+// listings, eg: `PutBucketPolicy`, are denied since they affect every tenant. Keys with dot segments are denied.
+func (t *tenancy) apply(req *http.Request, res s3Resource, op s3Operation, tenant string) error {
+	prefix := strings.ReplaceAll(t.prefix, "{tenant}", tenant)
+	switch {
+	case res.Key != "":
+		if err := t.confined("key", res.Key, prefix); err != nil {
+			return err
+		}
+		if err := t.applyCopySource(req, prefix); err != nil {
+			return err
+		}
+		if !t.inject {
+			return nil
+		}
+		req.URL.Path = strings.TrimSuffix(req.URL.Path, res.Key) + prefix + res.Key
+		req.URL.RawPath = ""
+		return nil
+	case listings[op.Name]:
+		q := req.URL.Query()
+		if err := t.confined("listing prefix", q.Get("prefix"), prefix); err != nil || !t.inject {
+			return err
+		}
+		q.Set("prefix", prefix+q.Get("prefix"))
+		req.URL.RawQuery = q.Encode()
+		return nil
+	case op.Name == "DeleteObjects":
+		return t.applyDelete(req, prefix)
+	case op.Name == "HeadBucket" || op.Name == "GetBucketLocation":
+		return nil
+	default:
+		return fmt.Errorf("operation %s is not allowed for tenants", op.Name)
+	}
+}
+
+// applyCopySource confines the `x-amz-copy-source` of `CopyObject` and `UploadPartCopy`, eg: `/bucket/key?versionId=1`.
+func (t *tenancy) applyCopySource(req *http.Request, prefix string) error {
+	h := req.Header.Get("X-Amz-Copy-Source")
+	if h == "" {
+		return nil
+	}
+	src, version, hasVersion := strings.Cut(strings.TrimPrefix(h, "/"), "?")
+	src, err := url.PathUnescape(src)
+	if err != nil {
+		return errors.New("invalid copy source")
+	}
+	bucket, key, _ := strings.Cut(src, "/")
+	if err := t.confined("copy source", key, prefix); err != nil || !t.inject {
+		return err
+	}
+	h = (&url.URL{Path: "/" + bucket + "/" + prefix + key}).EscapedPath()
+	if hasVersion {
+		h += "?" + version
+	}
+	req.Header.Set("X-Amz-Copy-Source", h)
+	return nil
+}
+
+type deleteRequest struct {
+	XMLName xml.Name       `xml:"Delete"`
+	Objects []deleteObject `xml:"Object"`
+	Quiet   bool           `xml:"Quiet,omitempty"`
+}
+
+type deleteObject struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+}
+
+// readDelete reads the body of a `DeleteObjects` request, restoring it for the next checks and the backend.
+func readDelete(req *http.Request) (deleteRequest, []byte, error) {
+	var d deleteRequest
+	if req.Body == nil {
+		return d, nil, errors.New("invalid delete request")
+	}
+	b, err := io.ReadAll(io.LimitReader(req.Body, maxDeleteBodyBytes+1))
+	if err != nil {
+		return d, nil, fmt.Errorf("failed to read the delete request: %w", err)
+	}
+	if len(b) > maxDeleteBodyBytes {
+		return d, nil, errors.New("the delete request is too large")
+	}
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	if err := xml.Unmarshal(b, &d); err != nil {
+		return d, nil, errors.New("invalid delete request")
+	}
+	return d, b, nil
+}
+
+// applyDelete confines the keys of a `DeleteObjects` request. When injecting the body is rewritten, so its checksums
+// are recomputed or dropped.
+func (t *tenancy) applyDelete(req *http.Request, prefix string) error {
+	d, b, err := readDelete(req)
+	if err != nil {
+		return err
+	}
+	for i, o := range d.Objects {
+		if err := t.confined("key", o.Key, prefix); err != nil {
+			return err
+		}
+		if t.inject {
+			d.Objects[i].Key = prefix + o.Key
+		}
+	}
+	if t.inject {
+		if b, err = xml.Marshal(d); err != nil {
+			return err
+		}
+		sum := md5.Sum(b) //nolint:gosec
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		for k := range req.Header {
+			if strings.HasPrefix(k, "X-Amz-Checksum-") || k == "X-Amz-Sdk-Checksum-Algorithm" {
+				req.Header.Del(k)
+			}
+		}
+		req.Header.Set("Content-Length", strconv.Itoa(len(b)))
+		req.ContentLength = int64(len(b))
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestTenancy(t *testing.T) {
+	tc := []struct {
+		name               string
+		inject             bool
+		method             string
+		target             string
+		copySource         string
+		body               string
+		expectedStatus     int
+		expectedTarget     string
+		expectedCopySource string
+		expectedBody       string
+	}{
+		{
+			name:           "object inside the prefix",
+			method:         http.MethodGet,
+			target:         "/shared/tenants/acme/report.csv",
+			expectedStatus: http.StatusOK,
+			expectedTarget: "/shared/tenants/acme/report.csv",
+		},
+		{
+			name:           "object outside the prefix",
+			method:         http.MethodGet,
+			target:         "/shared/tenants/other/report.csv",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "object escaping the prefix",
+			method:         http.MethodGet,
+			target:         "/shared/tenants/acme/../other/report.csv",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "injected object escaping the prefix",
+			inject:         true,
+			method:         http.MethodGet,
+			target:         "/shared/../other/report.csv",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "copy source escaping the prefix",
+			method:         http.MethodPut,
+			target:         "/shared/tenants/acme/copy.csv",
+			copySource:     "/shared/tenants/acme/../other/report.csv",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "delete escaping the prefix",
+			method:         http.MethodPost,
+			target:         "/shared?delete",
+			body:           `<Delete><Object><Key>tenants/acme/../other/a.csv</Key></Object></Delete>`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "listing inside the prefix",
+			method:         http.MethodGet,
+			target:         "/shared?list-type=2&prefix=tenants%2Facme%2F",
+			expectedStatus: http.StatusOK,
+			expectedTarget: "/shared?list-type=2&prefix=tenants%2Facme%2F",
+		},
+		{
+			name:           "listing the whole bucket",
+			method:         http.MethodGet,
+			target:         "/shared?list-type=2",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "bucket configuration",
+			method:         http.MethodPut,
+			target:         "/shared?policy",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "copy source outside the prefix",
+			method:         http.MethodPut,
+			target:         "/shared/tenants/acme/copy.csv",
+			copySource:     "/shared/tenants/other/report.csv",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "delete outside the prefix",
+			method:         http.MethodPost,
+			target:         "/shared?delete",
+			body:           `<Delete><Object><Key>tenants/acme/a.csv</Key></Object><Object><Key>b.csv</Key></Object></Delete>`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "injected object",
+			inject:         true,
+			method:         http.MethodGet,
+			target:         "/shared/report.csv",
+			expectedStatus: http.StatusOK,
+			expectedTarget: "/shared/tenants/acme/report.csv",
+		},
+		{
+			name:           "injected listing",
+			inject:         true,
+			method:         http.MethodGet,
+			target:         "/shared?list-type=2&prefix=2025%2F",
+			expectedStatus: http.StatusOK,
+			expectedTarget: "/shared?list-type=2&prefix=tenants%2Facme%2F2025%2F",
+		},
+		{
+			name:               "injected copy source",
+			inject:             true,
+			method:             http.MethodPut,
+			target:             "/shared/copy.csv",
+			copySource:         "/shared/report%20final.csv?versionId=1",
+			expectedStatus:     http.StatusOK,
+			expectedTarget:     "/shared/tenants/acme/copy.csv",
+			expectedCopySource: "/shared/tenants/acme/report%20final.csv?versionId=1",
+		},
+		{
+			name:           "injected delete",
+			inject:         true,
+			method:         http.MethodPost,
+			target:         "/shared?delete",
+			body:           `<Delete><Object><Key>a.csv</Key></Object></Delete>`,
+			expectedStatus: http.StatusOK,
+			expectedTarget: "/shared?delete",
+			expectedBody:   `<Delete><Object><Key>tenants/acme/a.csv</Key></Object></Delete>`,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var target, copySource, body string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				target = req.URL.RequestURI()
+				copySource = req.Header.Get("X-Amz-Copy-Source")
+				b, _ := io.ReadAll(req.Body)
+				body = string(b)
+			})
+			cred := validCredential()
+			cred.Tenant = "acme"
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.Tenancy = &plugin.TenancyConfig{Prefix: "tenants/{tenant}/", Inject: tt.inject}
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, "https://s3.example.com"+tt.target, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.copySource != "" {
+				req.Header.Set("X-Amz-Copy-Source", tt.copySource)
+			}
+			signRequest(t, req, cred, time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC))
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if target != tt.expectedTarget {
+				t.Errorf("expected the backend target %q, got %q", tt.expectedTarget, target)
+			}
+			if tt.expectedCopySource != "" && copySource != tt.expectedCopySource {
+				t.Errorf("expected the copy source %q, got %q", tt.expectedCopySource, copySource)
+			}
+			if tt.expectedBody != "" && body != tt.expectedBody {
+				t.Errorf("expected the body %q, got %q", tt.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestInvalidTenancy(t *testing.T) {
+	for _, prefix := range []string{"tenants/", "tenants/{tenant}", "/{tenant}/"} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.Tenancy = &plugin.TenancyConfig{Prefix: prefix}
+		if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "tenancy") {
+			t.Errorf("expected a tenancy error for %q, got %v", prefix, err)
+		}
+	}
+	cred := validCredential()
+	cred.Tenant = "acme/other"
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "tenant") {
+		t.Errorf("expected a tenant error, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package traefik_plugin_s3_auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const defaultFailurePeriod = 15 * time.Minute
+
+// FailureThrottleConfig slows down the clients failing to authenticate, eg: to make guessing secrets or enumerating
+// access key ids impractical.
+type FailureThrottleConfig struct {
+	// Delay holds every failed response of the client back, eg: `1s`.
+	Delay string `json:"delay,omitempty"`
+	// MaxFailures is the number of failures of a client ip per Period after which its requests are rejected with
+	// `SlowDown` until the period ends.
+	MaxFailures int64 `json:"maxFailures,omitempty"`
+	// Period of the failure counters, defaults to `15m`.
+	Period string `json:"period,omitempty"`
+}
+
+type failureThrottle struct {
+	delay       time.Duration
+	maxFailures int64
+	period      time.Duration
+	counters    counters
+}
+
+func newFailureThrottle(config *FailureThrottleConfig, c counters) (*failureThrottle, error) {
+	if config == nil {
+		return nil, nil
+	}
+	t := &failureThrottle{maxFailures: config.MaxFailures, period: defaultFailurePeriod, counters: c}
+	if config.Delay != "" {
+		d, err := time.ParseDuration(config.Delay)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid failure throttle `delay` %q, eg: `1s`", config.Delay)
+		}
+		t.delay = d
+	}
+	if config.Period != "" {
+		d, err := time.ParseDuration(config.Period)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid failure throttle `period` %q, eg: `15m`", config.Period)
+		}
+		t.period = d
+	}
+	if t.maxFailures < 0 {
+		return nil, errors.New("the failure throttle `maxFailures` can't be negative")
+	}
+	if t.delay == 0 && t.maxFailures == 0 {
+		return nil, errors.New("the failure throttle needs a `delay` or `maxFailures`")
+	}
+	return t, nil
+}
+
+// window returns the key of the failure counter of the address for the current period, and when the period ends.
+func (t *failureThrottle) window(addr string, now time.Time) (string, time.Time) {
+	start := now.Truncate(t.period)
+	return "failures:" + addr + ":" + strconv.FormatInt(start.Unix(), 10), start.Add(t.period)
+}
+
+// blocked reports whether the address failed MaxFailures times in the current period, and for how long it stays
+// blocked. Like the quotas, the throttle fails open.
+func (t *failureThrottle) blocked(addr string, now time.Time) (time.Duration, bool) {
+	if t.maxFailures == 0 {
+		return 0, false
+	}
+	key, end := t.window(addr, now)
+	n, err := t.counters.get(key)
+	if err != nil {
+		logs.error("failed to read the failures", "sourceIp", addr, "error", err)
+		return 0, false
+	}
+	return end.Sub(now), n >= t.maxFailures
+}
+
+// fail counts the failure of the address and holds the response back for the delay.
+func (t *failureThrottle) fail(ctx context.Context, addr string, now time.Time) {
+	if t.maxFailures > 0 {
+		key, end := t.window(addr, now)
+		if _, err := t.counters.incr(key, end.Sub(now)); err != nil {
+			logs.error("failed to count the failure", "sourceIp", addr, "error", err)
+		}
+	}
+	if t.delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(t.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
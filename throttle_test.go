@@ -0,0 +1,88 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestFailureThrottle(t *testing.T) {
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.FailureThrottle = &plugin.FailureThrottleConfig{Delay: "20ms", MaxFailures: 2, Period: "1h"}
+	p := newTestPlugin(t, cfg)
+
+	wrong := validCredential()
+	wrong.AccessSecretKey = "WRONG_WRONG_WRONG"
+	tc := []struct {
+		name           string
+		cred           *plugin.Credential
+		addr           string
+		expectedStatus int
+		delayed        bool
+	}{
+		{name: "unsigned", addr: "203.0.113.8:1234", expectedStatus: http.StatusForbidden},
+		{name: "first failure", cred: wrong, addr: "203.0.113.8:1234", expectedStatus: http.StatusForbidden, delayed: true},
+		{name: "valid", cred: cred, addr: "203.0.113.8:1234", expectedStatus: http.StatusOK},
+		{name: "second failure", cred: wrong, addr: "203.0.113.8:1234", expectedStatus: http.StatusForbidden, delayed: true},
+		{name: "blocked", cred: cred, addr: "203.0.113.8:1234", expectedStatus: http.StatusServiceUnavailable},
+		{name: "other client", cred: cred, addr: "203.0.113.9:1234", expectedStatus: http.StatusOK},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/object.txt", nil)
+			req.RemoteAddr = tt.addr
+			if tt.cred != nil {
+				signRequest(t, req, tt.cred, p.Now())
+			}
+			recorder := httptest.NewRecorder()
+			start := time.Now()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status code %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body)
+			}
+			if elapsed := time.Since(start); tt.delayed && elapsed < 20*time.Millisecond {
+				t.Errorf("expected the failure to be delayed, answered after %s", elapsed)
+			}
+			if tt.expectedStatus == http.StatusServiceUnavailable {
+				if !strings.Contains(recorder.Body.String(), "<Code>SlowDown</Code>") {
+					t.Errorf("expected a SlowDown error, got %s", recorder.Body)
+				}
+				// Until the end of the hour.
+				if recorder.Header().Get("Retry-After") != "900" {
+					t.Errorf("expected to retry once the period ends, got %q", recorder.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidFailureThrottle(t *testing.T) {
+	tc := []struct {
+		name     string
+		throttle *plugin.FailureThrottleConfig
+		expected string
+	}{
+		{name: "empty", throttle: &plugin.FailureThrottleConfig{}, expected: "needs a `delay` or `maxFailures`"},
+		{name: "invalid delay", throttle: &plugin.FailureThrottleConfig{Delay: "soon"}, expected: "`delay`"},
+		{name: "invalid period", throttle: &plugin.FailureThrottleConfig{MaxFailures: 5, Period: "1ms"}, expected: "`period`"},
+		{name: "negative", throttle: &plugin.FailureThrottleConfig{MaxFailures: -1}, expected: "`maxFailures`"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			cfg.FailureThrottle = tt.throttle
+			_, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "s3-plugin")
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected an error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
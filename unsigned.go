@@ -0,0 +1,66 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// UnsignedClientsConfig lets legacy clients without SigV4 support reach the backend from trusted networks. Their
+// unsigned requests act as one of the credentials and are signed with the upstream credentials.
+type UnsignedClientsConfig struct {
+	// CIDRs the unsigned requests are accepted from, eg: `10.0.0.0/8`.
+	CIDRs []string `json:"cidrs,omitempty"`
+	// AccessKeyID of the credential the requests act as, so its restrictions, policies and quotas apply.
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+}
+
+type unsignedClients struct {
+	cidrs       []string
+	accessKeyID string
+}
+
+func newUnsignedClients(config *UnsignedClientsConfig, upstream *upstream) (*unsignedClients, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if upstream == nil {
+		return nil, errors.New("`unsignedClients` requires `upstream` to sign the requests")
+	}
+	if len(config.CIDRs) == 0 || config.AccessKeyID == "" {
+		return nil, errors.New("must specify the `cidrs` and the `accessKeyId` of the unsigned clients")
+	}
+	cidrs, err := normalizeCIDRs(config.CIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unsigned clients: %w", err)
+	}
+	return &unsignedClients{cidrs: cidrs, accessKeyID: config.AccessKeyID}, nil
+}
+
+// covers reports whether the request is unsigned, ie: has neither the authorization header nor a presigned signature,
+// and comes from one of the trusted networks.
+func (u *unsignedClients) covers(req *http.Request, headerName, ip string) bool {
+	if req.Header.Get(headerName) != "" || req.URL.Query().Get("X-Amz-Signature") != "" {
+		return false
+	}
+	return inCIDRs(u.cidrs, ip)
+}
+
+// credential returns the credential the unsigned requests act as.
+func (u *unsignedClients) credential(store *credentialStore, now time.Time) (*Credential, error) {
+	creds, err := store.active()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range creds {
+		if c.AccessKeyID != u.accessKeyID {
+			continue
+		}
+		if !c.notAfter.IsZero() && now.After(c.notAfter) {
+			return nil, fmt.Errorf("access key id %q expired at %s", c.AccessKeyID, c.NotAfter)
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown access key id of the unsigned clients: %q", u.accessKeyID)
+}
@@ -0,0 +1,38 @@
+package traefik_plugin_s3_auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	upgradeValidate         = "validate"
+	upgradeReject           = "reject"
+	upgradeBypass           = "bypass"
+	upgradeValidateIfSigned = "validateIfSigned"
+)
+
+func checkUpgradePolicy(policy string) error {
+	switch policy {
+	case "", upgradeValidate, upgradeReject, upgradeBypass, upgradeValidateIfSigned:
+		return nil
+	default:
+		return fmt.Errorf("invalid `upgradePolicy` %q, must be `validate`, `reject`, `bypass` or `validateIfSigned`", policy)
+	}
+}
+
+// isUpgrade reports whether the request asks to switch protocols, eg: a WebSocket handshake.
+func isUpgrade(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+var errEntityTooLarge = errors.New("upload is larger than the maximum size")
+
+// checkUploadSize rejects uploads whose declared size is over max. The `aws-chunked` encoding declares the size of
+// the object in `x-amz-decoded-content-length`, while `Content-Length` includes the framing and chunk signatures.
+func checkUploadSize(req *http.Request, max int64) error {
+	if req.ContentLength > max && !isAWSChunked(req) {
+		return fmt.Errorf("%w: %d bytes", errEntityTooLarge, req.ContentLength)
+	}
+	if v := req.Header.Get("X-Amz-Decoded-Content-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid x-amz-decoded-content-length: %q", v)
+		}
+		if n > max {
+			return fmt.Errorf("%w: %d bytes", errEntityTooLarge, n)
+		}
+	}
+	return nil
+}
+
+// limitedBody fails reads once more than max bytes were read, for streamed uploads without a `Content-Length`.
+type limitedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	if b.left < 0 {
+		return n, errEntityTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,91 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestMaxUploadSize(t *testing.T) {
+	tc := []struct {
+		name           string
+		body           string
+		streamed       bool
+		decodedLength  string
+		expectedStatus int
+	}{
+		{
+			name:           "within the limit",
+			body:           "12345678",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "content length over the limit",
+			body:           "123456789012",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "decoded content length over the limit",
+			body:           "12345678",
+			decodedLength:  "4096",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "streamed within the limit",
+			body:           "12345678",
+			streamed:       true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "streamed over the limit",
+			body:           "123456789012",
+			streamed:       true,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+	cred := validCredential()
+	cred.MaxUploadSize = 10
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if _, err := io.Copy(io.Discard, req.Body); err != nil {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	})
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPut, "https://s3.example.com/bucket/object.bin", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.streamed {
+				req.ContentLength = -1
+			}
+			if tt.decodedLength != "" {
+				req.Header.Set("X-Amz-Decoded-Content-Length", tt.decodedLength)
+			}
+			signRequest(t, req, cred, p.Now())
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus == http.StatusBadRequest && !strings.Contains(recorder.Body.String(), "<Code>EntityTooLarge</Code>") {
+				t.Errorf("expected an EntityTooLarge error, got %s", recorder.Body)
+			}
+		})
+	}
+}
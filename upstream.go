@@ -0,0 +1,194 @@
+package traefik_plugin_s3_auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	streamingPayload = "STREAMING-AWS4-"
+)
+
+// presignedParams authenticate presigned URLs. They are dropped when re-signing, so the backend only sees the header
+// authentication.
+var presignedParams = []string{
+	"X-Amz-Algorithm", "X-Amz-Credential", "X-Amz-Date", "X-Amz-Expires", "X-Amz-SignedHeaders", "X-Amz-Signature",
+	"X-Amz-Security-Token",
+}
+
+// errStreamingPayload is returned for uploads whose chunks are signed with the client credentials. Unsigned streaming
+// uploads, eg: `STREAMING-UNSIGNED-PAYLOAD-TRAILER`, are forwarded as is.
+var errStreamingPayload = errors.New("streaming signed payloads can't be re-signed")
+
+// signingScheme is the flavor of SigV4 the backend expects.
+type signingScheme struct {
+	algorithm  string
+	keyPrefix  string
+	service    string
+	terminator string
+	// headers is the prefix of the date and payload headers, eg: `x-amz-date`.
+	headers string
+}
+
+// signingSchemes are the schemes upstreams can sign with, `goog4` is the HMAC scheme of the GCS XML API.
+// https://cloud.google.com/storage/docs/authentication/signatures
+var signingSchemes = map[string]signingScheme{
+	"aws4":  {algorithm: "AWS4-HMAC-SHA256", keyPrefix: "AWS4", service: "s3", terminator: "aws4_request", headers: "x-amz-"},
+	"goog4": {algorithm: "GOOG4-HMAC-SHA256", keyPrefix: "GOOG4", service: "storage", terminator: "goog4_request", headers: "x-goog-"},
+}
+
+// UpstreamConfig re-signs validated requests with the credentials of the backend, eg: a real S3 bucket or MinIO, so
+// clients never hold them.
+type UpstreamConfig struct {
+	// AccessKeyID and AccessSecretKey of the backend, or the names of the environment variables holding them.
+	AccessKeyID        string `json:"accessKeyId,omitempty"`
+	AccessSecretKey    string `json:"accessSecretKey,omitempty"`
+	AccessKeyIDEnv     string `json:"accessKeyIdEnv,omitempty"`
+	AccessSecretKeyEnv string `json:"accessSecretKeyEnv,omitempty"`
+	// Region of the backend, defaults to `us-east-1`.
+	Region string `json:"region,omitempty"`
+	// Host is the backend host, eg: `s3.eu-central-1.amazonaws.com`. The request is signed for and sent with it, it
+	// defaults to the host of the request.
+	Host string `json:"host,omitempty"`
+	// Scheme is either `aws4` (the default) or `goog4` to sign for the GCS XML API with an HMAC key.
+	Scheme string `json:"scheme,omitempty"`
+}
+
+type upstream struct {
+	accessKeyID string
+	secret      string
+	region      string
+	host        string
+	scheme      signingScheme
+}
+
+func newUpstream(config *UpstreamConfig) (*upstream, error) {
+	if config == nil {
+		return nil, nil
+	}
+	u := &upstream{accessKeyID: config.AccessKeyID, secret: config.AccessSecretKey, region: config.Region, host: config.Host}
+	if config.AccessKeyIDEnv != "" {
+		u.accessKeyID = os.Getenv(config.AccessKeyIDEnv)
+	}
+	if config.AccessSecretKeyEnv != "" {
+		u.secret = os.Getenv(config.AccessSecretKeyEnv)
+	}
+	if u.accessKeyID == "" || u.secret == "" {
+		return nil, errors.New("must specify both the upstream `accessKeyId` and `accessSecretKey`, or their environment variables")
+	}
+	if u.region == "" {
+		u.region = "us-east-1"
+	}
+	name := config.Scheme
+	if name == "" {
+		name = "aws4"
+	}
+	scheme, ok := signingSchemes[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid upstream `scheme` %q, must be `aws4` or `goog4`", config.Scheme)
+	}
+	u.scheme = scheme
+	return u, nil
+}
+
+// sign replaces the signature of the client with one of the backend credentials. Only the host, the `x-amz-*` and
+// `x-goog-*` headers and `content-md5` are signed, proxies may change the others.
+func (u *upstream) sign(req *http.Request, now time.Time) error {
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if strings.HasPrefix(payload, streamingPayload) {
+		return errStreamingPayload
+	}
+	if payload == "" {
+		payload = unsignedPayload
+	}
+	req.Header.Del("Authorization")
+	req.Header.Del("X-Amz-Security-Token")
+	req.Header.Del("X-Amz-Date")
+	req.Header.Del("X-Amz-Content-Sha256")
+	if q := req.URL.Query(); q.Get("X-Amz-Signature") != "" {
+		for _, k := range presignedParams {
+			q.Del(k)
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+	date := now.UTC().Format("20060102T150405Z")
+	req.Header.Set(u.scheme.headers+"date", date)
+	req.Header.Set(u.scheme.headers+"content-sha256", payload)
+	if u.host != "" {
+		req.Host = u.host
+	}
+
+	headers := map[string]string{"host": req.Host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || strings.HasPrefix(k, "x-goog-") || k == "content-md5" {
+			headers[k] = strings.Join(v, ",")
+		}
+	}
+	names := sortedKeys(headers)
+	canonical := make([]string, 0, len(names))
+	for _, n := range names {
+		canonical = append(canonical, n+":"+strings.TrimSpace(headers[n]))
+	}
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{
+		req.Method, awsEncode(req.URL.Path, false), canonicalQuery(req), strings.Join(canonical, "\n") + "\n", signed, payload,
+	}, "\n")
+
+	s := u.scheme
+	sum := sha256.Sum256([]byte(request))
+	scope := date[:8] + "/" + u.region + "/" + s.service + "/" + s.terminator
+	toSign := s.algorithm + "\n" + date + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	m := hmac.New(sha256.New, deriveKey(s.keyPrefix, s.terminator, u.secret, date[:8], u.region, s.service))
+	m.Write([]byte(toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.algorithm, u.accessKeyID, scope, signed, hex.EncodeToString(m.Sum(nil))))
+	return nil
+}
+
+// canonicalQuery encodes every parameter and value, sorted by name then value.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html#create-canonical-request
+func canonicalQuery(req *http.Request) string {
+	var pairs [][2]string
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			pairs = append(pairs, [2]string{awsEncode(k, true), awsEncode(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	out := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		out = append(out, p[0]+"="+p[1])
+	}
+	return strings.Join(out, "&")
+}
+
+// awsEncode percent-encodes everything but the unreserved characters, and the slashes of paths.
+func awsEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
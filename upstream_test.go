@@ -0,0 +1,273 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestUpstream(t *testing.T) {
+	backend := &plugin.Credential{
+		AccessKeyID:     "BACKEND_BACKEND_KEY1",
+		AccessSecretKey: "BACKENDsecret123456BACKENDsecret12345678",
+		Region:          "eu-central-1",
+		Service:         "s3",
+	}
+	t.Setenv("S3AUTH_TEST_UPSTREAM_SECRET", backend.AccessSecretKey)
+
+	tc := []struct {
+		name           string
+		method         string
+		path           string
+		payload        string
+		public         bool
+		expectedStatus int
+	}{
+		{
+			name:           "object",
+			method:         http.MethodGet,
+			path:           "/bucket/reports/2025.csv",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "listing",
+			method:         http.MethodGet,
+			path:           "/bucket?list-type=2&prefix=reports%2F&max-keys=10",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "public read",
+			method:         http.MethodGet,
+			path:           "/site/index.html",
+			public:         true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "presigned public read",
+			method:         http.MethodGet,
+			path:           "/site/index.html?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKIAOTHER%2F20250710%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Signature=abc",
+			public:         true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "streaming upload",
+			method:         http.MethodPut,
+			path:           "/bucket/reports/2025.csv",
+			payload:        "STREAMING-AWS4-HMAC-SHA256-PAYLOAD",
+			expectedStatus: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+			// The backend is another middleware only knowing the backend credentials.
+			verifyCfg := plugin.CreateConfig()
+			verifyCfg.Credentials = []*plugin.Credential{backend}
+			verified := false
+			verify, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				verified = true
+			}), verifyCfg, "s3-backend")
+			if err != nil {
+				t.Fatal(err)
+			}
+			verify.(*plugin.Plugin).Now = func() time.Time { return now }
+
+			var forwarded *http.Request
+			cred := validCredential()
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.PublicReadPrefixes = []string{"site/"}
+			cfg.Upstream = &plugin.UpstreamConfig{AccessKeyID: backend.AccessKeyID, AccessSecretKeyEnv: "S3AUTH_TEST_UPSTREAM_SECRET", Region: backend.Region, Host: "s3.eu-central-1.example.com"}
+			handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req
+			}), cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return now }
+
+			req := httptest.NewRequest(tt.method, "https://s3.example.com"+tt.path, nil)
+			if tt.payload != "" {
+				req.Header.Set("X-Amz-Content-Sha256", tt.payload)
+			}
+			if strings.HasPrefix(tt.payload, "STREAMING-") {
+				req.Header.Set("X-Amz-Decoded-Content-Length", "0")
+			}
+			if !tt.public {
+				signRequest(t, req, cred, now)
+			}
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if forwarded.Host != "s3.eu-central-1.example.com" || !strings.Contains(forwarded.Header.Get("Authorization"), "Credential="+backend.AccessKeyID+"/") {
+				t.Fatalf("expected the request to be re-signed for the backend, got host %q and %q", forwarded.Host, forwarded.Header.Get("Authorization"))
+			}
+			if q := forwarded.URL.Query(); q.Get("X-Amz-Signature") != "" || q.Get("X-Amz-Credential") != "" {
+				t.Errorf("expected the query authentication to be dropped, got %q", forwarded.URL.RawQuery)
+			}
+			recorder = httptest.NewRecorder()
+			verify.ServeHTTP(recorder, forwarded)
+			if recorder.Code != http.StatusOK || !verified {
+				t.Errorf("expected the backend to accept the signature, got status code %d", recorder.Code)
+			}
+		})
+	}
+}
+
+func TestUpstreamGCS(t *testing.T) {
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.Upstream = &plugin.UpstreamConfig{
+		AccessKeyID: "GOOG1EXAMPLEKEY", AccessSecretKey: "gcs-secret", Region: "auto", Host: "storage.googleapis.com", Scheme: "goog4",
+	}
+	var forwarded *http.Request
+	handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req
+	}), cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+	p.Now = func() time.Time { return now }
+
+	req := httptest.NewRequest(http.MethodGet, "https://s3.example.com/bucket/reports/2025.csv", nil)
+	signRequest(t, req, cred, now)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if forwarded == nil {
+		t.Fatal("expected the request to be forwarded")
+	}
+	prefix := "GOOG4-HMAC-SHA256 Credential=GOOG1EXAMPLEKEY/20250710/auto/storage/goog4_request, SignedHeaders=host;x-goog-content-sha256;x-goog-date, Signature="
+	if a := forwarded.Header.Get("Authorization"); !strings.HasPrefix(a, prefix) {
+		t.Errorf("expected a GOOG4 authorization, got %q", a)
+	}
+	if forwarded.Header.Get("X-Goog-Date") != "20250710T054500Z" || forwarded.Header.Get("X-Amz-Date") != "" || forwarded.Header.Get("X-Amz-Content-Sha256") != "" {
+		t.Errorf("expected the x-amz signing headers to be translated, got %v", forwarded.Header)
+	}
+}
+
+func TestInvalidUpstream(t *testing.T) {
+	tc := []struct {
+		name      string
+		configure func(cfg *plugin.Config)
+	}{
+		{
+			name: "unset secret",
+			configure: func(cfg *plugin.Config) {
+				cfg.Upstream = &plugin.UpstreamConfig{AccessKeyID: "BACKEND_BACKEND_KEY1", AccessSecretKeyEnv: "S3AUTH_TEST_UNSET_SECRET"}
+			},
+		},
+		{
+			name: "unknown scheme",
+			configure: func(cfg *plugin.Config) {
+				cfg.Upstream = &plugin.UpstreamConfig{AccessKeyID: "BACKEND_BACKEND_KEY1", AccessSecretKey: "secret", Scheme: "azure"}
+			},
+		},
+		{
+			name: "unsigned clients without upstream",
+			configure: func(cfg *plugin.Config) {
+				cfg.UnsignedClients = &plugin.UnsignedClientsConfig{CIDRs: []string{"10.0.0.0/8"}, AccessKeyID: "ACCESS_ACCESS_ACCESS"}
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{validCredential()}
+			tt.configure(cfg)
+			if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "upstream") {
+				t.Errorf("expected an upstream error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnsignedClients(t *testing.T) {
+	backend := &plugin.Credential{
+		AccessKeyID:     "BACKEND_BACKEND_KEY1",
+		AccessSecretKey: "BACKENDsecret123456BACKENDsecret12345678",
+		Region:          "us-east-1",
+		Service:         "s3",
+	}
+	tc := []struct {
+		name           string
+		method         string
+		remoteAddr     string
+		expectedStatus int
+	}{
+		{
+			name:           "trusted network",
+			method:         http.MethodGet,
+			remoteAddr:     "10.1.2.3:43210",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "restricted by the credential",
+			method:         http.MethodPut,
+			remoteAddr:     "10.1.2.3:43210",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "other network",
+			method:         http.MethodGet,
+			remoteAddr:     "192.0.2.10:43210",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC)
+			verifyCfg := plugin.CreateConfig()
+			verifyCfg.Credentials = []*plugin.Credential{backend}
+			verify, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), verifyCfg, "s3-backend")
+			if err != nil {
+				t.Fatal(err)
+			}
+			verify.(*plugin.Plugin).Now = func() time.Time { return now }
+
+			var forwarded *http.Request
+			legacy := validCredential()
+			legacy.AllowedMethods = []string{http.MethodGet, http.MethodHead}
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{legacy}
+			cfg.Upstream = &plugin.UpstreamConfig{AccessKeyID: backend.AccessKeyID, AccessSecretKey: backend.AccessSecretKey}
+			cfg.UnsignedClients = &plugin.UnsignedClientsConfig{CIDRs: []string{"10.0.0.0/8"}, AccessKeyID: legacy.AccessKeyID}
+			handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req
+			}), cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return now }
+
+			req := httptest.NewRequest(tt.method, "https://s3.example.com/bucket/reports/2025.csv", nil)
+			req.RemoteAddr = tt.remoteAddr
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			recorder = httptest.NewRecorder()
+			verify.ServeHTTP(recorder, forwarded)
+			if recorder.Code != http.StatusOK {
+				t.Errorf("expected the backend to accept the signature, got status code %d", recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package traefik_plugin_s3_auth
+
+import (
+	"sync"
+	"time"
+)
+
+// CredentialStatus describes a configured credential and its usage, without the secret.
+type CredentialStatus struct {
+	AccessKeyID  string              `json:"accessKeyId"`
+	Region       string              `json:"region"`
+	Service      string              `json:"service"`
+	NotAfter     string              `json:"notAfter,omitempty"`
+	Roles        []string            `json:"roles,omitempty"`
+	Groups       []string            `json:"groups,omitempty"`
+	LastUsed     time.Time           `json:"lastUsed"`
+	LastSourceIP string              `json:"lastSourceIp,omitempty"`
+	Uses         uint64              `json:"uses"`
+	Quota        *QuotaStatus        `json:"quota,omitempty"`
+	Requests     *RequestQuotaStatus `json:"requests,omitempty"`
+	Warnings     []string            `json:"warnings,omitempty"`
+}
+
+type usage struct {
+	LastUsed time.Time
+	SourceIP string
+	Count    uint64
+}
+
+// usageTracker records the last successful use of each access key.
+type usageTracker struct {
+	mu    sync.Mutex
+	usage map[string]usage
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{usage: map[string]usage{}}
+}
+
+func (u *usageTracker) record(accessKeyID string, now time.Time, sourceIP string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	v := u.usage[accessKeyID]
+	v.LastUsed = now
+	v.SourceIP = sourceIP
+	v.Count++
+	u.usage[accessKeyID] = v
+}
+
+func (u *usageTracker) get(accessKeyID string) usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.usage[accessKeyID]
+}
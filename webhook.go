@@ -0,0 +1,102 @@
+package traefik_plugin_s3_auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// credentialDiff lists the access key ids that changed between two credential sets, never the secrets.
+type credentialDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+func (d credentialDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func diffCredentials(before, after []*Credential) credentialDiff {
+	index := func(creds []*Credential) map[string][]*Credential {
+		m := map[string][]*Credential{}
+		for _, c := range creds {
+			m[c.AccessKeyID] = append(m[c.AccessKeyID], c)
+		}
+		return m
+	}
+	b, a := index(before), index(after)
+
+	d := credentialDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for id, creds := range a {
+		old, ok := b[id]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, id)
+		case !reflect.DeepEqual(old, creds):
+			d.Changed = append(d.Changed, id)
+		}
+	}
+	for id := range b {
+		if _, ok := a[id]; !ok {
+			d.Removed = append(d.Removed, id)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
+
+type reloadEvent struct {
+	Event  string    `json:"event"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+	Total  int       `json:"total"`
+	credentialDiff
+}
+
+func notifyReload(url string, reason string, diff credentialDiff, total int) {
+	ev := reloadEvent{
+		Event:          "credentials.reloaded",
+		Reason:         reason,
+		Time:           time.Now().UTC(),
+		Total:          total,
+		credentialDiff: diff,
+	}
+	if err := postJSON(url, ev); err != nil {
+		logs.error("failed to notify the reload webhook", "error", err)
+	}
+}
+
+func postJSON(url string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
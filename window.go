@@ -0,0 +1,105 @@
+package traefik_plugin_s3_auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AccessWindow is a recurring period during which a credential works, eg: `Mon` to `Fri` from `22:00` to `06:00` in
+// `Europe/Lisbon`. Windows ending before they start run overnight, and belong to the day they start on.
+type AccessWindow struct {
+	// Days of the week, eg: `Mon` or `Saturday`, every day when empty.
+	Days []string `json:"days,omitempty"`
+	// Start and End times as `HH:MM`, the whole day when empty.
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	// TimeZone is an IANA time zone, eg: `America/New_York`, defaults to `UTC`.
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// accessWindow is a validated AccessWindow, with the times in minutes since midnight.
+type accessWindow struct {
+	days       map[time.Weekday]bool
+	start, end int
+	loc        *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func compileWindows(windows []*AccessWindow) ([]accessWindow, error) {
+	out := make([]accessWindow, 0, len(windows))
+	for _, w := range windows {
+		c := accessWindow{start: 0, end: 24 * 60, loc: time.UTC}
+		if len(w.Days) > 0 {
+			c.days = map[time.Weekday]bool{}
+			for _, d := range w.Days {
+				day, ok := weekdays[strings.ToLower(d)]
+				if !ok && len(d) > 3 {
+					day, ok = weekdays[strings.ToLower(d[:3])]
+				}
+				if !ok {
+					return nil, fmt.Errorf("invalid day: %q", d)
+				}
+				c.days[day] = true
+			}
+		}
+		var err error
+		if w.Start != "" {
+			if c.start, err = parseClock(w.Start); err != nil {
+				return nil, err
+			}
+		}
+		if w.End != "" {
+			if c.end, err = parseClock(w.End); err != nil {
+				return nil, err
+			}
+		}
+		if c.start == c.end {
+			return nil, fmt.Errorf("window can't start and end at the same time: %q", w.Start)
+		}
+		if w.TimeZone != "" {
+			if c.loc, err = time.LoadLocation(w.TimeZone); err != nil {
+				return nil, fmt.Errorf("invalid time zone %q: %w", w.TimeZone, err)
+			}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// parseClock parses `HH:MM` into minutes since midnight, `24:00` is accepted as an end of day.
+func parseClock(v string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(v, "%d:%d", &h, &m); err != nil || len(v) != 5 || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time, expected `HH:MM`: %q", v)
+	}
+	return h*60 + m, nil
+}
+
+func (w accessWindow) contains(now time.Time) bool {
+	t := now.In(w.loc)
+	minutes := t.Hour()*60 + t.Minute()
+	day := func(d time.Weekday) bool { return w.days == nil || w.days[d] }
+	if w.start < w.end {
+		return day(t.Weekday()) && minutes >= w.start && minutes < w.end
+	}
+	// Overnight, eg: `22:00` to `06:00` on a Friday also covers Saturday morning.
+	return (day(t.Weekday()) && minutes >= w.start) || (day((t.Weekday()+6)%7) && minutes < w.end)
+}
+
+// inWindows reports whether now is inside one of the windows, always true without windows.
+func inWindows(windows []accessWindow, now time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}
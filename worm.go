@@ -0,0 +1,111 @@
+package traefik_plugin_s3_auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var errWriteOnce = errors.New("the object is write-once")
+
+// writeOnce rejects the deletes and overwrites of write-once objects, remembering the keys written through the
+// middleware in the shared counters, ie: in memory or in Redis.
+type writeOnce struct {
+	store    counters
+	prefixes []string
+}
+
+// normalizeObjectPrefixes validates object prefixes of the form `bucket/key-prefix`, eg: `backups/` or `logs/2025/`.
+func normalizeObjectPrefixes(prefixes []string) ([]string, error) {
+	for _, p := range prefixes {
+		if bucket, _, _ := strings.Cut(p, "/"); bucket == "" || !strings.Contains(p, "/") {
+			return nil, fmt.Errorf("invalid prefix %q, eg: `backups/` or `backups/daily/`", p)
+		}
+	}
+	return prefixes, nil
+}
+
+// covers reports whether the object is write-once, either because of the credential or of its prefix.
+func (w *writeOnce) covers(cred *Credential, res s3Resource) bool {
+	if cred.WriteOnce {
+		return true
+	}
+	name := res.Bucket + "/" + res.Key
+	for _, p := range w.prefixes {
+		// Bucket requests, eg: `DeleteObjects`, are covered as soon as any prefix is in the bucket.
+		if strings.HasPrefix(name, p) || (res.Key == "" && strings.HasPrefix(p, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// check rejects deletes and writes to keys already written. Browser form uploads (`PostObject`) are rejected too,
+// since their key is in the body. The writes reserve the key atomically, so of concurrent writes of a new key only one
+// goes through, and check returns whether the reservation must be released unless the backend accepts the write.
+// Errors other than errWriteOnce come from the store.
+func (w *writeOnce) check(op s3Operation, res s3Resource) (bool, error) {
+	switch op.Name {
+	case "DeleteObject", "DeleteObjects", "PostObject":
+		return false, fmt.Errorf("%w: %s is not allowed", errWriteOnce, op.Name)
+	case "CreateMultipartUpload":
+		// Nothing is written until the upload completes.
+		n, err := w.store.get(writeOnceKey(res))
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return false, fmt.Errorf("%w: %q was already written", errWriteOnce, res.Bucket+"/"+res.Key)
+		}
+		return false, nil
+	case "PutObject", "CompleteMultipartUpload":
+		n, err := w.store.incr(writeOnceKey(res), 0)
+		if err != nil {
+			return false, err
+		}
+		if n > 1 {
+			return false, fmt.Errorf("%w: %q was already written or is being written", errWriteOnce, res.Bucket+"/"+res.Key)
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// release drops the reservation of a write the backend didn't accept, so it can be retried.
+func (w *writeOnce) release(res s3Resource) {
+	if err := w.store.del(writeOnceKey(res)); err != nil {
+		logs.error("failed to release the write-once object", "object", res.Bucket+"/"+res.Key, "error", err)
+	}
+}
+
+func writeOnceKey(res s3Resource) string {
+	return "worm:" + res.Bucket + "/" + res.Key
+}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
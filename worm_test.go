@@ -0,0 +1,186 @@
+package traefik_plugin_s3_auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plugin "github.com/csobrinho/traefik-plugin-s3-auth"
+)
+
+func TestWriteOnce(t *testing.T) {
+	tc := []struct {
+		name           string
+		writeOnce      bool
+		method         string
+		target         string
+		expectedStatus int
+	}{
+		{
+			name:           "first write",
+			method:         http.MethodPut,
+			target:         "/worm-backups/daily/2025-07-10.tar",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "overwrite",
+			method:         http.MethodPut,
+			target:         "/worm-backups/daily/2025-07-10.tar",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "failed write is not remembered",
+			method:         http.MethodPut,
+			target:         "/worm-backups/daily/failed.tar",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "retry after a failed write",
+			method:         http.MethodPut,
+			target:         "/worm-backups/daily/failed.tar",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "delete",
+			method:         http.MethodDelete,
+			target:         "/worm-backups/daily/2025-07-10.tar",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "multi-object delete",
+			method:         http.MethodPost,
+			target:         "/worm-backups?delete",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "browser form upload",
+			method:         http.MethodPost,
+			target:         "/worm-backups",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "read",
+			method:         http.MethodGet,
+			target:         "/worm-backups/daily/2025-07-10.tar",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "outside of the prefixes",
+			method:         http.MethodDelete,
+			target:         "/worm-backups/scratch/tmp.txt",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "write-once credential",
+			writeOnce:      true,
+			method:         http.MethodDelete,
+			target:         "/worm-backups/scratch/tmp.txt",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if strings.Contains(req.URL.Path, "failed") {
+					rw.WriteHeader(http.StatusInternalServerError)
+				}
+			})
+			cred := validCredential()
+			cred.WriteOnce = tt.writeOnce
+			cfg := plugin.CreateConfig()
+			cfg.Credentials = []*plugin.Credential{cred}
+			cfg.WriteOncePrefixes = []string{"worm-backups/daily/"}
+			handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := handler.(*plugin.Plugin)
+			p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, newSignedRequest(t, tt.method, tt.target, cred))
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestWriteOnceConcurrentWrites(t *testing.T) {
+	started, done := make(chan struct{}), make(chan struct{})
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-done
+	})
+	cred := validCredential()
+	cfg := plugin.CreateConfig()
+	cfg.Credentials = []*plugin.Credential{cred}
+	cfg.WriteOncePrefixes = []string{"worm-concurrent/"}
+	handler, err := plugin.New(context.Background(), next, cfg, "s3-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := handler.(*plugin.Plugin)
+	p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+
+	first := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		p.ServeHTTP(first, newSignedRequest(t, http.MethodPut, "/worm-concurrent/key.tar", cred))
+	}()
+	<-started
+	// The second write of the key arrives while the first one is still in flight.
+	second := httptest.NewRecorder()
+	p.ServeHTTP(second, newSignedRequest(t, http.MethodPut, "/worm-concurrent/key.tar", cred))
+	close(done)
+	<-served
+	if first.Code != http.StatusOK || second.Code != http.StatusForbidden {
+		t.Errorf("expected a single write to go through, got %d and %d", first.Code, second.Code)
+	}
+}
+
+func TestWriteOnceMiddlewares(t *testing.T) {
+	cred := validCredential()
+	newPlugin := func(name string) *plugin.Plugin {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{cred}
+		cfg.WriteOncePrefixes = []string{"worm-middlewares/"}
+		handler, err := plugin.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := handler.(*plugin.Plugin)
+		p.Now = func() time.Time { return time.Date(2025, 7, 10, 5, 45, 0, 0, time.UTC) }
+		return p
+	}
+	// Another middleware, eg: in front of another backend, doesn't know the keys written through the first one.
+	for i, step := range []struct {
+		name     string
+		expected int
+	}{
+		{name: "worm-a", expected: http.StatusOK},
+		{name: "worm-a", expected: http.StatusForbidden},
+		{name: "worm-b", expected: http.StatusOK},
+	} {
+		recorder := httptest.NewRecorder()
+		newPlugin(step.name).ServeHTTP(recorder, newSignedRequest(t, http.MethodPut, "/worm-middlewares/key.tar", cred))
+		if recorder.Code != step.expected {
+			t.Errorf("write %d: expected status code %d, got %d", i, step.expected, recorder.Code)
+		}
+	}
+}
+
+func TestInvalidWriteOncePrefixes(t *testing.T) {
+	for _, prefix := range []string{"backups", "/daily/"} {
+		cfg := plugin.CreateConfig()
+		cfg.Credentials = []*plugin.Credential{validCredential()}
+		cfg.WriteOncePrefixes = []string{prefix}
+		if _, err := plugin.New(context.Background(), http.NotFoundHandler(), cfg, "s3-plugin"); err == nil || !strings.Contains(err.Error(), "writeOncePrefixes") {
+			t.Errorf("expected a writeOncePrefixes error for %q, got %v", prefix, err)
+		}
+	}
+}